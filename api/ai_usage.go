@@ -0,0 +1,214 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/facturaIA/invoice-ocr-service/internal/ai"
+	"github.com/facturaIA/invoice-ocr-service/internal/db"
+	"github.com/facturaIA/invoice-ocr-service/internal/models"
+)
+
+const defaultCheaperProvider = "ollama"
+
+// errAIQuotaExceeded is returned by processInvoice for an empresa past a
+// blocking monthly quota
+var errAIQuotaExceeded = errors.New("monthly AI quota exceeded")
+
+// estimateCostUSD prices a call with the configured price of its model
+func estimateCostUSD(pricing map[string]models.ModelPricing, usage ai.Usage) float64 {
+	price, ok := pricing[usage.Model]
+	if !ok {
+		return 0
+	}
+	return (float64(usage.InputTokens)*price.InputPerMillion + float64(usage.OutputTokens)*price.OutputPerMillion) / 1e6
+}
+
+// usageRecorder returns the function that stores the provider calls of an
+// extraction. Failures are only logged: metering never fails an extraction.
+func (h *Handler) usageRecorder(ctx context.Context, hints extractionHints) func(ai.Call) {
+	// The call is paid even when the client stops waiting
+	ctx = context.WithoutCancel(ctx)
+	return func(call ai.Call) {
+		u := db.AIUsage{
+			EmpresaAlias: hints.EmpresaAlias,
+			ClienteID:    hints.ClienteID,
+			FacturaID:    hints.FacturaID,
+			Provider:     call.Usage.Provider,
+			Model:        call.Usage.Model,
+			LatencyMs:    call.Latency.Milliseconds(),
+			InputTokens:  call.Usage.InputTokens,
+			OutputTokens: call.Usage.OutputTokens,
+			CostUSD:      estimateCostUSD(h.config.AI.Pricing, call.Usage),
+			Outcome:      call.Outcome(),
+		}
+		if call.Err != nil {
+			u.Error = call.Err.Error()
+		}
+		fmt.Printf("[AI Usage] %s/%s: %d+%d tokens, $%.6f, %dms, %s\n",
+			u.Provider, u.Model, u.InputTokens, u.OutputTokens, u.CostUSD, u.LatencyMs, u.Outcome)
+		if db.Pool == nil {
+			return
+		}
+		if err := db.RecordAIUsage(ctx, u); err != nil {
+			log.Printf("usageRecorder: DB error: %v", err)
+		}
+	}
+}
+
+// quotaFor returns the monthly quota of an empresa
+func (h *Handler) quotaFor(empresaAlias string) models.AIQuota {
+	if q, ok := h.config.AI.Quotas.Empresas[empresaAlias]; ok {
+		return q
+	}
+	return h.config.AI.Quotas.Default
+}
+
+// quotaExceeded tells whether a month of usage is past a quota
+func quotaExceeded(q models.AIQuota, usage *db.AIUsageTotals) bool {
+	if q.MonthlyCostUSD > 0 && usage.CostoUSD >= q.MonthlyCostUSD {
+		return true
+	}
+	return q.MonthlyTokens > 0 && usage.InputTokens+usage.OutputTokens >= q.MonthlyTokens
+}
+
+// applyAIQuota returns the provider an extraction may use: providerName
+// within the monthly quota, the quota's cheaper provider past it, or
+// errAIQuotaExceeded when the quota blocks. Without database usage is not
+// known and nothing is limited.
+func (h *Handler) applyAIQuota(ctx context.Context, hints extractionHints, providerName string) (string, error) {
+	quota := h.quotaFor(hints.EmpresaAlias)
+	if (quota.MonthlyCostUSD <= 0 && quota.MonthlyTokens <= 0) || db.Pool == nil {
+		return providerName, nil
+	}
+	usage, err := db.GetAIUsageMonth(ctx, hints.EmpresaAlias, hints.ClienteID, time.Now())
+	if err != nil {
+		log.Printf("applyAIQuota: DB error: %v", err)
+		return providerName, nil
+	}
+	if usage.EmpresaAlias != hints.EmpresaAlias {
+		// Resolved from the cliente: its empresa may have its own quota
+		quota = h.quotaFor(usage.EmpresaAlias)
+	}
+	if !quotaExceeded(quota, usage) {
+		return providerName, nil
+	}
+
+	if quota.OnExceeded == "block" {
+		log.Printf("[Quota] %s past its monthly AI quota ($%.2f, %d tokens): blocked",
+			usage.EmpresaAlias, usage.CostoUSD, usage.InputTokens+usage.OutputTokens)
+		return "", errAIQuotaExceeded
+	}
+	cheaper := quota.CheaperProvider
+	if cheaper == "" {
+		cheaper = defaultCheaperProvider
+	}
+	if cheaper != providerName {
+		log.Printf("[Quota] %s past its monthly AI quota ($%.2f, %d tokens): %s instead of %s",
+			usage.EmpresaAlias, usage.CostoUSD, usage.InputTokens+usage.OutputTokens, cheaper, providerName)
+	}
+	return cheaper, nil
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: GET /api/admin/ai-usage
+// ─────────────────────────────────────────────────────────────────────────────
+
+// GetAIUsage devuelve el consumo de IA de cada empresa en un mes
+// (?mes=YYYY-MM, por defecto el actual): llamadas, errores, tokens y costo
+// estimado
+func (h *Handler) GetAIUsage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if db.Pool == nil {
+		sendAppError(w, ErrDBUnavailable)
+		return
+	}
+
+	mes := time.Now()
+	if v := r.URL.Query().Get("mes"); v != "" {
+		t, err := time.ParseInLocation("2006-01", v, time.Local)
+		if err != nil {
+			h.sendError(w, http.StatusBadRequest, "mes must be YYYY-MM")
+			return
+		}
+		mes = t
+	}
+
+	empresas, err := db.GetAIUsageByEmpresa(r.Context(), mes)
+	if err != nil {
+		log.Printf("GetAIUsage: DB error: %v", err)
+		h.sendError(w, http.StatusInternalServerError, "failed to load AI usage")
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"mes":      mes.Format("2006-01"),
+		"empresas": empresas,
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: GET /api/admin/ai-usage/{empresa}
+// ─────────────────────────────────────────────────────────────────────────────
+
+// GetEmpresaAIUsage devuelve el consumo de IA de una empresa por mes,
+// proveedor y modelo en los últimos ?meses=N (por defecto 12), con su cuota
+// mensual y el consumo del mes en curso
+func (h *Handler) GetEmpresaAIUsage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if db.Pool == nil {
+		sendAppError(w, ErrDBUnavailable)
+		return
+	}
+
+	empresa := mux.Vars(r)["empresa"]
+	meses := 12
+	if v := r.URL.Query().Get("meses"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 120 {
+			h.sendError(w, http.StatusBadRequest, "meses must be between 1 and 120")
+			return
+		}
+		meses = n
+	}
+
+	now := time.Now()
+	desde := time.Date(now.Year(), now.Month()-time.Month(meses-1), 1, 0, 0, 0, 0, now.Location())
+	detalle, err := db.GetAIUsageEmpresa(r.Context(), empresa, desde)
+	if err != nil {
+		log.Printf("GetEmpresaAIUsage: DB error: %v", err)
+		h.sendError(w, http.StatusInternalServerError, "failed to load AI usage")
+		return
+	}
+	actual, err := db.GetAIUsageMonth(r.Context(), empresa, "", now)
+	if err != nil {
+		log.Printf("GetEmpresaAIUsage: DB error: %v", err)
+		h.sendError(w, http.StatusInternalServerError, "failed to load AI usage")
+		return
+	}
+
+	quota := h.quotaFor(empresa)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"empresa":    empresa,
+		"mes_actual": actual,
+		"cuota": map[string]interface{}{
+			"monthly_cost_usd": quota.MonthlyCostUSD,
+			"monthly_tokens":   quota.MonthlyTokens,
+			"on_exceeded":      quota.OnExceeded,
+			"cheaper_provider": quota.CheaperProvider,
+		},
+		"excedida": quotaExceeded(quota, actual),
+		"por_mes":  detalle,
+	})
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/facturaIA/invoice-ocr-service/internal/ai"
+	"github.com/facturaIA/invoice-ocr-service/internal/db"
+	"github.com/facturaIA/invoice-ocr-service/internal/models"
+)
+
+func TestEstimateCostUSD(t *testing.T) {
+	pricing := map[string]models.ModelPricing{"gpt-4o": {InputPerMillion: 2.5, OutputPerMillion: 10}}
+
+	cost := estimateCostUSD(pricing, ai.Usage{Model: "gpt-4o", InputTokens: 2000, OutputTokens: 500})
+	if math.Abs(cost-0.01) > 1e-9 {
+		t.Errorf("cost = %f, want 0.01", cost)
+	}
+	if cost := estimateCostUSD(pricing, ai.Usage{Model: "mistral", InputTokens: 2000}); cost != 0 {
+		t.Errorf("unpriced model cost = %f, want 0", cost)
+	}
+}
+
+func TestQuotaExceeded(t *testing.T) {
+	usage := &db.AIUsageTotals{CostoUSD: 4.5, InputTokens: 800, OutputTokens: 200}
+	for _, tc := range []struct {
+		quota models.AIQuota
+		want  bool
+	}{
+		{models.AIQuota{}, false},
+		{models.AIQuota{MonthlyCostUSD: 5}, false},
+		{models.AIQuota{MonthlyCostUSD: 4.5}, true},
+		{models.AIQuota{MonthlyTokens: 1000}, true},
+		{models.AIQuota{MonthlyTokens: 1001}, false},
+	} {
+		if got := quotaExceeded(tc.quota, usage); got != tc.want {
+			t.Errorf("quotaExceeded(%+v) = %v, want %v", tc.quota, got, tc.want)
+		}
+	}
+}
+
+func TestQuotaPerEmpresa(t *testing.T) {
+	h := newTestHandler(ai.NewFakeProvider())
+	h.config.AI.Quotas = models.AIQuotasConfig{
+		Default:  models.AIQuota{MonthlyCostUSD: 10},
+		Empresas: map[string]models.AIQuota{"acme": {MonthlyCostUSD: 50, OnExceeded: "block"}},
+	}
+	if q := h.quotaFor("acme"); q.MonthlyCostUSD != 50 || q.OnExceeded != "block" {
+		t.Errorf("quotaFor(acme) = %+v", q)
+	}
+	if q := h.quotaFor("otra"); q.MonthlyCostUSD != 10 {
+		t.Errorf("quotaFor(otra) = %+v", q)
+	}
+
+	// Without database usage is unknown: nothing is limited
+	if p, err := h.applyAIQuota(context.Background(), extractionHints{EmpresaAlias: "acme"}, "gemini"); err != nil || p != "gemini" {
+		t.Errorf("applyAIQuota without DB = %s, %v", p, err)
+	}
+}
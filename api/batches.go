@@ -0,0 +1,411 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/facturaIA/invoice-ocr-service/internal/auth"
+	"github.com/facturaIA/invoice-ocr-service/internal/db"
+)
+
+const (
+	MaxBatchUploadSize = 200 * 1024 * 1024 // 200MB per request (and expanded ZIP content)
+	MaxBatchFiles      = 500
+	// Multipart parts beyond this are spooled to temp files
+	batchFormMemory = 32 * 1024 * 1024
+)
+
+// Per-file states reported for a batch
+const (
+	batchPendiente  = "pendiente"
+	batchProcesando = "procesando"
+	batchProcesada  = "procesada"
+	batchRevision   = "revision"
+	batchDuplicada  = "duplicada"
+	batchError      = "error"
+)
+
+// batchFile is one file taken from the request: an invoice to queue, or a
+// rejection reason (rejected files are reported in the batch too)
+type batchFile struct {
+	name        string
+	data        []byte
+	contentType string
+	rejectCode  int
+	rejectMsg   string
+}
+
+// batchCollector gathers files from multipart parts and ZIP archives,
+// enforcing the per-file, total size and file count limits
+type batchCollector struct {
+	files []batchFile
+	total int64
+}
+
+func (c *batchCollector) reject(name string, code int, msg string) {
+	c.files = append(c.files, batchFile{name: name, rejectCode: code, rejectMsg: msg})
+}
+
+// add takes one file's content, rejecting it if it's not a supported invoice
+// format or a limit is exceeded. ZIP archives are expanded.
+func (c *batchCollector) add(name string, r io.Reader) error {
+	if len(c.files) >= MaxBatchFiles {
+		return fmt.Errorf("el lote supera el máximo de %d archivos", MaxBatchFiles)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, MaxBatchUploadSize+1))
+	if err != nil {
+		c.reject(name, http.StatusBadRequest, "no se pudo leer el archivo: "+err.Error())
+		return nil
+	}
+
+	if isZip(name, data) {
+		return c.addZip(name, data)
+	}
+
+	if len(data) > MaxUploadSize {
+		c.reject(name, http.StatusRequestEntityTooLarge, ErrFileTooLarge.UserMessage)
+		return nil
+	}
+	if c.total+int64(len(data)) > MaxBatchUploadSize {
+		c.reject(name, http.StatusRequestEntityTooLarge, "el lote supera el tamaño máximo permitido")
+		return nil
+	}
+	contentType := detectInvoiceContentType(name, data)
+	if contentType == "" {
+		c.reject(name, http.StatusUnsupportedMediaType, ErrFormatUnsupported.UserMessage)
+		return nil
+	}
+
+	c.total += int64(len(data))
+	c.files = append(c.files, batchFile{name: name, data: data, contentType: contentType})
+	return nil
+}
+
+// addZip adds every file of a ZIP archive. Folders, hidden files and macOS
+// metadata are skipped; each entry is read with the per-file limit so a
+// compressed bomb can't exhaust memory.
+func (c *batchCollector) addZip(name string, data []byte) error {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		c.reject(name, http.StatusBadRequest, "ZIP inválido: "+err.Error())
+		return nil
+	}
+
+	for _, f := range zr.File {
+		base := path.Base(f.Name)
+		if f.FileInfo().IsDir() || strings.HasPrefix(f.Name, "__MACOSX/") || strings.HasPrefix(base, ".") {
+			continue
+		}
+		entryName := name + "/" + f.Name
+
+		rc, err := f.Open()
+		if err != nil {
+			c.reject(entryName, http.StatusBadRequest, "no se pudo leer el archivo: "+err.Error())
+			continue
+		}
+		entry, err := io.ReadAll(io.LimitReader(rc, MaxUploadSize+1))
+		rc.Close()
+		if err != nil {
+			c.reject(entryName, http.StatusBadRequest, "no se pudo leer el archivo: "+err.Error())
+			continue
+		}
+		if len(entry) > MaxUploadSize {
+			c.reject(entryName, http.StatusRequestEntityTooLarge, ErrFileTooLarge.UserMessage)
+			continue
+		}
+		if isZip(f.Name, entry) {
+			c.reject(entryName, http.StatusUnsupportedMediaType, "no se admiten ZIP dentro de otro ZIP")
+			continue
+		}
+		if err := c.add(entryName, bytes.NewReader(entry)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isZip(name string, data []byte) bool {
+	return strings.EqualFold(path.Ext(name), ".zip") || bytes.HasPrefix(data, []byte("PK\x03\x04"))
+}
+
+// detectInvoiceContentType returns the content type of a supported invoice
+// file (image or PDF), or "" if the format is not supported
+func detectInvoiceContentType(name string, data []byte) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	case ".png":
+		return "image/png"
+	case ".webp":
+		return "image/webp"
+	case ".gif":
+		return "image/gif"
+	case ".pdf":
+		return "application/pdf"
+	}
+	switch ct := http.DetectContentType(data); ct {
+	case "image/jpeg", "image/png", "image/webp", "image/gif", "application/pdf":
+		return ct
+	}
+	return ""
+}
+
+// resolveBatchCliente decides whose invoices the batch creates. A cliente
+// uploads for itself; a contador for the clientes it owns; an admin for any.
+// Returns the cliente ID and the empresa alias used for image storage.
+func resolveBatchCliente(ctx context.Context, claims *auth.Claims, clienteID string) (string, string, int, error) {
+	if clienteID == "" || clienteID == claims.UserID {
+		return claims.UserID, claims.EmpresaAlias, 0, nil
+	}
+	if claims.Rol != "admin" && claims.Rol != "contador" {
+		return "", "", http.StatusForbidden, errors.New("no puede subir facturas para otro cliente")
+	}
+
+	owner, err := db.GetClientOwnerID(ctx, clienteID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", "", http.StatusNotFound, errors.New("cliente no encontrado")
+	}
+	if err != nil {
+		return "", "", http.StatusInternalServerError, err
+	}
+	if claims.Rol == "contador" && owner != claims.UserID {
+		return "", "", http.StatusForbidden, errors.New("el cliente no pertenece a este contador")
+	}
+	return clienteID, owner, 0, nil
+}
+
+// batchFileState maps a job to the per-file state shown to the user
+func batchFileState(f db.BatchFile) string {
+	switch f.JobStatus {
+	case db.JobQueued:
+		return batchPendiente
+	case db.JobRunning:
+		return batchProcesando
+	case db.JobFailed:
+		if f.Error == "DUPLICATE_NCF" || f.Error == "DUPLICATE_AMOUNT" {
+			return batchDuplicada
+		}
+		return batchError
+	}
+	if f.ExtractionStatus == "validated" {
+		return batchProcesada
+	}
+	return batchRevision
+}
+
+// batchResponse is the batch with per-file states and totals
+func batchResponse(b *db.UploadBatch) map[string]interface{} {
+	resumen := map[string]int{
+		batchPendiente:  0,
+		batchProcesando: 0,
+		batchProcesada:  0,
+		batchRevision:   0,
+		batchDuplicada:  0,
+		batchError:      0,
+	}
+	archivos := make([]map[string]interface{}, 0, len(b.Archivos))
+	for _, f := range b.Archivos {
+		estado := batchFileState(f)
+		resumen[estado]++
+		archivos = append(archivos, map[string]interface{}{
+			"job_id":            f.JobID,
+			"archivo":           f.ArchivoNombre,
+			"estado":            estado,
+			"extraction_status": f.ExtractionStatus,
+			"factura_id":        f.FacturaID,
+			"error":             f.Error,
+			"finished_at":       f.FinishedAt,
+		})
+	}
+
+	estado := "completado"
+	if resumen[batchPendiente]+resumen[batchProcesando] > 0 {
+		estado = "en_proceso"
+	}
+	return map[string]interface{}{
+		"id":         b.ID,
+		"cliente_id": b.ClienteID,
+		"total":      b.Total,
+		"estado":     estado,
+		"resumen":    resumen,
+		"archivos":   archivos,
+		"created_at": b.CreatedAt,
+		"status_url": fmt.Sprintf("/api/facturas/batch/%s", b.ID),
+	}
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: POST /api/facturas/batch/
+// ─────────────────────────────────────────────────────────────────────────────
+
+// UploadBatch queues many invoices at once: a multipart form with any number
+// of files (ZIPs are expanded) or a raw application/zip body. Each file
+// becomes a processing job; the job workers run them with at most
+// jobs.batch_concurrency files of the batch at a time. Optional cliente_id
+// (contador/admin) and the processing options of the single upload are
+// accepted as form fields or query parameters.
+func (h *Handler) UploadBatch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	ctx := r.Context()
+
+	claims, err := auth.GetClaimsFromContext(ctx)
+	if err != nil {
+		h.sendError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if db.Pool == nil {
+		sendAppError(w, ErrDBUnavailable)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, MaxBatchUploadSize)
+	collector := &batchCollector{}
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		if err := r.ParseMultipartForm(batchFormMemory); err != nil {
+			sendAppError(w, ErrFileTooLarge)
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+
+		for _, headers := range r.MultipartForm.File {
+			for _, fh := range headers {
+				if err := addMultipartFile(collector, fh); err != nil {
+					h.sendError(w, http.StatusRequestEntityTooLarge, err.Error())
+					return
+				}
+			}
+		}
+	} else {
+		name := r.URL.Query().Get("filename")
+		if name == "" {
+			name = "lote.zip"
+		}
+		if err := collector.add(name, r.Body); err != nil {
+			h.sendError(w, http.StatusRequestEntityTooLarge, err.Error())
+			return
+		}
+	}
+
+	if len(collector.files) == 0 {
+		h.sendError(w, http.StatusBadRequest, "No files provided (multipart files or a ZIP)")
+		return
+	}
+
+	clienteID, empresaAlias, status, err := resolveBatchCliente(ctx, claims, r.FormValue("cliente_id"))
+	if err != nil {
+		if status == http.StatusInternalServerError {
+			log.Printf("UploadBatch: DB error: %v", err)
+		}
+		h.sendError(w, status, err.Error())
+		return
+	}
+
+	jobs := make([]*db.ProcessingJob, 0, len(collector.files))
+	for _, f := range collector.files {
+		job := &db.ProcessingJob{ArchivoNombre: f.name}
+		if f.rejectMsg != "" {
+			code := f.rejectCode
+			job.Status = db.JobFailed
+			job.HTTPStatus = &code
+			job.Error = f.rejectMsg
+		} else {
+			params := h.uploadParamsFromForm(r, clienteID, empresaAlias)
+			params.ContentType = f.contentType
+			if job.Params, err = json.Marshal(params); err != nil {
+				h.sendError(w, http.StatusInternalServerError, "Failed to encode job")
+				return
+			}
+			job.Imagen = f.data
+		}
+		jobs = append(jobs, job)
+	}
+
+	batch := &db.UploadBatch{ClienteID: clienteID, CreatedBy: claims.UserID}
+	if err := db.CreateUploadBatch(ctx, batch, jobs); err != nil {
+		log.Printf("UploadBatch: DB error: %v", err)
+		sendAppError(w, ErrDBUnavailable)
+		return
+	}
+	h.wakeJobWorker()
+	log.Printf("[Batch] Lote %s: %d archivos para cliente %s", batch.ID, batch.Total, clienteID)
+
+	for _, job := range jobs {
+		batch.Archivos = append(batch.Archivos, db.BatchFile{
+			JobID:         job.ID,
+			ArchivoNombre: job.ArchivoNombre,
+			JobStatus:     job.Status,
+			HTTPStatus:    job.HTTPStatus,
+			Error:         job.Error,
+		})
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":      true,
+		"batch":        batchResponse(batch),
+		"user_message": fmt.Sprintf("Recibimos %d archivos. Se procesarán en los próximos minutos.", batch.Total),
+	})
+}
+
+func addMultipartFile(c *batchCollector, fh *multipart.FileHeader) error {
+	f, err := fh.Open()
+	if err != nil {
+		c.reject(fh.Filename, http.StatusBadRequest, "no se pudo leer el archivo: "+err.Error())
+		return nil
+	}
+	defer f.Close()
+	return c.add(fh.Filename, f)
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: GET /api/facturas/batch/{id}
+// ─────────────────────────────────────────────────────────────────────────────
+
+// GetUploadBatch reports per-file status and totals (processed, needing
+// review, duplicates, errors) of a batch
+func (h *Handler) GetUploadBatch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, err := auth.GetClaimsFromContext(r.Context())
+	if err != nil {
+		h.sendError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if db.Pool == nil {
+		sendAppError(w, ErrDBUnavailable)
+		return
+	}
+
+	batch, err := db.GetUploadBatch(r.Context(), claims.UserID, mux.Vars(r)["id"])
+	if err != nil {
+		log.Printf("GetUploadBatch: DB error: %v", err)
+		h.sendError(w, http.StatusNotFound, "batch not found")
+		return
+	}
+	if batch == nil {
+		h.sendError(w, http.StatusNotFound, "batch not found")
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"batch":   batchResponse(batch),
+	})
+}
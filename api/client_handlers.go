@@ -2,6 +2,7 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -11,12 +12,14 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
 
 	"github.com/minio/minio-go/v7"
 
 	"github.com/facturaIA/invoice-ocr-service/internal/auth"
 	"github.com/facturaIA/invoice-ocr-service/internal/db"
 	"github.com/facturaIA/invoice-ocr-service/internal/storage"
+	"github.com/facturaIA/invoice-ocr-service/internal/webhooks"
 )
 
 // GetClientInvoices - GET /api/facturas/mis-facturas/
@@ -176,10 +179,25 @@ func (h *Handler) DeleteClientInvoice(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	invoiceID := vars["id"]
 
-	if err := db.DeleteClientInvoice(r.Context(), claims.UserID, invoiceID); err != nil {
+	if inv, err := db.GetClientInvoiceByID(r.Context(), claims.UserID, invoiceID); err == nil {
+		if err := checkPeriodo606Abierto(r.Context(), inv); err != nil {
+			h.sendPeriodoLockError(w, "DeleteClientInvoice", err)
+			return
+		}
+	}
+
+	err = db.DeleteClientInvoiceWithOutbox(r.Context(), claims.UserID, invoiceID, func(inv *db.ClientInvoice) []db.OutboxEvent {
+		return invoiceWebhookEvent(webhooks.InvoiceDeleted, inv, inv.ID)
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		h.sendError(w, http.StatusNotFound, "invoice not found")
+		return
+	}
+	if err != nil {
 		h.sendError(w, http.StatusInternalServerError, "failed to delete invoice")
 		return
 	}
+	h.outbox.Notify()
 
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"success": true,
@@ -212,6 +230,18 @@ func (h *Handler) ReprocesarClientInvoice(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// Reprocessing stores estado "procesado": it would bring a voided or
+	// rejected invoice back into the 606, 607 and IR-17
+	if voidedOrRejected(invoice) {
+		h.sendError(w, http.StatusConflict, fmt.Sprintf("la factura está %s", invoice.Estado))
+		return
+	}
+
+	if err := checkPeriodo606Abierto(r.Context(), invoice); err != nil {
+		h.sendPeriodoLockError(w, "ReprocesarClientInvoice", err)
+		return
+	}
+
 	// Download image from MinIO
 	if storage.Client == nil {
 		sendAppError(w, ErrStorageUnavailable)
@@ -243,87 +273,33 @@ func (h *Handler) ReprocesarClientInvoice(w http.ResponseWriter, r *http.Request
 
 	// Reprocess with AI
 	fmt.Printf("[Reprocesar] Reprocesando factura %s con AI\n", invoiceID)
-	reprocessedInvoice, _, _, _, err := h.processInvoice(
-		imageData,
-		true, // useVisionModel
-		h.config.AI.DefaultProvider,
-		"",
-		h.config.OCR.Language,
-	)
-
+	updatedInvoice, _, err := h.reextractInvoice(r.Context(), imageData,
+		extractionHints{ClienteID: claims.UserID, EmpresaAlias: claims.EmpresaAlias, FacturaID: invoiceID, EmisorRNC: invoice.EmisorRNC,
+			Force: r.URL.Query().Get("force") == "true"})
+	if errors.Is(err, errAIQuotaExceeded) {
+		sendAppError(w, ErrAIQuotaExceeded)
+		return
+	}
 	if err != nil {
 		h.sendError(w, http.StatusInternalServerError, "OCR reprocessing failed: "+err.Error())
 		return
 	}
 
-	// Parse fecha
-	var fechaDoc *time.Time
-	if !reprocessedInvoice.FechaFactura.IsZero() {
-		t := reprocessedInvoice.FechaFactura
-		fechaDoc = &t
-	} else if !reprocessedInvoice.Date.IsZero() {
-		t := reprocessedInvoice.Date
-		fechaDoc = &t
-	}
-
-	// Build OCR notes summary
-	ocrNotes := ""
-	if ocrJSON, err := json.Marshal(reprocessedInvoice); err == nil {
-		ocrNotes = string(ocrJSON)
-	}
-
-	// Serialize items to JSON
-	itemsJSON := ""
-	if len(reprocessedInvoice.Items) > 0 {
-		if ij, err := json.Marshal(reprocessedInvoice.Items); err == nil {
-			itemsJSON = string(ij)
-		}
-	}
-
-	// Build updated invoice
-	updatedInvoice := &db.ClientInvoice{
-		NCF:              reprocessedInvoice.NCF,
-		TipoNCF:          reprocessedInvoice.TipoNCF,
-		EmisorRNC:        reprocessedInvoice.RNCEmisor,
-		Proveedor:        reprocessedInvoice.NombreEmisor,
-		ReceptorNombre:   reprocessedInvoice.NombreReceptor,
-		ReceptorRNC:      reprocessedInvoice.RNCReceptor,
-		HoraFactura:      reprocessedInvoice.HoraFactura,
-		FechaDocumento:   fechaDoc,
-		Monto:            decimalToFloat64(reprocessedInvoice.Total),
-		Subtotal:         decimalToFloat64(reprocessedInvoice.Subtotal),
-		Descuento:        decimalToFloat64(reprocessedInvoice.Descuento),
-		ITBIS:            decimalToFloat64(reprocessedInvoice.ITBIS),
-		ITBISRetenido:    decimalToFloat64(reprocessedInvoice.ITBISRetenido),
-		ITBISExento:      decimalToFloat64(reprocessedInvoice.ITBISExento),
-		ITBISProporcionalidad: decimalToFloat64(reprocessedInvoice.ITBISProporcionalidad),
-		ITBISCosto:       decimalToFloat64(reprocessedInvoice.ITBISCosto),
-		ISR:              decimalToFloat64(reprocessedInvoice.ISR),
-		RetencionISRTipo: intToPtr(reprocessedInvoice.RetencionISRTipo),
-		ISC:              decimalToFloat64(reprocessedInvoice.ISC),
-		ISCCategoria:     reprocessedInvoice.ISCCategoria,
-		CDTMonto:         decimalToFloat64(reprocessedInvoice.CDTMonto),
-		Cargo911:         decimalToFloat64(reprocessedInvoice.Cargo911),
-		Propina:          decimalToFloat64(reprocessedInvoice.Propina),
-		OtrosImpuestos:   decimalToFloat64(reprocessedInvoice.OtrosImpuestos),
-		MontoNoFacturable: decimalToFloat64(reprocessedInvoice.MontoNoFacturable),
-		FormaPago:        reprocessedInvoice.FormaPago,
-		TipoBienServicio: reprocessedInvoice.TipoBienServicio,
-		ConfidenceScore:  reprocessedInvoice.Confidence,
-		RawOCRJSON:       ocrNotes,
-		ItemsJSON:        itemsJSON,
-		ExtractionStatus: "validated",
-		ReviewNotes:      "",
-		Estado:           "procesado",
+	// The reprocessed data may move the invoice into another (finalized) period
+	if err := checkPeriodo606Abierto(r.Context(), updatedInvoice); err != nil {
+		h.sendPeriodoLockError(w, "ReprocesarClientInvoice", err)
+		return
 	}
 
 	// Update in database
-	if err := db.UpdateClientInvoice(r.Context(), claims.UserID, invoiceID, updatedInvoice); err != nil {
+	if err := db.UpdateClientInvoiceWithOutbox(r.Context(), claims.UserID, invoiceID, updatedInvoice, auditActor(claims, "reprocesamiento con IA"), invoiceUpdatedEvent); err != nil {
 		log.Printf("ReprocesarClientInvoice: DB update error: %v", err)
 		h.sendError(w, http.StatusInternalServerError, "failed to update invoice in database")
 		return
 	}
 
+	h.outbox.Notify()
+
 	// Get updated invoice to return
 	finalInvoice, err := db.GetClientInvoiceByID(r.Context(), claims.UserID, invoiceID)
 	if err != nil {
@@ -338,6 +314,189 @@ func (h *Handler) ReprocesarClientInvoice(w http.ResponseWriter, r *http.Request
 	})
 }
 
+// PatchClientInvoice - PATCH /api/facturas/{id}
+// Corrige campos DGII (NCF, montos, ITBIS...) sin reprocesar con IA. Solo se
+// escriben los campos enviados; la factura se valida de nuevo y se recalculan
+// extraction_status y confidence_score. Responde con la misma forma que el
+// upload. Un contador puede corregir las facturas de sus clientes.
+func (h *Handler) PatchClientInvoice(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, err := auth.GetClaimsFromContext(r.Context())
+	if err != nil {
+		h.sendError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if db.Pool == nil {
+		sendAppError(w, ErrDBUnavailable)
+		return
+	}
+
+	var req struct {
+		invoiceCorrection
+		Motivo string `json:"motivo"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	invoiceID := mux.Vars(r)["id"]
+	invoice, ok := h.loadEditableInvoice(w, r, claims, invoiceID, "PatchClientInvoice")
+	if !ok {
+		return
+	}
+
+	cambios, err := req.apply(invoice)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(cambios) == 0 {
+		h.sendError(w, http.StatusBadRequest, "no hay campos que corregir")
+		return
+	}
+	// La nueva fecha puede caer en un período ya finalizado
+	if _, ok := cambios["fecha_documento"]; ok {
+		if err := checkPeriodo606Abierto(r.Context(), invoice); err != nil {
+			h.sendPeriodoLockError(w, "PatchClientInvoice", err)
+			return
+		}
+	}
+
+	validationResult := revalidateCorrection(invoice)
+
+	reason := "corrección manual"
+	if motivo := strings.TrimSpace(req.Motivo); motivo != "" {
+		reason += ": " + motivo
+	}
+	actor := auditActor(claims, reason)
+	if err := db.UpdateClientInvoiceWithOutbox(r.Context(), invoice.ClienteID, invoiceID, invoice, actor, invoiceUpdatedEvent, correctionColumns(cambios)...); err != nil {
+		log.Printf("PatchClientInvoice: DB update error: %v", err)
+		sendAppError(w, ErrDBSaveError)
+		return
+	}
+	h.outbox.Notify()
+	learnFromCorrection(r.Context(), invoice)
+
+	var userMessage string
+	switch invoice.ExtractionStatus {
+	case "error":
+		userMessage = "Los datos corregidos no pasan la validación fiscal. Revisa los campos marcados."
+	case "review":
+		userMessage = "Algunos datos necesitan revisión. Por favor verifica los campos marcados."
+	default:
+		userMessage = "Factura corregida exitosamente."
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":           true,
+		"invoice_id":        invoiceID,
+		"extraction_status": invoice.ExtractionStatus,
+		"user_message":      userMessage,
+		"data":              clientInvoiceToFrontend(invoice),
+		"validation":        validationResult,
+		"cambios":           cambios,
+	})
+}
+
+// AnularClientInvoice - POST /api/facturas/{id}/anular
+// Registra el NCF como anulado (Formato 608) y marca la factura como 'anulada'.
+func (h *Handler) AnularClientInvoice(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, err := auth.GetClaimsFromContext(r.Context())
+	if err != nil {
+		h.sendError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if db.Pool == nil {
+		sendAppError(w, ErrDBUnavailable)
+		return
+	}
+
+	vars := mux.Vars(r)
+	invoiceID := vars["id"]
+
+	var body struct {
+		TipoAnulacion  string `json:"tipo_anulacion"`
+		FechaAnulacion string `json:"fecha_anulacion"` // YYYY-MM-DD, default hoy
+		Motivo         string `json:"motivo"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		h.sendError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if _, ok := tiposAnulacion608[body.TipoAnulacion]; !ok {
+		h.sendError(w, http.StatusBadRequest, "tipo_anulacion debe ser un código DGII 01-10")
+		return
+	}
+
+	fechaAnulacion := time.Now()
+	if body.FechaAnulacion != "" {
+		t, err := time.Parse("2006-01-02", body.FechaAnulacion)
+		if err != nil {
+			h.sendError(w, http.StatusBadRequest, "fecha_anulacion debe tener formato YYYY-MM-DD")
+			return
+		}
+		fechaAnulacion = t
+	}
+
+	invoice, err := db.GetClientInvoiceByID(r.Context(), claims.UserID, invoiceID)
+	if err != nil {
+		h.sendError(w, http.StatusNotFound, "invoice not found")
+		return
+	}
+
+	// Only NCFs issued by the client itself (ventas) are reported in the 608
+	if invoice.TipoFactura != "ingresos" {
+		h.sendError(w, http.StatusBadRequest, "solo se pueden anular comprobantes emitidos (facturas de ingresos)")
+		return
+	}
+	if invoice.NCF == "" {
+		h.sendError(w, http.StatusBadRequest, "la factura no tiene NCF")
+		return
+	}
+	if invoice.Estado == "anulada" {
+		h.sendError(w, http.StatusConflict, "la factura ya está anulada")
+		return
+	}
+	// The 608 line needs the issuer's RNC; check it before the status changes
+	rnc := cleanRNC(invoice.EmisorRNC)
+	if rnc == "" {
+		h.sendError(w, http.StatusBadRequest, "la factura no tiene RNC del emisor")
+		return
+	}
+
+	anulado := &db.ComprobanteAnulado{
+		RNC:              rnc,
+		NCF:              invoice.NCF,
+		TipoAnulacion:    body.TipoAnulacion,
+		FechaComprobante: invoice.FechaDocumento,
+		FechaAnulacion:   fechaAnulacion,
+		Motivo:           body.Motivo,
+	}
+
+	if err := db.AnularClientInvoice(r.Context(), claims.UserID, invoiceID, anulado); err != nil {
+		if errors.Is(err, db.ErrAlreadyAnulado) {
+			h.sendError(w, http.StatusConflict, err.Error())
+			return
+		}
+		log.Printf("AnularClientInvoice: DB error: %v", err)
+		h.sendError(w, http.StatusInternalServerError, "error anulando factura")
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"anulacion": anulado,
+		"message":   "comprobante anulado",
+	})
+}
+
 // GetClientInvoiceImage - GET /api/facturas/{id}/imagen - Proxy MinIO image
 // Validates client ownership when JWT is present
 func (h *Handler) GetClientInvoiceImage(w http.ResponseWriter, r *http.Request) {
@@ -470,8 +629,20 @@ func clientInvoiceToFrontend(inv *db.ClientInvoice) map[string]interface{} {
 		"confidence_score":    inv.ConfidenceScore,
 		"forma_pago":          inv.FormaPago,
 		"tipo_bien_servicio":  inv.TipoBienServicio,
+		"tipo_factura":        inv.TipoFactura,
+		"moneda":              inv.Moneda,
+		"tasa_cambio":         inv.TasaCambio,
+		"montos_originales":   json.RawMessage(nullIfEmpty(inv.MontosOriginalesJSON)),
 		"notas_cliente":       inv.NotasCliente,
 		"notas_contador":      inv.NotasContador,
 		"created_at":          inv.CreatedAt,
 	}
 }
+
+// nullIfEmpty returns a JSON null for empty stored JSON documents
+func nullIfEmpty(s string) string {
+	if s == "" {
+		return "null"
+	}
+	return s
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/facturaIA/invoice-ocr-service/internal/ai"
+)
+
+func TestReextractInvoiceValidates(t *testing.T) {
+	fake := ai.NewFakeProvider(ai.FakeJSON(validInvoice))
+	h := newTestHandler(fake)
+
+	updated, validation, err := h.reextractInvoice(context.Background(), []byte("image"), extractionHints{ClienteID: "cliente-1", FacturaID: "f-1"})
+	if err != nil {
+		t.Fatalf("reextractInvoice: %v", err)
+	}
+	if updated.ExtractionStatus != "validated" || !validation.Valid {
+		t.Errorf("extraction_status = %s, valid = %v; want validated", updated.ExtractionStatus, validation.Valid)
+	}
+	if updated.NCF != "B0100000001" || updated.Estado != "procesado" {
+		t.Errorf("updated = %+v", updated)
+	}
+}
+
+func TestReextractInvoiceFlagsInconsistentTotals(t *testing.T) {
+	inconsistent := map[string]interface{}{}
+	for k, v := range validInvoice {
+		inconsistent[k] = v
+	}
+	inconsistent["total"] = 500
+
+	h := newTestHandler(ai.NewFakeProvider(ai.FakeJSON(inconsistent)))
+	updated, _, err := h.reextractInvoice(context.Background(), []byte("image"), extractionHints{})
+	if err != nil {
+		t.Fatalf("reextractInvoice: %v", err)
+	}
+	if updated.ExtractionStatus == "validated" {
+		t.Error("a reprocessed invoice whose total does not add up is marked validated")
+	}
+	if updated.ReviewNotes == "" {
+		t.Error("review_notes lacks the validation errors")
+	}
+}
+
+func TestReprocesarClientInvoiceWithoutDatabase(t *testing.T) {
+	h := newTestHandler(ai.NewFakeProvider())
+
+	w := httptest.NewRecorder()
+	h.ReprocesarClientInvoice(w, httptest.NewRequest(http.MethodPost, "/api/facturas/f-1/reprocesar", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("without claims: status = %d, want 401", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	h.ReprocesarClientInvoice(w, withClaims(httptest.NewRequest(http.MethodPost, "/api/facturas/f-1/reprocesar", nil)))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("without database: status = %d, want 503", w.Code)
+	}
+}
@@ -69,6 +69,18 @@ var (
 		Message:     "File exceeds maximum size",
 		UserMessage: "La imagen es demasiado grande. El tamaño máximo es 20MB.",
 	}
+	ErrAIQuotaExceeded = AppError{
+		HTTPStatus:  429,
+		ErrorCode:   "ai_quota_exceeded",
+		Message:     "Monthly AI quota exceeded",
+		UserMessage: "Tu empresa alcanzó el límite mensual de procesamiento con IA. Contacta a tu contador o al administrador.",
+	}
+	ErrPeriodo606Finalizado = AppError{
+		HTTPStatus:  409,
+		ErrorCode:   "periodo_606_finalizado",
+		Message:     "Invoice belongs to a finalized 606 period",
+		UserMessage: "El 606 de este período ya fue finalizado. Crea una rectificativa para modificar sus facturas.",
+	}
 )
 
 // sendJSON sends a JSON response with the given status code and data
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/facturaIA/invoice-ocr-service/internal/ai"
+	"github.com/facturaIA/invoice-ocr-service/internal/db"
+	"github.com/facturaIA/invoice-ocr-service/internal/models"
+)
+
+const defaultExtractionCacheTTLDays = 90
+
+// extractionCacheTTL returns how long extraction results are reused, 0 when
+// the cache is disabled
+func (h *Handler) extractionCacheTTL() time.Duration {
+	days := h.config.AI.Cache.TTLDays
+	if days < 0 {
+		return 0
+	}
+	if days == 0 {
+		days = defaultExtractionCacheTTLDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// providerModel returns the model an extraction uses: modelName or the
+// configured one of the provider
+func (h *Handler) providerModel(providerName, modelName string) string {
+	if modelName != "" {
+		return modelName
+	}
+	switch providerName {
+	case "openai":
+		return h.config.AI.OpenAI.Model
+	case "gemini":
+		return h.config.AI.Gemini.Model
+	case "ollama":
+		return h.config.AI.Ollama.Model
+	}
+	return ""
+}
+
+// extractionCacheKey identifies an extraction of a cliente by the SHA-256 of
+// the image, the prompt, the model and whether the AI saw it redacted; in
+// text mode the Tesseract language is part of the model, since it decides the
+// text the model reads
+func (h *Handler) extractionCacheKey(imageData []byte, prompt *ai.Prompt, clienteID string, redacted, vision bool, providerName, modelName, language string) db.ExtractionCacheKey {
+	sum := sha256.Sum256(imageData)
+	model := providerName + "/" + h.providerModel(providerName, modelName)
+	if !vision {
+		model = fmt.Sprintf("tesseract-%s+%s", language, model)
+	}
+	key := db.ExtractionCacheKey{
+		ClienteID:     clienteID,
+		ImageSHA256:   hex.EncodeToString(sum[:]),
+		PromptVersion: prompt.Revision(),
+		Vision:        vision,
+		Model:         model,
+	}
+	if redacted {
+		key.Redaction = "redact"
+	}
+	return key
+}
+
+// cachedExtraction returns the stored extraction of key and its OCR text. A
+// miss or a lookup error means extracting again, and so does a correction
+// example of the emisor: only results extracted without examples are cached,
+// and one would be in the prompt now.
+func (h *Handler) cachedExtraction(ctx context.Context, key db.ExtractionCacheKey, hints extractionHints) (*models.Invoice, string, bool) {
+	cached, err := db.GetCachedExtraction(ctx, key, h.extractionCacheTTL())
+	if err != nil {
+		log.Printf("processInvoice: extraction cache error: %v", err)
+		return nil, "", false
+	}
+	if cached == nil {
+		return nil, "", false
+	}
+	var invoice models.Invoice
+	if err := json.Unmarshal(cached.Invoice, &invoice); err != nil {
+		log.Printf("processInvoice: invalid cached extraction %s: %v", key.ImageSHA256, err)
+		return nil, "", false
+	}
+	if h.hasExamples(ctx, hints, hints.EmisorRNC, invoice.RNCEmisor) {
+		fmt.Printf("[Process] Extraction cache skipped: %s has correction examples now\n", key.ImageSHA256[:12])
+		return nil, "", false
+	}
+	fmt.Printf("[Process] Extraction cache hit: %s (%s, %s, from %s)\n",
+		key.ImageSHA256[:12], key.PromptVersion, key.Model, cached.CreatedAt.Format(time.RFC3339))
+	return &invoice, cached.OCRText, true
+}
+
+// cacheExtraction stores an extraction for the next upload or reprocess of
+// the same image. Failures are only logged.
+func (h *Handler) cacheExtraction(ctx context.Context, key db.ExtractionCacheKey, invoice *models.Invoice, ocrText string) {
+	data, err := json.Marshal(invoice)
+	if err == nil {
+		err = db.SaveCachedExtraction(ctx, key, data, ocrText)
+	}
+	if err != nil {
+		log.Printf("processInvoice: extraction cache save error: %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/facturaIA/invoice-ocr-service/internal/ai"
+	"github.com/facturaIA/invoice-ocr-service/internal/models"
+)
+
+func TestExtractionCacheKey(t *testing.T) {
+	h := NewHandler(&models.Config{AI: models.AIConfig{Gemini: models.GeminiConfig{Model: "gemini-2.0-flash"}}})
+	prompt := ai.DefaultPrompt()
+	image := []byte("receipt")
+
+	key := h.extractionCacheKey(image, prompt, "cliente-1", false, true, "gemini", "", "spa")
+	if len(key.ImageSHA256) != 64 || key.PromptVersion != prompt.Revision() || key.Model != "gemini/gemini-2.0-flash" ||
+		key.ClienteID != "cliente-1" || key.Redaction != "" {
+		t.Errorf("key = %+v", key)
+	}
+	if again := h.extractionCacheKey([]byte("receipt"), prompt, "cliente-1", false, true, "gemini", "gemini-2.0-flash", "spa"); again != key {
+		t.Errorf("same image, prompt and model: %+v != %+v", again, key)
+	}
+	if other := h.extractionCacheKey([]byte("receipt2"), prompt, "cliente-1", false, true, "gemini", "", "spa"); other.ImageSHA256 == key.ImageSHA256 {
+		t.Error("different images share a key")
+	}
+	if other := h.extractionCacheKey(image, prompt, "cliente-1", false, true, "gemini", "gemini-1.5-pro", "spa"); other == key {
+		t.Error("different models share a key")
+	}
+	if other := h.extractionCacheKey(image, prompt, "cliente-2", false, true, "gemini", "", "spa"); other == key {
+		t.Error("different clientes share a key")
+	}
+	if redacted := h.extractionCacheKey(image, prompt, "cliente-1", true, true, "gemini", "", "spa"); redacted == key || redacted.Redaction != "redact" {
+		t.Errorf("redacted key = %+v", redacted)
+	}
+	text := h.extractionCacheKey(image, prompt, "cliente-1", false, false, "ollama", "mistral", "spa")
+	if text.Vision || text.Model != "tesseract-spa+ollama/mistral" {
+		t.Errorf("text mode key = %+v", text)
+	}
+}
+
+func TestExtractionCacheTTL(t *testing.T) {
+	h := NewHandler(&models.Config{})
+	if ttl := h.extractionCacheTTL(); ttl != defaultExtractionCacheTTLDays*24*time.Hour {
+		t.Errorf("default TTL = %v", ttl)
+	}
+	h.config.AI.Cache.TTLDays = -1
+	if ttl := h.extractionCacheTTL(); ttl != 0 {
+		t.Errorf("disabled TTL = %v, want 0", ttl)
+	}
+}
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/facturaIA/invoice-ocr-service/internal/ai"
+	"github.com/facturaIA/invoice-ocr-service/internal/db"
+	"github.com/facturaIA/invoice-ocr-service/internal/models"
+)
+
+const (
+	defaultFewShotExamples    = 3
+	defaultFewShotTokenBudget = 1500
+)
+
+// exampleFields are the facturas_clientes columns kept in correction examples,
+// with their key in the extraction prompt's JSON
+var exampleFields = []struct{ column, promptKey string }{
+	{"ncf", "ncf"},
+	{"tipo_ncf", "tipoNcf"},
+	{"ncf_modifica", "ncfModifica"},
+	{"emisor_rnc", "rncEmisor"},
+	{"proveedor", "nombreEmisor"},
+	{"tipo_id_emisor", "tipoIdEmisor"},
+	{"receptor_rnc", "rncReceptor"},
+	{"receptor_nombre", "nombreReceptor"},
+	{"tipo_id_receptor", "tipoIdReceptor"},
+	{"fecha_documento", "fechaFactura"},
+	{"hora_factura", "horaFactura"},
+	{"fecha_pago", "fechaPago"},
+	{"subtotal", "subtotal"},
+	{"descuento", "descuento"},
+	{"monto_servicios", "montoServicios"},
+	{"monto_bienes", "montoBienes"},
+	{"itbis", "itbis"},
+	{"itbis_tasa", "itbisTasa"},
+	{"itbis_retenido", "itbisRetenido"},
+	{"itbis_retenido_porcentaje", "itbisRetenidoPorcentaje"},
+	{"itbis_exento", "itbisExento"},
+	{"isr", "isr"},
+	{"retencion_isr_tipo", "retencionIsrTipo"},
+	{"isc", "isc"},
+	{"isc_categoria", "iscCategoria"},
+	{"cdt_monto", "cdtMonto"},
+	{"cargo_911", "cargo911"},
+	{"propina", "propina"},
+	{"otros_impuestos", "otrosImpuestos"},
+	{"monto_no_facturable", "montoNoFacturable"},
+	{"monto", "total"},
+	{"forma_pago", "formaPago"},
+	{"tipo_bien_servicio", "tipoBienServicio"},
+}
+
+// extractionHints identify the invoice being extracted, so the client's
+// corrected examples of its emisor are added to the prompt and the tenant's
+// prompt version is used
+type extractionHints struct {
+	ClienteID    string
+	EmpresaAlias string // Tenant: prompt version, PII policy and quota
+	FacturaID    string // Invoice being extracted again, left out of its own examples
+	EmisorRNC    string // Emisor already known (reprocessing)
+	Force        bool   // Skip the extraction cache
+}
+
+// fewShotLimits returns the configured examples per prompt and their token
+// budget
+func (h *Handler) fewShotLimits() (int, int) {
+	maxExamples := h.config.AI.FewShot.MaxExamples
+	if maxExamples == 0 {
+		maxExamples = defaultFewShotExamples
+	}
+	budget := h.config.AI.FewShot.TokenBudget
+	if budget <= 0 {
+		budget = defaultFewShotTokenBudget
+	}
+	return maxExamples, budget
+}
+
+// hasExamples reports whether the client has correction examples of any of
+// the emisors, that an extraction would put in its prompt. A lookup error
+// counts as having them.
+func (h *Handler) hasExamples(ctx context.Context, hints extractionHints, emisorRNCs ...string) bool {
+	maxExamples, _ := h.fewShotLimits()
+	if maxExamples < 0 || hints.ClienteID == "" || db.Pool == nil {
+		return false
+	}
+	rncs := make([]string, 0, len(emisorRNCs))
+	for _, rnc := range emisorRNCs {
+		if rnc != "" {
+			rncs = append(rncs, rnc)
+		}
+	}
+	if len(rncs) == 0 {
+		return false
+	}
+	stored, err := db.GetExtractionExamples(ctx, hints.ClienteID, rncs, hints.FacturaID, 1)
+	if err != nil {
+		log.Printf("processInvoice: error loading correction examples: %v", err)
+		return true
+	}
+	return len(stored) > 0
+}
+
+// useExamples sets up extractor with the client's corrected examples. Lookup
+// errors only cost the examples.
+func (h *Handler) useExamples(ctx context.Context, extractor *ai.Extractor, hints extractionHints) {
+	maxExamples, budget := h.fewShotLimits()
+	if maxExamples < 0 || hints.ClienteID == "" || db.Pool == nil {
+		return
+	}
+	extractor.UseExamples(func(rncs []string) []ai.Example {
+		stored, err := db.GetExtractionExamples(ctx, hints.ClienteID, rncs, hints.FacturaID, maxExamples)
+		if err != nil {
+			log.Printf("processInvoice: error loading correction examples: %v", err)
+			return nil
+		}
+		examples := make([]ai.Example, 0, len(stored))
+		for _, ex := range stored {
+			examples = append(examples, promptExample(ex))
+		}
+		return examples
+	}, hints.EmisorRNC, budget)
+	extractor.UseSecondPass(h.config.AI.FewShot.SecondPass)
+}
+
+// promptExample converts a stored example to prompt keys and values
+func promptExample(ex db.ExtractionExample) ai.Example {
+	campos := make(map[string]bool, len(ex.Campos))
+	for _, c := range ex.Campos {
+		campos[c] = true
+	}
+	example := ai.Example{Corrected: map[string]interface{}{}}
+	for _, f := range exampleFields {
+		correct, ok := ex.Corrected[f.column]
+		if !ok {
+			continue
+		}
+		example.Corrected[f.promptKey] = promptValue(correct)
+		if campos[f.column] {
+			example.Corrections = append(example.Corrections, ai.FieldCorrection{
+				Field:     f.promptKey,
+				Extracted: promptValue(ex.AIOutput[f.column]),
+				Correct:   promptValue(correct),
+			})
+		}
+	}
+	return example
+}
+
+// promptValue decodes a stored value, with dates as YYYY-MM-DD like the
+// prompt asks for them
+func promptValue(raw json.RawMessage) interface{} {
+	var v interface{}
+	if len(raw) == 0 || json.Unmarshal(raw, &v) != nil {
+		return nil
+	}
+	if s, ok := v.(string); ok {
+		if t, err := time.Parse(time.RFC3339, s); err == nil {
+			return t.Format("2006-01-02")
+		}
+	}
+	return v
+}
+
+// learnFromCorrection keeps a corrected invoice, with what the AI extracted
+// for it, as an example for the next invoices of its emisor. Invoices without
+// a stored extraction have nothing to learn from. Failures are only logged:
+// the correction is already saved.
+func learnFromCorrection(ctx context.Context, inv *db.ClientInvoice) {
+	if inv.RawOCRJSON == "" {
+		return
+	}
+	var extracted models.Invoice
+	if err := json.Unmarshal([]byte(inv.RawOCRJSON), &extracted); err != nil {
+		log.Printf("learnFromCorrection: factura %s: raw_ocr_json inválido: %v", inv.ID, err)
+		return
+	}
+
+	columns := make([]string, len(exampleFields))
+	for i, f := range exampleFields {
+		columns[i] = f.column
+	}
+	if err := db.SaveExtractionExample(ctx, inv, clientInvoiceFromExtraction(&extracted), columns); err != nil {
+		log.Printf("learnFromCorrection: factura %s: DB error: %v", inv.ID, err)
+	}
+}
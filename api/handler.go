@@ -24,6 +24,8 @@ import (
 	"github.com/facturaIA/invoice-ocr-service/internal/db"
 	"github.com/facturaIA/invoice-ocr-service/internal/models"
 	"github.com/facturaIA/invoice-ocr-service/internal/ocr"
+	"github.com/facturaIA/invoice-ocr-service/internal/outbox"
+	"github.com/facturaIA/invoice-ocr-service/internal/progress"
 	"github.com/facturaIA/invoice-ocr-service/internal/services"
 	"github.com/facturaIA/invoice-ocr-service/internal/storage"
 )
@@ -35,14 +37,29 @@ const (
 
 // Handler handles HTTP requests for invoice processing
 type Handler struct {
-	config *models.Config
+	config        *models.Config
+	exchangeRates services.ExchangeRateSource
+	jobWake       chan struct{}
+	outbox        *outbox.Dispatcher
+	progress      *progress.Broker
+	prompts       *ai.PromptLibrary
+
+	// Builds the AI provider of an extraction: createProvider, or a fake in tests
+	newProvider func(providerName, modelName string) (ai.Provider, error)
 }
 
 // NewHandler creates a new API handler
 func NewHandler(config *models.Config) *Handler {
-	return &Handler{
-		config: config,
-	}
+	h := &Handler{
+		config:        config,
+		exchangeRates: services.NewExchangeRateSource(config.ExchangeRates),
+		jobWake:       make(chan struct{}, 1),
+		outbox:        newOutboxDispatcher(),
+		progress:      progress.NewBroker(),
+		prompts:       loadPromptLibrary(config.AI.Prompts.Dir),
+	}
+	h.newProvider = h.createProvider
+	return h
 }
 
 // SetupRoutes configures the HTTP routes
@@ -66,12 +83,41 @@ func (h *Handler) SetupRoutes() *mux.Router {
 
 	// === ALIAS PARA FRONTEND FACTURAIA ===
 	router.HandleFunc("/api/facturas/upload/", h.ProcessInvoice).Methods("POST")
+	router.HandleFunc("/api/facturas/batch/", h.UploadBatch).Methods("POST")
+	router.HandleFunc("/api/facturas/batch/{id}", h.GetUploadBatch).Methods("GET")
 	router.HandleFunc("/api/facturas/mis-facturas/", h.GetClientInvoices).Methods("GET")
 	router.HandleFunc("/api/facturas/resumen", h.GetClientStats).Methods("GET")
 	router.Handle("/api/facturas/{id}/reprocesar", auth.RequireRole("admin", "contador")(http.HandlerFunc(h.ReprocesarClientInvoice))).Methods("POST")
 	router.HandleFunc("/api/facturas/{id}/imagen", h.GetClientInvoiceImage).Methods("GET")
+	router.HandleFunc("/api/facturas/{id}/anular", h.AnularClientInvoice).Methods("POST")
+	router.HandleFunc("/api/facturas/{id}/history", h.GetClientInvoiceHistory).Methods("GET")
+	router.HandleFunc("/api/facturas/{id}/revert", h.RevertClientInvoice).Methods("POST")
 	router.HandleFunc("/api/facturas/{id}", h.GetClientInvoice).Methods("GET")
 	router.HandleFunc("/api/facturas/{id}", h.DeleteClientInvoice).Methods("DELETE")
+	router.HandleFunc("/api/facturas/{id}", h.PatchClientInvoice).Methods("PATCH")
+
+	// === COLA DE REVISION (contador) ===
+	reviewer := auth.RequireRole("admin", "contador")
+	router.Handle("/api/revision/cola", reviewer(http.HandlerFunc(h.GetReviewQueue))).Methods("GET")
+	router.Handle("/api/revision/{id}/tomar", reviewer(http.HandlerFunc(h.ClaimReviewItem))).Methods("POST")
+	router.Handle("/api/revision/{id}/liberar", reviewer(http.HandlerFunc(h.ReleaseReviewItem))).Methods("POST")
+	router.Handle("/api/revision/{id}/corregir", reviewer(http.HandlerFunc(h.CorrectReviewItem))).Methods("PUT")
+	router.Handle("/api/revision/{id}/aprobar", reviewer(http.HandlerFunc(h.ApproveReviewItem))).Methods("POST")
+	router.Handle("/api/revision/{id}/rechazar", reviewer(http.HandlerFunc(h.RejectReviewItem))).Methods("POST")
+	router.Handle("/api/revision/{id}", reviewer(http.HandlerFunc(h.GetReviewItem))).Methods("GET")
+
+	// === WEBHOOKS ===
+	router.HandleFunc("/api/webhooks", h.CreateWebhookEndpoint).Methods("POST")
+	router.HandleFunc("/api/webhooks", h.GetWebhookEndpoints).Methods("GET")
+	router.HandleFunc("/api/webhooks/deliveries/{id}/replay", h.ReplayWebhookDelivery).Methods("POST")
+	router.HandleFunc("/api/webhooks/{id}/deliveries", h.GetWebhookDeliveries).Methods("GET")
+	router.HandleFunc("/api/webhooks/{id}", h.DeleteWebhookEndpoint).Methods("DELETE")
+
+	// === JOBS DE PROCESAMIENTO ASINCRONO ===
+	router.HandleFunc("/api/jobs/{id}", h.GetJob).Methods("GET")
+
+	// === PROGRESO DE PROCESAMIENTO (SSE) ===
+	router.HandleFunc("/api/progress/{id}", h.StreamProgress).Methods("GET")
 
 	// === VALIDACION IMPUESTOS DGII ===
 	router.HandleFunc("/api/v1/invoices/validate", h.ValidateInvoiceTaxes).Methods("POST")
@@ -82,12 +128,51 @@ func (h *Handler) SetupRoutes() *mux.Router {
 	// === SHAREPOINT SYNC MONITORING ===
 	router.Handle("/api/admin/sharepoint-queue", auth.RequireRole("admin")(http.HandlerFunc(h.GetSharePointQueueStatus))).Methods("GET")
 
+	// === OUTBOX (efectos secundarios) ===
+	router.Handle("/api/admin/outbox", auth.RequireRole("admin")(http.HandlerFunc(h.GetOutboxMetrics))).Methods("GET")
+
+	// === TASAS DE CAMBIO (BCRD) ===
+	router.Handle("/api/admin/tasas-cambio", auth.RequireRole("admin")(http.HandlerFunc(h.ImportTasasCambio))).Methods("POST")
+
+	// === PROMPTS DE EXTRACCIÓN (versiones) ===
+	router.Handle("/api/admin/prompts", auth.RequireRole("admin")(http.HandlerFunc(h.GetPrompts))).Methods("GET")
+	router.Handle("/api/admin/prompts/reload", auth.RequireRole("admin")(http.HandlerFunc(h.ReloadPrompts))).Methods("POST")
+
+	// === CONSUMO DE IA (tokens y costo por empresa) ===
+	router.Handle("/api/admin/ai-usage", auth.RequireRole("admin")(http.HandlerFunc(h.GetAIUsage))).Methods("GET")
+	router.Handle("/api/admin/ai-usage/{empresa}", auth.RequireRole("admin")(http.HandlerFunc(h.GetEmpresaAIUsage))).Methods("GET")
+
 	// === FORMATO 606 DGII ===
 	router.HandleFunc("/api/formato-606/{rnc_receptor}/preview", h.GetFormato606Preview).Methods("GET")
 	router.HandleFunc("/api/formato-606/{rnc_receptor}/validate", h.ValidateFormato606).Methods("POST")
+	router.HandleFunc("/api/formato-606/{rnc_receptor}/validate/reporte", h.GetReporteErrores606).Methods("GET")
+	router.HandleFunc("/api/formato-606/{rnc_receptor}/envios", h.GetEnvios606).Methods("GET")
+	router.HandleFunc("/api/formato-606/{rnc_receptor}/diff", h.GetFormato606Diff).Methods("GET")
+	router.HandleFunc("/api/formato-606/{rnc_receptor}/rectificar", h.RectificarFormato606).Methods("POST")
 	router.HandleFunc("/api/formato-606/{rnc_receptor}", h.GetFormato606).Methods("GET")
 	router.HandleFunc("/api/formato-606/factura/{id}/toggle-aplica606", h.ToggleAplica606).Methods("PUT")
 	router.HandleFunc("/api/envios-606/{id}/referencia", h.UpdateEnvio606Referencia).Methods("PUT")
+	router.HandleFunc("/api/envios-606/{id}/finalizar", h.FinalizarEnvio606).Methods("POST")
+
+	// === FORMATO 607 DGII (ventas) ===
+	router.HandleFunc("/api/formato-607/{rnc_emisor}/preview", h.GetFormato607Preview).Methods("GET")
+	router.HandleFunc("/api/formato-607/{rnc_emisor}/validate", h.ValidateFormato607).Methods("POST")
+	router.HandleFunc("/api/formato-607/{rnc_emisor}", h.GetFormato607).Methods("GET")
+	router.HandleFunc("/api/envios-607/{id}/referencia", h.UpdateEnvio607Referencia).Methods("PUT")
+
+	// === FORMATO 608 DGII (comprobantes anulados) ===
+	router.HandleFunc("/api/formato-608/{rnc}/preview", h.GetFormato608Preview).Methods("GET")
+	router.HandleFunc("/api/formato-608/{rnc}/validate", h.ValidateFormato608).Methods("POST")
+	router.HandleFunc("/api/formato-608/{rnc}", h.GetFormato608).Methods("GET")
+	router.HandleFunc("/api/envios-608/{id}/referencia", h.UpdateEnvio608Referencia).Methods("PUT")
+
+	// === IT-1 DGII (declaración ITBIS) ===
+	router.HandleFunc("/api/it1/{rnc}/export", h.ExportIT1).Methods("GET")
+	router.HandleFunc("/api/it1/{rnc}", h.GetIT1).Methods("GET")
+
+	// === IR-17 DGII (retenciones) ===
+	router.HandleFunc("/api/ir17/{rnc}/export", h.ExportIR17).Methods("GET")
+	router.HandleFunc("/api/ir17/{rnc}", h.GetIR17).Methods("GET")
 
 	return router
 }
@@ -251,7 +336,6 @@ func (h *Handler) ProcessInvoice(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	ctx := r.Context()
-	startTime := time.Now()
 
 	// Get claims from JWT
 	claims, err := auth.GetClaimsFromContext(ctx)
@@ -286,7 +370,77 @@ func (h *Handler) ProcessInvoice(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get optional parameters
+	contentType := header.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "image/jpeg"
+	}
+
+	params := h.uploadParamsFromForm(r, claims.UserID, claims.EmpresaAlias)
+	params.ImageData = imageData
+	params.ContentType = contentType
+
+	// Progress: the client picks an upload ID and follows /api/progress/{id}
+	params.UploadID = r.Header.Get("X-Upload-ID")
+	if params.UploadID == "" {
+		params.UploadID = r.FormValue("upload_id")
+	}
+	var tracker *progress.Tracker
+	if params.UploadID != "" {
+		tracker = h.progress.Tracker(progress.Key(claims.UserID, params.UploadID))
+		ctx = progress.WithTracker(ctx, tracker)
+		tracker.Emit(progress.Event{Stage: progress.StageReceived, Message: "Archivo recibido",
+			Data: map[string]interface{}{"bytes": len(imageData), "content_type": contentType}})
+	}
+
+	// Idempotency-Key: a retry of a request already seen gets the stored
+	// response instead of a second extraction
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+	if idempotencyKey != "" {
+		var handled bool
+		idempotencyKey, handled = h.claimIdempotencyKey(w, claims.UserID, idempotencyKey, imageData)
+		if handled {
+			return
+		}
+		if idempotencyKey != "" {
+			// Finish even if the client stops waiting: its retry gets the result
+			ctx = context.WithoutCancel(ctx)
+		}
+	}
+
+	var res uploadResult
+	if h.wantsAsync(r) {
+		// Async: persist the job and answer immediately with its ID
+		res = h.enqueueUpload(ctx, params)
+	} else {
+		res = h.runUpload(ctx, params)
+		emitUploadDone(tracker, res)
+	}
+
+	if idempotencyKey != "" {
+		completeIdempotencyKey(claims.UserID, idempotencyKey, res)
+	}
+	w.WriteHeader(res.Status)
+	json.NewEncoder(w).Encode(res.Body)
+}
+
+// emitUploadDone publishes the final event of an upload
+func emitUploadDone(tracker *progress.Tracker, res uploadResult) {
+	ev := progress.Event{Stage: progress.StageDone, Error: res.Err,
+		Data: map[string]interface{}{"status": res.Status}}
+	if res.FacturaID != "" {
+		ev.Data["factura_id"] = res.FacturaID
+	}
+	if res.Err == "" {
+		ev.Message = "Procesamiento terminado"
+	} else {
+		ev.Message = "Procesamiento terminado con error"
+	}
+	tracker.Emit(ev)
+}
+
+// uploadParamsFromForm reads the optional processing options of an upload
+// form (aiProvider, model, language, useVisionModel, force)
+func (h *Handler) uploadParamsFromForm(r *http.Request, clienteID, empresaAlias string) *UploadParams {
 	aiProvider := r.FormValue("aiProvider")
 	if aiProvider == "" {
 		aiProvider = h.config.AI.DefaultProvider
@@ -296,17 +450,56 @@ func (h *Handler) ProcessInvoice(w http.ResponseWriter, r *http.Request) {
 	useVisionModelParam := r.FormValue("useVisionModel")
 	useVisionModel := useVisionModelParam == "true" || (useVisionModelParam == "" && (aiProvider == "gemini" || aiProvider == "openai"))
 
-	model := r.FormValue("model")
 	language := r.FormValue("language")
 	if language == "" {
 		language = h.config.OCR.Language
 	}
 
-	// Generate unique filename
-	contentType := header.Header.Get("Content-Type")
-	if contentType == "" {
-		contentType = "image/jpeg"
+	return &UploadParams{
+		ClienteID:      clienteID,
+		EmpresaAlias:   empresaAlias,
+		AIProvider:     aiProvider,
+		Model:          r.FormValue("model"),
+		Language:       language,
+		UseVisionModel: useVisionModel,
+		Force:          r.FormValue("force") == "true",
 	}
+}
+
+// UploadParams is everything runUpload needs from an upload request. It is
+// also the payload persisted for async jobs.
+type UploadParams struct {
+	ClienteID      string `json:"cliente_id"`
+	EmpresaAlias   string `json:"empresa_alias"`
+	ImageData      []byte `json:"-"`
+	ContentType    string `json:"content_type"`
+	AIProvider     string `json:"ai_provider"`
+	Model          string `json:"model,omitempty"`
+	Language       string `json:"language,omitempty"`
+	UseVisionModel bool   `json:"use_vision_model"`
+	Force          bool   `json:"force,omitempty"`     // Extract again even if the image is in the extraction cache
+	UploadID       string `json:"upload_id,omitempty"` // Client-chosen progress stream ID
+}
+
+// uploadResult is the HTTP status and JSON body an upload produces. Err is set
+// when the invoice could not be processed (used as the job error).
+type uploadResult struct {
+	Status    int
+	Body      interface{}
+	Err       string
+	FacturaID string
+}
+
+// runUpload stores the image, extracts, validates, dedups and saves the
+// invoice. Shared by the synchronous upload and the job workers.
+func (h *Handler) runUpload(ctx context.Context, p *UploadParams) uploadResult {
+	startTime := time.Now()
+	imageData := p.ImageData
+	contentType := p.ContentType
+	tracker := progress.FromContext(ctx)
+	var err error
+
+	// Generate unique filename
 	filename := fmt.Sprintf("%s_%s%s",
 		time.Now().Format("20060102_150405"),
 		uuid.New().String()[:8],
@@ -319,7 +512,7 @@ func (h *Handler) ProcessInvoice(w http.ResponseWriter, r *http.Request) {
 		imageReader := bytes.NewReader(imageData)
 		imagenURL, err = storage.UploadInvoiceImage(
 			ctx,
-			claims.EmpresaAlias,
+			p.EmpresaAlias,
 			filename,
 			imageReader,
 			int64(len(imageData)),
@@ -328,154 +521,100 @@ func (h *Handler) ProcessInvoice(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			// Log but don't fail - image storage is optional
 			fmt.Printf("Warning: failed to upload image to MinIO: %v\n", err)
+			tracker.Emit(progress.Event{Stage: progress.StageStored, Message: "No se pudo guardar la imagen; se continúa", Error: err.Error()})
+		} else {
+			tracker.Stage(progress.StageStored, "Imagen guardada")
 		}
 	}
 
 	// Process OCR
 	invoice, ocrDuration, aiDuration, _, err := h.processInvoice(
+		ctx,
 		imageData,
-		useVisionModel,
-		aiProvider,
-		model,
-		language,
+		p.UseVisionModel,
+		p.AIProvider,
+		p.Model,
+		p.Language,
+		extractionHints{ClienteID: p.ClienteID, EmpresaAlias: p.EmpresaAlias, Force: p.Force},
 	)
 
 	totalDuration := time.Since(startTime).Seconds()
 
 	if err != nil {
+		if errors.Is(err, errAIQuotaExceeded) {
+			appErr := ErrAIQuotaExceeded
+			return uploadResult{Status: appErr.HTTPStatus, Body: map[string]interface{}{
+				"success":      false,
+				"error_code":   appErr.ErrorCode,
+				"error":        appErr.Message,
+				"user_message": appErr.UserMessage,
+			}, Err: appErr.ErrorCode}
+		}
 		if errors.Is(err, ai.ErrAllProvidersFailed) {
 			// All AI providers failed transiently — save invoice for manual review
 			// The image is already in MinIO (uploaded before processInvoice was called)
 			log.Printf("[OCR] All AI providers failed: %v. Saving as revision_manual.", err)
+			facturaID := ""
 			if db.Pool != nil && imagenURL != "" {
 				manualInvoice := &db.ClientInvoice{
-					ClienteID:        claims.UserID,
+					ClienteID:        p.ClienteID,
+					EmpresaAlias:     p.EmpresaAlias,
 					ArchivoURL:       imagenURL,
 					ArchivoNombre:    "factura_scan.jpg",
 					Estado:           "procesado",
 					ExtractionStatus: "revision_manual",
 					ReviewNotes:      fmt.Sprintf(`{"error":"all_providers_failed","detail":%q}`, err.Error()),
 				}
-				if saveErr := db.SaveClientInvoice(ctx, manualInvoice); saveErr != nil {
+				if saveErr := db.SaveClientInvoiceWithOutbox(ctx, manualInvoice, func(inv *db.ClientInvoice) []db.OutboxEvent {
+					return extractionWebhookEvent(inv, inv.ID)
+				}); saveErr != nil {
 					log.Printf("[OCR] Failed to save revision_manual invoice: %v", saveErr)
+				} else {
+					facturaID = manualInvoice.ID
+					h.outbox.Notify()
 				}
 			}
-			w.WriteHeader(http.StatusOK)
-			json.NewEncoder(w).Encode(map[string]interface{}{
+			return uploadResult{Status: http.StatusOK, Body: map[string]interface{}{
 				"success":           true,
 				"extraction_status": "revision_manual",
 				"user_message":      "Tu factura fue guardada. La IA no está disponible temporalmente. Un contador la revisará pronto.",
 				"totalDuration":     time.Since(startTime).Seconds(),
-			})
-			return
+			}, FacturaID: facturaID}
 		}
 		response := models.ProcessResponse{
 			Success:       false,
 			Error:         err.Error(),
 			TotalDuration: totalDuration,
 		}
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(response)
-		return
-	}
-
-	// === PASO: Validación cruzada de impuestos ===
-
-	// Calcular montoServicios/montoBienes con fallback al subtotal
-	montoServicios := decimalToFloat64(invoice.MontoServicios)
-	montoBienes := decimalToFloat64(invoice.MontoBienes)
-	// Fallback: si la IA no separó servicios/bienes, usar subtotal
-	if montoServicios == 0 && montoBienes == 0 {
-		montoServicios = decimalToFloat64(invoice.Subtotal)
-	}
-
-	// FechaPago: solo si no es zero
-	fechaPagoStr := ""
-	if !invoice.FechaPago.IsZero() {
-		fechaPagoStr = invoice.FechaPago.Format("2006-01-02")
-	}
-
-	// NCFVencimiento: solo si no es zero
-	ncfVencimientoStr := ""
-	if !invoice.FechaVencimiento.IsZero() {
-		ncfVencimientoStr = invoice.FechaVencimiento.Format("2006-01-02")
-	}
-
-	validationInput := &services.InvoiceInput{
-		MontoServicios:          montoServicios,
-		MontoBienes:             montoBienes,
-		Descuento:               decimalToFloat64(invoice.Descuento),
-		ITBISFacturado:          decimalToFloat64(invoice.ITBIS),
-		ITBISTasa:               decimalToFloat64(invoice.ITBISTasa),
-		ITBISExento:             decimalToFloat64(invoice.ITBISExento),
-		ITBISRetenido:           decimalToFloat64(invoice.ITBISRetenido),
-		ITBISProporcionalidad:   decimalToFloat64(invoice.ITBISProporcionalidad),
-		ITBISCosto:              decimalToFloat64(invoice.ITBISCosto),
-		ISCMonto:                decimalToFloat64(invoice.ISC),
-		ISCCategoria:            invoice.ISCCategoria,
-		CDTMonto:                decimalToFloat64(invoice.CDTMonto),
-		Cargo911:                decimalToFloat64(invoice.Cargo911),
-		PropinaLegal:            decimalToFloat64(invoice.Propina),
-		OtrosImpuestos:          decimalToFloat64(invoice.OtrosImpuestos),
-		MontoNoFacturable:       decimalToFloat64(invoice.MontoNoFacturable),
-		RetencionISRTipo:        invoice.RetencionISRTipo,
-		RetencionISRMonto:       decimalToFloat64(invoice.ISR),
-		TotalFactura:            decimalToFloat64(invoice.Total),
-		NCF:                     invoice.NCF,
-		NCFModifica:             invoice.NCFModifica,
-		TipoNCF:                 invoice.TipoNCF,
-		ITBISRetenidoPorcentaje: invoice.ITBISRetenidoPorcentaje,
-		FechaPago:               fechaPagoStr,
-		NCFVencimiento:          ncfVencimientoStr,
+		return uploadResult{Status: http.StatusOK, Body: response, Err: err.Error()}
 	}
 
-	validator := services.NewTaxValidator()
-	validationResult := validator.Validate(validationInput)
-
-	// Determinar extraction_status según validación y confidence
-	extractionStatus := "validated"
-	if !validationResult.Valid {
-		extractionStatus = "error"
-	} else if validationResult.NeedsReview && invoice.Confidence < 0.75 {
-		extractionStatus = "review"
-		validationResult.NeedsReview = true
+	// === PASO: Conversión a DOP (facturas en moneda extranjera) ===
+	// Los montos se convierten antes de validar para que el validador y el 606
+	// trabajen siempre en pesos; los originales quedan en MontosOriginales.
+	conversionErr := services.ConvertirADOP(ctx, h.exchangeRates, invoice)
+	if conversionErr != nil {
+		log.Printf("[OCR] Conversión %s→DOP falló: %v", invoice.Moneda, conversionErr)
+		tracker.Emit(progress.Event{Stage: progress.StageConverted, Message: "Conversión a DOP no disponible", Error: conversionErr.Error()})
+	} else if invoice.Moneda != "" && invoice.Moneda != "DOP" {
+		tracker.Emit(progress.Event{Stage: progress.StageConverted, Message: "Montos convertidos a DOP",
+			Data: map[string]interface{}{"moneda": invoice.Moneda, "tasa_cambio": decimalToFloat64(invoice.TasaCambio)}})
 	}
 
-	// Serializar errores/warnings para review_notes
-	reviewNotes := ""
-	if len(validationResult.Errors) > 0 || len(validationResult.Warnings) > 0 {
-		if rn, err := json.Marshal(validationResult); err == nil {
-			reviewNotes = string(rn)
-		}
-	}
+	// === PASO: Validación cruzada de impuestos ===
+	validationInput, validationResult, extractionStatus, reviewNotes := validateExtraction(invoice, conversionErr)
+	tracker.Emit(progress.Event{Stage: progress.StageValidated, Message: "Impuestos validados",
+		Data: map[string]interface{}{"extraction_status": extractionStatus, "warnings": len(validationResult.Warnings)}})
+	montoServicios := validationInput.MontoServicios
+	montoBienes := validationInput.MontoBienes
 
 	// Save to facturas_clientes (client mobile app table)
 	var savedClientInvoice *db.ClientInvoice
 	rncMismatchWarning := ""
 	if db.Pool != nil && invoice != nil {
-		// Parse fecha
-		var fechaDoc *time.Time
-		if !invoice.FechaFactura.IsZero() {
-			t := invoice.FechaFactura
-			fechaDoc = &t
-		} else if !invoice.Date.IsZero() {
-			t := invoice.Date
-			fechaDoc = &t
-		}
-
-		// Build OCR notes summary
-		ocrNotes := ""
-		if ocrJSON, err := json.Marshal(invoice); err == nil {
-			ocrNotes = string(ocrJSON)
-		}
-
-		// Serialize items to JSON
-		itemsJSON := ""
-		if len(invoice.Items) > 0 {
-			if ij, err := json.Marshal(invoice.Items); err == nil {
-				itemsJSON = string(ij)
-			}
-		}
+		// Map extracted fields; fechaDoc is also used by the dedup check
+		clientInvoice := clientInvoiceFromExtraction(invoice)
+		fechaDoc := clientInvoice.FechaDocumento
 
 		// Toda factura escaneada exitosamente = "procesado" para el usuario
 		// extraction_status y review_notes guardan los detalles internos para el contador
@@ -484,36 +623,38 @@ func (h *Handler) ProcessInvoice(w http.ResponseWriter, r *http.Request) {
 		// === VALIDACION 1: Duplicados ===
 		if invoice.NCF != "" {
 			// Con NCF: dedup exacto por NCF + emisor
-			isDup, dupErr := db.CheckDuplicateNCF(ctx, claims.UserID, invoice.NCF, invoice.RNCEmisor)
+			isDup, dupErr := db.CheckDuplicateNCF(ctx, p.ClienteID, invoice.NCF, invoice.RNCEmisor)
+			tracker.Emit(progress.Event{Stage: progress.StageDuplicateCheck, Message: "Verificación de NCF duplicado",
+				Data: map[string]interface{}{"duplicada": isDup}})
 			if dupErr == nil && isDup {
-				w.WriteHeader(http.StatusConflict)
-				json.NewEncoder(w).Encode(map[string]interface{}{
+				h.notifyDuplicateRejected(ctx, p.ClienteID, "DUPLICATE_NCF", duplicateWebhookData(invoice))
+				return uploadResult{Status: http.StatusConflict, Body: map[string]interface{}{
 					"success":      false,
 					"error_code":   "DUPLICATE_NCF",
 					"error":        fmt.Sprintf("Ya existe una factura con NCF %s del mismo proveedor", invoice.NCF),
 					"user_message": fmt.Sprintf("Ya tienes registrada una factura con NCF %s de este proveedor (RNC %s). No se guardó para evitar duplicados.", invoice.NCF, invoice.RNCEmisor),
-				})
-				return
+				}, Err: "DUPLICATE_NCF"}
 			}
 		} else {
 			// Sin NCF: dedup por monto + emisor + fecha + hora
 			total := decimalToFloat64(invoice.Total)
-			isDup, dupErr := db.CheckDuplicateByAmount(ctx, claims.UserID, total, invoice.RNCEmisor, fechaDoc, invoice.HoraFactura)
+			isDup, dupErr := db.CheckDuplicateByAmount(ctx, p.ClienteID, total, invoice.RNCEmisor, fechaDoc, invoice.HoraFactura)
+			tracker.Emit(progress.Event{Stage: progress.StageDuplicateCheck, Message: "Verificación de duplicado por monto y fecha",
+				Data: map[string]interface{}{"duplicada": isDup}})
 			if dupErr == nil && isDup {
-				w.WriteHeader(http.StatusConflict)
-				json.NewEncoder(w).Encode(map[string]interface{}{
+				h.notifyDuplicateRejected(ctx, p.ClienteID, "DUPLICATE_AMOUNT", duplicateWebhookData(invoice))
+				return uploadResult{Status: http.StatusConflict, Body: map[string]interface{}{
 					"success":      false,
 					"error_code":   "DUPLICATE_AMOUNT",
 					"error":        "Factura duplicada detectada",
 					"user_message": "Ya existe una factura del mismo proveedor, mismo monto, misma fecha y hora. Si es una factura diferente, verifique que la hora sea distinta.",
-				})
-				return
+				}, Err: "DUPLICATE_AMOUNT"}
 			}
 		}
 
 		// === VALIDACION 2: Receptor RNC — advertencia DGII ===
 		// Se guarda la factura siempre, pero se advierte si el RNC no sirve para devengar impuestos
-		clientRNC, _ := db.GetClientRNC(ctx, claims.UserID)
+		clientRNC, _ := db.GetClientRNC(ctx, p.ClienteID)
 		if clientRNC != "" {
 			if invoice.RNCReceptor == "" {
 				// Factura sin RNC receptor
@@ -527,89 +668,24 @@ func (h *Handler) ProcessInvoice(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
-		// FechaPago para BD
-		var fechaPago *time.Time
-		if !invoice.FechaPago.IsZero() {
-			t := invoice.FechaPago
-			fechaPago = &t
-		}
-
-		clientInvoice := &db.ClientInvoice{
-			ClienteID:        claims.UserID,
-			ArchivoURL:       imagenURL,
-			ArchivoNombre:    "factura_scan.jpg",
-			TipoDocumento:    invoice.TipoNCF,
-			HoraFactura:      invoice.HoraFactura,
-			FechaDocumento:   fechaDoc,
-			Monto:            decimalToFloat64(invoice.Total),
-			NCF:              invoice.NCF,
-			Proveedor:        invoice.NombreEmisor,
-			Estado:           estado,
-			NotasCliente:     "",
-			EmisorRNC:        invoice.RNCEmisor,
-			ReceptorNombre:   invoice.NombreReceptor,
-			ReceptorRNC:      invoice.RNCReceptor,
-			// Montos base
-			Subtotal:  decimalToFloat64(invoice.Subtotal),
-			Descuento: decimalToFloat64(invoice.Descuento),
-			// ITBIS
-			ITBIS:                 decimalToFloat64(invoice.ITBIS),
-			ITBISRetenido:         decimalToFloat64(invoice.ITBISRetenido),
-			ITBISExento:           decimalToFloat64(invoice.ITBISExento),
-			ITBISProporcionalidad: decimalToFloat64(invoice.ITBISProporcionalidad),
-			ITBISCosto:            decimalToFloat64(invoice.ITBISCosto),
-			// ISR
-			ISR:              decimalToFloat64(invoice.ISR),
-			RetencionISRTipo: intToPtr(invoice.RetencionISRTipo),
-			// ISC
-			ISC:          decimalToFloat64(invoice.ISC),
-			ISCCategoria: invoice.ISCCategoria,
-			// Otros cargos
-			CDTMonto:          decimalToFloat64(invoice.CDTMonto),
-			Cargo911:          decimalToFloat64(invoice.Cargo911),
-			Propina:           decimalToFloat64(invoice.Propina),
-			OtrosImpuestos:    decimalToFloat64(invoice.OtrosImpuestos),
-			MontoNoFacturable: decimalToFloat64(invoice.MontoNoFacturable),
-			// Clasificación
-			FormaPago:        invoice.FormaPago,
-			TipoNCF:          invoice.TipoNCF,
-			TipoBienServicio: invoice.TipoBienServicio,
-			ConfidenceScore:  invoice.Confidence,
-			RawOCRJSON:       ocrNotes,
-			ItemsJSON:        itemsJSON,
-			ExtractionStatus: extractionStatus,
-			ReviewNotes:      reviewNotes,
-			// Campos nuevos
-			ITBISTasa:               decimalToFloat64(invoice.ITBISTasa),
-			NCFModifica:             invoice.NCFModifica,
-			TipoIDEmisor:            invoice.TipoIDEmisor,
-			TipoIDReceptor:          invoice.TipoIDReceptor,
-			MontoServicios:          decimalToFloat64(invoice.MontoServicios),
-			MontoBienes:             decimalToFloat64(invoice.MontoBienes),
-			ITBISRetenidoPorcentaje: invoice.ITBISRetenidoPorcentaje,
-			FechaPago:               fechaPago,
-		}
-
-		if err := db.SaveClientInvoice(ctx, clientInvoice); err != nil {
+		clientInvoice.ClienteID = p.ClienteID
+		clientInvoice.EmpresaAlias = p.EmpresaAlias
+		clientInvoice.ArchivoURL = imagenURL
+		clientInvoice.ArchivoNombre = "factura_scan.jpg"
+		clientInvoice.Estado = estado
+		clientInvoice.ExtractionStatus = extractionStatus
+		clientInvoice.ReviewNotes = reviewNotes
+
+		// The SharePoint sync is queued through the outbox in the same transaction
+		if err := db.SaveClientInvoiceWithOutbox(ctx, clientInvoice, func(inv *db.ClientInvoice) []db.OutboxEvent {
+			return h.invoiceSavedEvents(inv, p.EmpresaAlias, filename)
+		}); err != nil {
 			fmt.Printf("Warning: failed to save client invoice to DB: %v\n", err)
 		} else {
 			savedClientInvoice = clientInvoice
-
-			// Queue for SharePoint sync (non-blocking)
-			go func(facturaID, clienteID, rncCliente string, fechaFactura *time.Time, archivoURL, archivoNombre string) {
-				if archivoURL != "" && db.Pool != nil {
-					_, qErr := db.Pool.Exec(context.Background(),
-						`INSERT INTO sharepoint_sync_queue (factura_id, cliente_id, rnc_cliente, fecha_factura, archivo_url, archivo_nombre)
-						 VALUES ($1, $2, $3, $4, $5, $6)
-						 ON CONFLICT DO NOTHING`,
-						facturaID, clienteID, rncCliente, fechaFactura, archivoURL, archivoNombre)
-					if qErr != nil {
-						log.Printf("[SharePoint Queue] Error queueing factura %s: %v", facturaID, qErr)
-					} else {
-						log.Printf("[SharePoint Queue] Queued factura %s for sync", facturaID)
-					}
-				}
-			}(clientInvoice.ID, clientInvoice.ClienteID, claims.EmpresaAlias, fechaDoc, imagenURL, filename)
+			h.outbox.Notify()
+			tracker.Emit(progress.Event{Stage: progress.StageSaved, Message: "Factura guardada",
+				Data: map[string]interface{}{"factura_id": clientInvoice.ID}})
 		}
 	}
 
@@ -662,6 +738,10 @@ func (h *Handler) ProcessInvoice(w http.ResponseWriter, r *http.Request) {
 		"confidence_score": invoice.Confidence,
 		"forma_pago":       invoice.FormaPago,
 		"tipo_bien_servicio": invoice.TipoBienServicio,
+		"tipo_factura":     invoice.TipoFactura,
+		"moneda":           invoice.Moneda,
+		"tasa_cambio":      decimalToFloat64(invoice.TasaCambio),
+		"montos_originales": invoice.MontosOriginales,
 		"imagen_url":       imagenURL,
 		"items":            invoice.Items,
 	}
@@ -701,8 +781,147 @@ func (h *Handler) ProcessInvoice(w http.ResponseWriter, r *http.Request) {
 		responseData["saved_to_db"] = false
 	}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(responseData)
+	res := uploadResult{Status: http.StatusOK, Body: responseData}
+	if savedClientInvoice != nil {
+		res.FacturaID = savedClientInvoice.ID
+	}
+	return res
+}
+
+// validateExtraction runs the DGII cross-validation on an extracted invoice and
+// derives extraction_status and review_notes from it. conversionErr is the
+// result of ConvertirADOP: without an exchange rate the invoice goes to review.
+func validateExtraction(invoice *models.Invoice, conversionErr error) (*services.InvoiceInput, *services.ValidationResult, string, string) {
+	validationInput := services.InvoiceInputFromExtraction(invoice)
+
+	validator := services.NewTaxValidator()
+	validationResult := validator.Validate(validationInput)
+	extractionStatus := extractionStatusFor(validationResult, invoice.Confidence)
+
+	// Sin tasa de cambio los montos siguen en moneda extranjera: revisión manual
+	if conversionErr != nil {
+		extractionStatus = "review"
+		validationResult.NeedsReview = true
+		validationResult.Warnings = append(validationResult.Warnings, services.ValidationWarning{
+			Field:   "moneda",
+			Code:    "tasa_cambio_no_disponible",
+			Message: fmt.Sprintf("Factura en %s sin tasa de cambio para su fecha; montos no convertidos a DOP", invoice.Moneda),
+		})
+	}
+
+	return validationInput, validationResult, extractionStatus, reviewNotesFor(validationResult)
+}
+
+// extractionStatusFor derives extraction_status from a tax validation and the
+// extraction confidence
+func extractionStatusFor(result *services.ValidationResult, confidence float64) string {
+	if !result.Valid {
+		return "error"
+	}
+	if result.NeedsReview && confidence < 0.75 {
+		return "review"
+	}
+	return "validated"
+}
+
+// reviewNotesFor serializes the validation errors/warnings for review_notes
+func reviewNotesFor(result *services.ValidationResult) string {
+	if len(result.Errors) == 0 && len(result.Warnings) == 0 {
+		return ""
+	}
+	rn, err := json.Marshal(result)
+	if err != nil {
+		return ""
+	}
+	return string(rn)
+}
+
+// clientInvoiceFromExtraction maps the extracted invoice onto the
+// facturas_clientes fields. Ownership, file and status fields are left for the
+// caller to fill in.
+func clientInvoiceFromExtraction(invoice *models.Invoice) *db.ClientInvoice {
+	// Parse fecha
+	var fechaDoc *time.Time
+	if !invoice.FechaFactura.IsZero() {
+		t := invoice.FechaFactura
+		fechaDoc = &t
+	} else if !invoice.Date.IsZero() {
+		t := invoice.Date
+		fechaDoc = &t
+	}
+
+	// FechaPago para BD
+	var fechaPago *time.Time
+	if !invoice.FechaPago.IsZero() {
+		t := invoice.FechaPago
+		fechaPago = &t
+	}
+
+	// Build OCR notes summary
+	ocrNotes := ""
+	if ocrJSON, err := json.Marshal(invoice); err == nil {
+		ocrNotes = string(ocrJSON)
+	}
+
+	// Serialize items to JSON
+	itemsJSON := ""
+	if len(invoice.Items) > 0 {
+		if ij, err := json.Marshal(invoice.Items); err == nil {
+			itemsJSON = string(ij)
+		}
+	}
+
+	clientInvoice := &db.ClientInvoice{
+		TipoDocumento:  invoice.TipoNCF,
+		HoraFactura:    invoice.HoraFactura,
+		FechaDocumento: fechaDoc,
+		Monto:          decimalToFloat64(invoice.Total),
+		NCF:            invoice.NCF,
+		Proveedor:      invoice.NombreEmisor,
+		EmisorRNC:      invoice.RNCEmisor,
+		ReceptorNombre: invoice.NombreReceptor,
+		ReceptorRNC:    invoice.RNCReceptor,
+		// Montos base
+		Subtotal:  decimalToFloat64(invoice.Subtotal),
+		Descuento: decimalToFloat64(invoice.Descuento),
+		// ITBIS
+		ITBIS:                 decimalToFloat64(invoice.ITBIS),
+		ITBISRetenido:         decimalToFloat64(invoice.ITBISRetenido),
+		ITBISExento:           decimalToFloat64(invoice.ITBISExento),
+		ITBISProporcionalidad: decimalToFloat64(invoice.ITBISProporcionalidad),
+		ITBISCosto:            decimalToFloat64(invoice.ITBISCosto),
+		// ISR
+		ISR:              decimalToFloat64(invoice.ISR),
+		RetencionISRTipo: intToPtr(invoice.RetencionISRTipo),
+		// ISC
+		ISC:          decimalToFloat64(invoice.ISC),
+		ISCCategoria: invoice.ISCCategoria,
+		// Otros cargos
+		CDTMonto:          decimalToFloat64(invoice.CDTMonto),
+		Cargo911:          decimalToFloat64(invoice.Cargo911),
+		Propina:           decimalToFloat64(invoice.Propina),
+		OtrosImpuestos:    decimalToFloat64(invoice.OtrosImpuestos),
+		MontoNoFacturable: decimalToFloat64(invoice.MontoNoFacturable),
+		// Clasificación
+		FormaPago:        invoice.FormaPago,
+		TipoNCF:          invoice.TipoNCF,
+		TipoBienServicio: invoice.TipoBienServicio,
+		ConfidenceScore:  invoice.Confidence,
+		RawOCRJSON:       ocrNotes,
+		ItemsJSON:        itemsJSON,
+		// Campos nuevos
+		ITBISTasa:               decimalToFloat64(invoice.ITBISTasa),
+		NCFModifica:             invoice.NCFModifica,
+		TipoIDEmisor:            invoice.TipoIDEmisor,
+		TipoIDReceptor:          invoice.TipoIDReceptor,
+		MontoServicios:          decimalToFloat64(invoice.MontoServicios),
+		MontoBienes:             decimalToFloat64(invoice.MontoBienes),
+		ITBISRetenidoPorcentaje: invoice.ITBISRetenidoPorcentaje,
+		FechaPago:               fechaPago,
+		TipoFactura:             invoice.TipoFactura,
+	}
+	clientInvoice.Moneda, clientInvoice.TasaCambio, clientInvoice.MontosOriginalesJSON = monedaFields(invoice)
+	return clientInvoice
 }
 
 // GetInvoices returns invoices for the authenticated user's empresa
@@ -914,29 +1133,92 @@ func (h *Handler) GetStats(w http.ResponseWriter, r *http.Request) {
 
 // processInvoice performs the actual processing and returns OCR text
 func (h *Handler) processInvoice(
+	ctx context.Context,
 	imageData []byte,
 	useVisionModel bool,
 	providerName string,
 	modelName string,
 	language string,
+	hints extractionHints,
 ) (*models.Invoice, float64, float64, string, error) {
 	var ocrText string
 	var ocrDuration float64
 	var imageBase64 string
+	tracker := progress.FromContext(ctx)
+
+	// Monthly AI quota: past it the empresa gets a cheaper provider or is blocked
+	allowedProvider, err := h.applyAIQuota(ctx, hints, providerName)
+	if err != nil {
+		return nil, 0, 0, "", err
+	}
+	if allowedProvider != providerName {
+		providerName, modelName = allowedProvider, ""
+		useVisionModel = providerName == "gemini" || providerName == "openai"
+	}
+
+	// PII policy of the empresa: extract locally, or redact what a
+	// third-party AI would see
+	var redactor *ocr.Redactor
+	switch policy := h.redactionFor(hints); policy.Mode {
+	case "local_only":
+		providerName, modelName, useVisionModel = localOnly(providerName, modelName, useVisionModel)
+	case "redact":
+		if providerName != localProvider {
+			if redactor, err = ocr.NewRedactor(policy.Patterns); err != nil {
+				return nil, 0, 0, "", fmt.Errorf("redaction: %w", err)
+			}
+		}
+	case "", "off":
+	default:
+		// A misconfigured policy is no reason to send the invoice out
+		log.Printf("processInvoice: unknown redaction mode %q, extracting locally", policy.Mode)
+		providerName, modelName, useVisionModel = localOnly(providerName, modelName, useVisionModel)
+	}
+
+	// Extraction cache: the same image bytes already extracted for the cliente
+	// with the same prompt, model and redaction are not paid for again, unless
+	// forced
+	prompt := h.promptFor(hints)
+	cacheKey := h.extractionCacheKey(imageData, prompt, hints.ClienteID, redactor != nil, useVisionModel, providerName, modelName, language)
+	useCache := db.Pool != nil && hints.ClienteID != "" && h.extractionCacheTTL() > 0
+	if useCache && !hints.Force {
+		if invoice, cachedText, ok := h.cachedExtraction(ctx, cacheKey, hints); ok {
+			tracker.Emit(progress.Event{Stage: progress.StageAIDone, Message: "Datos extraídos (resultado en caché)",
+				Data: map[string]interface{}{"confidence": invoice.Confidence, "cached": true}})
+			return invoice, 0, 0, cachedText, nil
+		}
+	}
+
+	// The image leaves the server redacted, or is extracted locally instead
+	visionImage := imageData
+	if redactor != nil && useVisionModel {
+		if redacted, ok := redactImage(ctx, redactor, language, imageData); ok {
+			visionImage = redacted
+		} else {
+			redactor = nil
+			providerName, modelName, useVisionModel = localOnly(providerName, modelName, useVisionModel)
+			cacheKey = h.extractionCacheKey(imageData, prompt, hints.ClienteID, false, useVisionModel, providerName, modelName, language)
+		}
+	}
 
 	// Step 2: OCR or prepare image for vision model
 	if useVisionModel {
 		// For AI vision models (Gemini), send the ORIGINAL image - no grayscale
 		// Gemini reads color images better than grayscale preprocessed ones
-		imageBase64 = "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(imageData)
+		imageBase64 = "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(visionImage)
 		fmt.Printf("[Process] Using original image for vision model (%d bytes)\n", len(imageData))
+		tracker.Emit(progress.Event{Stage: progress.StageQualityChecked, Message: "Imagen original lista para el modelo de visión",
+			Data: map[string]interface{}{"bytes": len(imageData), "vision": true}})
 	} else {
 		// For Tesseract OCR, preprocess with grayscale+contrast
 		preprocessor := ocr.NewPreprocessor(h.config.OCR.Engine == "easyocr")
+		prepStart := time.Now()
 		processedImage, err := preprocessor.PreprocessImageFromBytes(imageData)
 		if err != nil {
 			return nil, 0, 0, "", fmt.Errorf("image preprocessing failed: %w", err)
 		}
+		tracker.Emit(progress.Event{Stage: progress.StageQualityChecked, Message: "Imagen preprocesada para OCR",
+			DurationMs: time.Since(prepStart).Milliseconds(), Data: map[string]interface{}{"bytes": len(processedImage), "vision": false}})
 		tesseract := ocr.NewTesseractOCR(language)
 		text, duration, err := tesseract.ExtractText(processedImage)
 		if err != nil {
@@ -944,20 +1226,49 @@ func (h *Handler) processInvoice(
 		}
 		ocrText = text
 		ocrDuration = duration
+		tracker.Emit(progress.Event{Stage: progress.StageOCRDone, Message: "Texto extraído con OCR",
+			DurationMs: int64(duration * 1000), Data: map[string]interface{}{"caracteres": len(text)}})
+		if redactor != nil {
+			ocrText = redactor.RedactText(ocrText)
+		}
 	}
 
-	// Step 3: Create AI provider
-	provider, err := h.createProvider(providerName, modelName)
+	// Step 3: Create AI provider, every call metered for the empresa
+	provider, err := h.newProvider(providerName, modelName)
 	if err != nil {
 		return nil, ocrDuration, 0, ocrText, err
 	}
+	record := h.usageRecorder(ctx, hints)
+	fellBack := false
+	provider = ai.Metered(provider, providerName, func(call ai.Call) {
+		record(call)
+		fellBack = fellBack || call.Outcome() == "transient_error"
+	})
+	if fp, ok := provider.(*ai.FallbackProvider); ok {
+		// The chain reports each provider it tries and each fallback
+		fp.Observe(func(ev ai.FallbackEvent) {
+			tracker.Emit(fallbackProgressEvent(ev))
+		})
+	} else {
+		tracker.Emit(progress.Event{Stage: progress.StageAIAttempt, Provider: providerName, Message: "Consultando " + providerName})
+	}
 
 	// Step 4: Extract data with AI
 	extractor := ai.NewExtractor(provider, h.config.Categories)
+	extractor.UsePrompt(prompt)
+	h.useExamples(ctx, extractor, hints)
 	invoice, aiDuration, err := extractor.Extract(ocrText, imageBase64)
 	if err != nil {
 		return nil, ocrDuration, 0, ocrText, fmt.Errorf("AI extraction failed: %w", err)
 	}
+	tracker.Emit(progress.Event{Stage: progress.StageAIDone, Message: "Datos extraídos", DurationMs: int64(aiDuration * 1000),
+		Data: map[string]interface{}{"confidence": invoice.Confidence}})
+
+	// A fallback model answered: its result is not the cached model's. With
+	// correction examples the result changes with the next correction.
+	if useCache && !fellBack && !extractor.UsedExamples() {
+		h.cacheExtraction(ctx, cacheKey, invoice, ocrText)
+	}
 
 	// Store raw text in invoice
 	// invoice.RawText = ocrText // Comentado - extractor ya lo maneja con campos DGII
@@ -965,14 +1276,66 @@ func (h *Handler) processInvoice(
 	return invoice, ocrDuration, aiDuration, ocrText, nil
 }
 
+// reextractInvoice extracts the image of a stored invoice again, with the
+// default provider in vision mode, and returns its new fields converted to DOP
+// and validated like an upload. Used by reprocessing and the revision_manual
+// retries.
+func (h *Handler) reextractInvoice(ctx context.Context, imageData []byte, hints extractionHints) (*db.ClientInvoice, *services.ValidationResult, error) {
+	invoice, _, _, _, err := h.processInvoice(
+		ctx,
+		imageData,
+		true, // useVisionModel
+		h.config.AI.DefaultProvider,
+		"",
+		h.config.OCR.Language,
+		hints,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Convert foreign-currency amounts to DOP before storing
+	conversionErr := services.ConvertirADOP(ctx, h.exchangeRates, invoice)
+	if conversionErr != nil {
+		log.Printf("reextractInvoice: conversión %s→DOP falló: %v", invoice.Moneda, conversionErr)
+	}
+	_, validationResult, extractionStatus, reviewNotes := validateExtraction(invoice, conversionErr)
+
+	updated := clientInvoiceFromExtraction(invoice)
+	updated.Estado = "procesado"
+	updated.ExtractionStatus = extractionStatus
+	updated.ReviewNotes = reviewNotes
+	return updated, validationResult, nil
+}
+
+// fallbackProgressEvent turns a FallbackProvider step into a progress event
+func fallbackProgressEvent(ev ai.FallbackEvent) progress.Event {
+	pe := progress.Event{Provider: ev.Provider, DurationMs: ev.Duration.Milliseconds()}
+	if ev.Err != nil {
+		pe.Error = ev.Err.Error()
+	}
+	switch ev.Kind {
+	case "attempt":
+		pe.Stage = progress.StageAIAttempt
+		pe.Message = "Consultando " + ev.Provider
+	case "fallback":
+		pe.Stage = progress.StageAIFallback
+		pe.Message = ev.Provider + " no disponible, probando el siguiente proveedor"
+	case "failed":
+		pe.Stage = progress.StageAIAttempt
+		pe.Message = ev.Provider + " falló"
+	default:
+		pe.Stage = progress.StageAIAttempt
+		pe.Message = ev.Provider + " respondió"
+	}
+	return pe
+}
+
 // createProvider creates the appropriate AI provider
 func (h *Handler) createProvider(providerName, modelName string) (ai.Provider, error) {
 	switch providerName {
 	case "openai":
-		model := modelName
-		if model == "" {
-			model = h.config.AI.OpenAI.Model
-		}
+		model := h.providerModel(providerName, modelName)
 		primary := ai.NewOpenAIProvider(
 			h.config.AI.OpenAI.APIKey,
 			h.config.AI.OpenAI.BaseURL,
@@ -990,20 +1353,14 @@ func (h *Handler) createProvider(providerName, modelName string) (ai.Provider, e
 		), nil
 
 	case "gemini":
-		model := modelName
-		if model == "" {
-			model = h.config.AI.Gemini.Model
-		}
+		model := h.providerModel(providerName, modelName)
 		return ai.NewGeminiProvider(
 			h.config.AI.Gemini.APIKey,
 			model,
 		), nil
 
 	case "ollama":
-		model := modelName
-		if model == "" {
-			model = h.config.AI.Ollama.Model
-		}
+		model := h.providerModel(providerName, modelName)
 		return ai.NewOllamaProvider(
 			h.config.AI.Ollama.BaseURL,
 			model,
@@ -1035,6 +1392,22 @@ func intToPtr(i int) *int {
 	return &i
 }
 
+// monedaFields returns the moneda/tasa_cambio/montos_originales values stored in
+// facturas_clientes for an extracted invoice
+func monedaFields(inv *models.Invoice) (string, float64, string) {
+	tasa := decimalToFloat64(inv.TasaCambio)
+	if tasa == 0 {
+		tasa = 1
+	}
+	originales := ""
+	if inv.MontosOriginales != nil {
+		if oj, err := json.Marshal(inv.MontosOriginales); err == nil {
+			originales = string(oj)
+		}
+	}
+	return inv.Moneda, tasa, originales
+}
+
 // ValidateInvoiceTaxes validates tax fields from OCR/AI extraction
 // POST /api/v1/invoices/validate
 func (h *Handler) ValidateInvoiceTaxes(w http.ResponseWriter, r *http.Request) {
@@ -1090,6 +1463,56 @@ func (h *Handler) GetSharePointQueueStatus(w http.ResponseWriter, r *http.Reques
 	json.NewEncoder(w).Encode(result)
 }
 
+// ImportTasasCambio loads Banco Central exchange rates from a CSV, sent either
+// as the raw request body or as multipart field "file". Query param moneda sets
+// the currency for files without a moneda column (default USD).
+func (h *Handler) ImportTasasCambio(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if db.Pool == nil {
+		h.sendError(w, http.StatusServiceUnavailable, "database not available")
+		return
+	}
+
+	moneda := r.URL.Query().Get("moneda")
+	if moneda == "" {
+		moneda = "USD"
+	}
+
+	var body io.Reader = http.MaxBytesReader(w, r.Body, MaxUploadSize)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		if err := r.ParseMultipartForm(MaxUploadSize); err != nil {
+			h.sendError(w, http.StatusBadRequest, "Failed to parse form: "+err.Error())
+			return
+		}
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			h.sendError(w, http.StatusBadRequest, "No file provided")
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+
+	tasas, err := services.ParseTasasBCRD(body, moneda)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	n, err := db.UpsertTasasCambio(r.Context(), tasas)
+	if err != nil {
+		log.Printf("ImportTasasCambio: DB error: %v", err)
+		sendAppError(w, ErrDBUnavailable)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"importadas": n,
+	})
+}
+
 // ReceiveErrorReport receives error reports from the mobile app
 func (h *Handler) ReceiveErrorReport(w http.ResponseWriter, r *http.Request) {
 	var report struct {
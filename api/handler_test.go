@@ -0,0 +1,140 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/facturaIA/invoice-ocr-service/internal/ai"
+	"github.com/facturaIA/invoice-ocr-service/internal/auth"
+	"github.com/facturaIA/invoice-ocr-service/internal/models"
+)
+
+// validInvoice is an extraction that passes the TaxValidator
+var validInvoice = map[string]interface{}{
+	"ncf":          "B0100000001",
+	"rncEmisor":    "131047939",
+	"nombreEmisor": "PLAZA LAMA",
+	"fechaFactura": "2025-03-15",
+	"subtotal":     100,
+	"itbis":        18,
+	"itbisTasa":    18,
+	"total":        118,
+	"confidence":   0.95,
+}
+
+// newTestHandler returns a handler without database or storage whose
+// extractions are answered by provider
+func newTestHandler(provider ai.Provider) *Handler {
+	h := NewHandler(&models.Config{AI: models.AIConfig{DefaultProvider: "gemini"}})
+	h.newProvider = func(providerName, modelName string) (ai.Provider, error) {
+		return provider, nil
+	}
+	return h
+}
+
+func withClaims(r *http.Request) *http.Request {
+	claims := &auth.Claims{UserID: "cliente-1", EmpresaAlias: "empresa", Rol: "cliente"}
+	return r.WithContext(context.WithValue(r.Context(), auth.ClaimsContextKey, claims))
+}
+
+func uploadRequest(t *testing.T) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", "factura.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write([]byte("\xff\xd8\xff fake jpeg"))
+	mw.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/api/process-invoice", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+func decodeBody(t *testing.T, w *httptest.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("response is not JSON: %v: %s", err, w.Body.String())
+	}
+	return body
+}
+
+func TestProcessInvoiceExtractsAndValidates(t *testing.T) {
+	fake := ai.NewFakeProvider(ai.FakeJSON(validInvoice))
+	h := newTestHandler(fake)
+
+	w := httptest.NewRecorder()
+	h.ProcessInvoice(w, withClaims(uploadRequest(t)))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	body := decodeBody(t, w)
+	if body["success"] != true || body["extraction_status"] != "validated" {
+		t.Errorf("success = %v, extraction_status = %v; want true, validated", body["success"], body["extraction_status"])
+	}
+	data, _ := body["data"].(map[string]interface{})
+	if data["ncf"] != "B0100000001" || data["total"] != 118.0 {
+		t.Errorf("data = %v", data)
+	}
+	calls := fake.Calls()
+	if len(calls) != 1 || calls[0].ImageBase64 == "" {
+		t.Errorf("want one vision call with the image, got %d calls", len(calls))
+	}
+}
+
+func TestProcessInvoiceAllProvidersFailed(t *testing.T) {
+	overloaded := ai.FakeResponse{Err: errors.New("429 Too Many Requests")}
+	chain := ai.NewFallbackProvider(
+		ai.NamedProvider("gemini", ai.NewFakeProvider(overloaded)),
+		ai.NamedProvider("openai", ai.NewFakeProvider(overloaded)),
+	)
+	h := newTestHandler(chain)
+
+	w := httptest.NewRecorder()
+	h.ProcessInvoice(w, withClaims(uploadRequest(t)))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	body := decodeBody(t, w)
+	if body["success"] != true || body["extraction_status"] != "revision_manual" {
+		t.Errorf("success = %v, extraction_status = %v; want true, revision_manual", body["success"], body["extraction_status"])
+	}
+}
+
+func TestProcessInvoicePermanentProviderError(t *testing.T) {
+	h := newTestHandler(ai.NewFakeProvider(ai.FakeResponse{Err: errors.New("invalid API key")}))
+
+	w := httptest.NewRecorder()
+	h.ProcessInvoice(w, withClaims(uploadRequest(t)))
+
+	body := decodeBody(t, w)
+	if body["success"] != false || body["extraction_status"] == "revision_manual" {
+		t.Errorf("body = %v, want a failed extraction", body)
+	}
+}
+
+func TestProcessInvoiceUnauthorized(t *testing.T) {
+	fake := ai.NewFakeProvider()
+	h := newTestHandler(fake)
+
+	w := httptest.NewRecorder()
+	h.ProcessInvoice(w, uploadRequest(t))
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", w.Code)
+	}
+	if n := len(fake.Calls()); n != 0 {
+		t.Errorf("provider called %d times without claims", n)
+	}
+}
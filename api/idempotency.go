@@ -0,0 +1,117 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/facturaIA/invoice-ocr-service/internal/db"
+)
+
+const (
+	// Retries within this window get the stored response
+	idempotencyTTL = 24 * time.Hour
+	// A key left processing for longer belongs to a request that died
+	idempotencyLease     = jobStaleAfter
+	maxIdempotencyKeyLen = 255
+)
+
+// claimIdempotencyKey registers the Idempotency-Key of an upload. If a
+// previous request with the key finished, its response is written and handled
+// is true; a request still in flight gets 409. It returns the key the caller
+// must complete, or "" when idempotency can't be honored (no database), in
+// which case the upload proceeds normally.
+func (h *Handler) claimIdempotencyKey(w http.ResponseWriter, clienteID, key string, file []byte) (string, bool) {
+	if len(key) > maxIdempotencyKeyLen {
+		h.sendError(w, http.StatusBadRequest, "Idempotency-Key too long (max 255)")
+		return "", true
+	}
+	if db.Pool == nil {
+		return "", false
+	}
+
+	sum := sha256.Sum256(file)
+	hash := hex.EncodeToString(sum[:])
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	rec, err := db.ClaimIdempotencyKey(ctx, clienteID, key, hash, idempotencyTTL, idempotencyLease)
+	if err != nil {
+		log.Printf("ProcessInvoice: idempotency DB error: %v", err)
+		return "", false
+	}
+	if rec == nil {
+		return key, false
+	}
+
+	switch {
+	case rec.RequestHash != hash:
+		sendJSON(w, http.StatusUnprocessableEntity, map[string]string{
+			"error_code":   "IDEMPOTENCY_KEY_REUSED",
+			"error":        "Idempotency-Key already used with a different file",
+			"user_message": "Esta clave de reintento ya se usó con otra factura.",
+		})
+	case rec.Status == db.IdempotencyProcessing:
+		w.Header().Set("Retry-After", "5")
+		sendJSON(w, http.StatusConflict, map[string]string{
+			"error_code":   "IDEMPOTENCY_IN_PROGRESS",
+			"error":        "A request with this Idempotency-Key is still being processed",
+			"user_message": "Tu factura todavía se está procesando. Intenta de nuevo en unos segundos.",
+		})
+	default:
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(rec.HTTPStatus)
+		w.Write(rec.Response)
+	}
+	return "", true
+}
+
+// completeIdempotencyKey stores the response for retries. Server errors are
+// not stored: the key is released so a retry processes the upload again.
+func completeIdempotencyKey(clienteID, key string, res uploadResult) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if res.Status >= http.StatusInternalServerError {
+		if err := db.ReleaseIdempotencyKey(ctx, clienteID, key); err != nil {
+			log.Printf("ProcessInvoice: error releasing idempotency key: %v", err)
+		}
+		return
+	}
+
+	body, err := json.Marshal(res.Body)
+	if err == nil {
+		err = db.CompleteIdempotencyKey(ctx, clienteID, key, res.Status, body)
+	}
+	if err != nil {
+		log.Printf("ProcessInvoice: error storing idempotent response: %v", err)
+	}
+}
+
+// StartIdempotencyCleanup deletes expired idempotency keys every hour until
+// ctx ends
+func (h *Handler) StartIdempotencyCleanup(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if db.Pool == nil {
+				continue
+			}
+			if n, err := db.DeleteExpiredIdempotencyKeys(ctx); err != nil {
+				log.Printf("[Idempotency] Error deleting expired keys: %v", err)
+			} else if n > 0 {
+				log.Printf("[Idempotency] Deleted %d expired keys", n)
+			}
+		}
+	}()
+}
@@ -0,0 +1,243 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/facturaIA/invoice-ocr-service/internal/db"
+	"github.com/facturaIA/invoice-ocr-service/internal/services"
+)
+
+// invoiceCorrection is a partial set of DGII fields entered by a person. Nil
+// fields are left as stored; dates are YYYY-MM-DD and "" clears them.
+type invoiceCorrection struct {
+	NCF              *string `json:"ncf"`
+	TipoNCF          *string `json:"tipo_ncf"`
+	NCFModifica      *string `json:"ncf_modifica"`
+	EmisorRNC        *string `json:"emisor_rnc"`
+	Proveedor        *string `json:"proveedor"`
+	ReceptorNombre   *string `json:"receptor_nombre"`
+	ReceptorRNC      *string `json:"receptor_rnc"`
+	TipoIDEmisor     *string `json:"tipo_id_emisor"`
+	TipoIDReceptor   *string `json:"tipo_id_receptor"`
+	FechaDocumento   *string `json:"fecha_documento"`
+	FechaPago        *string `json:"fecha_pago"`
+	FormaPago        *string `json:"forma_pago"`
+	TipoBienServicio *string `json:"tipo_bien_servicio"`
+	TipoFactura      *string `json:"tipo_factura"`
+	// Montos
+	Monto             *float64 `json:"monto"`
+	Subtotal          *float64 `json:"subtotal"`
+	Descuento         *float64 `json:"descuento"`
+	MontoServicios    *float64 `json:"monto_servicios"`
+	MontoBienes       *float64 `json:"monto_bienes"`
+	MontoNoFacturable *float64 `json:"monto_no_facturable"`
+	// ITBIS
+	ITBIS                   *float64 `json:"itbis"`
+	ITBISTasa               *float64 `json:"itbis_tasa"`
+	ITBISRetenido           *float64 `json:"itbis_retenido"`
+	ITBISRetenidoPorcentaje *int     `json:"itbis_retenido_porcentaje"`
+	ITBISExento             *float64 `json:"itbis_exento"`
+	ITBISProporcionalidad   *float64 `json:"itbis_proporcionalidad"`
+	ITBISCosto              *float64 `json:"itbis_costo"`
+	// ISR / ISC / otros
+	ISR              *float64 `json:"isr"`
+	RetencionISRTipo *int     `json:"retencion_isr_tipo"` // 0 clears it
+	ISC              *float64 `json:"isc"`
+	ISCCategoria     *string  `json:"isc_categoria"`
+	CDTMonto         *float64 `json:"cdt_monto"`
+	Cargo911         *float64 `json:"cargo_911"`
+	Propina          *float64 `json:"propina"`
+	OtrosImpuestos   *float64 `json:"otros_impuestos"`
+}
+
+// correctField sets *dst to *v when v is given and differs, recording the change
+func correctField[T comparable](cambios map[string]db.FieldChange, name string, dst *T, v *T) {
+	if v == nil || *dst == *v {
+		return
+	}
+	cambios[name] = db.FieldChange{Old: *dst, New: *v}
+	*dst = *v
+}
+
+// correctDate is correctField for optional YYYY-MM-DD dates
+func correctDate(cambios map[string]db.FieldChange, name string, dst **time.Time, v *string) error {
+	if v == nil {
+		return nil
+	}
+	var t *time.Time
+	if s := strings.TrimSpace(*v); s != "" {
+		parsed, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			return fmt.Errorf("%s: fecha inválida %q (formato YYYY-MM-DD)", name, *v)
+		}
+		t = &parsed
+	}
+	if formatDate(*dst) == formatDate(t) {
+		return nil
+	}
+	cambios[name] = db.FieldChange{Old: formatDate(*dst), New: formatDate(t)}
+	*dst = t
+	return nil
+}
+
+func formatDate(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+// apply writes the given fields into inv and returns what changed, keyed by
+// field name (the JSON name, which is also the facturas_clientes column)
+func (c *invoiceCorrection) apply(inv *db.ClientInvoice) (map[string]db.FieldChange, error) {
+	cambios := map[string]db.FieldChange{}
+
+	correctField(cambios, "ncf", &inv.NCF, trimmed(c.NCF))
+	correctField(cambios, "tipo_ncf", &inv.TipoNCF, trimmed(c.TipoNCF))
+	correctField(cambios, "ncf_modifica", &inv.NCFModifica, trimmed(c.NCFModifica))
+	correctField(cambios, "emisor_rnc", &inv.EmisorRNC, trimmed(c.EmisorRNC))
+	correctField(cambios, "proveedor", &inv.Proveedor, trimmed(c.Proveedor))
+	correctField(cambios, "receptor_nombre", &inv.ReceptorNombre, trimmed(c.ReceptorNombre))
+	correctField(cambios, "receptor_rnc", &inv.ReceptorRNC, trimmed(c.ReceptorRNC))
+	correctField(cambios, "tipo_id_emisor", &inv.TipoIDEmisor, trimmed(c.TipoIDEmisor))
+	correctField(cambios, "tipo_id_receptor", &inv.TipoIDReceptor, trimmed(c.TipoIDReceptor))
+	correctField(cambios, "forma_pago", &inv.FormaPago, trimmed(c.FormaPago))
+	correctField(cambios, "tipo_bien_servicio", &inv.TipoBienServicio, trimmed(c.TipoBienServicio))
+	correctField(cambios, "isc_categoria", &inv.ISCCategoria, trimmed(c.ISCCategoria))
+	if c.TipoFactura != nil && *c.TipoFactura != "gastos" && *c.TipoFactura != "ingresos" {
+		return nil, fmt.Errorf("tipo_factura debe ser gastos o ingresos")
+	}
+	correctField(cambios, "tipo_factura", &inv.TipoFactura, c.TipoFactura)
+	if err := correctDate(cambios, "fecha_documento", &inv.FechaDocumento, c.FechaDocumento); err != nil {
+		return nil, err
+	}
+	if err := correctDate(cambios, "fecha_pago", &inv.FechaPago, c.FechaPago); err != nil {
+		return nil, err
+	}
+	// The invoice's own type follows tipo_ncf, as on upload
+	if _, ok := cambios["tipo_ncf"]; ok {
+		inv.TipoDocumento = inv.TipoNCF
+	}
+
+	correctField(cambios, "monto", &inv.Monto, c.Monto)
+	correctField(cambios, "subtotal", &inv.Subtotal, c.Subtotal)
+	correctField(cambios, "descuento", &inv.Descuento, c.Descuento)
+	correctField(cambios, "monto_servicios", &inv.MontoServicios, c.MontoServicios)
+	correctField(cambios, "monto_bienes", &inv.MontoBienes, c.MontoBienes)
+	correctField(cambios, "monto_no_facturable", &inv.MontoNoFacturable, c.MontoNoFacturable)
+	correctField(cambios, "itbis", &inv.ITBIS, c.ITBIS)
+	correctField(cambios, "itbis_tasa", &inv.ITBISTasa, c.ITBISTasa)
+	correctField(cambios, "itbis_retenido", &inv.ITBISRetenido, c.ITBISRetenido)
+	correctField(cambios, "itbis_retenido_porcentaje", &inv.ITBISRetenidoPorcentaje, c.ITBISRetenidoPorcentaje)
+	correctField(cambios, "itbis_exento", &inv.ITBISExento, c.ITBISExento)
+	correctField(cambios, "itbis_proporcionalidad", &inv.ITBISProporcionalidad, c.ITBISProporcionalidad)
+	correctField(cambios, "itbis_costo", &inv.ITBISCosto, c.ITBISCosto)
+	correctField(cambios, "isr", &inv.ISR, c.ISR)
+	correctField(cambios, "isc", &inv.ISC, c.ISC)
+	correctField(cambios, "cdt_monto", &inv.CDTMonto, c.CDTMonto)
+	correctField(cambios, "cargo_911", &inv.Cargo911, c.Cargo911)
+	correctField(cambios, "propina", &inv.Propina, c.Propina)
+	correctField(cambios, "otros_impuestos", &inv.OtrosImpuestos, c.OtrosImpuestos)
+
+	if c.RetencionISRTipo != nil {
+		old := 0
+		if inv.RetencionISRTipo != nil {
+			old = *inv.RetencionISRTipo
+		}
+		if *c.RetencionISRTipo < 0 || *c.RetencionISRTipo > 8 {
+			return nil, fmt.Errorf("retencion_isr_tipo debe estar entre 1 y 8 (0 la quita)")
+		}
+		if old != *c.RetencionISRTipo {
+			cambios["retencion_isr_tipo"] = db.FieldChange{Old: old, New: *c.RetencionISRTipo}
+			inv.RetencionISRTipo = intToPtr(*c.RetencionISRTipo)
+		}
+	}
+
+	return cambios, nil
+}
+
+func trimmed(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	t := strings.TrimSpace(*s)
+	return &t
+}
+
+// validateClientInvoice runs the DGII cross-validation on a stored invoice,
+// like validateExtraction does on an extraction, and derives extraction_status
+// and review_notes from it
+func validateClientInvoice(inv *db.ClientInvoice) (*services.ValidationResult, string, string) {
+	montoServicios := inv.MontoServicios
+	montoBienes := inv.MontoBienes
+	if montoServicios == 0 && montoBienes == 0 {
+		montoServicios = inv.Subtotal
+	}
+	retencionISRTipo := 0
+	if inv.RetencionISRTipo != nil {
+		retencionISRTipo = *inv.RetencionISRTipo
+	}
+
+	input := &services.InvoiceInput{
+		MontoServicios:          montoServicios,
+		MontoBienes:             montoBienes,
+		Descuento:               inv.Descuento,
+		ITBISFacturado:          inv.ITBIS,
+		ITBISTasa:               inv.ITBISTasa,
+		ITBISExento:             inv.ITBISExento,
+		ITBISRetenido:           inv.ITBISRetenido,
+		ITBISProporcionalidad:   inv.ITBISProporcionalidad,
+		ITBISCosto:              inv.ITBISCosto,
+		ISCMonto:                inv.ISC,
+		ISCCategoria:            inv.ISCCategoria,
+		CDTMonto:                inv.CDTMonto,
+		Cargo911:                inv.Cargo911,
+		PropinaLegal:            inv.Propina,
+		OtrosImpuestos:          inv.OtrosImpuestos,
+		MontoNoFacturable:       inv.MontoNoFacturable,
+		RetencionISRTipo:        retencionISRTipo,
+		RetencionISRMonto:       inv.ISR,
+		TotalFactura:            inv.Monto,
+		NCF:                     inv.NCF,
+		NCFModifica:             inv.NCFModifica,
+		TipoNCF:                 inv.TipoNCF,
+		ITBISRetenidoPorcentaje: inv.ITBISRetenidoPorcentaje,
+		FechaPago:               formatDate(inv.FechaPago),
+		NCFVencimiento:          formatDate(inv.NCFVencimiento),
+	}
+
+	result := services.NewTaxValidator().Validate(input)
+	return result, extractionStatusFor(result, inv.ConfidenceScore), reviewNotesFor(result)
+}
+
+// revalidateCorrection validates a manually corrected invoice and sets its
+// confidence_score, extraction_status and review_notes. Values typed by a
+// person are taken as read from the document: a correction that passes the
+// validation cleanly is fully trusted, otherwise the extraction's confidence
+// is kept.
+func revalidateCorrection(inv *db.ClientInvoice) *services.ValidationResult {
+	result, _, _ := validateClientInvoice(inv)
+	if result.Valid && !result.NeedsReview {
+		inv.ConfidenceScore = 1
+	}
+	inv.ExtractionStatus = extractionStatusFor(result, inv.ConfidenceScore)
+	inv.ReviewNotes = reviewNotesFor(result)
+	return result
+}
+
+// correctionColumns are the facturas_clientes columns a correction writes:
+// the changed fields plus what revalidateCorrection derives
+func correctionColumns(cambios map[string]db.FieldChange) []string {
+	columns := []string{"confidence_score", "extraction_status", "review_notes"}
+	for name := range cambios {
+		columns = append(columns, name)
+	}
+	if _, ok := cambios["tipo_ncf"]; ok {
+		columns = append(columns, "tipo_documento")
+	}
+	sort.Strings(columns)
+	return columns
+}
@@ -0,0 +1,197 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/facturaIA/invoice-ocr-service/internal/auth"
+	"github.com/facturaIA/invoice-ocr-service/internal/db"
+)
+
+// auditActor attributes a change made through the API to the logged-in user
+func auditActor(claims *auth.Claims, reason string) db.AuditActor {
+	source := db.AuditSourceUser
+	if claims.Rol == "contador" || claims.Rol == "admin" {
+		source = db.AuditSourceContador
+	}
+	return db.AuditActor{ID: claims.UserID, Source: source, Reason: reason}
+}
+
+// loadClientInvoice returns an invoice of the user, or of one of their clients
+// for a contador (any client for an admin), writing the response and
+// returning nil when there is none
+func (h *Handler) loadClientInvoice(w http.ResponseWriter, r *http.Request, claims *auth.Claims, invoiceID, fn string) *db.ClientInvoice {
+	invoice, err := db.GetClientInvoiceByID(r.Context(), claims.UserID, invoiceID)
+	if errors.Is(err, pgx.ErrNoRows) && (claims.Rol == "contador" || claims.Rol == "admin") {
+		invoice, err = db.GetClientInvoiceForOwner(r.Context(), reviewOwnerID(claims), invoiceID)
+	}
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		log.Printf("%s: DB error: %v", fn, err)
+		sendAppError(w, ErrDBUnavailable)
+		return nil
+	}
+	if invoice == nil {
+		h.sendError(w, http.StatusNotFound, "invoice not found")
+		return nil
+	}
+	return invoice
+}
+
+// voidedOrRejected reports whether an invoice left the books (anulada, or
+// rejected in review): its fields are not changed anymore
+func voidedOrRejected(invoice *db.ClientInvoice) bool {
+	return invoice.Estado == "anulada" || invoice.Estado == db.ReviewRejectedEstado
+}
+
+// loadEditableInvoice is loadClientInvoice for a change of the invoice's
+// fields: voided or rejected invoices and finalized 606 periods are refused
+func (h *Handler) loadEditableInvoice(w http.ResponseWriter, r *http.Request, claims *auth.Claims, invoiceID, fn string) (*db.ClientInvoice, bool) {
+	invoice := h.loadClientInvoice(w, r, claims, invoiceID, fn)
+	if invoice == nil {
+		return nil, false
+	}
+	if voidedOrRejected(invoice) {
+		h.sendError(w, http.StatusConflict, fmt.Sprintf("la factura está %s", invoice.Estado))
+		return nil, false
+	}
+	if err := checkPeriodo606Abierto(r.Context(), invoice); err != nil {
+		h.sendPeriodoLockError(w, fn, err)
+		return nil, false
+	}
+	return invoice, true
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: GET /api/facturas/{id}/history
+// ─────────────────────────────────────────────────────────────────────────────
+
+// GetClientInvoiceHistory lista las versiones de una factura: por cada cambio,
+// quién lo hizo (ai, user, contador, system), el motivo y los valores
+// anterior y nuevo de cada campo. La versión 0 es la factura tal como se subió.
+func (h *Handler) GetClientInvoiceHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, err := auth.GetClaimsFromContext(r.Context())
+	if err != nil {
+		h.sendError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if db.Pool == nil {
+		sendAppError(w, ErrDBUnavailable)
+		return
+	}
+
+	invoiceID := mux.Vars(r)["id"]
+	invoice := h.loadClientInvoice(w, r, claims, invoiceID, "GetClientInvoiceHistory")
+	if invoice == nil {
+		return
+	}
+
+	versions, err := db.GetInvoiceHistory(r.Context(), invoice.ClienteID, invoiceID)
+	if err != nil {
+		log.Printf("GetClientInvoiceHistory: DB error: %v", err)
+		sendAppError(w, ErrDBUnavailable)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"invoice_id": invoiceID,
+		"versions":   versions,
+		"total":      len(versions),
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: POST /api/facturas/{id}/revert
+// ─────────────────────────────────────────────────────────────────────────────
+
+// RevertClientInvoice devuelve los campos de una factura a los valores que
+// tenían en una versión del historial, incluidos extraction_status y
+// confidence_score. La reversión es un cambio más: queda registrada como una
+// versión nueva.
+func (h *Handler) RevertClientInvoice(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, err := auth.GetClaimsFromContext(r.Context())
+	if err != nil {
+		h.sendError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if db.Pool == nil {
+		sendAppError(w, ErrDBUnavailable)
+		return
+	}
+
+	var req struct {
+		Version *int   `json:"version"`
+		Motivo  string `json:"motivo"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if req.Version == nil || *req.Version < 0 {
+		h.sendError(w, http.StatusBadRequest, "version es requerida (0 = factura original)")
+		return
+	}
+
+	invoiceID := mux.Vars(r)["id"]
+	invoice, ok := h.loadEditableInvoice(w, r, claims, invoiceID, "RevertClientInvoice")
+	if !ok {
+		return
+	}
+
+	values, err := db.GetInvoiceValuesAtVersion(r.Context(), invoice.ClienteID, invoiceID, *req.Version)
+	if err != nil {
+		log.Printf("RevertClientInvoice: DB error: %v", err)
+		sendAppError(w, ErrDBUnavailable)
+		return
+	}
+	columns, err := db.RestoreInvoiceValues(invoice, values)
+	if err != nil {
+		log.Printf("RevertClientInvoice: restore error: %v", err)
+		sendAppError(w, ErrDBSaveError)
+		return
+	}
+	if len(columns) == 0 {
+		h.sendError(w, http.StatusBadRequest, fmt.Sprintf("no hay cambios posteriores a la versión %d", *req.Version))
+		return
+	}
+	// La fecha restaurada puede caer en un período ya finalizado
+	if err := checkPeriodo606Abierto(r.Context(), invoice); err != nil {
+		h.sendPeriodoLockError(w, "RevertClientInvoice", err)
+		return
+	}
+
+	reason := fmt.Sprintf("revertida a versión %d", *req.Version)
+	if motivo := strings.TrimSpace(req.Motivo); motivo != "" {
+		reason += ": " + motivo
+	}
+	if err := db.UpdateClientInvoiceWithOutbox(r.Context(), invoice.ClienteID, invoiceID, invoice, auditActor(claims, reason), invoiceUpdatedEvent, columns...); err != nil {
+		log.Printf("RevertClientInvoice: DB update error: %v", err)
+		sendAppError(w, ErrDBSaveError)
+		return
+	}
+	h.outbox.Notify()
+	// A revert may undo a correction, or bring one back
+	learnFromCorrection(r.Context(), invoice)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":           true,
+		"invoice_id":        invoiceID,
+		"version":           *req.Version,
+		"campos":            columns,
+		"extraction_status": invoice.ExtractionStatus,
+		"data":              clientInvoiceToFrontend(invoice),
+	})
+}
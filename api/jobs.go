@@ -0,0 +1,258 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/facturaIA/invoice-ocr-service/internal/auth"
+	"github.com/facturaIA/invoice-ocr-service/internal/db"
+	"github.com/facturaIA/invoice-ocr-service/internal/progress"
+)
+
+const (
+	defaultJobWorkers       = 4
+	defaultJobMaxAttempts   = 3
+	defaultBatchConcurrency = 2
+	jobPollInterval         = 2 * time.Second
+	jobTimeout              = 5 * time.Minute
+	// A job running for longer than this belongs to an instance that died
+	jobStaleAfter = 2 * jobTimeout
+)
+
+// wantsAsync reports whether the upload should be queued as a job: the form
+// field async=true/false wins, otherwise config jobs.async_uploads decides.
+// Without a database there is nowhere to persist the job, so it runs inline.
+func (h *Handler) wantsAsync(r *http.Request) bool {
+	if db.Pool == nil {
+		return false
+	}
+	switch r.FormValue("async") {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return h.config.Jobs.AsyncUploads
+}
+
+// enqueueUpload persists the upload as a queued job; the answer is 202 with
+// its ID
+func (h *Handler) enqueueUpload(ctx context.Context, p *UploadParams) uploadResult {
+	params, err := json.Marshal(p)
+	if err != nil {
+		return uploadResult{Status: http.StatusInternalServerError, Body: map[string]string{"error": "Failed to encode job"}, Err: err.Error()}
+	}
+
+	job := &db.ProcessingJob{
+		ClienteID: p.ClienteID,
+		Params:    params,
+		Imagen:    p.ImageData,
+	}
+	if err := db.CreateProcessingJob(ctx, job); err != nil {
+		log.Printf("enqueueUpload: DB error: %v", err)
+		return uploadResult{Status: ErrDBUnavailable.HTTPStatus, Body: ErrDBUnavailable, Err: err.Error()}
+	}
+
+	h.wakeJobWorker()
+	progress.FromContext(ctx).Emit(progress.Event{Stage: progress.StageQueued, Message: "En cola de procesamiento",
+		Data: map[string]interface{}{"job_id": job.ID}})
+
+	return uploadResult{Status: http.StatusAccepted, Body: map[string]interface{}{
+		"success":      true,
+		"job_id":       job.ID,
+		"status":       job.Status,
+		"status_url":   fmt.Sprintf("/api/jobs/%s", job.ID),
+		"user_message": "Tu factura está en cola y se procesará en unos segundos.",
+	}}
+}
+
+// wakeJobWorker wakes an idle worker instead of waiting for the next poll
+func (h *Handler) wakeJobWorker() {
+	select {
+	case h.jobWake <- struct{}{}:
+	default:
+	}
+}
+
+// batchConcurrency is how many jobs of one batch may run at once (0 = no limit)
+func (h *Handler) batchConcurrency() int {
+	switch n := h.config.Jobs.BatchConcurrency; {
+	case n < 0:
+		return 0
+	case n == 0:
+		return defaultBatchConcurrency
+	default:
+		return n
+	}
+}
+
+// StartJobWorkers launches the bounded pool that runs queued uploads, plus a
+// janitor that requeues jobs orphaned by a restart. Workers stop when ctx ends.
+func (h *Handler) StartJobWorkers(ctx context.Context) {
+	workers := h.config.Jobs.Workers
+	if workers <= 0 {
+		workers = defaultJobWorkers
+	}
+	maxAttempts := h.config.Jobs.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultJobMaxAttempts
+	}
+
+	go h.requeueStaleJobs(ctx, maxAttempts)
+	for i := 0; i < workers; i++ {
+		go h.jobWorker(ctx)
+	}
+	log.Printf("[Jobs] %d workers started", workers)
+}
+
+func (h *Handler) jobWorker(ctx context.Context) {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		h.drainJobs(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.jobWake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// drainJobs runs queued jobs until the queue is empty
+func (h *Handler) drainJobs(ctx context.Context) {
+	for ctx.Err() == nil && db.Pool != nil {
+		job, err := db.ClaimNextProcessingJob(ctx, h.batchConcurrency())
+		if err != nil {
+			log.Printf("[Jobs] Error claiming job: %v", err)
+			return
+		}
+		if job == nil {
+			return
+		}
+		h.runJob(ctx, job)
+	}
+}
+
+func (h *Handler) runJob(ctx context.Context, job *db.ProcessingJob) {
+	start := time.Now()
+
+	var p UploadParams
+	if err := json.Unmarshal(job.Params, &p); err != nil {
+		h.finishJob(job.ID, uploadResult{Status: http.StatusBadRequest, Err: "parámetros inválidos: " + err.Error()})
+		return
+	}
+	p.ClienteID = job.ClienteID
+	p.ImageData = job.Imagen
+
+	keys := []string{progress.Key(job.ClienteID, job.ID)}
+	if p.UploadID != "" {
+		keys = append(keys, progress.Key(job.ClienteID, p.UploadID))
+	}
+	tracker := h.progress.Tracker(keys...)
+
+	jobCtx, cancel := context.WithTimeout(progress.WithTracker(ctx, tracker), jobTimeout)
+	defer cancel()
+
+	res := func() (res uploadResult) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				log.Printf("[Jobs] Job %s panicked: %v", job.ID, rec)
+				res = uploadResult{Status: http.StatusInternalServerError, Err: fmt.Sprintf("panic: %v", rec)}
+			}
+		}()
+		return h.runUpload(jobCtx, &p)
+	}()
+
+	h.finishJob(job.ID, res)
+	emitUploadDone(tracker, res)
+	log.Printf("[Jobs] Job %s finished in %.1fs (attempt %d, status %d)", job.ID, time.Since(start).Seconds(), job.Attempts, res.Status)
+}
+
+// finishJob records the result; it uses its own context so a job completed
+// during shutdown is still stored
+func (h *Handler) finishJob(jobID string, res uploadResult) {
+	status := db.JobDone
+	if res.Err != "" {
+		status = db.JobFailed
+	}
+
+	var body []byte
+	if res.Body != nil {
+		var err error
+		if body, err = json.Marshal(res.Body); err != nil {
+			log.Printf("[Jobs] Error encoding result of job %s: %v", jobID, err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.FinishProcessingJob(ctx, jobID, status, res.Status, body, res.Err, res.FacturaID); err != nil {
+		log.Printf("[Jobs] Error saving result of job %s: %v", jobID, err)
+	}
+}
+
+func (h *Handler) requeueStaleJobs(ctx context.Context, maxAttempts int) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		if db.Pool != nil {
+			n, err := db.RequeueStaleProcessingJobs(ctx, jobStaleAfter, maxAttempts)
+			if err != nil {
+				log.Printf("[Jobs] Error requeueing stale jobs: %v", err)
+			} else if n > 0 {
+				log.Printf("[Jobs] Requeued %d interrupted jobs", n)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: GET /api/jobs/{id}
+// ─────────────────────────────────────────────────────────────────────────────
+
+// GetJob reports the status of an asynchronous upload. Once the job is done or
+// failed, result holds the same payload the synchronous upload returns.
+func (h *Handler) GetJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, err := auth.GetClaimsFromContext(r.Context())
+	if err != nil {
+		h.sendError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if db.Pool == nil {
+		sendAppError(w, ErrDBUnavailable)
+		return
+	}
+
+	job, err := db.GetProcessingJob(r.Context(), claims.UserID, mux.Vars(r)["id"])
+	if err != nil {
+		log.Printf("GetJob: DB error: %v", err)
+		h.sendError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	if job == nil {
+		h.sendError(w, http.StatusNotFound, "job not found")
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"job":     job,
+	})
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/facturaIA/invoice-ocr-service/internal/db"
+	"github.com/facturaIA/invoice-ocr-service/internal/outbox"
+	"github.com/facturaIA/invoice-ocr-service/internal/webhooks"
+)
+
+// newOutboxDispatcher registers the handler of every outbox topic
+func newOutboxDispatcher() *outbox.Dispatcher {
+	d := outbox.NewDispatcher()
+	d.Register(outbox.TopicSharePointSync, outbox.SharePointSyncHandler)
+
+	wh := webhooks.NewDeliverer()
+	d.Register(webhooks.TopicEvent, wh.FanOut)
+	d.Register(webhooks.TopicDelivery, wh.Deliver)
+	return d
+}
+
+// StartOutboxDispatcher delivers outbox events in the background until ctx ends
+func (h *Handler) StartOutboxDispatcher(ctx context.Context) {
+	go h.outbox.Run(ctx)
+}
+
+// invoiceSavedEvents are the side effects of storing a new invoice. Called
+// inside the save transaction, once inv.ID is known.
+func (h *Handler) invoiceSavedEvents(inv *db.ClientInvoice, rncCliente, archivoNombre string) []db.OutboxEvent {
+	var events []db.OutboxEvent
+	if inv.ArchivoURL != "" {
+		ev, err := outbox.NewSharePointSyncEvent(outbox.SharePointSyncPayload{
+			FacturaID:     inv.ID,
+			ClienteID:     inv.ClienteID,
+			RNCCliente:    rncCliente,
+			FechaFactura:  inv.FechaDocumento,
+			ArchivoURL:    inv.ArchivoURL,
+			ArchivoNombre: archivoNombre,
+		})
+		if err != nil {
+			log.Printf("[Outbox] Error building SharePoint event for %s: %v", inv.ID, err)
+		} else {
+			events = append(events, ev)
+		}
+	}
+	return append(events, extractionWebhookEvent(inv, inv.ID)...)
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: GET /api/admin/outbox
+// ─────────────────────────────────────────────────────────────────────────────
+
+// GetOutboxMetrics reports delivery counters, backlog, failures and lag per topic
+func (h *Handler) GetOutboxMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if db.Pool == nil {
+		sendAppError(w, ErrDBUnavailable)
+		return
+	}
+
+	metrics, err := h.outbox.Metrics(r.Context())
+	if err != nil {
+		log.Printf("GetOutboxMetrics: DB error: %v", err)
+		sendAppError(w, ErrDBUnavailable)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"outbox":  metrics,
+	})
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/facturaIA/invoice-ocr-service/internal/auth"
+	"github.com/facturaIA/invoice-ocr-service/internal/db"
+	"github.com/facturaIA/invoice-ocr-service/internal/progress"
+)
+
+// Comment lines keep proxies from closing an idle stream
+const sseHeartbeat = 15 * time.Second
+
+// writeSSE writes one Server-Sent Event named after its stage
+func writeSSE(w http.ResponseWriter, seq int, ev progress.Event) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", seq, ev.Stage, data)
+	return err
+}
+
+// finishedJobEvent builds the final event of a job from the database, for
+// streams that are no longer (or never were) in this instance's memory
+func finishedJobEvent(r *http.Request, clienteID, id string) *progress.Event {
+	if db.Pool == nil {
+		return nil
+	}
+	job, err := db.GetProcessingJob(r.Context(), clienteID, id)
+	if err != nil || job == nil || (job.Status != db.JobDone && job.Status != db.JobFailed) {
+		return nil
+	}
+	ev := &progress.Event{Stage: progress.StageDone, Message: "Procesamiento terminado", Error: job.Error,
+		At: time.Now(), Data: map[string]interface{}{"job_status": job.Status}}
+	if job.HTTPStatus != nil {
+		ev.Data["status"] = *job.HTTPStatus
+	}
+	if job.FacturaID != nil {
+		ev.Data["factura_id"] = *job.FacturaID
+	}
+	return ev
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: GET /api/progress/{id}
+// ─────────────────────────────────────────────────────────────────────────────
+
+// StreamProgress streams the processing stages of an upload as Server-Sent
+// Events. id is the upload_id (X-Upload-ID header or form field) the client
+// sent with the upload, or a job ID. Stages already published are replayed
+// first, so the client may connect before or during the upload; the stream
+// ends after the "done" event.
+func (h *Handler) StreamProgress(w http.ResponseWriter, r *http.Request) {
+	claims, err := auth.GetClaimsFromContext(r.Context())
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		h.sendError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		h.sendError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	history, events, cancel := h.progress.Subscribe(progress.Key(claims.UserID, id))
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx: don't buffer the stream
+	w.WriteHeader(http.StatusOK)
+
+	seq := 0
+	send := func(ev progress.Event) bool {
+		seq++
+		if err := writeSSE(w, seq, ev); err != nil {
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+
+	if len(history) == 0 {
+		// A job processed by another instance, or finished long ago
+		if ev := finishedJobEvent(r, claims.UserID, id); ev != nil {
+			send(*ev)
+			return
+		}
+	}
+	for _, ev := range history {
+		if !send(ev) {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			if !send(ev) {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
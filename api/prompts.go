@@ -0,0 +1,115 @@
+package api
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"log"
+	"net/http"
+
+	"github.com/facturaIA/invoice-ocr-service/internal/ai"
+)
+
+// loadPromptLibrary loads the built-in prompts and those of the configured
+// directory. A broken directory only costs its versions: the service starts
+// with the built-in ones.
+func loadPromptLibrary(dir string) *ai.PromptLibrary {
+	prompts, err := ai.NewPromptLibrary(dir)
+	if err != nil {
+		log.Printf("Prompts: %v; using the built-in prompts only", err)
+		prompts, _ = ai.NewPromptLibrary("")
+	}
+	return prompts
+}
+
+// promptTenant is the tenant whose prompt version an extraction uses: the
+// empresa, unless only the cliente has a version of its own
+func (h *Handler) promptTenant(hints extractionHints) string {
+	tenants := h.config.AI.Prompts.Tenants
+	if _, ok := tenants[hints.EmpresaAlias]; !ok && hints.ClienteID != "" {
+		if _, ok := tenants[hints.ClienteID]; ok {
+			return hints.ClienteID
+		}
+	}
+	return hints.EmpresaAlias
+}
+
+// promptVersionFor picks the prompt version of a tenant: its own, then its
+// A/B cohort, then the default
+func (h *Handler) promptVersionFor(tenant string) string {
+	cfg := h.config.AI.Prompts
+	if v, ok := cfg.Tenants[tenant]; ok && v != "" {
+		return v
+	}
+	if tenant != "" && len(cfg.Experiments) > 0 {
+		cohort := promptCohort(tenant)
+		limit := 0
+		for _, exp := range cfg.Experiments {
+			limit += exp.Percent
+			if cohort < limit {
+				return exp.Version
+			}
+		}
+	}
+	if cfg.Default != "" {
+		return cfg.Default
+	}
+	return ai.DefaultPromptVersion
+}
+
+// promptCohort places a tenant in 0-99, always the same one, so a tenant
+// keeps its prompt version across uploads
+func promptCohort(tenant string) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(tenant))
+	return int(hash.Sum32() % 100)
+}
+
+// promptFor returns the prompt of an extraction. A selected version that is
+// not loaded falls back to the built-in default.
+func (h *Handler) promptFor(hints extractionHints) *ai.Prompt {
+	version := h.promptVersionFor(h.promptTenant(hints))
+	if prompt, ok := h.prompts.Get(version); ok {
+		return prompt
+	}
+	log.Printf("processInvoice: prompt version %q not loaded, using %s", version, ai.DefaultPromptVersion)
+	return ai.DefaultPrompt()
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: GET /api/admin/prompts
+// ─────────────────────────────────────────────────────────────────────────────
+
+// GetPrompts lista las versiones de prompt cargadas y su asignación por
+// tenant y cohorte A/B
+func (h *Handler) GetPrompts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cfg := h.config.AI.Prompts
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"versions":    h.prompts.Versions(),
+		"default":     h.promptVersionFor(""),
+		"tenants":     cfg.Tenants,
+		"experiments": cfg.Experiments,
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: POST /api/admin/prompts/reload
+// ─────────────────────────────────────────────────────────────────────────────
+
+// ReloadPrompts vuelve a leer las plantillas del directorio de prompts, para
+// publicar una versión nueva sin redesplegar
+func (h *Handler) ReloadPrompts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := h.prompts.Reload(); err != nil {
+		log.Printf("ReloadPrompts: %v", err)
+		h.sendError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"versions": h.prompts.Versions(),
+	})
+}
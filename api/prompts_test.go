@@ -0,0 +1,61 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/facturaIA/invoice-ocr-service/internal/ai"
+	"github.com/facturaIA/invoice-ocr-service/internal/models"
+)
+
+func TestPromptVersionFor(t *testing.T) {
+	h := NewHandler(&models.Config{AI: models.AIConfig{Prompts: models.PromptsConfig{
+		Tenants:     map[string]string{"acme": "v3"},
+		Experiments: []models.PromptExperiment{{Version: "v2", Percent: 50}},
+	}}})
+
+	if v := h.promptVersionFor("acme"); v != "v3" {
+		t.Errorf("tenant override = %s, want v3", v)
+	}
+	if v := h.promptVersionFor(""); v != ai.DefaultPromptVersion {
+		t.Errorf("no tenant = %s, want %s", v, ai.DefaultPromptVersion)
+	}
+
+	// Cohorts are stable and split the tenants
+	counts := map[string]int{}
+	for _, tenant := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l"} {
+		v := h.promptVersionFor(tenant)
+		if again := h.promptVersionFor(tenant); again != v {
+			t.Errorf("tenant %s moved from %s to %s", tenant, v, again)
+		}
+		counts[v]++
+	}
+	if counts["v2"] == 0 || counts[ai.DefaultPromptVersion] == 0 {
+		t.Errorf("cohorts = %v, want both versions used", counts)
+	}
+
+	// A version that is not loaded falls back to the built-in prompt
+	if p := h.promptFor(extractionHints{EmpresaAlias: "acme"}); p.Version != ai.DefaultPromptVersion {
+		t.Errorf("promptFor(unloaded) = %s", p.Version)
+	}
+}
+
+func TestPromptTenant(t *testing.T) {
+	h := NewHandler(&models.Config{AI: models.AIConfig{Prompts: models.PromptsConfig{
+		Tenants: map[string]string{"acme": "v3", "cliente-pinned": "v2"},
+	}}})
+
+	cases := []struct {
+		hints extractionHints
+		want  string
+	}{
+		{extractionHints{EmpresaAlias: "acme", ClienteID: "cliente-1"}, "acme"},
+		{extractionHints{EmpresaAlias: "acme", ClienteID: "cliente-pinned"}, "acme"},
+		{extractionHints{EmpresaAlias: "other", ClienteID: "cliente-pinned"}, "cliente-pinned"},
+		{extractionHints{EmpresaAlias: "other", ClienteID: "cliente-1"}, "other"},
+	}
+	for _, c := range cases {
+		if got := h.promptTenant(c.hints); got != c.want {
+			t.Errorf("promptTenant(%+v) = %s, want %s", c.hints, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"context"
+	"log"
+
+	"github.com/facturaIA/invoice-ocr-service/internal/models"
+	"github.com/facturaIA/invoice-ocr-service/internal/ocr"
+	"github.com/facturaIA/invoice-ocr-service/internal/progress"
+)
+
+// localProvider runs on our own servers: nothing sent to it leaves them
+const localProvider = "ollama"
+
+// redactionFor returns the PII policy of an extraction: the empresa's, the
+// cliente's, or the default one
+func (h *Handler) redactionFor(hints extractionHints) models.RedactionPolicy {
+	cfg := h.config.AI.Redaction
+	for _, tenant := range []string{hints.EmpresaAlias, hints.ClienteID} {
+		if policy, ok := cfg.Empresas[tenant]; ok && tenant != "" {
+			return policy
+		}
+	}
+	return cfg.Default
+}
+
+// redactImage blacks out the sensitive words of an image before it is sent
+// to a third-party AI. ok is false when they cannot be located (no OCR word
+// boxes, undecodable image): then the image must not leave the server.
+func redactImage(ctx context.Context, redactor *ocr.Redactor, language string, imageData []byte) ([]byte, bool) {
+	redacted, n, err := redactor.RedactImageWords(ocr.NewTesseractOCR(language), imageData)
+	if err != nil {
+		log.Printf("processInvoice: redaction failed, extracting locally: %v", err)
+		return nil, false
+	}
+	progress.FromContext(ctx).Emit(progress.Event{Stage: progress.StageRedacted, Message: "Datos sensibles ocultados",
+		Data: map[string]interface{}{"palabras": n}})
+	return redacted, true
+}
+
+// localOnly moves an extraction to the local provider, in text mode like
+// any Ollama upload
+func localOnly(providerName, modelName string, useVisionModel bool) (string, string, bool) {
+	if providerName == localProvider {
+		return providerName, modelName, useVisionModel
+	}
+	log.Printf("processInvoice: %s instead of %s (local-only PII policy)", localProvider, providerName)
+	return localProvider, "", false
+}
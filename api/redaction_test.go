@@ -0,0 +1,97 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/facturaIA/invoice-ocr-service/internal/ai"
+	"github.com/facturaIA/invoice-ocr-service/internal/db"
+	"github.com/facturaIA/invoice-ocr-service/internal/models"
+)
+
+// uploadWithPolicy uploads with gemini as provider under a redaction policy
+// and returns the providers asked for and the calls they got
+func uploadWithPolicy(t *testing.T, policy models.RedactionPolicy) ([]string, []ai.FakeCall) {
+	t.Helper()
+	fake := ai.NewFakeProvider(ai.FakeJSON(validInvoice))
+	h := newTestHandler(fake)
+	h.config.AI.Redaction.Empresas = map[string]models.RedactionPolicy{"empresa": policy}
+	var asked []string
+	h.newProvider = func(providerName, modelName string) (ai.Provider, error) {
+		asked = append(asked, providerName)
+		return fake, nil
+	}
+
+	w := httptest.NewRecorder()
+	h.ProcessInvoice(w, withClaims(uploadRequest(t)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	return asked, fake.Calls()
+}
+
+func TestLocalOnlyPolicyForcesOllama(t *testing.T) {
+	asked, calls := uploadWithPolicy(t, models.RedactionPolicy{Mode: "local_only"})
+	if len(asked) != 1 || asked[0] != localProvider {
+		t.Errorf("providers = %v, want only %s", asked, localProvider)
+	}
+	for _, c := range calls {
+		if c.ImageBase64 != "" {
+			t.Error("image sent under local_only")
+		}
+	}
+}
+
+func TestRedactPolicyWithoutWordBoxesStaysLocal(t *testing.T) {
+	// The OCR of this build finds no word boxes: the image cannot be
+	// redacted, so it must not reach gemini
+	asked, calls := uploadWithPolicy(t, models.RedactionPolicy{Mode: "redact"})
+	if len(asked) != 1 || asked[0] != localProvider {
+		t.Errorf("providers = %v, want only %s", asked, localProvider)
+	}
+	for _, c := range calls {
+		if c.ImageBase64 != "" {
+			t.Error("unredacted image sent")
+		}
+	}
+}
+
+func TestUnknownPolicyFailsClosed(t *testing.T) {
+	asked, _ := uploadWithPolicy(t, models.RedactionPolicy{Mode: "local-only"})
+	if len(asked) != 1 || asked[0] != localProvider {
+		t.Errorf("providers = %v, want only %s", asked, localProvider)
+	}
+}
+
+func TestNoPolicySendsImage(t *testing.T) {
+	asked, calls := uploadWithPolicy(t, models.RedactionPolicy{})
+	if len(asked) != 1 || asked[0] != "gemini" || len(calls) == 0 || calls[0].ImageBase64 == "" {
+		t.Errorf("providers = %v, calls = %d; want gemini with the image", asked, len(calls))
+	}
+}
+
+func TestRevisionManualRetryUsesEmpresaPolicy(t *testing.T) {
+	fake := ai.NewFakeProvider(ai.FakeJSON(validInvoice))
+	h := newTestHandler(fake)
+	h.config.AI.Redaction.Empresas = map[string]models.RedactionPolicy{"empresa": {Mode: "local_only"}}
+	var asked []string
+	h.newProvider = func(providerName, modelName string) (ai.Provider, error) {
+		asked = append(asked, providerName)
+		return fake, nil
+	}
+
+	p := db.RevisionManualPendiente{ID: "f-1", ClienteID: "cliente-1", EmpresaAlias: "empresa", RetryAttempts: 1}
+	if _, err := h.reextractPendiente(context.Background(), p, []byte("image")); err != nil {
+		t.Fatalf("reextractPendiente: %v", err)
+	}
+	if len(asked) != 1 || asked[0] != localProvider {
+		t.Errorf("providers = %v, want only %s", asked, localProvider)
+	}
+	for _, c := range fake.Calls() {
+		if c.ImageBase64 != "" {
+			t.Error("image sent under local_only on retry")
+		}
+	}
+}
@@ -1,18 +1,26 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
 
 	"github.com/facturaIA/invoice-ocr-service/internal/auth"
 	"github.com/facturaIA/invoice-ocr-service/internal/db"
+	"github.com/facturaIA/invoice-ocr-service/internal/services"
 )
 
 // ─────────────────────────────────────────────────────────────────────────────
@@ -194,98 +202,82 @@ type validationResult606 struct {
 	Advertencias []string `json:"advertencias"`
 }
 
-func validate606Invoice(inv db.Formato606Invoice, idx int) validationResult606 {
-	var res validationResult606
-	prefix := fmt.Sprintf("Registro %d", idx+1)
-
-	if cleanRNC(inv.EmisorRNC) == "" {
-		res.Errores = append(res.Errores, fmt.Sprintf("%s: RNC emisor vacío", prefix))
-	}
-	if inv.NCF == "" {
-		res.Advertencias = append(res.Advertencias, fmt.Sprintf("%s: Sin NCF", prefix))
-	}
-	if inv.FechaDocumento == nil || inv.FechaDocumento.IsZero() {
-		res.Errores = append(res.Errores, fmt.Sprintf("%s: Fecha documento vacía", prefix))
-	}
-	total := inv.MontoServicios + inv.MontoBienes
-	if total == 0 {
-		total = inv.Subtotal
-	}
-	if total == 0 {
-		res.Errores = append(res.Errores, fmt.Sprintf("%s: Monto total es 0", prefix))
-	}
-	return res
-}
-
 // ─────────────────────────────────────────────────────────────────────────────
 // Handler: GET /api/formato-606/{rnc_receptor}?periodo=YYYYMM  (download TXT)
 // ─────────────────────────────────────────────────────────────────────────────
 
 func (h *Handler) GetFormato606(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-
-	claims, err := auth.GetClaimsFromContext(ctx)
-	if err != nil {
-		h.sendError(w, http.StatusUnauthorized, "unauthorized")
+	claims, rncReceptor, periodo, ok := h.parseFormatoRequest(w, r, "rnc_receptor")
+	if !ok {
 		return
 	}
 
-	if db.Pool == nil {
-		sendAppError(w, ErrDBUnavailable)
+	// A finalized period is served exactly as it was filed; changes need a rectificativa
+	envio, err := db.GetCurrentEnvio606(ctx, rncReceptor, periodo)
+	if err != nil {
+		log.Printf("GetFormato606: GetCurrentEnvio606 error: %v", err)
+		h.sendError(w, http.StatusInternalServerError, "error consultando envíos")
 		return
 	}
 
-	vars := mux.Vars(r)
-	rncReceptor := cleanRNC(vars["rnc_receptor"])
-	periodo := r.URL.Query().Get("periodo")
-	if len(periodo) != 6 {
-		h.sendError(w, http.StatusBadRequest, "periodo requerido en formato YYYYMM")
-		return
-	}
+	if envio == nil || !envio.Finalizado {
+		invoices, err := db.GetFormato606Invoices(ctx, rncReceptor, periodo)
+		if err != nil {
+			log.Printf("GetFormato606: DB error: %v", err)
+			h.sendError(w, http.StatusInternalServerError, "error consultando facturas")
+			return
+		}
 
-	invoices, err := db.GetFormato606Invoices(ctx, rncReceptor, periodo)
-	if err != nil {
-		log.Printf("GetFormato606: DB error: %v", err)
-		h.sendError(w, http.StatusInternalServerError, "error consultando facturas")
-		return
+		envio = build606Envio(rncReceptor, periodo, invoices)
+		if err := db.SaveEnvio606Borrador(ctx, claims.UserID, envio); err != nil {
+			log.Printf("GetFormato606: SaveEnvio606Borrador error: %v", err)
+			h.sendError(w, http.StatusInternalServerError, "error guardando envío")
+			return
+		}
+		h.notifyEnvio606Generated(ctx, claims.UserID, envio)
 	}
 
-	// Build TXT content
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, envio.ArchivoNombre))
+	w.Header().Set("X-Envio-606-ID", envio.ID)
+	w.Header().Set("X-Envio-606-Version", strconv.Itoa(envio.Version))
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, envio.ArchivoTXT)
+}
+
+// build606Envio renders the 606 TXT for invoices and computes the envío totals
+// and the per-invoice line snapshot
+func build606Envio(rncReceptor, periodo string, invoices []db.Formato606Invoice) *db.Envio606 {
 	// Cabecera: 606|{rnc}|{periodo}|{cantidad}
 	lines := make([]string, 0, len(invoices)+1)
 	lines = append(lines, fmt.Sprintf("606|%s|%s|%d", rncReceptor, periodo, len(invoices)))
 
-	var totalMonto, totalITBIS, totalAdelantar float64
+	e := &db.Envio606{
+		RNC:               rncReceptor,
+		Periodo:           periodo,
+		ArchivoNombre:     fmt.Sprintf("DGII_F_606_%s_%s.TXT", rncReceptor, periodo),
+		CantidadRegistros: len(invoices),
+		Lineas:            make(map[string]string, len(invoices)),
+	}
 	for _, inv := range invoices {
-		lines = append(lines, build606Line(inv))
+		line := build606Line(inv)
+		lines = append(lines, line)
+		e.Lineas[inv.ID] = line
+
 		total := inv.MontoServicios + inv.MontoBienes
 		if total == 0 {
 			total = inv.Subtotal
 		}
-		totalMonto += total
-		totalITBIS += inv.ITBIS
+		e.TotalMontoFacturado += total
+		e.TotalITBISFacturado += inv.ITBIS
 		itbisAdel := inv.ITBIS - inv.ITBISRetenido - inv.ITBISProporcionalidad - inv.ITBISCosto
 		if itbisAdel > 0 {
-			totalAdelantar += itbisAdel
+			e.TotalITBISPorAdelantar += itbisAdel
 		}
 	}
-
-	contenido := strings.Join(lines, "\n") + "\n"
-	filename := fmt.Sprintf("DGII_F_606_%s_%s.TXT", rncReceptor, periodo)
-
-	// Save to envios_606 (non-blocking on error — still deliver the file)
-	go func() {
-		_, insErr := db.InsertEnvio606(ctx, claims.UserID, rncReceptor, periodo, contenido, filename,
-			len(invoices), totalMonto, totalITBIS, totalAdelantar)
-		if insErr != nil {
-			log.Printf("GetFormato606: InsertEnvio606 error: %v", insErr)
-		}
-	}()
-
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprint(w, contenido)
+	e.ArchivoTXT = strings.Join(lines, "\n") + "\n"
+	return e
 }
 
 // ─────────────────────────────────────────────────────────────────────────────
@@ -313,6 +305,7 @@ type Formato606PreviewResponse struct {
 	Errores          []string                  `json:"errores"`
 	Advertencias     []string                  `json:"advertencias"`
 	Detalle          []Formato606PreviewDetail `json:"detalle"`
+	Excluidas        []db.FacturaSinConvertir  `json:"excluidas"`
 }
 
 func (h *Handler) GetFormato606Preview(w http.ResponseWriter, r *http.Request) {
@@ -344,6 +337,12 @@ func (h *Handler) GetFormato606Preview(w http.ResponseWriter, r *http.Request) {
 		h.sendError(w, http.StatusInternalServerError, "error consultando facturas")
 		return
 	}
+	excluidas, err := db.GetFormato606SinConvertir(ctx, rncReceptor, periodo)
+	if err != nil {
+		log.Printf("GetFormato606Preview: DB error: %v", err)
+		h.sendError(w, http.StatusInternalServerError, "error consultando facturas")
+		return
+	}
 
 	resp := Formato606PreviewResponse{
 		RNC:          rncReceptor,
@@ -352,9 +351,14 @@ func (h *Handler) GetFormato606Preview(w http.ResponseWriter, r *http.Request) {
 		Errores:      []string{},
 		Advertencias: []string{},
 		Detalle:      make([]Formato606PreviewDetail, 0, len(invoices)),
+		Excluidas:    excluidas,
 	}
 
-	for i, inv := range invoices {
+	vr := resumen606(append(validar606(rncReceptor, periodo, invoices), sinConvertir606(excluidas)...))
+	resp.Errores = vr.Errores
+	resp.Advertencias = vr.Advertencias
+
+	for _, inv := range invoices {
 		total := inv.MontoServicios + inv.MontoBienes
 		if total == 0 {
 			total = inv.Subtotal
@@ -368,11 +372,6 @@ func (h *Handler) GetFormato606Preview(w http.ResponseWriter, r *http.Request) {
 		resp.ITBISFacturado += inv.ITBIS
 		resp.ITBISPorAdelantar += itbisAdel
 
-		// Validate each line
-		vr := validate606Invoice(inv, i)
-		resp.Errores = append(resp.Errores, vr.Errores...)
-		resp.Advertencias = append(resp.Advertencias, vr.Advertencias...)
-
 		fechaStr := fmtFecha(inv.FechaDocumento)
 		resp.Detalle = append(resp.Detalle, Formato606PreviewDetail{
 			ID:             inv.ID,
@@ -423,32 +422,74 @@ func (h *Handler) ValidateFormato606(w http.ResponseWriter, r *http.Request) {
 		h.sendError(w, http.StatusInternalServerError, "error consultando facturas")
 		return
 	}
-
-	var allErrors, allWarnings []string
-	for i, inv := range invoices {
-		vr := validate606Invoice(inv, i)
-		allErrors = append(allErrors, vr.Errores...)
-		allWarnings = append(allWarnings, vr.Advertencias...)
+	excluidas, err := db.GetFormato606SinConvertir(ctx, rncReceptor, periodo)
+	if err != nil {
+		log.Printf("ValidateFormato606: DB error: %v", err)
+		h.sendError(w, http.StatusInternalServerError, "error consultando facturas")
+		return
 	}
 
-	if allErrors == nil {
-		allErrors = []string{}
-	}
-	if allWarnings == nil {
-		allWarnings = []string{}
-	}
+	errs := append(validar606(rncReceptor, periodo, invoices), sinConvertir606(excluidas)...)
+	vr := resumen606(errs)
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"rnc":          rncReceptor,
 		"periodo":      periodo,
 		"registros":    len(invoices),
-		"valido":       len(allErrors) == 0,
-		"errores":      allErrors,
-		"advertencias": allWarnings,
+		"valido":       len(vr.Errores) == 0,
+		"errores":      vr.Errores,
+		"advertencias": vr.Advertencias,
+		"detalle":      errs,
+		"excluidas":    excluidas,
 	})
 }
 
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: GET /api/formato-606/{rnc_receptor}/validate/reporte?periodo=YYYYMM
+// Downloadable CSV error report (one row per rule violation)
+// ─────────────────────────────────────────────────────────────────────────────
+
+func (h *Handler) GetReporteErrores606(w http.ResponseWriter, r *http.Request) {
+	_, rncReceptor, periodo, ok := h.parseFormatoRequest(w, r, "rnc_receptor")
+	if !ok {
+		return
+	}
+
+	invoices, err := db.GetFormato606Invoices(r.Context(), rncReceptor, periodo)
+	if err != nil {
+		log.Printf("GetReporteErrores606: DB error: %v", err)
+		h.sendError(w, http.StatusInternalServerError, "error consultando facturas")
+		return
+	}
+	excluidas, err := db.GetFormato606SinConvertir(r.Context(), rncReceptor, periodo)
+	if err != nil {
+		log.Printf("GetReporteErrores606: DB error: %v", err)
+		h.sendError(w, http.StatusInternalServerError, "error consultando facturas")
+		return
+	}
+
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	cw.Write([]string{"registro", "factura_id", "ncf", "campo", "nombre_campo", "codigo", "severidad", "mensaje", "valor"})
+	for _, e := range append(validar606(rncReceptor, periodo, invoices), sinConvertir606(excluidas)...) {
+		cw.Write([]string{strconv.Itoa(e.Registro), e.FacturaID, e.NCF, strconv.Itoa(e.Campo), e.NombreCampo,
+			e.Codigo, e.Severidad, e.Mensaje, e.Valor})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		log.Printf("GetReporteErrores606: CSV error: %v", err)
+		h.sendError(w, http.StatusInternalServerError, "error generando archivo")
+		return
+	}
+
+	filename := fmt.Sprintf("ERRORES_606_%s_%s.csv", rncReceptor, periodo)
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
 // ─────────────────────────────────────────────────────────────────────────────
 // Handler: PUT /api/formato-606/factura/{id}/toggle-aplica606
 // ─────────────────────────────────────────────────────────────────────────────
@@ -479,7 +520,17 @@ func (h *Handler) ToggleAplica606(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := db.ToggleAplica606(ctx, claims.UserID, invoiceID, body.Aplica606); err != nil {
+	current, err := db.GetClientInvoiceByID(ctx, claims.UserID, invoiceID)
+	if err != nil {
+		h.sendError(w, http.StatusNotFound, "factura no encontrada")
+		return
+	}
+	if err := checkPeriodo606Abierto(ctx, current); err != nil {
+		h.sendPeriodoLockError(w, "ToggleAplica606", err)
+		return
+	}
+
+	if err := db.ToggleAplica606(ctx, claims.UserID, invoiceID, body.Aplica606, auditActor(claims, "aplica 606")); err != nil {
 		log.Printf("ToggleAplica606: DB error: %v", err)
 		h.sendError(w, http.StatusInternalServerError, "error actualizando factura")
 		return
@@ -509,11 +560,15 @@ func (h *Handler) ToggleAplica606(w http.ResponseWriter, r *http.Request) {
 // ─────────────────────────────────────────────────────────────────────────────
 
 func (h *Handler) UpdateEnvio606Referencia(w http.ResponseWriter, r *http.Request) {
+	h.updateEnvioReferencia(w, r, "606")
+}
+
+// updateEnvioReferencia records the DGII reference of an envío of formato
+func (h *Handler) updateEnvioReferencia(w http.ResponseWriter, r *http.Request, formato string) {
 	w.Header().Set("Content-Type", "application/json")
 	ctx := r.Context()
 
-	_, err := auth.GetClaimsFromContext(ctx)
-	if err != nil {
+	if _, err := auth.GetClaimsFromContext(ctx); err != nil {
 		h.sendError(w, http.StatusUnauthorized, "unauthorized")
 		return
 	}
@@ -523,8 +578,7 @@ func (h *Handler) UpdateEnvio606Referencia(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	vars := mux.Vars(r)
-	envioID := vars["id"]
+	envioID := mux.Vars(r)["id"]
 
 	var body struct {
 		ReferenciaDGII string `json:"referencia_dgii"`
@@ -540,8 +594,8 @@ func (h *Handler) UpdateEnvio606Referencia(w http.ResponseWriter, r *http.Reques
 		estado = "enviado"
 	}
 
-	if err := db.UpdateEnvio606Referencia(ctx, envioID, body.ReferenciaDGII, estado); err != nil {
-		log.Printf("UpdateEnvio606Referencia: DB error: %v", err)
+	if err := db.UpdateEnvioReferencia(ctx, formato, envioID, body.ReferenciaDGII, estado); err != nil {
+		log.Printf("UpdateEnvio%sReferencia: DB error: %v", formato, err)
 		h.sendError(w, http.StatusInternalServerError, "error actualizando envío")
 		return
 	}
@@ -554,3 +608,987 @@ func (h *Handler) UpdateEnvio606Referencia(w http.ResponseWriter, r *http.Reques
 		"fecha_envio":     time.Now().Format(time.RFC3339),
 	})
 }
+
+// ─────────────────────────────────────────────────────────────────────────────
+// 606 envío versioning (finalizar / rectificar / diff)
+// ─────────────────────────────────────────────────────────────────────────────
+
+// checkPeriodo606Abierto returns db.ErrPeriodoFinalizado when inv belongs to a
+// 606 period locked by a finalized envío (see db.Bloqueado606). Ventas are not
+// part of the 606.
+func checkPeriodo606Abierto(ctx context.Context, inv *db.ClientInvoice) error {
+	if inv == nil || inv.TipoFactura == "ingresos" || inv.FechaDocumento == nil {
+		return nil
+	}
+	finalizado, err := db.IsPeriodo606Finalizado(ctx, cleanRNC(inv.ReceptorRNC), inv.FechaDocumento.Format("200601"))
+	if err != nil {
+		return err
+	}
+	if finalizado {
+		return db.ErrPeriodoFinalizado
+	}
+	return nil
+}
+
+// sendPeriodoLockError writes the response for a checkPeriodo606Abierto error
+func (h *Handler) sendPeriodoLockError(w http.ResponseWriter, fn string, err error) {
+	if errors.Is(err, db.ErrPeriodoFinalizado) {
+		sendAppError(w, ErrPeriodo606Finalizado)
+		return
+	}
+	log.Printf("%s: IsPeriodo606Finalizado error: %v", fn, err)
+	h.sendError(w, http.StatusInternalServerError, "error verificando periodo 606")
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: GET /api/formato-606/{rnc_receptor}/envios?periodo=YYYYMM
+// ─────────────────────────────────────────────────────────────────────────────
+
+func (h *Handler) GetEnvios606(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, rncReceptor, periodo, ok := h.parseFormatoRequest(w, r, "rnc_receptor")
+	if !ok {
+		return
+	}
+
+	envios, err := db.GetEnvios606(r.Context(), rncReceptor, periodo)
+	if err != nil {
+		log.Printf("GetEnvios606: DB error: %v", err)
+		h.sendError(w, http.StatusInternalServerError, "error consultando envíos")
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"rnc":        rncReceptor,
+		"periodo":    periodo,
+		"finalizado": db.Bloqueado606(envios),
+		"envios":     envios,
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: POST /api/envios-606/{id}/finalizar
+// ─────────────────────────────────────────────────────────────────────────────
+
+func (h *Handler) FinalizarEnvio606(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	ctx := r.Context()
+
+	if _, err := auth.GetClaimsFromContext(ctx); err != nil {
+		h.sendError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if db.Pool == nil {
+		sendAppError(w, ErrDBUnavailable)
+		return
+	}
+
+	envio, err := db.FinalizarEnvio606(ctx, mux.Vars(r)["id"])
+	if errors.Is(err, pgx.ErrNoRows) {
+		h.sendError(w, http.StatusNotFound, "envío no encontrado o no es la versión vigente del periodo")
+		return
+	}
+	if err != nil {
+		log.Printf("FinalizarEnvio606: DB error: %v", err)
+		h.sendError(w, http.StatusInternalServerError, "error finalizando envío")
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"envio":   envio,
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: POST /api/formato-606/{rnc_receptor}/rectificar?periodo=YYYYMM
+// ─────────────────────────────────────────────────────────────────────────────
+
+func (h *Handler) RectificarFormato606(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	ctx := r.Context()
+	claims, rncReceptor, periodo, ok := h.parseFormatoRequest(w, r, "rnc_receptor")
+	if !ok {
+		return
+	}
+
+	invoices, err := db.GetFormato606Invoices(ctx, rncReceptor, periodo)
+	if err != nil {
+		log.Printf("RectificarFormato606: DB error: %v", err)
+		h.sendError(w, http.StatusInternalServerError, "error consultando facturas")
+		return
+	}
+
+	envio := build606Envio(rncReceptor, periodo, invoices)
+	err = db.RectificarEnvio606(ctx, claims.UserID, envio)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		h.sendError(w, http.StatusNotFound, "no existe un 606 generado para el periodo")
+		return
+	case errors.Is(err, db.ErrEnvioNoFinalizado):
+		h.sendError(w, http.StatusConflict, "el 606 no tiene una versión finalizada o ya tiene una rectificativa abierta; puede regenerarse directamente")
+		return
+	case err != nil:
+		log.Printf("RectificarFormato606: DB error: %v", err)
+		h.sendError(w, http.StatusInternalServerError, "error creando rectificativa")
+		return
+	}
+	h.notifyEnvio606Generated(ctx, claims.UserID, envio)
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"envio":   envio,
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: GET /api/formato-606/{rnc_receptor}/diff?periodo=YYYYMM
+// Compares the current invoices against the last envío accepted by DGII.
+// ─────────────────────────────────────────────────────────────────────────────
+
+type Diff606Linea struct {
+	FacturaID string `json:"factura_id"`
+	Linea     string `json:"linea"`
+}
+
+type Diff606Cambio struct {
+	FacturaID string `json:"factura_id"`
+	Anterior  string `json:"anterior"`
+	Actual    string `json:"actual"`
+}
+
+type Diff606Response struct {
+	RNC           string          `json:"rnc"`
+	Periodo       string          `json:"periodo"`
+	BaseEnvioID   string          `json:"base_envio_id"`
+	BaseVersion   int             `json:"base_version"`
+	BaseSinLineas bool            `json:"base_sin_lineas"` // Base predates versioning: only totals are compared
+	Agregadas     []Diff606Linea  `json:"agregadas"`
+	Eliminadas    []Diff606Linea  `json:"eliminadas"`
+	Modificadas   []Diff606Cambio `json:"modificadas"`
+	SinCambios    int             `json:"sin_cambios"`
+	TieneCambios  bool            `json:"tiene_cambios"`
+}
+
+// diff606Lineas compares two factura id → line snapshots
+func diff606Lineas(anterior, actual map[string]string) ([]Diff606Linea, []Diff606Linea, []Diff606Cambio, int) {
+	agregadas := []Diff606Linea{}
+	eliminadas := []Diff606Linea{}
+	modificadas := []Diff606Cambio{}
+	sinCambios := 0
+
+	for id, linea := range actual {
+		prev, existed := anterior[id]
+		switch {
+		case !existed:
+			agregadas = append(agregadas, Diff606Linea{FacturaID: id, Linea: linea})
+		case prev != linea:
+			modificadas = append(modificadas, Diff606Cambio{FacturaID: id, Anterior: prev, Actual: linea})
+		default:
+			sinCambios++
+		}
+	}
+	for id, linea := range anterior {
+		if _, ok := actual[id]; !ok {
+			eliminadas = append(eliminadas, Diff606Linea{FacturaID: id, Linea: linea})
+		}
+	}
+
+	sort.Slice(agregadas, func(i, j int) bool { return agregadas[i].Linea < agregadas[j].Linea })
+	sort.Slice(eliminadas, func(i, j int) bool { return eliminadas[i].Linea < eliminadas[j].Linea })
+	sort.Slice(modificadas, func(i, j int) bool { return modificadas[i].Actual < modificadas[j].Actual })
+	return agregadas, eliminadas, modificadas, sinCambios
+}
+
+func (h *Handler) GetFormato606Diff(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	ctx := r.Context()
+	_, rncReceptor, periodo, ok := h.parseFormatoRequest(w, r, "rnc_receptor")
+	if !ok {
+		return
+	}
+
+	base, err := db.GetLastCompletadoEnvio606(ctx, rncReceptor, periodo)
+	if err != nil {
+		log.Printf("GetFormato606Diff: DB error: %v", err)
+		h.sendError(w, http.StatusInternalServerError, "error consultando envíos")
+		return
+	}
+	if base == nil {
+		h.sendError(w, http.StatusNotFound, "no hay un 606 completado para el periodo")
+		return
+	}
+
+	invoices, err := db.GetFormato606Invoices(ctx, rncReceptor, periodo)
+	if err != nil {
+		log.Printf("GetFormato606Diff: DB error: %v", err)
+		h.sendError(w, http.StatusInternalServerError, "error consultando facturas")
+		return
+	}
+	actual := build606Envio(rncReceptor, periodo, invoices)
+
+	resp := Diff606Response{
+		RNC:         rncReceptor,
+		Periodo:     periodo,
+		BaseEnvioID: base.ID,
+		BaseVersion: base.Version,
+	}
+	if base.SinLineas() {
+		resp.BaseSinLineas = true
+		resp.Agregadas, resp.Eliminadas, resp.Modificadas = []Diff606Linea{}, []Diff606Linea{}, []Diff606Cambio{}
+		resp.TieneCambios = actual.CantidadRegistros != base.CantidadRegistros ||
+			math.Abs(actual.TotalMontoFacturado-base.TotalMontoFacturado) >= 0.01 ||
+			math.Abs(actual.TotalITBISFacturado-base.TotalITBISFacturado) >= 0.01
+	} else {
+		resp.Agregadas, resp.Eliminadas, resp.Modificadas, resp.SinCambios = diff606Lineas(base.Lineas, actual.Lineas)
+		resp.TieneCambios = len(resp.Agregadas)+len(resp.Eliminadas)+len(resp.Modificadas) > 0
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// 607 Line builder (ventas)
+// ─────────────────────────────────────────────────────────────────────────────
+
+// tipoIngreso607Default is "01 - Ingresos por operaciones (no financieros)".
+// facturas_clientes does not store the tipo de ingreso yet.
+const tipoIngreso607Default = "01"
+
+// montoFacturado607 returns the monto facturado (before taxes) for a 607 line,
+// falling back to subtotal when servicios/bienes were not split.
+func montoFacturado607(inv db.Formato607Invoice) float64 {
+	total := inv.MontoServicios + inv.MontoBienes
+	if total == 0 {
+		total = inv.Subtotal
+	}
+	return total
+}
+
+// formaPago607Index maps a DGII forma de pago code to its 607 column offset
+// (0=efectivo … 6=otras formas). Unknown or mixed codes go to "otras formas".
+func formaPago607Index(formaPago string) int {
+	code := formaPago
+	if len(code) > 2 {
+		code = code[:2]
+	}
+	switch code {
+	case "01":
+		return 0 // Efectivo
+	case "02":
+		return 1 // Cheque / Transferencia / Depósito
+	case "03":
+		return 2 // Tarjeta débito / crédito
+	case "04":
+		return 3 // Venta a crédito
+	case "05":
+		return 5 // Permuta
+	default:
+		return 6 // Nota de crédito, mixto u otras formas
+	}
+}
+
+// build607Line generates the pipe-delimited 607 data line for one invoice.
+// Field order per DGII Formato 607 (23 fields, indices 1-23):
+//  1 RNC_CLIENTE  2 TIPO_ID  3 NCF  4 NCF_MOD  5 TIPO_INGRESO
+//  6 FECHA_COMP  7 FECHA_RETENCION  8 MONTO_FACTURADO  9 ITBIS_FACT
+// 10 ITBIS_RET_TERCEROS  11 ITBIS_PERC  12 RET_RENTA_TERCEROS  13 ISR_PERC
+// 14 ISC  15 OTROS_IMPTOS  16 PROPINA
+// 17 EFECTIVO  18 CHEQUE_TRANSF  19 TARJETA  20 CREDITO  21 BONOS  22 PERMUTA  23 OTRAS
+func build607Line(inv db.Formato607Invoice) string {
+	// Fields 1-2: RNC/Cédula del cliente and tipo de identificación
+	rncCliente := cleanRNC(inv.ReceptorRNC)
+	tipoID := inv.TipoIDReceptor
+	if tipoID == "" {
+		tipoID = tipoIDFromRNC(rncCliente)
+	}
+	if rncCliente == "" {
+		// Consumidor final sin identificación: DGII accepts empty id fields
+		tipoID = ""
+	}
+
+	// Field 7: Fecha de retención — only when a third party withheld taxes
+	fechaRet := ""
+	if inv.ITBISRetenido > 0 || inv.ISR > 0 {
+		fechaRet = fmtFecha(inv.FechaPago)
+	}
+
+	montoFact := montoFacturado607(inv)
+
+	// Fields 17-23: total cobrado distribuido por forma de pago
+	formas := make([]string, 7)
+	cobrado := inv.Monto
+	if cobrado == 0 {
+		cobrado = montoFact + inv.ITBIS
+	}
+	formas[formaPago607Index(inv.FormaPago)] = fmtMonto(cobrado, false)
+
+	parts := []string{
+		rncCliente, tipoID, inv.NCF, inv.NCFModifica, tipoIngreso607Default,
+		fmtFecha(inv.FechaDocumento), fechaRet,
+		fmtMonto(montoFact, true),
+		fmtMonto(inv.ITBIS, false),
+		fmtMonto(inv.ITBISRetenido, false),
+		fmtMonto(inv.ITBISPercibido, false),
+		fmtMonto(inv.ISR, false),
+		fmtMonto(inv.ISRPercibido, false),
+		fmtMonto(inv.ISC, false),
+		fmtMonto(inv.CDTMonto+inv.Cargo911+inv.OtrosImpuestos, false),
+		fmtMonto(inv.Propina, false),
+	}
+	parts = append(parts, formas...)
+	return strings.Join(parts, "|")
+}
+
+func validate607Invoice(inv db.Formato607Invoice, idx int) validationResult606 {
+	var res validationResult606
+	prefix := fmt.Sprintf("Registro %d", idx+1)
+
+	if inv.NCF == "" {
+		res.Errores = append(res.Errores, fmt.Sprintf("%s: Sin NCF", prefix))
+	}
+	if inv.FechaDocumento == nil || inv.FechaDocumento.IsZero() {
+		res.Errores = append(res.Errores, fmt.Sprintf("%s: Fecha documento vacía", prefix))
+	}
+	if montoFacturado607(inv) == 0 {
+		res.Errores = append(res.Errores, fmt.Sprintf("%s: Monto facturado es 0", prefix))
+	}
+	// Crédito fiscal (B01/E31) requires identifying the buyer
+	if cleanRNC(inv.ReceptorRNC) == "" && (strings.HasPrefix(inv.NCF, "B01") || strings.HasPrefix(inv.NCF, "E31")) {
+		res.Errores = append(res.Errores, fmt.Sprintf("%s: NCF de crédito fiscal sin RNC del cliente", prefix))
+	}
+	if (inv.ITBISRetenido > 0 || inv.ISR > 0) && (inv.FechaPago == nil || inv.FechaPago.IsZero()) {
+		res.Advertencias = append(res.Advertencias, fmt.Sprintf("%s: Retención sin fecha de retención", prefix))
+	}
+	return res
+}
+
+// sinConvertir607 reports the sales left out of the 607 for their currency
+func sinConvertir607(facturas []db.FacturaSinConvertir) []string {
+	errs := make([]string, 0, len(facturas))
+	for _, f := range facturas {
+		errs = append(errs, fmt.Sprintf("Factura excluida [%s]: %s", codigoSinConvertir, mensajeSinConvertir(f, "607")))
+	}
+	return errs
+}
+
+// parseFormatoRequest checks auth and DB availability, then extracts the RNC path
+// variable (rncVar) and ?periodo=YYYYMM. It writes the error response itself and
+// returns ok=false when the request cannot proceed.
+func (h *Handler) parseFormatoRequest(w http.ResponseWriter, r *http.Request, rncVar string) (claims *auth.Claims, rnc, periodo string, ok bool) {
+	claims, err := auth.GetClaimsFromContext(r.Context())
+	if err != nil {
+		h.sendError(w, http.StatusUnauthorized, "unauthorized")
+		return nil, "", "", false
+	}
+	if db.Pool == nil {
+		sendAppError(w, ErrDBUnavailable)
+		return nil, "", "", false
+	}
+	rnc = cleanRNC(mux.Vars(r)[rncVar])
+	periodo = r.URL.Query().Get("periodo")
+	if len(periodo) != 6 {
+		h.sendError(w, http.StatusBadRequest, "periodo requerido en formato YYYYMM")
+		return nil, "", "", false
+	}
+	return claims, rnc, periodo, true
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: GET /api/formato-607/{rnc_emisor}?periodo=YYYYMM  (download TXT)
+// ─────────────────────────────────────────────────────────────────────────────
+
+func (h *Handler) GetFormato607(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims, rncEmisor, periodo, ok := h.parseFormatoRequest(w, r, "rnc_emisor")
+	if !ok {
+		return
+	}
+
+	invoices, err := db.GetFormato607Invoices(ctx, rncEmisor, periodo)
+	if err != nil {
+		log.Printf("GetFormato607: DB error: %v", err)
+		h.sendError(w, http.StatusInternalServerError, "error consultando facturas")
+		return
+	}
+
+	// Cabecera: 607|{rnc}|{periodo}|{cantidad}
+	lines := make([]string, 0, len(invoices)+1)
+	lines = append(lines, fmt.Sprintf("607|%s|%s|%d", rncEmisor, periodo, len(invoices)))
+
+	var totalMonto, totalITBIS, totalRetenido float64
+	for _, inv := range invoices {
+		lines = append(lines, build607Line(inv))
+		totalMonto += montoFacturado607(inv)
+		totalITBIS += inv.ITBIS
+		totalRetenido += inv.ITBISRetenido
+	}
+
+	contenido := strings.Join(lines, "\n") + "\n"
+	filename := fmt.Sprintf("DGII_F_607_%s_%s.TXT", rncEmisor, periodo)
+
+	// Save to envios_607 (still deliver the file on error)
+	if _, insErr := db.InsertEnvio607(ctx, claims.UserID, rncEmisor, periodo, contenido, filename,
+		len(invoices), totalMonto, totalITBIS, totalRetenido); insErr != nil {
+		log.Printf("GetFormato607: InsertEnvio607 error: %v", insErr)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, contenido)
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: GET /api/formato-607/{rnc_emisor}/preview?periodo=YYYYMM
+// ─────────────────────────────────────────────────────────────────────────────
+
+type Formato607PreviewDetail struct {
+	ID            string  `json:"id"`
+	RNCCliente    string  `json:"rnc_cliente"`
+	Cliente       string  `json:"cliente"`
+	NCF           string  `json:"ncf"`
+	FechaDoc      string  `json:"fecha_documento"`
+	Monto         float64 `json:"monto_facturado"`
+	ITBIS         float64 `json:"itbis"`
+	ITBISRetenido float64 `json:"itbis_retenido"`
+	FormaPago     string  `json:"forma_pago"`
+}
+
+type Formato607PreviewResponse struct {
+	RNC            string                    `json:"rnc"`
+	Periodo        string                    `json:"periodo"`
+	Registros      int                       `json:"registros"`
+	TotalFacturado float64                   `json:"total_facturado"`
+	ITBISFacturado float64                   `json:"itbis_facturado"`
+	ITBISRetenido  float64                   `json:"itbis_retenido"`
+	Errores        []string                  `json:"errores"`
+	Advertencias   []string                  `json:"advertencias"`
+	Detalle        []Formato607PreviewDetail `json:"detalle"`
+	Excluidas      []db.FacturaSinConvertir  `json:"excluidas"`
+}
+
+func (h *Handler) GetFormato607Preview(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	ctx := r.Context()
+
+	_, rncEmisor, periodo, ok := h.parseFormatoRequest(w, r, "rnc_emisor")
+	if !ok {
+		return
+	}
+
+	invoices, err := db.GetFormato607Invoices(ctx, rncEmisor, periodo)
+	if err != nil {
+		log.Printf("GetFormato607Preview: DB error: %v", err)
+		h.sendError(w, http.StatusInternalServerError, "error consultando facturas")
+		return
+	}
+	excluidas, err := db.GetFormato607SinConvertir(ctx, rncEmisor, periodo)
+	if err != nil {
+		log.Printf("GetFormato607Preview: DB error: %v", err)
+		h.sendError(w, http.StatusInternalServerError, "error consultando facturas")
+		return
+	}
+
+	resp := Formato607PreviewResponse{
+		RNC:          rncEmisor,
+		Periodo:      periodo,
+		Registros:    len(invoices),
+		Errores:      sinConvertir607(excluidas),
+		Advertencias: []string{},
+		Detalle:      make([]Formato607PreviewDetail, 0, len(invoices)),
+		Excluidas:    excluidas,
+	}
+
+	for i, inv := range invoices {
+		monto := montoFacturado607(inv)
+		resp.TotalFacturado += monto
+		resp.ITBISFacturado += inv.ITBIS
+		resp.ITBISRetenido += inv.ITBISRetenido
+
+		vr := validate607Invoice(inv, i)
+		resp.Errores = append(resp.Errores, vr.Errores...)
+		resp.Advertencias = append(resp.Advertencias, vr.Advertencias...)
+
+		resp.Detalle = append(resp.Detalle, Formato607PreviewDetail{
+			ID:            inv.ID,
+			RNCCliente:    cleanRNC(inv.ReceptorRNC),
+			Cliente:       inv.ReceptorNombre,
+			NCF:           inv.NCF,
+			FechaDoc:      fmtFecha(inv.FechaDocumento),
+			Monto:         monto,
+			ITBIS:         inv.ITBIS,
+			ITBISRetenido: inv.ITBISRetenido,
+			FormaPago:     inv.FormaPago,
+		})
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: POST /api/formato-607/{rnc_emisor}/validate?periodo=YYYYMM
+// ─────────────────────────────────────────────────────────────────────────────
+
+func (h *Handler) ValidateFormato607(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	ctx := r.Context()
+
+	_, rncEmisor, periodo, ok := h.parseFormatoRequest(w, r, "rnc_emisor")
+	if !ok {
+		return
+	}
+
+	invoices, err := db.GetFormato607Invoices(ctx, rncEmisor, periodo)
+	if err != nil {
+		log.Printf("ValidateFormato607: DB error: %v", err)
+		h.sendError(w, http.StatusInternalServerError, "error consultando facturas")
+		return
+	}
+	excluidas, err := db.GetFormato607SinConvertir(ctx, rncEmisor, periodo)
+	if err != nil {
+		log.Printf("ValidateFormato607: DB error: %v", err)
+		h.sendError(w, http.StatusInternalServerError, "error consultando facturas")
+		return
+	}
+
+	allErrors := sinConvertir607(excluidas)
+	allWarnings := []string{}
+	for i, inv := range invoices {
+		vr := validate607Invoice(inv, i)
+		allErrors = append(allErrors, vr.Errores...)
+		allWarnings = append(allWarnings, vr.Advertencias...)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"rnc":          rncEmisor,
+		"periodo":      periodo,
+		"registros":    len(invoices),
+		"valido":       len(allErrors) == 0,
+		"errores":      allErrors,
+		"advertencias": allWarnings,
+		"excluidas":    excluidas,
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: PUT /api/envios-607/{id}/referencia
+// ─────────────────────────────────────────────────────────────────────────────
+
+func (h *Handler) UpdateEnvio607Referencia(w http.ResponseWriter, r *http.Request) {
+	h.updateEnvioReferencia(w, r, "607")
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// 608 Line builder (comprobantes anulados)
+// ─────────────────────────────────────────────────────────────────────────────
+
+// tiposAnulacion608 lists the DGII tipo de anulación codes for Formato 608.
+var tiposAnulacion608 = map[string]string{
+	"01": "Deterioro de factura pre-imprenta",
+	"02": "Errores de impresión (factura pre-imprenta)",
+	"03": "Impresión defectuosa",
+	"04": "Corrección de la información",
+	"05": "Cambio de productos",
+	"06": "Devolución de productos",
+	"07": "Omisión de productos",
+	"08": "Errores en secuencia de NCF",
+	"09": "Por cese de operaciones",
+	"10": "Pérdida o hurto de talonarios",
+}
+
+// fechaComprobante608 returns the comprobante date, falling back to the anulación date
+// when the NCF was never linked to a dated invoice.
+func fechaComprobante608(ca db.ComprobanteAnulado) *time.Time {
+	if ca.FechaComprobante != nil && !ca.FechaComprobante.IsZero() {
+		return ca.FechaComprobante
+	}
+	return &ca.FechaAnulacion
+}
+
+// build608Line generates the pipe-delimited 608 data line for one NCF anulado.
+// Field order per DGII Formato 608: 1 NCF  2 FECHA_COMPROBANTE  3 TIPO_ANULACION
+func build608Line(ca db.ComprobanteAnulado) string {
+	return strings.Join([]string{ca.NCF, fmtFecha(fechaComprobante608(ca)), ca.TipoAnulacion}, "|")
+}
+
+func validate608Comprobante(ca db.ComprobanteAnulado, idx int) validationResult606 {
+	var res validationResult606
+	prefix := fmt.Sprintf("Registro %d", idx+1)
+
+	if ca.NCF == "" {
+		res.Errores = append(res.Errores, fmt.Sprintf("%s: NCF vacío", prefix))
+	}
+	if _, ok := tiposAnulacion608[ca.TipoAnulacion]; !ok {
+		res.Errores = append(res.Errores, fmt.Sprintf("%s: Tipo de anulación inválido (%s)", prefix, ca.TipoAnulacion))
+	}
+	if ca.FechaComprobante == nil || ca.FechaComprobante.IsZero() {
+		res.Advertencias = append(res.Advertencias, fmt.Sprintf("%s: Sin fecha de comprobante, se usa la fecha de anulación", prefix))
+	}
+	return res
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: GET /api/formato-608/{rnc}?periodo=YYYYMM  (download TXT)
+// ─────────────────────────────────────────────────────────────────────────────
+
+func (h *Handler) GetFormato608(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims, rnc, periodo, ok := h.parseFormatoRequest(w, r, "rnc")
+	if !ok {
+		return
+	}
+
+	anulados, err := db.GetFormato608Comprobantes(ctx, rnc, periodo)
+	if err != nil {
+		log.Printf("GetFormato608: DB error: %v", err)
+		h.sendError(w, http.StatusInternalServerError, "error consultando comprobantes anulados")
+		return
+	}
+
+	// Cabecera: 608|{rnc}|{periodo}|{cantidad}
+	lines := make([]string, 0, len(anulados)+1)
+	lines = append(lines, fmt.Sprintf("608|%s|%s|%d", rnc, periodo, len(anulados)))
+	for _, ca := range anulados {
+		lines = append(lines, build608Line(ca))
+	}
+
+	contenido := strings.Join(lines, "\n") + "\n"
+	filename := fmt.Sprintf("DGII_F_608_%s_%s.TXT", rnc, periodo)
+
+	if _, insErr := db.InsertEnvio608(ctx, claims.UserID, rnc, periodo, contenido, filename, len(anulados)); insErr != nil {
+		log.Printf("GetFormato608: InsertEnvio608 error: %v", insErr)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, contenido)
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: GET /api/formato-608/{rnc}/preview?periodo=YYYYMM
+// ─────────────────────────────────────────────────────────────────────────────
+
+type Formato608PreviewDetail struct {
+	ID                 string `json:"id"`
+	FacturaID          string `json:"factura_id,omitempty"`
+	NCF                string `json:"ncf"`
+	FechaComprobante   string `json:"fecha_comprobante"`
+	FechaAnulacion     string `json:"fecha_anulacion"`
+	TipoAnulacion      string `json:"tipo_anulacion"`
+	TipoAnulacionDescr string `json:"tipo_anulacion_descripcion"`
+	Motivo             string `json:"motivo,omitempty"`
+}
+
+type Formato608PreviewResponse struct {
+	RNC          string                    `json:"rnc"`
+	Periodo      string                    `json:"periodo"`
+	Registros    int                       `json:"registros"`
+	Errores      []string                  `json:"errores"`
+	Advertencias []string                  `json:"advertencias"`
+	Detalle      []Formato608PreviewDetail `json:"detalle"`
+}
+
+func (h *Handler) GetFormato608Preview(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	ctx := r.Context()
+
+	_, rnc, periodo, ok := h.parseFormatoRequest(w, r, "rnc")
+	if !ok {
+		return
+	}
+
+	anulados, err := db.GetFormato608Comprobantes(ctx, rnc, periodo)
+	if err != nil {
+		log.Printf("GetFormato608Preview: DB error: %v", err)
+		h.sendError(w, http.StatusInternalServerError, "error consultando comprobantes anulados")
+		return
+	}
+
+	resp := Formato608PreviewResponse{
+		RNC:          rnc,
+		Periodo:      periodo,
+		Registros:    len(anulados),
+		Errores:      []string{},
+		Advertencias: []string{},
+		Detalle:      make([]Formato608PreviewDetail, 0, len(anulados)),
+	}
+
+	for i, ca := range anulados {
+		vr := validate608Comprobante(ca, i)
+		resp.Errores = append(resp.Errores, vr.Errores...)
+		resp.Advertencias = append(resp.Advertencias, vr.Advertencias...)
+
+		facturaID := ""
+		if ca.FacturaID != nil {
+			facturaID = *ca.FacturaID
+		}
+		resp.Detalle = append(resp.Detalle, Formato608PreviewDetail{
+			ID:                 ca.ID,
+			FacturaID:          facturaID,
+			NCF:                ca.NCF,
+			FechaComprobante:   fmtFecha(fechaComprobante608(ca)),
+			FechaAnulacion:     fmtFecha(&ca.FechaAnulacion),
+			TipoAnulacion:      ca.TipoAnulacion,
+			TipoAnulacionDescr: tiposAnulacion608[ca.TipoAnulacion],
+			Motivo:             ca.Motivo,
+		})
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: POST /api/formato-608/{rnc}/validate?periodo=YYYYMM
+// ─────────────────────────────────────────────────────────────────────────────
+
+func (h *Handler) ValidateFormato608(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	ctx := r.Context()
+
+	_, rnc, periodo, ok := h.parseFormatoRequest(w, r, "rnc")
+	if !ok {
+		return
+	}
+
+	anulados, err := db.GetFormato608Comprobantes(ctx, rnc, periodo)
+	if err != nil {
+		log.Printf("ValidateFormato608: DB error: %v", err)
+		h.sendError(w, http.StatusInternalServerError, "error consultando comprobantes anulados")
+		return
+	}
+
+	allErrors := []string{}
+	allWarnings := []string{}
+	for i, ca := range anulados {
+		vr := validate608Comprobante(ca, i)
+		allErrors = append(allErrors, vr.Errores...)
+		allWarnings = append(allWarnings, vr.Advertencias...)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"rnc":          rnc,
+		"periodo":      periodo,
+		"registros":    len(anulados),
+		"valido":       len(allErrors) == 0,
+		"errores":      allErrors,
+		"advertencias": allWarnings,
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: PUT /api/envios-608/{id}/referencia
+// ─────────────────────────────────────────────────────────────────────────────
+
+func (h *Handler) UpdateEnvio608Referencia(w http.ResponseWriter, r *http.Request) {
+	h.updateEnvioReferencia(w, r, "608")
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// IT-1 (declaración mensual de ITBIS)
+// ─────────────────────────────────────────────────────────────────────────────
+
+// buildIT1 loads the 606 and 607 rows for rnc/periodo and computes the worksheet,
+// cross-checked against the latest generated 606 envío.
+func (h *Handler) buildIT1(w http.ResponseWriter, r *http.Request) (*services.IT1Worksheet, bool) {
+	ctx := r.Context()
+	_, rnc, periodo, ok := h.parseFormatoRequest(w, r, "rnc")
+	if !ok {
+		return nil, false
+	}
+
+	compras, err := db.GetFormato606Invoices(ctx, rnc, periodo)
+	if err != nil {
+		log.Printf("IT1: GetFormato606Invoices error: %v", err)
+		h.sendError(w, http.StatusInternalServerError, "error consultando facturas")
+		return nil, false
+	}
+	ventas, err := db.GetFormato607Invoices(ctx, rnc, periodo)
+	if err != nil {
+		log.Printf("IT1: GetFormato607Invoices error: %v", err)
+		h.sendError(w, http.StatusInternalServerError, "error consultando facturas")
+		return nil, false
+	}
+
+	ws := services.CalcularIT1(rnc, periodo, compras, ventas)
+
+	// Invoices the 606/607 leave out for their currency are missing from the totals
+	comprasExcluidas, err := db.GetFormato606SinConvertir(ctx, rnc, periodo)
+	if err != nil {
+		log.Printf("IT1: GetFormato606SinConvertir error: %v", err)
+		h.sendError(w, http.StatusInternalServerError, "error consultando facturas")
+		return nil, false
+	}
+	ventasExcluidas, err := db.GetFormato607SinConvertir(ctx, rnc, periodo)
+	if err != nil {
+		log.Printf("IT1: GetFormato607SinConvertir error: %v", err)
+		h.sendError(w, http.StatusInternalServerError, "error consultando facturas")
+		return nil, false
+	}
+	for _, f := range append(comprasExcluidas, ventasExcluidas...) {
+		ws.Discrepancias = append(ws.Discrepancias, services.IT1Discrepancia{
+			Campo:   "factura_sin_convertir",
+			Message: fmt.Sprintf("[%s] %s", codigoSinConvertir, mensajeSinConvertir(f, "IT-1")),
+		})
+	}
+
+	envio, err := db.GetLatestEnvio606(ctx, rnc, periodo)
+	if err != nil {
+		// The cross-check is informative only
+		log.Printf("IT1: GetLatestEnvio606 error: %v", err)
+	}
+	ws.CompararCon606(envio)
+
+	return ws, true
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: GET /api/it1/{rnc}?periodo=YYYYMM  (JSON preview)
+// ─────────────────────────────────────────────────────────────────────────────
+
+func (h *Handler) GetIT1(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	ws, ok := h.buildIT1(w, r)
+	if !ok {
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"it1":            ws,
+		"conciliado_606": len(ws.Discrepancias) == 0,
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: GET /api/it1/{rnc}/export?periodo=YYYYMM  (download CSV)
+// ─────────────────────────────────────────────────────────────────────────────
+
+func (h *Handler) ExportIT1(w http.ResponseWriter, r *http.Request) {
+	ws, ok := h.buildIT1(w, r)
+	if !ok {
+		return
+	}
+
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	cw.Write([]string{"linea", "seccion", "concepto", "monto"})
+	for _, l := range ws.Lineas {
+		cw.Write([]string{strconv.Itoa(l.Linea), l.Seccion, l.Concepto, fmtMonto(l.Monto, true)})
+	}
+	for _, d := range ws.Discrepancias {
+		cw.Write([]string{"", "Discrepancia 606", d.Message, fmt.Sprintf("%.2f vs %.2f", d.IT1, d.Envio606)})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		log.Printf("ExportIT1: CSV error: %v", err)
+		h.sendError(w, http.StatusInternalServerError, "error generando archivo")
+		return
+	}
+
+	filename := fmt.Sprintf("IT1_%s_%s.csv", ws.RNC, ws.Periodo)
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// IR-17 (retenciones ISR e ITBIS)
+// ─────────────────────────────────────────────────────────────────────────────
+
+func (h *Handler) buildIR17(w http.ResponseWriter, r *http.Request) (*services.IR17Report, bool) {
+	_, rnc, periodo, ok := h.parseFormatoRequest(w, r, "rnc")
+	if !ok {
+		return nil, false
+	}
+
+	retenciones, err := db.GetIR17Retenciones(r.Context(), rnc, periodo)
+	if err != nil {
+		log.Printf("IR17: DB error: %v", err)
+		h.sendError(w, http.StatusInternalServerError, "error consultando retenciones")
+		return nil, false
+	}
+	excluidas, err := db.GetIR17SinConvertir(r.Context(), rnc, periodo)
+	if err != nil {
+		log.Printf("IR17: DB error: %v", err)
+		h.sendError(w, http.StatusInternalServerError, "error consultando retenciones")
+		return nil, false
+	}
+	rep := services.CalcularIR17(rnc, periodo, retenciones)
+	for _, f := range excluidas {
+		rep.Alertas = append(rep.Alertas, services.IR17Alerta{
+			FacturaID: f.ID,
+			NCF:       f.NCF,
+			EmisorRNC: f.RNC,
+			Code:      codigoSinConvertir,
+			Message:   mensajeSinConvertir(f, "IR-17"),
+		})
+	}
+	return rep, true
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: GET /api/ir17/{rnc}?periodo=YYYYMM  (JSON preview)
+// ─────────────────────────────────────────────────────────────────────────────
+
+func (h *Handler) GetIR17(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	rep, ok := h.buildIR17(w, r)
+	if !ok {
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ir17":      rep,
+		"sin_alertas": len(rep.Alertas) == 0,
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: GET /api/ir17/{rnc}/export?periodo=YYYYMM  (download CSV)
+// ─────────────────────────────────────────────────────────────────────────────
+
+func (h *Handler) ExportIR17(w http.ResponseWriter, r *http.Request) {
+	rep, ok := h.buildIR17(w, r)
+	if !ok {
+		return
+	}
+
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	cw.Write([]string{"impuesto", "tipo", "descripcion", "cantidad", "monto_base", "retenido"})
+	for _, g := range rep.ISR {
+		cw.Write([]string{"ISR", strconv.Itoa(g.Tipo), g.Descripcion, strconv.Itoa(g.Cantidad),
+			fmtMonto(g.MontoBase, true), fmtMonto(g.Retenido, true)})
+	}
+	for _, g := range rep.ITBIS {
+		cw.Write([]string{"ITBIS", strconv.Itoa(g.Porcentaje), fmt.Sprintf("Retención %d%%", g.Porcentaje),
+			strconv.Itoa(g.Cantidad), "", fmtMonto(g.Retenido, true)})
+	}
+	cw.Write([]string{"TOTAL", "", "Total ISR", "", "", fmtMonto(rep.TotalISR, true)})
+	cw.Write([]string{"TOTAL", "", "Total ITBIS", "", "", fmtMonto(rep.TotalITBIS, true)})
+	cw.Write([]string{"TOTAL", "", "Total a pagar", "", "", fmtMonto(rep.TotalRetenido, true)})
+	for _, a := range rep.Alertas {
+		cw.Write([]string{"ALERTA", a.Code, a.Message + " (NCF " + a.NCF + ")", "", "", ""})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		log.Printf("ExportIR17: CSV error: %v", err)
+		h.sendError(w, http.StatusInternalServerError, "error generando archivo")
+		return
+	}
+
+	filename := fmt.Sprintf("IR17_%s_%s.csv", rep.RNC, rep.Periodo)
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
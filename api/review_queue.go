@@ -0,0 +1,516 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/facturaIA/invoice-ocr-service/internal/auth"
+	"github.com/facturaIA/invoice-ocr-service/internal/db"
+)
+
+const (
+	// A claimed item is hidden from other reviewers for this long
+	reviewClaimLease = 30 * time.Minute
+	// Longest notas accepted on a review action
+	maxReviewNotasLen = 2000
+)
+
+// reviewOwnerID limits a contador to the invoices of their clients; admins
+// see every client
+func reviewOwnerID(claims *auth.Claims) string {
+	if claims.Rol == "admin" {
+		return ""
+	}
+	return claims.UserID
+}
+
+// reviewAction builds the db.ReviewAction of a request on /api/revision/{id}
+func reviewAction(r *http.Request, claims *auth.Claims, notas string) db.ReviewAction {
+	return db.ReviewAction{
+		OwnerID:   reviewOwnerID(claims),
+		ActorID:   claims.UserID,
+		FacturaID: mux.Vars(r)["id"],
+		Lease:     reviewClaimLease,
+		Notas:     strings.TrimSpace(notas),
+	}
+}
+
+// sendReviewError writes the response for an error of a review transition
+func (h *Handler) sendReviewError(w http.ResponseWriter, fn string, err error) {
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		h.sendError(w, http.StatusNotFound, "factura no encontrada")
+	case errors.Is(err, db.ErrReviewClaimed), errors.Is(err, db.ErrNotInReview):
+		h.sendError(w, http.StatusConflict, err.Error())
+	default:
+		log.Printf("%s: DB error: %v", fn, err)
+		sendAppError(w, ErrDBSaveError)
+	}
+}
+
+// loadReviewInvoice returns the invoice of a queue request, writing the
+// response and returning nil when it can't be acted on
+func (h *Handler) loadReviewInvoice(w http.ResponseWriter, r *http.Request, fn string, a db.ReviewAction) *db.ClientInvoice {
+	inv, err := db.GetClientInvoiceForOwner(r.Context(), a.OwnerID, a.FacturaID)
+	if err != nil {
+		log.Printf("%s: DB error: %v", fn, err)
+		sendAppError(w, ErrDBUnavailable)
+		return nil
+	}
+	if inv == nil {
+		h.sendError(w, http.StatusNotFound, "factura no encontrada")
+		return nil
+	}
+	if !db.IsReviewStatus(inv.ExtractionStatus) {
+		h.sendError(w, http.StatusConflict, db.ErrNotInReview.Error())
+		return nil
+	}
+	if err := checkPeriodo606Abierto(r.Context(), inv); err != nil {
+		h.sendPeriodoLockError(w, fn, err)
+		return nil
+	}
+	return inv
+}
+
+// decodeReviewNotas reads the optional {"notas": "..."} body of an action
+func (h *Handler) decodeReviewNotas(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var body struct {
+		Notas string `json:"notas"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			h.sendError(w, http.StatusBadRequest, "invalid JSON body")
+			return "", false
+		}
+	}
+	if len(body.Notas) > maxReviewNotasLen {
+		h.sendError(w, http.StatusBadRequest, fmt.Sprintf("notas: máximo %d caracteres", maxReviewNotasLen))
+		return "", false
+	}
+	return body.Notas, true
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: GET /api/revision/cola
+//   ?cliente_id=&status=review|error|revision_manual
+//   &min_confidence=&max_confidence=&min_age_hours=&max_age_hours=
+//   &sort=confidence|age&order=asc|desc&page=&limit=
+// ─────────────────────────────────────────────────────────────────────────────
+
+// GetReviewQueue lists the invoices of the contador's clients that need a
+// human: lowest confidence first by default, or oldest first with sort=age
+func (h *Handler) GetReviewQueue(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, err := auth.GetClaimsFromContext(r.Context())
+	if err != nil {
+		h.sendError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if db.Pool == nil {
+		sendAppError(w, ErrDBUnavailable)
+		return
+	}
+
+	q := r.URL.Query()
+	filter := db.ReviewQueueFilter{
+		OwnerID:   reviewOwnerID(claims),
+		ClienteID: q.Get("cliente_id"),
+		Status:    q.Get("status"),
+		Sort:      q.Get("sort"),
+		Desc:      q.Get("order") == "desc",
+		Lease:     reviewClaimLease,
+	}
+	if filter.Status != "" && !db.IsReviewStatus(filter.Status) {
+		h.sendError(w, http.StatusBadRequest, "status debe ser "+strings.Join(db.ReviewStatuses, ", "))
+		return
+	}
+	if filter.Sort != "" && filter.Sort != "confidence" && filter.Sort != "age" {
+		h.sendError(w, http.StatusBadRequest, "sort debe ser confidence o age")
+		return
+	}
+	if filter.ClienteID != "" {
+		if _, err := uuid.Parse(filter.ClienteID); err != nil {
+			h.sendError(w, http.StatusBadRequest, "cliente_id inválido")
+			return
+		}
+	}
+	for _, p := range []struct {
+		name string
+		dst  **float64
+	}{{"min_confidence", &filter.MinConfidence}, {"max_confidence", &filter.MaxConfidence}} {
+		if v := q.Get(p.name); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil || f < 0 || f > 1 {
+				h.sendError(w, http.StatusBadRequest, p.name+" debe estar entre 0 y 1")
+				return
+			}
+			*p.dst = &f
+		}
+	}
+	for _, p := range []struct {
+		name string
+		dst  *time.Duration
+	}{{"min_age_hours", &filter.MinAge}, {"max_age_hours", &filter.MaxAge}} {
+		if v := q.Get(p.name); v != "" {
+			hours, err := strconv.ParseFloat(v, 64)
+			if err != nil || hours < 0 {
+				h.sendError(w, http.StatusBadRequest, p.name+" inválido")
+				return
+			}
+			*p.dst = time.Duration(hours * float64(time.Hour))
+		}
+	}
+
+	page := 1
+	limit := 50
+	if val, err := strconv.Atoi(q.Get("page")); err == nil && val > 0 {
+		page = val
+	}
+	if val, err := strconv.Atoi(q.Get("limit")); err == nil && val > 0 && val <= 100 {
+		limit = val
+	}
+	filter.Limit = limit
+	filter.Offset = (page - 1) * limit
+
+	items, total, err := db.GetReviewQueue(r.Context(), filter)
+	if err != nil {
+		log.Printf("GetReviewQueue: DB error: %v", err)
+		sendAppError(w, ErrDBUnavailable)
+		return
+	}
+
+	totalPages := (total + limit - 1) / limit
+	if totalPages < 1 {
+		totalPages = 1
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"facturas":    items,
+		"total":       total,
+		"page":        page,
+		"limit":       limit,
+		"total_pages": totalPages,
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: GET /api/revision/{id}
+// ─────────────────────────────────────────────────────────────────────────────
+
+// GetReviewItem returns a queue item with its current validation and the
+// transitions recorded so far
+func (h *Handler) GetReviewItem(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, err := auth.GetClaimsFromContext(r.Context())
+	if err != nil {
+		h.sendError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if db.Pool == nil {
+		sendAppError(w, ErrDBUnavailable)
+		return
+	}
+
+	ownerID := reviewOwnerID(claims)
+	id := mux.Vars(r)["id"]
+	inv, err := db.GetClientInvoiceForOwner(r.Context(), ownerID, id)
+	if err != nil {
+		log.Printf("GetReviewItem: DB error: %v", err)
+		sendAppError(w, ErrDBUnavailable)
+		return
+	}
+	if inv == nil {
+		h.sendError(w, http.StatusNotFound, "factura no encontrada")
+		return
+	}
+
+	transitions, err := db.GetReviewTransitions(r.Context(), ownerID, id)
+	if err != nil {
+		log.Printf("GetReviewItem: DB error: %v", err)
+		sendAppError(w, ErrDBUnavailable)
+		return
+	}
+
+	validation, _, _ := validateClientInvoice(inv)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":           true,
+		"factura":           clientInvoiceToFrontend(inv),
+		"extraction_status": inv.ExtractionStatus,
+		"review_notes":      json.RawMessage(nullIfEmpty(inv.ReviewNotes)),
+		"validation":        validation,
+		"transiciones":      transitions,
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: POST /api/revision/{id}/tomar
+// ─────────────────────────────────────────────────────────────────────────────
+
+// ClaimReviewItem takes a queue item so other reviewers leave it alone for
+// reviewClaimLease. Taking it again renews the claim.
+func (h *Handler) ClaimReviewItem(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, err := auth.GetClaimsFromContext(r.Context())
+	if err != nil {
+		h.sendError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if db.Pool == nil {
+		sendAppError(w, ErrDBUnavailable)
+		return
+	}
+
+	t, err := db.ClaimReviewItem(r.Context(), reviewAction(r, claims, ""))
+	if err != nil {
+		h.sendReviewError(w, "ClaimReviewItem", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"transicion": t,
+		"expires_at": t.CreatedAt.Add(reviewClaimLease),
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: POST /api/revision/{id}/liberar
+// ─────────────────────────────────────────────────────────────────────────────
+
+// ReleaseReviewItem gives a claimed item back to the queue
+func (h *Handler) ReleaseReviewItem(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, err := auth.GetClaimsFromContext(r.Context())
+	if err != nil {
+		h.sendError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if db.Pool == nil {
+		sendAppError(w, ErrDBUnavailable)
+		return
+	}
+
+	notas, ok := h.decodeReviewNotas(w, r)
+	if !ok {
+		return
+	}
+
+	t, err := db.ReleaseReviewItem(r.Context(), reviewAction(r, claims, notas))
+	if err != nil {
+		h.sendReviewError(w, "ReleaseReviewItem", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"transicion": t,
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: PUT /api/revision/{id}/corregir
+// ─────────────────────────────────────────────────────────────────────────────
+
+// CorrectReviewItem stores corrected fields and validates the invoice again.
+// The item stays in the queue (claimed by the reviewer) until it is approved:
+// in "error" while the validation fails, otherwise in "review".
+func (h *Handler) CorrectReviewItem(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, err := auth.GetClaimsFromContext(r.Context())
+	if err != nil {
+		h.sendError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if db.Pool == nil {
+		sendAppError(w, ErrDBUnavailable)
+		return
+	}
+
+	var req struct {
+		Campos invoiceCorrection `json:"campos"`
+		Notas  string            `json:"notas"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if len(req.Notas) > maxReviewNotasLen {
+		h.sendError(w, http.StatusBadRequest, fmt.Sprintf("notas: máximo %d caracteres", maxReviewNotasLen))
+		return
+	}
+
+	action := reviewAction(r, claims, req.Notas)
+	inv := h.loadReviewInvoice(w, r, "CorrectReviewItem", action)
+	if inv == nil {
+		return
+	}
+
+	cambios, err := req.Campos.apply(inv)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(cambios) == 0 {
+		h.sendError(w, http.StatusBadRequest, "no hay campos que corregir")
+		return
+	}
+	// A new fecha_documento may fall in a finalized period
+	if _, ok := cambios["fecha_documento"]; ok {
+		if err := checkPeriodo606Abierto(r.Context(), inv); err != nil {
+			h.sendPeriodoLockError(w, "CorrectReviewItem", err)
+			return
+		}
+	}
+
+	validation := revalidateCorrection(inv)
+	if inv.ExtractionStatus == "validated" {
+		// Clean now, but only an approval takes it out of the queue
+		inv.ExtractionStatus = "review"
+	}
+	status := inv.ExtractionStatus
+
+	t, err := db.CorrectReviewItem(r.Context(), action, inv, cambios, correctionColumns(cambios), invoiceUpdatedEvent)
+	if err != nil {
+		h.sendReviewError(w, "CorrectReviewItem", err)
+		return
+	}
+	h.outbox.Notify()
+	learnFromCorrection(r.Context(), inv)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":           true,
+		"transicion":        t,
+		"extraction_status": status,
+		"validation":        validation,
+		"factura":           clientInvoiceToFrontend(inv),
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: POST /api/revision/{id}/aprobar
+// ─────────────────────────────────────────────────────────────────────────────
+
+// ApproveReviewItem marks the invoice validated. The stored fields must pass
+// the tax validation; notas are saved as notas_contador.
+func (h *Handler) ApproveReviewItem(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, err := auth.GetClaimsFromContext(r.Context())
+	if err != nil {
+		h.sendError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if db.Pool == nil {
+		sendAppError(w, ErrDBUnavailable)
+		return
+	}
+
+	notas, ok := h.decodeReviewNotas(w, r)
+	if !ok {
+		return
+	}
+
+	action := reviewAction(r, claims, notas)
+	inv := h.loadReviewInvoice(w, r, "ApproveReviewItem", action)
+	if inv == nil {
+		return
+	}
+
+	validation, _, reviewNotes := validateClientInvoice(inv)
+	if !validation.Valid {
+		sendJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+			"success":      false,
+			"error_code":   "VALIDATION_FAILED",
+			"error":        "invoice does not pass tax validation",
+			"user_message": "La factura tiene errores de validación. Corrígelos antes de aprobarla.",
+			"validation":   validation,
+		})
+		return
+	}
+
+	t, err := db.ApproveReviewItem(r.Context(), action, reviewNotes, func(inv *db.ClientInvoice) []db.OutboxEvent {
+		return extractionWebhookEvent(inv, uuid.New().String())
+	})
+	if err != nil {
+		h.sendReviewError(w, "ApproveReviewItem", err)
+		return
+	}
+	h.outbox.Notify()
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":           true,
+		"transicion":        t,
+		"extraction_status": db.ReviewApprovedStatus,
+		"validation":        validation,
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: POST /api/revision/{id}/rechazar
+// ─────────────────────────────────────────────────────────────────────────────
+
+// RejectReviewItem marks the invoice rejected (e.g. not a fiscal invoice or
+// illegible). It is excluded from the DGII formats; notas must give the reason.
+func (h *Handler) RejectReviewItem(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, err := auth.GetClaimsFromContext(r.Context())
+	if err != nil {
+		h.sendError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if db.Pool == nil {
+		sendAppError(w, ErrDBUnavailable)
+		return
+	}
+
+	notas, ok := h.decodeReviewNotas(w, r)
+	if !ok {
+		return
+	}
+	action := reviewAction(r, claims, notas)
+	if action.Notas == "" {
+		h.sendError(w, http.StatusBadRequest, "notas es requerido para rechazar una factura")
+		return
+	}
+
+	if inv := h.loadReviewInvoice(w, r, "RejectReviewItem", action); inv == nil {
+		return
+	}
+
+	t, err := db.RejectReviewItem(r.Context(), action, invoiceUpdatedEvent)
+	if err != nil {
+		h.sendReviewError(w, "RejectReviewItem", err)
+		return
+	}
+	h.outbox.Notify()
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":           true,
+		"transicion":        t,
+		"extraction_status": db.ReviewRejectedStatus,
+	})
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/facturaIA/invoice-ocr-service/internal/db"
+	"github.com/facturaIA/invoice-ocr-service/internal/services"
+	"github.com/facturaIA/invoice-ocr-service/internal/storage"
+)
+
+const (
+	defaultRetryMaxAttempts    = 5
+	defaultRetryBackoffMinutes = 5
+	retryPollInterval          = time.Minute
+	retryBatchSize             = 10
+	retryMaxBackoff            = 24 * time.Hour
+	// Claimed invoices are hidden from other workers for this long
+	retryLease = 2 * jobTimeout
+)
+
+// StartRevisionManualRetryWorker periodically retries the extraction of
+// invoices saved as revision_manual because every AI provider failed. Each
+// failure doubles the wait; after jobs.retry_max_attempts the invoice is left
+// for an accountant. A negative retry_max_attempts disables the worker.
+func (h *Handler) StartRevisionManualRetryWorker(ctx context.Context) {
+	maxAttempts := h.config.Jobs.RetryMaxAttempts
+	if maxAttempts < 0 {
+		log.Println("[Retry] revision_manual retries disabled")
+		return
+	}
+	if maxAttempts == 0 {
+		maxAttempts = defaultRetryMaxAttempts
+	}
+
+	go func() {
+		ticker := time.NewTicker(retryPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if db.Pool == nil || storage.Client == nil {
+				continue
+			}
+
+			pendientes, err := db.ClaimRevisionManualRetries(ctx, maxAttempts, retryBatchSize, retryLease, reviewClaimLease)
+			if err != nil {
+				log.Printf("[Retry] Error selecting revision_manual invoices: %v", err)
+				continue
+			}
+			for _, p := range pendientes {
+				if err := h.retryRevisionManual(ctx, p); err != nil {
+					h.scheduleRetry(p, maxAttempts, err)
+				}
+			}
+		}
+	}()
+	log.Printf("[Retry] revision_manual retry worker started (max %d attempts)", maxAttempts)
+}
+
+// retryRevisionManual re-downloads the image and runs extraction and
+// validation again, storing the result like a regular upload would. The
+// result is dropped if the invoice changed meanwhile (see
+// db.SaveRevisionManualRetry).
+func (h *Handler) retryRevisionManual(ctx context.Context, p db.RevisionManualPendiente) error {
+	ctx, cancel := context.WithTimeout(ctx, jobTimeout)
+	defer cancel()
+
+	imageData, err := storage.DownloadImage(ctx, p.ArchivoURL)
+	if err != nil {
+		return err
+	}
+
+	updated, err := h.reextractPendiente(ctx, p, imageData)
+	if err != nil {
+		return err
+	}
+
+	if err := checkPeriodo606Abierto(ctx, updated); err != nil {
+		return err
+	}
+
+	if err := db.SaveRevisionManualRetry(ctx, p, updated, reviewClaimLease, db.AuditActor{
+		Source: db.AuditSourceAI,
+		Reason: "reintento automático de extracción",
+	}, func(inv *db.ClientInvoice) []db.OutboxEvent {
+		return extractionWebhookEvent(inv, fmt.Sprintf("%s:retry:%d", inv.ID, p.RetryAttempts))
+	}); err != nil {
+		return err
+	}
+	h.outbox.Notify()
+	log.Printf("[Retry] Factura %s reprocesada (intento %d): %s", p.ID, p.RetryAttempts, updated.ExtractionStatus)
+	return nil
+}
+
+// reextractPendiente extracts a revision_manual invoice again under the
+// empresa it was uploaded with, so its PII policy and prompt version apply
+func (h *Handler) reextractPendiente(ctx context.Context, p db.RevisionManualPendiente, imageData []byte) (*db.ClientInvoice, error) {
+	updated, validationResult, err := h.reextractInvoice(ctx, imageData, extractionHints{
+		ClienteID:    p.ClienteID,
+		EmpresaAlias: p.EmpresaAlias,
+		FacturaID:    p.ID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The upload dedup never ran for this invoice: flag duplicates for review
+	// instead of deleting what the user already sent
+	if updated.NCF != "" {
+		if isDup, dupErr := db.CheckDuplicateNCF(ctx, p.ClienteID, updated.NCF, updated.EmisorRNC); dupErr == nil && isDup {
+			updated.ExtractionStatus = "review"
+			validationResult.NeedsReview = true
+			validationResult.Warnings = append(validationResult.Warnings, services.ValidationWarning{
+				Field:   "ncf",
+				Code:    "duplicate_ncf",
+				Message: fmt.Sprintf("Ya existe otra factura con NCF %s del mismo proveedor", updated.NCF),
+			})
+			updated.ReviewNotes = reviewNotesFor(validationResult)
+		}
+	}
+	return updated, nil
+}
+
+// scheduleRetry stores the failure and the next attempt time, or gives up
+// once maxAttempts is reached
+func (h *Handler) scheduleRetry(p db.RevisionManualPendiente, maxAttempts int, cause error) {
+	next, deferred := h.nextRetry(p, maxAttempts, cause, time.Now())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var err error
+	switch {
+	case deferred:
+		log.Printf("[Retry] Factura %s pospuesta sin contar el intento: %v. Próximo intento %s", p.ID, cause, next.Format(time.RFC3339))
+		err = db.DeferRevisionManualRetry(ctx, p.ID, *next, cause.Error())
+	case next != nil:
+		log.Printf("[Retry] Factura %s falló (intento %d/%d): %v. Próximo intento %s", p.ID, p.RetryAttempts, maxAttempts, cause, next.Format(time.RFC3339))
+		err = db.ScheduleRevisionManualRetry(ctx, p.ID, next, cause.Error())
+	default:
+		log.Printf("[Retry] Factura %s falló (intento %d/%d): %v. Queda para revisión manual", p.ID, p.RetryAttempts, maxAttempts, cause)
+		err = db.ScheduleRevisionManualRetry(ctx, p.ID, nil, cause.Error())
+	}
+	if err != nil {
+		log.Printf("[Retry] Error saving retry state of %s: %v", p.ID, err)
+	}
+}
+
+// nextRetry returns when to retry p after cause, nil to stop retrying. A
+// deferred retry does not count the attempt: the AI quota of the empresa was
+// exhausted, or a reviewer holds the invoice. An invoice closed in a
+// finalized 606 or changed during the retry is left to people.
+func (h *Handler) nextRetry(p db.RevisionManualPendiente, maxAttempts int, cause error, now time.Time) (next *time.Time, deferred bool) {
+	switch {
+	case errors.Is(cause, errAIQuotaExceeded):
+		t := now.Add(retryMaxBackoff)
+		return &t, true
+	case errors.Is(cause, db.ErrReviewClaimed):
+		t := now.Add(reviewClaimLease)
+		return &t, true
+	case errors.Is(cause, db.ErrPeriodoFinalizado), errors.Is(cause, db.ErrRetrySuperseded):
+		return nil, false
+	case p.RetryAttempts >= maxAttempts:
+		return nil, false
+	}
+
+	backoff := time.Duration(h.config.Jobs.RetryBackoffMinutes) * time.Minute
+	if backoff <= 0 {
+		backoff = defaultRetryBackoffMinutes * time.Minute
+	}
+	backoff <<= p.RetryAttempts - 1
+	if backoff <= 0 || backoff > retryMaxBackoff {
+		backoff = retryMaxBackoff
+	}
+	t := now.Add(backoff)
+	return &t, false
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/facturaIA/invoice-ocr-service/internal/ai"
+	"github.com/facturaIA/invoice-ocr-service/internal/db"
+)
+
+func TestNextRetry(t *testing.T) {
+	h := newTestHandler(ai.NewFakeProvider())
+	h.config.Jobs.RetryBackoffMinutes = 5
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	failed := errors.New("AI extraction failed: 503 service unavailable")
+
+	cases := []struct {
+		name     string
+		attempts int
+		cause    error
+		after    time.Duration // 0: no more retries
+		deferred bool
+	}{
+		{"first failure", 1, failed, 5 * time.Minute, false},
+		{"third failure", 3, failed, 20 * time.Minute, false},
+		{"last attempt", 5, failed, 0, false},
+		{"quota exhausted", 5, fmt.Errorf("retry: %w", errAIQuotaExceeded), retryMaxBackoff, true},
+		{"held by a reviewer", 2, db.ErrReviewClaimed, reviewClaimLease, true},
+		{"edited meanwhile", 1, db.ErrRetrySuperseded, 0, false},
+		{"606 finalizado", 1, db.ErrPeriodoFinalizado, 0, false},
+	}
+	for _, tc := range cases {
+		p := db.RevisionManualPendiente{ID: "f-1", RetryAttempts: tc.attempts}
+		next, deferred := h.nextRetry(p, 5, tc.cause, now)
+		switch {
+		case tc.after == 0 && next != nil:
+			t.Errorf("%s: next = %v, want no more retries", tc.name, next)
+		case tc.after != 0 && (next == nil || !next.Equal(now.Add(tc.after))):
+			t.Errorf("%s: next = %v, want %v", tc.name, next, now.Add(tc.after))
+		case deferred != tc.deferred:
+			t.Errorf("%s: deferred = %v, want %v", tc.name, deferred, tc.deferred)
+		}
+	}
+}
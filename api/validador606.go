@@ -0,0 +1,318 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/facturaIA/invoice-ocr-service/internal/db"
+)
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Validador estructural Formato 606 (reglas del pre-validador DGII)
+// ─────────────────────────────────────────────────────────────────────────────
+
+// Severidades de Error606
+const (
+	severidadError       = "error"
+	severidadAdvertencia = "advertencia"
+)
+
+// campos606 are the field names of the 606 data line, indexed by field number
+// (see build606Line). Field 0 is the header line.
+var campos606 = [24]string{
+	"CABECERA",
+	"RNC_PROVEEDOR", "TIPO_IDENTIFICACION", "TIPO_BIEN_SERVICIO", "NCF", "NCF_MODIFICADO",
+	"FECHA_COMPROBANTE", "FECHA_PAGO", "MONTO_SERVICIOS", "MONTO_BIENES", "TOTAL_MONTO_FACTURADO",
+	"ITBIS_FACTURADO", "ITBIS_RETENIDO", "ITBIS_PROPORCIONALIDAD", "ITBIS_COSTO", "ITBIS_POR_ADELANTAR",
+	"ITBIS_PERCIBIDO", "TIPO_RETENCION_ISR", "RETENCION_RENTA", "ISR_PERCIBIDO",
+	"ISC", "OTROS_IMPUESTOS", "PROPINA_LEGAL", "FORMA_PAGO",
+}
+
+// Error606 is one rule violation found by validar606
+type Error606 struct {
+	Registro    int    `json:"registro"` // 1-based data line; 0 = cabecera
+	FacturaID   string `json:"factura_id,omitempty"`
+	NCF         string `json:"ncf,omitempty"`
+	Campo       int    `json:"campo"`
+	NombreCampo string `json:"nombre_campo"`
+	Codigo      string `json:"codigo"`
+	Severidad   string `json:"severidad"`
+	Mensaje     string `json:"mensaje"`
+	Valor       string `json:"valor,omitempty"`
+}
+
+// String renders the error in the "Registro N: ..." form used by the preview lists
+func (e Error606) String() string {
+	if e.Registro == 0 && e.FacturaID != "" {
+		return fmt.Sprintf("Factura excluida [%s]: %s", e.Codigo, e.Mensaje)
+	}
+	if e.Registro == 0 {
+		return fmt.Sprintf("Cabecera [%s]: %s", e.Codigo, e.Mensaje)
+	}
+	return fmt.Sprintf("Registro %d, campo %d %s [%s]: %s", e.Registro, e.Campo, e.NombreCampo, e.Codigo, e.Mensaje)
+}
+
+var (
+	reDigits    = regexp.MustCompile(`^[0-9]+$`)
+	reNCF       = regexp.MustCompile(`^B(0[1-4]|1[1-7])[0-9]{8}$`)
+	reECF       = regexp.MustCompile(`^E(3[1-4]|4[1-7])[0-9]{10}$`)
+	reCodigo2   = regexp.MustCompile(`^[0-9]{2}$`)
+	rePeriodo   = regexp.MustCompile(`^[0-9]{4}(0[1-9]|1[0-2])$`)
+	maxMonto606 = 999999999999.99 // 12 enteros + 2 decimales
+)
+
+// ncfTipo returns the two-digit comprobante type ("01", "11", "31"...) or ""
+func ncfTipo(ncf string) string {
+	if len(ncf) < 3 {
+		return ""
+	}
+	return ncf[1:3]
+}
+
+// validar606 applies the DGII 606 structural rules to the header and every data
+// line of rnc/periodo. Lines are numbered in the same order build606Envio writes them.
+func validar606(rncReceptor, periodo string, invoices []db.Formato606Invoice) []Error606 {
+	errs := []Error606{}
+
+	cab := func(codigo, msg, valor string) {
+		errs = append(errs, Error606{Campo: 0, NombreCampo: campos606[0], Codigo: codigo,
+			Severidad: severidadError, Mensaje: msg, Valor: valor})
+	}
+	if !reDigits.MatchString(rncReceptor) || (len(rncReceptor) != 9 && len(rncReceptor) != 11) {
+		cab("E001", "RNC/cédula del informante debe tener 9 u 11 dígitos", rncReceptor)
+	}
+	if !rePeriodo.MatchString(periodo) {
+		cab("E002", "Período inválido; formato AAAAMM", periodo)
+	}
+
+	inicio, _ := time.Parse("200601", periodo)
+	fin := inicio.AddDate(0, 1, 0)
+	enPeriodo := func(t *time.Time) bool {
+		return inicio.IsZero() || (!t.Before(inicio) && t.Before(fin))
+	}
+
+	for i, inv := range invoices {
+		errs = append(errs, validar606Registro(inv, i+1, enPeriodo)...)
+	}
+	return errs
+}
+
+// codigoSinConvertir flags a foreign-currency invoice a report leaves out
+// because its conversion to DOP failed
+const codigoSinConvertir = "E003"
+
+// mensajeSinConvertir describes an invoice left out of formato
+func mensajeSinConvertir(f db.FacturaSinConvertir, formato string) string {
+	return fmt.Sprintf("Factura %s en %s sin convertir a DOP; excluida del %s hasta registrar la tasa de cambio", f.NCF, f.Moneda, formato)
+}
+
+// sinConvertir606 reports the purchases left out of the 606 for their
+// currency. They are errors: the file is incomplete without them.
+func sinConvertir606(facturas []db.FacturaSinConvertir) []Error606 {
+	errs := make([]Error606, 0, len(facturas))
+	for _, f := range facturas {
+		errs = append(errs, Error606{
+			FacturaID:   f.ID,
+			NCF:         f.NCF,
+			Campo:       10,
+			NombreCampo: campos606[10],
+			Codigo:      codigoSinConvertir,
+			Severidad:   severidadError,
+			Mensaje:     mensajeSinConvertir(f, "606"),
+			Valor:       fmt.Sprintf("%s %.2f", f.Moneda, f.Monto),
+		})
+	}
+	return errs
+}
+
+// validar606Registro validates one data line. Field values are taken exactly as
+// build606Line writes them.
+func validar606Registro(inv db.Formato606Invoice, registro int, enPeriodo func(*time.Time) bool) []Error606 {
+	var errs []Error606
+	add := func(campo int, severidad, codigo, msg, valor string) {
+		errs = append(errs, Error606{
+			Registro:    registro,
+			FacturaID:   inv.ID,
+			NCF:         inv.NCF,
+			Campo:       campo,
+			NombreCampo: campos606[campo],
+			Codigo:      codigo,
+			Severidad:   severidad,
+			Mensaje:     msg,
+			Valor:       valor,
+		})
+	}
+
+	// 1-2: RNC / tipo de identificación
+	rnc := cleanRNC(inv.EmisorRNC)
+	tipoID := inv.TipoIDEmisor
+	if tipoID == "" {
+		tipoID = tipoIDFromRNC(rnc)
+	}
+	switch {
+	case rnc == "":
+		add(1, severidadError, "E101", "RNC/cédula del proveedor requerido", "")
+	case !reDigits.MatchString(rnc):
+		add(1, severidadError, "E102", "RNC/cédula del proveedor solo admite dígitos", rnc)
+	case len(rnc) != 9 && len(rnc) != 11:
+		add(1, severidadError, "E103", "RNC debe tener 9 dígitos y cédula 11", rnc)
+	}
+	switch {
+	case tipoID != "1" && tipoID != "2":
+		add(2, severidadError, "E201", "Tipo de identificación debe ser 1 (RNC) o 2 (cédula)", tipoID)
+	case tipoID == "1" && rnc != "" && len(rnc) != 9:
+		add(2, severidadError, "E202", "Tipo 1 (RNC) requiere 9 dígitos", rnc)
+	case tipoID == "2" && rnc != "" && len(rnc) != 11:
+		add(2, severidadError, "E203", "Tipo 2 (cédula) requiere 11 dígitos", rnc)
+	}
+
+	// 3: Tipo de bien o servicio 01-11
+	if inv.TipoBienServicio == "" {
+		add(3, severidadAdvertencia, "W301", "Tipo de bien/servicio vacío; se reporta 06 por defecto", "")
+	} else if n, err := strconv.Atoi(inv.TipoBienServicio); err != nil || !reCodigo2.MatchString(inv.TipoBienServicio) || n < 1 || n > 11 {
+		add(3, severidadError, "E301", "Tipo de bien/servicio debe ser 01-11", inv.TipoBienServicio)
+	}
+
+	// 4: NCF (B + 2 + 8 = 11, e-CF E + 2 + 10 = 13) vs tipo de identificación
+	tipoNCF := ncfTipo(inv.NCF)
+	switch {
+	case inv.NCF == "":
+		add(4, severidadError, "E401", "NCF requerido", "")
+	case len(inv.NCF) != 11 && len(inv.NCF) != 13:
+		add(4, severidadError, "E402", "NCF debe tener 11 (B) o 13 (e-CF) caracteres", inv.NCF)
+	case !reNCF.MatchString(inv.NCF) && !reECF.MatchString(inv.NCF):
+		add(4, severidadError, "E403", "Formato o tipo de NCF inválido", inv.NCF)
+	default:
+		switch tipoNCF {
+		case "11", "41":
+			// Comprobante de compras: emitido a personas físicas no registradas
+			if tipoID != "2" {
+				add(4, severidadError, "E404", "Comprobante de compras (B11/E41) requiere proveedor con cédula (tipo 2)", inv.NCF)
+			}
+		case "01", "31":
+			if tipoID == "2" && len(rnc) != 11 {
+				add(4, severidadError, "E405", "Crédito fiscal con tipo 2 requiere cédula de 11 dígitos", inv.NCF)
+			}
+		case "02", "32":
+			add(4, severidadAdvertencia, "W401", "Factura de consumo (B02/E32) no otorga crédito fiscal de ITBIS", inv.NCF)
+		}
+	}
+
+	// 5: NCF modificado, obligatorio para notas de débito/crédito
+	esNota := tipoNCF == "03" || tipoNCF == "04" || tipoNCF == "33" || tipoNCF == "34"
+	switch {
+	case esNota && inv.NCFModifica == "":
+		add(5, severidadError, "E501", "Nota de débito/crédito requiere el NCF modificado", "")
+	case !esNota && inv.NCFModifica != "":
+		add(5, severidadError, "E502", "NCF modificado solo aplica a notas de débito/crédito", inv.NCFModifica)
+	case inv.NCFModifica != "" && !reNCF.MatchString(inv.NCFModifica) && !reECF.MatchString(inv.NCFModifica):
+		add(5, severidadError, "E503", "Formato de NCF modificado inválido", inv.NCFModifica)
+	}
+
+	// 6-7: fechas
+	hasFechaComp := inv.FechaDocumento != nil && !inv.FechaDocumento.IsZero()
+	hasFechaPago := inv.FechaPago != nil && !inv.FechaPago.IsZero()
+	if !hasFechaComp {
+		add(6, severidadError, "E601", "Fecha del comprobante requerida", "")
+	} else if inv.FechaDocumento.After(time.Now()) {
+		add(6, severidadError, "E602", "Fecha del comprobante no puede ser futura", fmtFecha(inv.FechaDocumento))
+	}
+	if hasFechaComp && hasFechaPago && inv.FechaPago.Before(*inv.FechaDocumento) {
+		add(7, severidadError, "E701", "Fecha de pago no puede ser anterior a la fecha del comprobante", fmtFecha(inv.FechaPago))
+	}
+	tieneRetencion := inv.ITBISRetenido > 0 || inv.ISR > 0
+	if tieneRetencion {
+		switch {
+		case !hasFechaPago:
+			add(7, severidadError, "E702", "Fecha de pago requerida cuando hay retenciones", "")
+		case !enPeriodo(inv.FechaPago):
+			add(7, severidadError, "E703", "Fecha de pago de una retención debe estar dentro del período", fmtFecha(inv.FechaPago))
+		}
+	}
+
+	// 8-10: montos; campo 10 = 8 + 9, or the subtotal when the split is empty.
+	// The split is checked against the invoice's own subtotal.
+	desglose := inv.MontoServicios + inv.MontoBienes
+	total := desglose
+	if total == 0 {
+		total = inv.Subtotal
+	}
+	if total <= 0 {
+		add(10, severidadError, "E1001", "Total monto facturado debe ser mayor que 0", fmtMonto(total, true))
+	} else if desglose != 0 && inv.Subtotal > 0 && math.Abs(desglose-inv.Subtotal) > 0.01 {
+		add(10, severidadError, "E1002", "Monto servicios (8) + monto bienes (9) debe ser igual al total facturado (10)",
+			fmt.Sprintf("%.2f + %.2f ≠ %.2f", inv.MontoServicios, inv.MontoBienes, inv.Subtotal))
+	}
+
+	montos := map[int]float64{
+		8: inv.MontoServicios, 9: inv.MontoBienes, 10: total,
+		11: inv.ITBIS, 12: inv.ITBISRetenido, 13: inv.ITBISProporcionalidad, 14: inv.ITBISCosto,
+		16: inv.ITBISPercibido, 18: inv.ISR, 19: inv.ISRPercibido,
+		20: inv.ISC, 21: inv.CDTMonto + inv.Cargo911, 22: inv.Propina,
+	}
+	for campo := 8; campo <= 22; campo++ {
+		v, ok := montos[campo]
+		if !ok {
+			continue
+		}
+		if v < 0 {
+			add(campo, severidadError, "EM01", "Monto no puede ser negativo", fmtMonto(v, true))
+		} else if v > maxMonto606 {
+			add(campo, severidadError, "EM02", "Monto excede 12 enteros y 2 decimales", fmtMonto(v, true))
+		}
+	}
+
+	// 11-15: ITBIS
+	if inv.ITBIS > total*0.18+0.01 && total > 0 {
+		add(11, severidadError, "E1101", "ITBIS facturado excede el 18% del total facturado", fmtMonto(inv.ITBIS, true))
+	}
+	if inv.ITBISRetenido > inv.ITBIS+0.01 {
+		add(12, severidadError, "E1201", "ITBIS retenido no puede exceder el ITBIS facturado", fmtMonto(inv.ITBISRetenido, true))
+	}
+	if inv.ITBISProporcionalidad+inv.ITBISCosto > inv.ITBIS+0.01 {
+		add(13, severidadError, "E1301", "ITBIS proporcionalidad (13) + costo (14) no puede exceder el ITBIS facturado (11)",
+			fmtMonto(inv.ITBISProporcionalidad+inv.ITBISCosto, true))
+	}
+
+	// 17-18: retención ISR
+	tipoRet := 0
+	if inv.RetencionISRTipo != nil {
+		tipoRet = *inv.RetencionISRTipo
+	}
+	switch {
+	case inv.ISR > 0 && (tipoRet < 1 || tipoRet > 8):
+		add(17, severidadError, "E1701", "Tipo de retención ISR (1-8) requerido cuando hay retención de renta", strconv.Itoa(tipoRet))
+	case inv.ISR == 0 && tipoRet != 0:
+		add(18, severidadError, "E1801", "Tipo de retención ISR informado sin monto retenido", strconv.Itoa(tipoRet))
+	}
+
+	// 23: forma de pago 01-07
+	formaPago := inv.FormaPago
+	if len(formaPago) > 2 {
+		formaPago = formaPago[:2]
+	}
+	if formaPago == "" {
+		add(23, severidadError, "E2301", "Forma de pago requerida", "")
+	} else if n, err := strconv.Atoi(formaPago); err != nil || !reCodigo2.MatchString(formaPago) || n < 1 || n > 7 {
+		add(23, severidadError, "E2302", "Forma de pago debe ser 01-07", inv.FormaPago)
+	}
+
+	return errs
+}
+
+// resumen606 splits validation results into the error/warning string lists used
+// by the preview and validate responses
+func resumen606(errs []Error606) validationResult606 {
+	res := validationResult606{Errores: []string{}, Advertencias: []string{}}
+	for _, e := range errs {
+		if e.Severidad == severidadError {
+			res.Errores = append(res.Errores, e.String())
+		} else {
+			res.Advertencias = append(res.Advertencias, e.String())
+		}
+	}
+	return res
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/facturaIA/invoice-ocr-service/internal/db"
+)
+
+func TestSinConvertirReportsExcludedInvoices(t *testing.T) {
+	excluidas := []db.FacturaSinConvertir{{ID: "f-1", NCF: "B0100000001", RNC: "101000001", Moneda: "USD", Monto: 118}}
+
+	errs := sinConvertir606(excluidas)
+	if len(errs) != 1 {
+		t.Fatalf("errs = %+v", errs)
+	}
+	e := errs[0]
+	if e.Codigo != codigoSinConvertir || e.Severidad != severidadError || e.FacturaID != "f-1" || e.Valor != "USD 118.00" {
+		t.Errorf("error = %+v", e)
+	}
+	vr := resumen606(errs)
+	if len(vr.Errores) != 1 || !strings.HasPrefix(vr.Errores[0], "Factura excluida [E003]") || !strings.Contains(vr.Errores[0], "B0100000001") {
+		t.Errorf("errores = %v", vr.Errores)
+	}
+
+	errs607 := sinConvertir607(excluidas)
+	if len(errs607) != 1 || !strings.Contains(errs607[0], "[E003]") || !strings.Contains(errs607[0], "excluida del 607") {
+		t.Errorf("607 errores = %v", errs607)
+	}
+	if got := sinConvertir607(nil); got == nil || len(got) != 0 {
+		t.Errorf("no exclusions = %#v, want an empty list", got)
+	}
+}
+
+// valid606 is a B01 purchase that passes every line check
+func valid606() db.Formato606Invoice {
+	fecha := time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC)
+	return db.Formato606Invoice{
+		ID:               "f-1",
+		EmisorRNC:        "101-00000-1",
+		TipoBienServicio: "02",
+		NCF:              "B0100000001",
+		FechaDocumento:   &fecha,
+		MontoServicios:   600,
+		MontoBienes:      400,
+		Subtotal:         1000,
+		ITBIS:            180,
+		FormaPago:        "01",
+	}
+}
+
+func TestValidar606Registro(t *testing.T) {
+	enMarzo := func(f *time.Time) bool { return f != nil && f.Year() == 2025 && f.Month() == time.March }
+	pago := func(d int) *time.Time { f := time.Date(2025, 3, d, 0, 0, 0, 0, time.UTC); return &f }
+	tipo := func(n int) *int { return &n }
+
+	tests := []struct {
+		name  string
+		edit  func(*db.Formato606Invoice)
+		codes []string
+	}{
+		{"valid", func(*db.Formato606Invoice) {}, nil},
+		{"no rnc", func(i *db.Formato606Invoice) { i.EmisorRNC = "" }, []string{"E101"}},
+		{"rnc with letters", func(i *db.Formato606Invoice) { i.EmisorRNC = "10100000A"; i.TipoIDEmisor = "1" }, []string{"E102"}},
+		{"rnc length", func(i *db.Formato606Invoice) { i.EmisorRNC = "1010000"; i.TipoIDEmisor = "1" }, []string{"E103", "E202"}},
+		{"tipo bien vacío", func(i *db.Formato606Invoice) { i.TipoBienServicio = "" }, []string{"W301"}},
+		{"tipo bien fuera de rango", func(i *db.Formato606Invoice) { i.TipoBienServicio = "12" }, []string{"E301"}},
+		{"no ncf", func(i *db.Formato606Invoice) { i.NCF = "" }, []string{"E401"}},
+		{"ncf length", func(i *db.Formato606Invoice) { i.NCF = "B01000001" }, []string{"E402"}},
+		{"ncf tipo", func(i *db.Formato606Invoice) { i.NCF = "B0900000001" }, []string{"E403"}},
+		{"B11 con RNC", func(i *db.Formato606Invoice) { i.NCF = "B1100000001" }, []string{"E404"}},
+		{"consumo", func(i *db.Formato606Invoice) { i.NCF = "B0200000001" }, []string{"W401"}},
+		{"nota sin modificado", func(i *db.Formato606Invoice) { i.NCF = "B0400000001" }, []string{"E501"}},
+		{"modificado sin nota", func(i *db.Formato606Invoice) { i.NCFModifica = "B0100000002" }, []string{"E502"}},
+		{"sin fecha", func(i *db.Formato606Invoice) { i.FechaDocumento = nil }, []string{"E601"}},
+		{"pago antes del comprobante", func(i *db.Formato606Invoice) { i.FechaPago = pago(1) }, []string{"E701"}},
+		{"retención sin pago", func(i *db.Formato606Invoice) { i.ITBISRetenido = 54 }, []string{"E702"}},
+		{"retención fuera del período", func(i *db.Formato606Invoice) {
+			i.ITBISRetenido = 54
+			f := time.Date(2025, 4, 2, 0, 0, 0, 0, time.UTC)
+			i.FechaPago = &f
+		}, []string{"E703"}},
+		{"retención en el período", func(i *db.Formato606Invoice) { i.ITBISRetenido = 54; i.FechaPago = pago(20) }, nil},
+		{"total cero", func(i *db.Formato606Invoice) {
+			i.MontoServicios, i.MontoBienes, i.Subtotal, i.ITBIS = 0, 0, 0, 0
+		}, []string{"E1001"}},
+		{"desglose distinto del subtotal", func(i *db.Formato606Invoice) { i.MontoBienes = 500 }, []string{"E1002"}},
+		{"sin desglose usa el subtotal", func(i *db.Formato606Invoice) { i.MontoServicios, i.MontoBienes = 0, 0 }, nil},
+		{"monto negativo", func(i *db.Formato606Invoice) { i.ISC = -1 }, []string{"EM01"}},
+		{"monto excesivo", func(i *db.Formato606Invoice) { i.Propina = 1e13 }, []string{"EM02"}},
+		{"itbis sobre 18%", func(i *db.Formato606Invoice) { i.ITBIS = 200 }, []string{"E1101"}},
+		{"retenido sobre itbis", func(i *db.Formato606Invoice) { i.ITBISRetenido = 200; i.FechaPago = pago(20) }, []string{"E1201"}},
+		{"proporcionalidad sobre itbis", func(i *db.Formato606Invoice) { i.ITBISProporcionalidad, i.ITBISCosto = 100, 100 }, []string{"E1301"}},
+		{"isr sin tipo", func(i *db.Formato606Invoice) { i.ISR = 100; i.FechaPago = pago(20) }, []string{"E1701"}},
+		{"tipo sin isr", func(i *db.Formato606Invoice) { i.RetencionISRTipo = tipo(2) }, []string{"E1801"}},
+		{"sin forma de pago", func(i *db.Formato606Invoice) { i.FormaPago = "" }, []string{"E2301"}},
+		{"forma de pago inválida", func(i *db.Formato606Invoice) { i.FormaPago = "09" }, []string{"E2302"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inv := valid606()
+			tt.edit(&inv)
+			var got []string
+			for _, e := range validar606Registro(inv, 1, enMarzo) {
+				got = append(got, e.Codigo)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.codes, ",") {
+				t.Errorf("codes = %v, want %v", got, tt.codes)
+			}
+		})
+	}
+}
@@ -0,0 +1,337 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/facturaIA/invoice-ocr-service/internal/auth"
+	"github.com/facturaIA/invoice-ocr-service/internal/db"
+	"github.com/facturaIA/invoice-ocr-service/internal/models"
+	"github.com/facturaIA/invoice-ocr-service/internal/webhooks"
+)
+
+const (
+	defaultDeliveriesLimit = 50
+	maxDeliveriesLimit     = 200
+)
+
+// invoiceWebhookEvent builds an invoice event whose data is the invoice as the
+// frontend sees it. key must be unique per occurrence of eventType.
+func invoiceWebhookEvent(eventType string, inv *db.ClientInvoice, key string) []db.OutboxEvent {
+	ev, err := webhooks.NewEvent(inv.ClienteID, eventType, "factura", inv.ID, key, map[string]interface{}{
+		"factura": clientInvoiceToFrontend(inv),
+	})
+	if err != nil {
+		log.Printf("[Webhooks] Error building %s event for %s: %v", eventType, inv.ID, err)
+		return nil
+	}
+	return []db.OutboxEvent{ev}
+}
+
+// invoiceUpdatedEvent is invoice.updated for an invoice changed after upload
+func invoiceUpdatedEvent(inv *db.ClientInvoice) []db.OutboxEvent {
+	return invoiceWebhookEvent(webhooks.InvoiceUpdated, inv, uuid.New().String())
+}
+
+// extractionWebhookEvent is invoice.processed for a validated extraction and
+// invoice.needs_review for anything an accountant has to look at
+func extractionWebhookEvent(inv *db.ClientInvoice, key string) []db.OutboxEvent {
+	if inv.ExtractionStatus == "validated" {
+		return invoiceWebhookEvent(webhooks.InvoiceProcessed, inv, key)
+	}
+	return invoiceWebhookEvent(webhooks.InvoiceNeedsReview, inv, key)
+}
+
+// notifyDuplicateRejected emits invoice.duplicate_rejected for an upload that
+// stored nothing
+func (h *Handler) notifyDuplicateRejected(ctx context.Context, clienteID, errorCode string, data map[string]interface{}) {
+	data["error_code"] = errorCode
+	ev, err := webhooks.NewEvent(clienteID, webhooks.InvoiceDuplicateRejected, "cliente", clienteID, uuid.New().String(), data)
+	if err == nil {
+		err = db.EnqueueOutboxEvents(ctx, ev)
+	}
+	if err != nil {
+		log.Printf("[Webhooks] Error queuing %s event: %v", webhooks.InvoiceDuplicateRejected, err)
+		return
+	}
+	h.outbox.Notify()
+}
+
+// duplicateWebhookData identifies the rejected invoice for
+// invoice.duplicate_rejected
+func duplicateWebhookData(invoice *models.Invoice) map[string]interface{} {
+	return map[string]interface{}{
+		"ncf":             invoice.NCF,
+		"emisor_rnc":      invoice.RNCEmisor,
+		"emisor_nombre":   invoice.NombreEmisor,
+		"fecha_documento": invoice.FechaFactura,
+		"hora_factura":    invoice.HoraFactura,
+		"monto":           decimalToFloat64(invoice.Total),
+	}
+}
+
+// notifyEnvio606Generated emits envio606.generated after a 606 draft or
+// rectificativa was stored
+func (h *Handler) notifyEnvio606Generated(ctx context.Context, clienteID string, envio *db.Envio606) {
+	key := fmt.Sprintf("%s:%d", envio.ID, envio.UpdatedAt.UnixNano())
+	ev, err := webhooks.NewEvent(clienteID, webhooks.Envio606Generated, "envio_606", envio.ID, key, map[string]interface{}{
+		"envio": envio,
+	})
+	if err == nil {
+		err = db.EnqueueOutboxEvents(ctx, ev)
+	}
+	if err != nil {
+		log.Printf("[Webhooks] Error queuing %s event for %s: %v", webhooks.Envio606Generated, envio.ID, err)
+		return
+	}
+	h.outbox.Notify()
+}
+
+// newWebhookSecret returns a random signing secret
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: POST /api/webhooks
+// ─────────────────────────────────────────────────────────────────────────────
+
+// CreateWebhookEndpoint registers an endpoint. The signing secret is only
+// returned here.
+func (h *Handler) CreateWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, err := auth.GetClaimsFromContext(r.Context())
+	if err != nil {
+		h.sendError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if db.Pool == nil {
+		sendAppError(w, ErrDBUnavailable)
+		return
+	}
+
+	var req struct {
+		URL         string   `json:"url"`
+		Eventos     []string `json:"eventos"`
+		Descripcion string   `json:"descripcion"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	u, err := webhooks.ValidateURL(r.Context(), strings.TrimSpace(req.URL))
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	for _, ev := range req.Eventos {
+		if !webhooks.ValidEventType(ev) {
+			h.sendError(w, http.StatusBadRequest, fmt.Sprintf("unknown event %q (valid: %s)", ev, strings.Join(webhooks.EventTypes, ", ")))
+			return
+		}
+	}
+
+	secret, err := newWebhookSecret()
+	if err != nil {
+		h.sendError(w, http.StatusInternalServerError, "failed to generate secret")
+		return
+	}
+
+	endpoint := &db.WebhookEndpoint{
+		ClienteID:   claims.UserID,
+		URL:         u.String(),
+		Secret:      secret,
+		Eventos:     req.Eventos,
+		Descripcion: req.Descripcion,
+	}
+	if err := db.CreateWebhookEndpoint(r.Context(), endpoint); err != nil {
+		log.Printf("CreateWebhookEndpoint: DB error: %v", err)
+		sendAppError(w, ErrDBSaveError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"endpoint": endpoint,
+		"secret":   secret,
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: GET /api/webhooks
+// ─────────────────────────────────────────────────────────────────────────────
+
+func (h *Handler) GetWebhookEndpoints(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, err := auth.GetClaimsFromContext(r.Context())
+	if err != nil {
+		h.sendError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if db.Pool == nil {
+		sendAppError(w, ErrDBUnavailable)
+		return
+	}
+
+	endpoints, err := db.GetWebhookEndpoints(r.Context(), claims.UserID)
+	if err != nil {
+		log.Printf("GetWebhookEndpoints: DB error: %v", err)
+		sendAppError(w, ErrDBUnavailable)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"endpoints": endpoints,
+		"eventos":   webhooks.EventTypes,
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: DELETE /api/webhooks/{id}
+// ─────────────────────────────────────────────────────────────────────────────
+
+func (h *Handler) DeleteWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, err := auth.GetClaimsFromContext(r.Context())
+	if err != nil {
+		h.sendError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if db.Pool == nil {
+		sendAppError(w, ErrDBUnavailable)
+		return
+	}
+
+	err = db.DeleteWebhookEndpoint(r.Context(), claims.UserID, mux.Vars(r)["id"])
+	if errors.Is(err, pgx.ErrNoRows) {
+		h.sendError(w, http.StatusNotFound, "webhook not found")
+		return
+	}
+	if err != nil {
+		log.Printf("DeleteWebhookEndpoint: DB error: %v", err)
+		h.sendError(w, http.StatusInternalServerError, "failed to delete webhook")
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "webhook eliminado",
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: GET /api/webhooks/{id}/deliveries?limit=N
+// ─────────────────────────────────────────────────────────────────────────────
+
+func (h *Handler) GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, err := auth.GetClaimsFromContext(r.Context())
+	if err != nil {
+		h.sendError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if db.Pool == nil {
+		sendAppError(w, ErrDBUnavailable)
+		return
+	}
+
+	limit := defaultDeliveriesLimit
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 {
+		limit = l
+	}
+	if limit > maxDeliveriesLimit {
+		limit = maxDeliveriesLimit
+	}
+
+	deliveries, err := db.GetWebhookDeliveries(r.Context(), claims.UserID, mux.Vars(r)["id"], limit)
+	if err != nil {
+		log.Printf("GetWebhookDeliveries: DB error: %v", err)
+		sendAppError(w, ErrDBUnavailable)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"deliveries": deliveries,
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// Handler: POST /api/webhooks/deliveries/{id}/replay
+// ─────────────────────────────────────────────────────────────────────────────
+
+// ReplayWebhookDelivery sends a logged event to its endpoint again, with the
+// same event ID so receivers can deduplicate it
+func (h *Handler) ReplayWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, err := auth.GetClaimsFromContext(r.Context())
+	if err != nil {
+		h.sendError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if db.Pool == nil {
+		sendAppError(w, ErrDBUnavailable)
+		return
+	}
+
+	delivery, err := db.GetWebhookDelivery(r.Context(), claims.UserID, mux.Vars(r)["id"])
+	if err != nil {
+		log.Printf("ReplayWebhookDelivery: DB error: %v", err)
+		sendAppError(w, ErrDBUnavailable)
+		return
+	}
+	if delivery == nil {
+		h.sendError(w, http.StatusNotFound, "delivery not found")
+		return
+	}
+
+	ev, err := webhooks.NewReplay(delivery)
+	if err != nil {
+		h.sendError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if err := db.EnqueueOutboxEvents(r.Context(), ev); err != nil {
+		log.Printf("ReplayWebhookDelivery: DB error: %v", err)
+		sendAppError(w, ErrDBSaveError)
+		return
+	}
+	h.outbox.Notify()
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"event_id":    delivery.EventID,
+		"endpoint_id": delivery.EndpointID,
+		"queued_at":   time.Now().UTC(),
+	})
+}
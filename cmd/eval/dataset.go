@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// goldenCase is one receipt of the dataset and the values expected from it
+type goldenCase struct {
+	Name      string
+	ImagePath string
+	OCRPath   string                 // Stored OCR text for text mode, "" to run Tesseract
+	Expected  map[string]interface{} // Keys of the extraction prompt's JSON
+}
+
+var imageExtensions = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".webp": true}
+
+// loadDataset reads every image of dir that has a <name>.json next to it with
+// the expected values. <name>.txt, if present, is its OCR text.
+func loadDataset(dir string) ([]goldenCase, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var cases []goldenCase
+	for _, e := range entries {
+		ext := strings.ToLower(filepath.Ext(e.Name()))
+		if e.IsDir() || !imageExtensions[ext] {
+			continue
+		}
+		name := strings.TrimSuffix(e.Name(), filepath.Ext(e.Name()))
+		expectedPath := filepath.Join(dir, name+".json")
+		data, err := os.ReadFile(expectedPath)
+		if os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "eval: %s has no %s.json, skipped\n", e.Name(), name)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		c := goldenCase{Name: name, ImagePath: filepath.Join(dir, e.Name())}
+		if err := json.Unmarshal(data, &c.Expected); err != nil {
+			return nil, fmt.Errorf("%s: %w", expectedPath, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, name+".txt")); err == nil {
+			c.OCRPath = filepath.Join(dir, name+".txt")
+		}
+		cases = append(cases, c)
+	}
+	sort.Slice(cases, func(i, j int) bool { return cases[i].Name < cases[j].Name })
+	return cases, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/facturaIA/invoice-ocr-service/internal/ai"
+	"github.com/facturaIA/invoice-ocr-service/internal/models"
+)
+
+const goldenDir = "testdata/golden"
+
+func TestLoadDataset(t *testing.T) {
+	cases, err := loadDataset(goldenDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, c := range cases {
+		names = append(names, c.Name)
+		if c.OCRPath == "" || c.Expected["ncf"] == nil {
+			t.Errorf("%s = %+v, want its OCR text and expected NCF", c.Name, c)
+		}
+	}
+	if got := strings.Join(names, ","); got != "farmacia-b02,plaza-lama-b01,supermercado-e31" {
+		t.Errorf("cases = %s", got)
+	}
+}
+
+// TestRunEvalGolden scores the fixtures with a model that answers each
+// receipt's expected values, except a total off by 40 centavos and a misread
+// e-NCF
+func TestRunEvalGolden(t *testing.T) {
+	cases, err := loadDataset(goldenDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := ai.NewFakeProvider()
+	provider.Respond = func(prompt, _ string) (string, error) {
+		for _, c := range cases {
+			ncf := c.Expected["ncf"].(string)
+			if !strings.Contains(prompt, ncf) {
+				continue
+			}
+			answer := map[string]interface{}{}
+			for k, v := range c.Expected {
+				answer[k] = v
+			}
+			switch c.Name {
+			case "farmacia-b02":
+				answer["total"] = 236.40
+			case "supermercado-e31":
+				answer["ncf"] = "E310000000128"
+			}
+			data, err := json.Marshal(answer)
+			return string(data), err
+		}
+		return "{}", nil
+	}
+
+	ec := &evalConfig{Label: "fake-text"}
+	rep := runEval(ec, provider, ai.DefaultPrompt(), defaultEvalYear, &models.Config{}, cases, tolerance{Abs: 1, Pct: 0.5})
+
+	if rep.Cases != 3 || rep.Errors != 0 || len(provider.Calls()) != 3 {
+		t.Fatalf("cases %d, errors %d, calls %d", rep.Cases, rep.Errors, len(provider.Calls()))
+	}
+	for _, res := range rep.Results {
+		for field, m := range res.Mismatches {
+			want := (res.Name == "farmacia-b02" && field == "total") || (res.Name == "supermercado-e31" && field == "ncf")
+			if !want {
+				t.Errorf("%s: unexpected mismatch on %s: %+v", res.Name, field, m)
+			}
+		}
+	}
+	if rep.ExactCases != 1 {
+		t.Errorf("exact cases = %d, want 1", rep.ExactCases)
+	}
+	if st := rep.Fields["total"]; st.TruePositives != 3 || st.ToleranceHits != 1 || st.Exact != 2 {
+		t.Errorf("total = %+v", *st)
+	}
+	if st := rep.Fields["ncf"]; st.Precision() != 2.0/3 || st.Recall() != 2.0/3 {
+		t.Errorf("ncf = %+v", *st)
+	}
+}
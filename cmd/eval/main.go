@@ -0,0 +1,294 @@
+// Command eval measures extraction accuracy on a golden dataset: a folder of
+// receipt images, each with a <name>.json holding the expected values under
+// the keys of the extraction prompt (ncf, rncEmisor, itbis, total...) and,
+// for text mode, an optional <name>.txt with its OCR text. testdata/golden
+// is a small dataset in this layout.
+//
+// AI responses are recorded per configuration under -cassettes, so runs are
+// repeatable offline:
+//
+//	go run ./cmd/eval -dataset eval/golden -mode record -a provider=gemini
+//	go run ./cmd/eval -dataset eval/golden -a provider=gemini -b provider=openai,model=gpt-4o
+//	go run ./cmd/eval -dataset eval/golden -mode record -a prompt=v1 -b prompt=v2
+//
+// Prompts are rendered with -year as the year of dates without one, instead
+// of the current year, so recordings keep matching after New Year.
+//
+// It reports per-field precision, recall and exact match, amounts matched
+// only within tolerance and the TaxValidator pass rate, side by side when two
+// configurations are given.
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/facturaIA/invoice-ocr-service/internal/ai"
+	"github.com/facturaIA/invoice-ocr-service/internal/models"
+	"github.com/facturaIA/invoice-ocr-service/internal/ocr"
+	"github.com/facturaIA/invoice-ocr-service/internal/services"
+)
+
+// defaultEvalYear is the year recorded prompts are rendered with
+const defaultEvalYear = 2025
+
+func main() {
+	dataset := flag.String("dataset", "eval/golden", "folder with receipt images and their expected <name>.json")
+	configPath := flag.String("config", "config.yaml", "service config (API keys and default models)")
+	mode := flag.String("mode", "replay", "replay (recorded responses only), record (call and record) or live")
+	cassettes := flag.String("cassettes", "eval/cassettes", "folder of recorded responses, one subfolder per configuration")
+	specA := flag.String("a", "", "configuration to evaluate: provider=...,model=...,vision=true|false,lang=...,prompt=...,label=...")
+	specB := flag.String("b", "", "second configuration, reported side by side with -a")
+	tolAbs := flag.Float64("tol", 1.0, "amounts within this many pesos match")
+	tolPct := flag.Float64("tol-pct", 0.5, "amounts within this percent of the expected amount match")
+	jsonOut := flag.String("json", "", "also write the full reports, with every mismatch, to this file")
+	verbose := flag.Bool("v", false, "list the mismatched fields of every receipt")
+	year := flag.Int("year", defaultEvalYear, "year the prompts give dates without one (part of every recorded prompt)")
+	flag.Parse()
+
+	if *mode != "replay" && *mode != "record" && *mode != "live" {
+		log.Fatalf("eval: unknown -mode %q", *mode)
+	}
+
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("eval: %v", err)
+	}
+	cases, err := loadDataset(*dataset)
+	if err != nil {
+		log.Fatalf("eval: dataset: %v", err)
+	}
+	if len(cases) == 0 {
+		log.Fatalf("eval: no receipts with expected values in %s", *dataset)
+	}
+
+	specs := []string{*specA}
+	if *specB != "" {
+		specs = append(specs, *specB)
+	}
+	tol := tolerance{Abs: *tolAbs, Pct: *tolPct}
+	prompts, err := ai.NewPromptLibrary(config.AI.Prompts.Dir)
+	if err != nil {
+		log.Fatalf("eval: %v", err)
+	}
+
+	var reports []*report
+	for _, spec := range specs {
+		ec, err := parseEvalConfig(spec, config)
+		if err != nil {
+			log.Fatalf("eval: %v", err)
+		}
+		prompt, ok := prompts.Get(ec.Prompt)
+		if !ok {
+			log.Fatalf("eval: %s: unknown prompt version %q (have %s)", ec.Label, ec.Prompt, strings.Join(prompts.Versions(), ", "))
+		}
+		provider, err := ec.provider(config, *mode, filepath.Join(*cassettes, ec.Label))
+		if err != nil {
+			log.Fatalf("eval: %s: %v", ec.Label, err)
+		}
+		reports = append(reports, runEval(ec, provider, prompt, *year, config, cases, tol))
+	}
+
+	printReports(os.Stdout, reports, *verbose)
+
+	if *jsonOut != "" {
+		data, err := json.MarshalIndent(reports, "", "  ")
+		if err == nil {
+			err = os.WriteFile(*jsonOut, data, 0o644)
+		}
+		if err != nil {
+			log.Fatalf("eval: writing %s: %v", *jsonOut, err)
+		}
+	}
+}
+
+// evalConfig is one way of extracting: provider, model, mode and prompt
+type evalConfig struct {
+	Label    string
+	Provider string
+	Model    string
+	Vision   bool
+	Language string
+	Prompt   string // Prompt version
+}
+
+// parseEvalConfig reads a key=value,... configuration; unset keys take the
+// service defaults
+func parseEvalConfig(spec string, config *models.Config) (*evalConfig, error) {
+	ec := &evalConfig{Provider: config.AI.DefaultProvider, Language: config.OCR.Language, Prompt: config.AI.Prompts.Default}
+	if ec.Prompt == "" {
+		ec.Prompt = ai.DefaultPromptVersion
+	}
+	visionSet, promptSet := false, false
+	for _, kv := range strings.Split(spec, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, fmt.Errorf("configuration %q: %q is not key=value", spec, kv)
+		}
+		switch key {
+		case "label":
+			ec.Label = value
+		case "provider":
+			ec.Provider = value
+		case "model":
+			ec.Model = value
+		case "vision":
+			ec.Vision, visionSet = value == "true", true
+		case "lang":
+			ec.Language = value
+		case "prompt":
+			ec.Prompt, promptSet = value, true
+		default:
+			return nil, fmt.Errorf("configuration %q: unknown key %q", spec, key)
+		}
+	}
+
+	if ec.Model == "" {
+		switch ec.Provider {
+		case "openai":
+			ec.Model = config.AI.OpenAI.Model
+		case "gemini":
+			ec.Model = config.AI.Gemini.Model
+		case "ollama":
+			ec.Model = config.AI.Ollama.Model
+		}
+	}
+	// Like uploads: vision by default for the providers that read images
+	if !visionSet {
+		ec.Vision = ec.Provider == "gemini" || ec.Provider == "openai"
+	}
+	if ec.Label == "" {
+		ec.Label = ec.Provider + "-" + ec.Model
+		if !ec.Vision {
+			ec.Label += "-text"
+		}
+		if promptSet {
+			ec.Label += "-" + ec.Prompt
+		}
+	}
+	return ec, nil
+}
+
+// provider builds the configured model alone, without the service's fallback
+// chain: a fallback would mix models in one measurement
+func (ec *evalConfig) provider(config *models.Config, mode, cassetteDir string) (ai.Provider, error) {
+	if mode == "replay" {
+		return ai.NewReplayProvider(cassetteDir), nil
+	}
+
+	var p ai.Provider
+	switch ec.Provider {
+	case "openai":
+		p = ai.NewOpenAIProvider(config.AI.OpenAI.APIKey, config.AI.OpenAI.BaseURL, ec.Model)
+	case "gemini":
+		p = ai.NewGeminiProvider(config.AI.Gemini.APIKey, ec.Model)
+	case "ollama":
+		p = ai.NewOllamaProvider(config.AI.Ollama.BaseURL, ec.Model)
+	default:
+		return nil, fmt.Errorf("unsupported AI provider: %s", ec.Provider)
+	}
+	if mode == "record" {
+		p = ai.NewRecordingProvider(p, cassetteDir)
+	}
+	return p, nil
+}
+
+// runEval extracts every receipt with one configuration and scores it
+func runEval(ec *evalConfig, provider ai.Provider, prompt *ai.Prompt, year int, config *models.Config, cases []goldenCase, tol tolerance) *report {
+	rep := newReport(ec.Label)
+	extractor := ai.NewExtractor(provider, config.Categories)
+	extractor.UsePrompt(prompt)
+	extractor.UseYear(year)
+	validator := services.NewTaxValidator()
+
+	for _, c := range cases {
+		res := caseResult{Name: c.Name}
+		start := time.Now()
+		invoice, err := extractCase(extractor, ec, config, c)
+		res.Duration = time.Since(start)
+
+		var got map[string]interface{}
+		if err != nil {
+			res.Error = err.Error()
+			fmt.Fprintf(os.Stderr, "eval: %s: %s: %v\n", ec.Label, c.Name, err)
+		} else {
+			res.ValidatorOK = validator.Validate(services.InvoiceInputFromExtraction(invoice)).Valid
+			data, _ := json.Marshal(invoice)
+			json.Unmarshal(data, &got)
+		}
+		rep.score(res, c.Expected, got, tol)
+	}
+	return rep
+}
+
+// extractCase runs one receipt through the Extractor as an upload would:
+// the image for vision, OCR text (stored or from Tesseract) otherwise
+func extractCase(extractor *ai.Extractor, ec *evalConfig, config *models.Config, c goldenCase) (*models.Invoice, error) {
+	imageData, err := os.ReadFile(c.ImagePath)
+	if err != nil {
+		return nil, err
+	}
+	if ec.Vision {
+		invoice, _, err := extractor.Extract("", "data:image/jpeg;base64,"+base64.StdEncoding.EncodeToString(imageData))
+		return invoice, err
+	}
+
+	var text string
+	if c.OCRPath != "" {
+		data, err := os.ReadFile(c.OCRPath)
+		if err != nil {
+			return nil, err
+		}
+		text = string(data)
+	} else {
+		processed, err := ocr.NewPreprocessor(config.OCR.Engine == "easyocr").PreprocessImageFromBytes(imageData)
+		if err != nil {
+			return nil, fmt.Errorf("image preprocessing failed: %w", err)
+		}
+		if text, _, err = ocr.NewTesseractOCR(ec.Language).ExtractText(processed); err != nil {
+			return nil, fmt.Errorf("OCR failed: %w", err)
+		}
+	}
+	invoice, _, err := extractor.Extract(text, "")
+	return invoice, err
+}
+
+// loadConfig reads the service config with the environment overrides that
+// matter for extraction
+func loadConfig(path string) (*models.Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	var config models.Config
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	if apiKey := os.Getenv("OPENAI_API_KEY"); apiKey != "" {
+		config.AI.OpenAI.APIKey = apiKey
+	}
+	if apiKey := os.Getenv("GEMINI_API_KEY"); apiKey != "" {
+		config.AI.Gemini.APIKey = apiKey
+	}
+	if baseURL := os.Getenv("OLLAMA_BASE_URL"); baseURL != "" {
+		config.AI.Ollama.BaseURL = baseURL
+	}
+	if baseURL := os.Getenv("OPENAI_BASE_URL"); baseURL != "" {
+		config.AI.OpenAI.BaseURL = baseURL
+	}
+	return &config, nil
+}
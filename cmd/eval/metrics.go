@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type fieldKind int
+
+const (
+	kindText   fieldKind = iota // Compared case-insensitively
+	kindID                      // RNC, NCF, codes: also without dashes and spaces
+	kindNumber                  // Amounts, within tolerance
+	kindDate                    // YYYY-MM-DD
+)
+
+// evalFields are the scored fields, with the keys of the extraction prompt.
+// A field missing from an expected file is expected empty (or 0).
+var evalFields = []struct {
+	name string
+	kind fieldKind
+}{
+	{"ncf", kindID},
+	{"tipoNcf", kindID},
+	{"ncfModifica", kindID},
+	{"rncEmisor", kindID},
+	{"nombreEmisor", kindText},
+	{"tipoIdEmisor", kindID},
+	{"rncReceptor", kindID},
+	{"nombreReceptor", kindText},
+	{"tipoIdReceptor", kindID},
+	{"fechaFactura", kindDate},
+	{"horaFactura", kindText},
+	{"fechaPago", kindDate},
+	{"subtotal", kindNumber},
+	{"descuento", kindNumber},
+	{"montoServicios", kindNumber},
+	{"montoBienes", kindNumber},
+	{"itbis", kindNumber},
+	{"itbisTasa", kindNumber},
+	{"itbisRetenido", kindNumber},
+	{"itbisRetenidoPorcentaje", kindNumber},
+	{"itbisExento", kindNumber},
+	{"isr", kindNumber},
+	{"retencionIsrTipo", kindNumber},
+	{"isc", kindNumber},
+	{"iscCategoria", kindText},
+	{"cdtMonto", kindNumber},
+	{"cargo911", kindNumber},
+	{"propina", kindNumber},
+	{"otrosImpuestos", kindNumber},
+	{"montoNoFacturable", kindNumber},
+	{"total", kindNumber},
+	{"moneda", kindID},
+	{"formaPago", kindID},
+	{"tipoBienServicio", kindID},
+}
+
+// tolerance decides when two amounts that differ still match: within Abs or
+// within Pct of the expected amount, whichever is larger
+type tolerance struct {
+	Abs float64
+	Pct float64
+}
+
+func (t tolerance) within(expected, got float64) bool {
+	return math.Abs(expected-got) <= math.Max(t.Abs, t.Pct/100*math.Abs(expected))
+}
+
+// fieldStats accumulates one field over the dataset. A value counts as found
+// when it matches (exactly or within tolerance).
+type fieldStats struct {
+	TruePositives  int `json:"true_positives"`  // Expected and found
+	FalsePositives int `json:"false_positives"` // Extracted but wrong or not expected
+	FalseNegatives int `json:"false_negatives"` // Expected but missing or wrong
+	Exact          int `json:"exact"`           // Exactly as expected (both empty included)
+	ToleranceHits  int `json:"tolerance_hits"`  // Amounts not exact but within tolerance
+	Cases          int `json:"cases"`
+}
+
+func (s fieldStats) Precision() float64 {
+	return ratio(s.TruePositives, s.TruePositives+s.FalsePositives)
+}
+
+func (s fieldStats) Recall() float64 {
+	return ratio(s.TruePositives, s.TruePositives+s.FalseNegatives)
+}
+
+func (s fieldStats) ExactMatch() float64 {
+	return ratio(s.Exact, s.Cases)
+}
+
+// ratio is 1 when there is nothing to measure (no positives to be wrong on)
+func ratio(n, d int) float64 {
+	if d == 0 {
+		return 1
+	}
+	return float64(n) / float64(d)
+}
+
+// caseResult is the outcome of one receipt under one configuration
+type caseResult struct {
+	Name        string          `json:"name"`
+	Error       string          `json:"error,omitempty"`
+	Mismatches  map[string]pair `json:"mismatches,omitempty"`
+	ValidatorOK bool            `json:"validator_ok"`
+	Duration    time.Duration   `json:"duration_ns"`
+}
+
+type pair struct {
+	Expected string `json:"expected"`
+	Got      string `json:"got"`
+}
+
+// report is the evaluation of one configuration over the dataset
+type report struct {
+	Config       string                 `json:"config"`
+	Cases        int                    `json:"cases"`
+	Errors       int                    `json:"errors"`
+	ExactCases   int                    `json:"exact_cases"` // Every field exact
+	ValidatorOK  int                    `json:"validator_ok"`
+	Fields       map[string]*fieldStats `json:"fields"`
+	Results      []caseResult           `json:"results"`
+	TotalLatency time.Duration          `json:"total_latency_ns"`
+}
+
+func newReport(config string) *report {
+	r := &report{Config: config, Fields: map[string]*fieldStats{}}
+	for _, f := range evalFields {
+		r.Fields[f.name] = &fieldStats{}
+	}
+	return r
+}
+
+// ValidatorPassRate is the share of receipts the TaxValidator accepts
+func (r *report) ValidatorPassRate() float64 {
+	return ratio(r.ValidatorOK, r.Cases)
+}
+
+// score compares an extraction (nil when it failed) with the expected values
+func (r *report) score(res caseResult, expected, got map[string]interface{}, tol tolerance) {
+	r.Cases++
+	r.TotalLatency += res.Duration
+	if res.Error != "" {
+		r.Errors++
+	}
+	if res.ValidatorOK {
+		r.ValidatorOK++
+	}
+
+	res.Mismatches = map[string]pair{}
+	for _, f := range evalFields {
+		st := r.Fields[f.name]
+		st.Cases++
+		want := normalize(f.kind, expected[f.name])
+		have := normalize(f.kind, got[f.name])
+
+		exact := want == have
+		match := exact
+		if !exact && f.kind == kindNumber && want != "" && have != "" {
+			w, _ := strconv.ParseFloat(want, 64)
+			h, _ := strconv.ParseFloat(have, 64)
+			if tol.within(w, h) {
+				match = true
+				st.ToleranceHits++
+			}
+		}
+
+		if exact {
+			st.Exact++
+		} else {
+			res.Mismatches[f.name] = pair{Expected: want, Got: have}
+		}
+		switch {
+		case want != "" && match:
+			st.TruePositives++
+		case want != "" && have != "":
+			st.FalseNegatives++
+			st.FalsePositives++
+		case want != "":
+			st.FalseNegatives++
+		case have != "":
+			st.FalsePositives++
+		}
+	}
+	if len(res.Mismatches) == 0 {
+		r.ExactCases++
+		res.Mismatches = nil
+	}
+	r.Results = append(r.Results, res)
+}
+
+// normalize turns a JSON value into its comparable form; "" means empty (and
+// 0 for amounts)
+func normalize(kind fieldKind, v interface{}) string {
+	var s string
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		s = strings.TrimSpace(x)
+	case float64:
+		s = strconv.FormatFloat(x, 'f', -1, 64)
+	case json.Number:
+		s = x.String()
+	default:
+		s = strings.TrimSpace(strings.Trim(jsonString(x), `"`))
+	}
+
+	switch kind {
+	case kindNumber:
+		f, err := strconv.ParseFloat(strings.ReplaceAll(s, ",", ""), 64)
+		if err != nil || f == 0 {
+			return ""
+		}
+		return strconv.FormatFloat(math.Round(f*100)/100, 'f', 2, 64)
+	case kindDate:
+		if len(s) >= 10 {
+			s = s[:10]
+		}
+		if s == "0001-01-01" {
+			return ""
+		}
+		return s
+	case kindID:
+		s = strings.NewReplacer("-", "", " ", "").Replace(s)
+		return strings.ToUpper(s)
+	default:
+		return strings.ToUpper(strings.Join(strings.Fields(s), " "))
+	}
+}
+
+func jsonString(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
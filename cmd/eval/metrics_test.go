@@ -0,0 +1,114 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		kind fieldKind
+		in   interface{}
+		want string
+	}{
+		{kindText, "  Plaza   Lama ", "PLAZA LAMA"},
+		{kindText, nil, ""},
+		{kindID, "1-31-04793-9", "131047939"},
+		{kindID, "b01 0000 4521", "B0100004521"},
+		{kindID, 1.0, "1"},
+		{kindNumber, 1180.0, "1180.00"},
+		{kindNumber, "1,180.004", "1180.00"},
+		{kindNumber, 0.0, ""},
+		{kindNumber, "n/a", ""},
+		{kindDate, "2025-03-10T00:00:00Z", "2025-03-10"},
+		{kindDate, "0001-01-01T00:00:00Z", ""},
+	}
+	for _, tt := range tests {
+		if got := normalize(tt.kind, tt.in); got != tt.want {
+			t.Errorf("normalize(%d, %#v) = %q, want %q", tt.kind, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestToleranceWithin(t *testing.T) {
+	tol := tolerance{Abs: 1, Pct: 0.5}
+	tests := []struct {
+		expected, got float64
+		want          bool
+	}{
+		{100, 101, true},       // Abs
+		{100, 101.01, false},   // Over both
+		{1000, 1005, true},     // Pct: 0.5% of 1000
+		{1000, 1005.01, false}, // Over both
+		{-1000, -1004, true},   // Pct of the absolute amount
+	}
+	for _, tt := range tests {
+		if got := tol.within(tt.expected, tt.got); got != tt.want {
+			t.Errorf("within(%v, %v) = %v, want %v", tt.expected, tt.got, got, tt.want)
+		}
+	}
+}
+
+func TestScore(t *testing.T) {
+	tol := tolerance{Abs: 1, Pct: 0.5}
+	expected := map[string]interface{}{
+		"ncf":          "B0100004521",
+		"rncEmisor":    "131047939",
+		"nombreEmisor": "Plaza Lama",
+		"itbis":        180.0,
+		"total":        1180.0,
+	}
+	r := newReport("test")
+
+	// Exact, dashes and case aside
+	r.score(caseResult{Name: "exact", ValidatorOK: true}, expected, map[string]interface{}{
+		"ncf": "b0100004521", "rncEmisor": "1-31-04793-9", "nombreEmisor": "PLAZA  LAMA", "itbis": 180.0, "total": "1,180.00",
+	}, tol)
+	// Total within tolerance, RNC wrong, ITBIS missing, an unexpected descuento
+	r.score(caseResult{Name: "partial"}, expected, map[string]interface{}{
+		"ncf": "B0100004521", "rncEmisor": "131047930", "nombreEmisor": "Plaza Lama", "total": 1180.9, "descuento": 10.0,
+	}, tol)
+	// Failed extraction
+	r.score(caseResult{Name: "failed", Error: "AI extraction failed"}, expected, nil, tol)
+
+	if r.Cases != 3 || r.Errors != 1 || r.ExactCases != 1 || r.ValidatorOK != 1 {
+		t.Errorf("report = cases %d, errors %d, exact %d, validator %d", r.Cases, r.Errors, r.ExactCases, r.ValidatorOK)
+	}
+	if r.Results[0].Mismatches != nil {
+		t.Errorf("exact case mismatches = %v", r.Results[0].Mismatches)
+	}
+	if m := r.Results[1].Mismatches; len(m) != 4 || m["rncEmisor"] != (pair{Expected: "131047939", Got: "131047930"}) {
+		t.Errorf("partial case mismatches = %v", m)
+	}
+
+	tests := []struct {
+		field             string
+		want              fieldStats
+		precision, recall float64
+		exactMatch        float64
+	}{
+		{"ncf", fieldStats{TruePositives: 2, FalseNegatives: 1, Exact: 2, Cases: 3}, 1, 2.0 / 3, 2.0 / 3},
+		{"rncEmisor", fieldStats{TruePositives: 1, FalsePositives: 1, FalseNegatives: 2, Exact: 1, Cases: 3}, 0.5, 1.0 / 3, 1.0 / 3},
+		{"itbis", fieldStats{TruePositives: 1, FalseNegatives: 2, Exact: 1, Cases: 3}, 1, 1.0 / 3, 1.0 / 3},
+		{"total", fieldStats{TruePositives: 2, FalseNegatives: 1, Exact: 1, ToleranceHits: 1, Cases: 3}, 1, 2.0 / 3, 1.0 / 3},
+		{"descuento", fieldStats{FalsePositives: 1, Exact: 2, Cases: 3}, 0, 1, 2.0 / 3},
+		{"isc", fieldStats{Exact: 3, Cases: 3}, 1, 1, 1}, // Never expected nor extracted
+	}
+	for _, tt := range tests {
+		st := r.Fields[tt.field]
+		if *st != tt.want {
+			t.Errorf("%s = %+v, want %+v", tt.field, *st, tt.want)
+		}
+		for _, m := range []struct {
+			name      string
+			got, want float64
+		}{{"precision", st.Precision(), tt.precision}, {"recall", st.Recall(), tt.recall}, {"exact match", st.ExactMatch(), tt.exactMatch}} {
+			if math.Abs(m.got-m.want) > 1e-9 {
+				t.Errorf("%s %s = %v, want %v", tt.field, m.name, m.got, m.want)
+			}
+		}
+	}
+	if got := r.ValidatorPassRate(); math.Abs(got-1.0/3) > 1e-9 {
+		t.Errorf("validator pass rate = %v", got)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// printReports writes the per-field metrics and the summary of each
+// configuration, in one column group per configuration
+func printReports(out io.Writer, reports []*report, verbose bool) {
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+
+	header := []string{"field"}
+	for _, r := range reports {
+		header = append(header, r.Config+" P", "R", "exact", "tol")
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t")+"\t")
+
+	for _, f := range evalFields {
+		row := []string{f.name}
+		for _, r := range reports {
+			st := r.Fields[f.name]
+			row = append(row, pct(st.Precision()), pct(st.Recall()), pct(st.ExactMatch()), fmt.Sprint(st.ToleranceHits))
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t")+"\t")
+	}
+	fmt.Fprintln(tw)
+
+	summary := []struct {
+		label string
+		value func(r *report) string
+	}{
+		{"receipts", func(r *report) string { return fmt.Sprint(r.Cases) }},
+		{"errors", func(r *report) string { return fmt.Sprint(r.Errors) }},
+		{"all fields exact", func(r *report) string { return pct(ratio(r.ExactCases, r.Cases)) }},
+		{"TaxValidator pass", func(r *report) string { return pct(r.ValidatorPassRate()) }},
+		{"avg latency", func(r *report) string {
+			if r.Cases == 0 {
+				return "-"
+			}
+			return (r.TotalLatency / time.Duration(r.Cases)).Round(time.Millisecond).String()
+		}},
+	}
+	for _, s := range summary {
+		row := []string{s.label}
+		for _, r := range reports {
+			row = append(row, s.value(r), "", "", "")
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t")+"\t")
+	}
+	tw.Flush()
+
+	if !verbose {
+		return
+	}
+	for _, r := range reports {
+		fmt.Fprintf(out, "\n== %s ==\n", r.Config)
+		for _, res := range r.Results {
+			if res.Error != "" {
+				fmt.Fprintf(out, "%s: error: %s\n", res.Name, res.Error)
+				continue
+			}
+			fields := make([]string, 0, len(res.Mismatches))
+			for name := range res.Mismatches {
+				fields = append(fields, name)
+			}
+			sort.Strings(fields)
+			for _, name := range fields {
+				m := res.Mismatches[name]
+				fmt.Fprintf(out, "%s: %s: expected %q, got %q\n", res.Name, name, m.Expected, m.Got)
+			}
+		}
+	}
+}
+
+func pct(f float64) string {
+	return fmt.Sprintf("%.1f%%", f*100)
+}
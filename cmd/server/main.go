@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
@@ -11,6 +12,8 @@ import (
 	"github.com/facturaIA/invoice-ocr-service/internal/auth"
 	"github.com/facturaIA/invoice-ocr-service/internal/db"
 	"github.com/facturaIA/invoice-ocr-service/internal/models"
+	"github.com/facturaIA/invoice-ocr-service/internal/services"
+	"github.com/facturaIA/invoice-ocr-service/internal/sharepoint"
 	"github.com/facturaIA/invoice-ocr-service/internal/storage"
 	"gopkg.in/yaml.v3"
 )
@@ -74,9 +77,26 @@ func main() {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
+	// Load Banco Central exchange rates if a CSV is configured
+	if config.ExchangeRates.CSVPath != "" && db.Pool != nil {
+		if err := loadTasasCambio(config.ExchangeRates.CSVPath); err != nil {
+			log.Printf("Warning: exchange rates not loaded: %v", err)
+		}
+	}
+
 	// Create API handler
 	handler := api.NewHandler(config)
 	router := handler.SetupRoutes()
+	handler.StartJobWorkers(context.Background())
+	handler.StartOutboxDispatcher(context.Background())
+	handler.StartRevisionManualRetryWorker(context.Background())
+	handler.StartIdempotencyCleanup(context.Background())
+
+	// Copy invoice images to SharePoint (consumes sharepoint_sync_queue)
+	if sp := config.SharePoint; sp.Enabled {
+		graph := sharepoint.NewHTTPGraphClient(sp.TenantID, sp.ClientID, sp.ClientSecret, sp.DriveID, sp.GraphBaseURL, sp.TokenURL)
+		go sharepoint.NewWorker(graph, sp).Run(context.Background())
+	}
 
 	// Add login endpoint
 	router.HandleFunc("/api/login", auth.LoginHandler).Methods("POST")
@@ -102,6 +122,8 @@ func main() {
 	log.Printf("  PUT  http://%s/api/invoice/{id}       - Update invoice (requires JWT)", addr)
 	log.Printf("  DELETE http://%s/api/invoice/{id}     - Delete invoice (requires JWT)", addr)
 	log.Printf("  GET  http://%s/api/stats              - Get monthly stats (requires JWT)", addr)
+	log.Printf("  GET  http://%s/api/jobs/{id}          - Async upload status (requires JWT)", addr)
+	log.Printf("  GET  http://%s/api/progress/{id}      - Upload progress stream, SSE (requires JWT)", addr)
 	log.Printf("  GET  http://%s/health                 - Health check", addr)
 
 	if err := http.ListenAndServe(addr, protectedRouter); err != nil {
@@ -109,6 +131,25 @@ func main() {
 	}
 }
 
+func loadTasasCambio(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tasas, err := services.ParseTasasBCRD(f, "USD")
+	if err != nil {
+		return err
+	}
+	n, err := db.UpsertTasasCambio(context.Background(), tasas)
+	if err != nil {
+		return err
+	}
+	log.Printf("Exchange rates loaded: %d from %s", n, path)
+	return nil
+}
+
 func loadConfig(path string) (*models.Config, error) {
 	// Read config file
 	data, err := os.ReadFile(path)
@@ -151,6 +192,18 @@ func loadConfig(path string) (*models.Config, error) {
 	if model := os.Getenv("GEMINI_MODEL"); model != "" {
 		config.AI.Gemini.Model = model
 	}
+	if tenantID := os.Getenv("SHAREPOINT_TENANT_ID"); tenantID != "" {
+		config.SharePoint.TenantID = tenantID
+	}
+	if clientID := os.Getenv("SHAREPOINT_CLIENT_ID"); clientID != "" {
+		config.SharePoint.ClientID = clientID
+	}
+	if secret := os.Getenv("SHAREPOINT_CLIENT_SECRET"); secret != "" {
+		config.SharePoint.ClientSecret = secret
+	}
+	if driveID := os.Getenv("SHAREPOINT_DRIVE_ID"); driveID != "" {
+		config.SharePoint.DriveID = driveID
+	}
 
 	return &config, nil
 }
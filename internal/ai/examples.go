@@ -0,0 +1,198 @@
+package ai
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Example is an earlier invoice of the same emisor whose extraction a person
+// corrected. Field names are the keys of the prompt's JSON.
+type Example struct {
+	Corrections []FieldCorrection      // Fields the model read wrong
+	Corrected   map[string]interface{} // The invoice as corrected
+}
+
+// FieldCorrection is a value the model extracted and the one a person set
+type FieldCorrection struct {
+	Field     string
+	Extracted interface{}
+	Correct   interface{}
+}
+
+// ExampleLookup returns corrected examples of any of the emisor RNCs, most
+// relevant first
+type ExampleLookup func(rncs []string) []Example
+
+// Fields that describe the emisor rather than one invoice: when every example
+// has the same corrected value it is given as a hint
+var emisorHintFields = []string{"rncEmisor", "nombreEmisor", "tipoIdEmisor", "tipoBienServicio", "formaPago", "itbisTasa", "iscCategoria"}
+
+// RNCs (9 digits, 1-31-04793-9) and cedulas (11 digits, 001-1234567-8) in OCR text
+var rncPattern = regexp.MustCompile(`\b(\d{9}|\d{11}|\d-\d{2}-\d{5}-\d|\d{3}-\d{7}-\d)\b`)
+
+// maxRNCCandidates bounds the RNCs of an OCR text looked up for examples
+const maxRNCCandidates = 10
+
+// rncCandidates returns the RNCs and cedulas found in OCR text, without
+// dashes, in order of appearance (the emisor's is usually first)
+func rncCandidates(text string) []string {
+	var rncs []string
+	seen := map[string]bool{}
+	for _, m := range rncPattern.FindAllString(text, -1) {
+		rnc := strings.ReplaceAll(m, "-", "")
+		if seen[rnc] {
+			continue
+		}
+		seen[rnc] = true
+		rncs = append(rncs, rnc)
+		if len(rncs) == maxRNCCandidates {
+			break
+		}
+	}
+	return rncs
+}
+
+// estimateTokens approximates the tokens of a prompt fragment (~4 characters
+// per token for Spanish text and JSON)
+func estimateTokens(s string) int {
+	return (len([]rune(s)) + 3) / 4
+}
+
+// buildExamplesSection renders examples and the emisor hints drawn from them
+// as a prompt section of at most tokenBudget tokens. Examples that don't fit
+// with their corrected output are given with their errors only; the rest are
+// left out. It returns "" when nothing fits.
+func buildExamplesSection(examples []Example, tokenBudget int) string {
+	if len(examples) == 0 || tokenBudget <= 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("\n## CORRECCIONES PREVIAS DE ESTE EMISOR\n\n")
+	b.WriteString("Facturas anteriores de este mismo emisor se extrajeron con errores que una persona corrigio. NO repitas esos errores: lee con especial cuidado los campos indicados.\n")
+	b.WriteString(emisorHints(examples))
+	if estimateTokens(b.String()) > tokenBudget {
+		return ""
+	}
+
+	added := 0
+	for i, ex := range examples {
+		brief := fmt.Sprintf("\nEjemplo %d:\n- Errores: %s\n", i+1, formatCorrections(ex.Corrections))
+		full := brief
+		if corrected, err := json.Marshal(nonEmptyFields(ex.Corrected)); err == nil {
+			full += "- Resultado correcto: " + string(corrected) + "\n"
+		}
+		used := estimateTokens(b.String())
+		switch {
+		case used+estimateTokens(full) <= tokenBudget:
+			b.WriteString(full)
+		case used+estimateTokens(brief) <= tokenBudget:
+			b.WriteString(brief)
+		default:
+			continue
+		}
+		added++
+	}
+	if added == 0 {
+		return ""
+	}
+	return b.String()
+}
+
+// emisorHints lists the fields corrected most often and the emisor values
+// every example agrees on
+func emisorHints(examples []Example) string {
+	counts := map[string]int{}
+	for _, ex := range examples {
+		for _, c := range ex.Corrections {
+			counts[c.Field]++
+		}
+	}
+	fields := make([]string, 0, len(counts))
+	for f := range counts {
+		fields = append(fields, f)
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		if counts[fields[i]] != counts[fields[j]] {
+			return counts[fields[i]] > counts[fields[j]]
+		}
+		return fields[i] < fields[j]
+	})
+
+	var b strings.Builder
+	if len(fields) > 0 {
+		parts := make([]string, len(fields))
+		for i, f := range fields {
+			parts[i] = fmt.Sprintf("%s (%d)", f, counts[f])
+		}
+		b.WriteString("- Campos corregidos (veces): " + strings.Join(parts, ", ") + "\n")
+	}
+
+	for _, f := range emisorHintFields {
+		if counts[f] == 0 {
+			continue
+		}
+		value, ok := examples[0].Corrected[f]
+		if !ok || isEmptyValue(value) {
+			continue
+		}
+		same := true
+		for _, ex := range examples[1:] {
+			if fmt.Sprint(ex.Corrected[f]) != fmt.Sprint(value) {
+				same = false
+				break
+			}
+		}
+		if same {
+			b.WriteString(fmt.Sprintf("- Valor correcto habitual de %s para este emisor: %s\n", f, formatValue(value)))
+		}
+	}
+	return b.String()
+}
+
+func formatCorrections(corrections []FieldCorrection) string {
+	parts := make([]string, len(corrections))
+	for i, c := range corrections {
+		parts[i] = fmt.Sprintf("%s: se extrajo %s, lo correcto era %s", c.Field, formatValue(c.Extracted), formatValue(c.Correct))
+	}
+	return strings.Join(parts, "; ")
+}
+
+func formatValue(v interface{}) string {
+	if isEmptyValue(v) {
+		return "vacio"
+	}
+	if data, err := json.Marshal(v); err == nil {
+		return string(data)
+	}
+	return fmt.Sprint(v)
+}
+
+// nonEmptyFields drops empty and zero values, which the prompt already
+// defines, to save tokens
+func nonEmptyFields(fields map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		if !isEmptyValue(v) {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+func isEmptyValue(v interface{}) bool {
+	switch x := v.(type) {
+	case nil:
+		return true
+	case string:
+		return x == ""
+	case float64:
+		return x == 0
+	case int:
+		return x == 0
+	}
+	return false
+}
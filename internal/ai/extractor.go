@@ -15,6 +15,15 @@ import (
 type Extractor struct {
 	provider   Provider
 	categories []string
+	prompt     *Prompt
+	year       int // Year of dates without one, 0 for the current year
+
+	// Corrected examples of the emisor, see UseExamples
+	examples      ExampleLookup
+	emisorRNC     string
+	exampleBudget int
+	secondPass    bool
+	usedExamples  bool
 }
 
 // NewExtractor creates a new AI extractor
@@ -22,9 +31,39 @@ func NewExtractor(provider Provider, categories []string) *Extractor {
 	return &Extractor{
 		provider:   provider,
 		categories: categories,
+		prompt:     DefaultPrompt(),
 	}
 }
 
+// UsePrompt extracts with prompt instead of the default one
+func (e *Extractor) UsePrompt(prompt *Prompt) {
+	e.prompt = prompt
+}
+
+// UseYear tells the model dates without a year are in year instead of the
+// current one, so the prompt does not change with the date
+func (e *Extractor) UseYear(year int) {
+	e.year = year
+}
+
+// UseExamples adds to the prompt the corrected extractions of the invoice's
+// emisor, within tokenBudget tokens. The emisor is emisorRNC when known
+// (reprocessing), otherwise the RNCs found in the OCR text. In vision mode,
+// without OCR text, the emisor is only known from the result; see
+// UseSecondPass.
+func (e *Extractor) UseExamples(lookup ExampleLookup, emisorRNC string, tokenBudget int) {
+	e.examples = lookup
+	e.emisorRNC = emisorRNC
+	e.exampleBudget = tokenBudget
+}
+
+// UseSecondPass extracts the invoice again with the examples of its emisor
+// when the emisor is only known from the first result. Off by default: it
+// costs a second AI call per invoice of an emisor with corrections.
+func (e *Extractor) UseSecondPass(on bool) {
+	e.secondPass = on
+}
+
 // Extract processes OCR text or image and returns structured invoice data
 func (e *Extractor) Extract(ocrText string, imageBase64 string) (*models.Invoice, float64, error) {
 	startTime := time.Now()
@@ -32,22 +71,70 @@ func (e *Extractor) Extract(ocrText string, imageBase64 string) (*models.Invoice
 	// Determine if we are using vision mode (image) or text mode (OCR)
 	isVisionMode := imageBase64 != "" && strings.TrimSpace(ocrText) == ""
 
+	var rncs []string
+	if e.emisorRNC != "" {
+		rncs = []string{e.emisorRNC}
+	} else if !isVisionMode {
+		rncs = rncCandidates(ocrText)
+	}
+	examples := e.examplesSection(rncs)
+
+	invoice, err := e.extract(ocrText, imageBase64, isVisionMode, examples)
+	if err != nil {
+		return nil, time.Since(startTime).Seconds(), err
+	}
+
+	// The emisor is only known now: extract again with its corrections
+	if e.secondPass && examples == "" && len(rncs) == 0 && invoice.RNCEmisor != "" {
+		if examples = e.examplesSection([]string{invoice.RNCEmisor}); examples != "" {
+			fmt.Printf("[AI] Re-extracting with corrected examples of emisor %s\n", invoice.RNCEmisor)
+			if second, err := e.extract(ocrText, imageBase64, isVisionMode, examples); err == nil {
+				invoice = second
+			} else {
+				fmt.Printf("[AI] Extraction with examples failed, keeping the first one: %v\n", err)
+			}
+		}
+	}
+
+	e.usedExamples = examples != ""
+	return invoice, time.Since(startTime).Seconds(), nil
+}
+
+// UsedExamples reports whether the last Extract put corrected examples in
+// the prompt: its result depends on the corrections made so far
+func (e *Extractor) UsedExamples() bool {
+	return e.usedExamples
+}
+
+// examplesSection looks up the corrected examples of the emisors and renders
+// them for the prompt ("" when there are none)
+func (e *Extractor) examplesSection(rncs []string) string {
+	if e.examples == nil || len(rncs) == 0 {
+		return ""
+	}
+	return buildExamplesSection(e.examples(rncs), e.exampleBudget)
+}
+
+// extract runs one prompt against the provider and parses the response
+func (e *Extractor) extract(ocrText, imageBase64 string, isVisionMode bool, examples string) (*models.Invoice, error) {
 	// Build appropriate prompt
 	var prompt string
+	var err error
 	if isVisionMode {
-		prompt = e.buildPromptVision()
+		prompt, err = e.buildPromptVision(examples)
 	} else {
-		prompt = e.buildPromptDGII(ocrText)
+		prompt, err = e.buildPromptDGII(ocrText, examples)
+	}
+	if err != nil {
+		return nil, err
 	}
 
 	// Call AI provider
 	response, err := e.provider.ExtractData(prompt, imageBase64)
 	if err != nil {
-		return nil, 0, fmt.Errorf("AI extraction failed: %w", err)
+		return nil, fmt.Errorf("AI extraction failed: %w", err)
 	}
 
-	duration := time.Since(startTime).Seconds()
-
 	// Log AI response for debugging
 	fmt.Printf("[AI Response] Vision mode: %v, Response length: %d\n", isVisionMode, len(response))
 	fmt.Printf("[AI Response] Raw: %s\n", response)
@@ -55,331 +142,24 @@ func (e *Extractor) Extract(ocrText string, imageBase64 string) (*models.Invoice
 	// Parse JSON response
 	invoice, err := e.parseResponseDGII(response, ocrText)
 	if err != nil {
-		return nil, duration, fmt.Errorf("failed to parse AI response: %w", err)
+		return nil, fmt.Errorf("failed to parse AI response: %w", err)
 	}
-
-	return invoice, duration, nil
+	invoice.PromptVersion = e.prompt.Revision()
+	return invoice, nil
 }
 
-// buildPromptVision creates prompt for direct image analysis (Gemini Vision)
-func (e *Extractor) buildPromptVision() string {
-	currentYear := time.Now().Year()
-
-	prompt := fmt.Sprintf(`Eres un EXPERTO en OCR y facturas fiscales de Republica Dominicana. Tu trabajo es LEER CUIDADOSAMENTE cada caracter de la imagen.
-
-## INSTRUCCIONES DE LECTURA
-
-PASO 1 - EXAMINA TODA LA IMAGEN COMPLETA:
-- Mira PRIMERO el encabezado arriba (logo, nombre empresa grande, direccion, telefono)
-- Mira la parte central (items, precios, totales)
-- Mira la parte inferior (datos del comprador, e-NCF, codigo de barras)
-- Mira los sellos/timbres (pueden tener RNC dentro de un circulo)
-
-PASO 2 - IDENTIFICA EL TIPO DE DOCUMENTO:
-- TICKET DE TIENDA: Papel termico largo y angosto (supermercados, tiendas, farmacias)
-  * El EMISOR (vendedor) esta ARRIBA: logo, nombre tienda, RNC, direccion, telefono
-  * El RECEPTOR (comprador) esta ABAJO: nombre empresa, "RNC/Cedula:", telefono
-  * Pistas del emisor: URL web (www.xxx.com), "CLUB xxx", slogan, logo
-- FACTURA FORMAL: Papel carta con formato estructurado
-  * El EMISOR esta en el membrete superior
-  * El RECEPTOR dice "Cliente:", "Facturar a:", "Vendido a:"
-
-PASO 3 - REGLA CRITICA EMISOR vs RECEPTOR:
-- EMISOR = Quien VENDE (la tienda/negocio que emite la factura)
-  * En tickets: es el negocio del ENCABEZADO (parte superior)
-  * Su RNC aparece ARRIBA cerca del logo o en un sello
-  * Si ves "www.xxx.com" o "CLUB xxx", el nombre de esa empresa es el EMISOR
-  * Tiendas conocidas RD: Plaza Lama, Jumbo, La Sirena, CCN, Iberia, Bravo, Nacional, etc.
-- RECEPTOR = Quien COMPRA (el cliente que paga)
-  * En tickets: aparece ABAJO, despues de "Total Articulos Vendidos" o "Gracias por su compra"
-  * Busca: "RNC/Cedula:", "Cliente:", "Facturar a:", "Vendido a:"
-  * Si un nombre de empresa aparece DEBAJO de los totales con "RNC/Cedula:", ESO es el RECEPTOR
-
-PASO 4 - EJEMPLO DE TICKET DOMINICANO TIPICO:
-  [Logo/Nombre Tienda]     <-- ESTO ES EL EMISOR
-  [RNC: XXXXXXXXX]         <-- rncEmisor
-  [Direccion, Tel]
-  ---
-  [Items y precios]
-  [ITBIS: X,XXX.XX]
-  [TOTAL: XX,XXX.XX]
-  ---
-  [Total Articulos Vendidos]
-  [Nombre Empresa Cliente]  <-- ESTO ES EL RECEPTOR
-  [RNC/Cedula: XXXXXXXXX]   <-- rncReceptor
-  [e-NCF: EXXXXXXXXXX]
-
-## FORMATO RNC DOMINICANO
-- Empresas: 9 digitos (ej: 131047939, 1-31-04793-9)
-- Personas: 11 digitos (cedula, ej: 00112345678)
-- Quita guiones al extraer: "1-31-04793-9" -> "131047939"
-- PUEDE haber DOS RNC diferentes en la factura: uno del emisor y otro del receptor
-
-## FORMATO NCF (Comprobante Fiscal)
-- Credito Fiscal: B01XXXXXXXXX (11 digitos despues de B01)
-- Consumidor Final: B02XXXXXXXXX
-- Gubernamental: B15XXXXXXXXX
-- E-CF: E31XXXXXXXXXXXXX (13 digitos despues de E31)
-- El e-NCF aparece frecuentemente al FINAL del ticket, NO confundir con datos del emisor
-
-## CAMPOS A EXTRAER
-
-Devuelve SOLO JSON valido (sin markdown, sin comentarios):
-{
-  "ncf": "el NCF completo",
-  "tipoNcf": "B01, B02, B04, B15, E31, etc",
-  "ncfModifica": "NCF original que se modifica, OBLIGATORIO si tipoNcf es B04, E32 o E33, null si no aplica",
-  "rncEmisor": "solo digitos, sin guiones - del VENDEDOR",
-  "nombreEmisor": "nombre de la tienda/empresa que VENDE",
-  "tipoIdEmisor": "1=RNC, 2=Cedula",
-  "rncReceptor": "solo digitos, sin guiones - del COMPRADOR",
-  "nombreReceptor": "nombre del cliente que COMPRA",
-  "tipoIdReceptor": "1=RNC, 2=Cedula",
-  "fechaFactura": "YYYY-MM-DD",
-  "horaFactura": "HH:MM (hora que aparece impresa en la factura, null si no se ve)",
-  "fechaVencimiento": "YYYY-MM-DD o null",
-  "fechaPago": "YYYY-MM-DD, requerida si hay retenciones ITBIS o ISR, null si no aplica",
-  "subtotal": numero (base antes de impuestos, usa 0 si no aparece),
-  "descuento": numero (descuento aplicado, usa 0 si no aparece),
-  "montoServicios": numero (monto de la parte de servicios; si la factura mezcla productos y servicios separar los montos; si solo servicios poner todo aqui; si solo bienes/productos usar 0),
-  "montoBienes": numero (monto de la parte de bienes/productos; si solo bienes poner todo aqui; si solo servicios usar 0),
-  "itbis": numero (ITBIS 18%% facturado, usa 0 si no aparece),
-  "itbisTasa": numero (18 normal o 16 zona franca, usa 18 por defecto),
-  "itbisRetenido": numero (ITBIS retenido, usa 0 si no aparece),
-  "itbisRetenidoPorcentaje": numero (30 si gran contribuyente retiene 30%%, 100 si retenedor designado retiene 100%%, 0 si no hay retencion),
-  "itbisExento": numero (monto exento de ITBIS, usa 0 si no aparece),
-  "isr": numero (ISR retenido, usa 0 si no aparece),
-  "retencionIsrTipo": numero 1-8 (tipo retencion ISR segun tabla DGII, usa 0 si no aplica),
-  "isc": numero (Impuesto Selectivo al Consumo, usa 0 si no aparece),
-  "iscCategoria": "seguros|telecom|alcohol|tabaco|vehiculos|combustibles" o null,
-  "cdtMonto": numero (Contribucion Desarrollo Telecom 2%%, usa 0 si no aparece),
-  "cargo911": numero (Contribucion al 911, usa 0 si no aparece),
-  "propina": numero (propina legal 10%%, usa 0 si no aparece),
-  "otrosImpuestos": numero (impuestos no clasificados, usa 0 si no aparece),
-  "montoNoFacturable": numero (propinas voluntarias, reembolsos, usa 0 si no aparece),
-  "total": numero final a pagar (usa 0 si no aparece, NUNCA null),
-  "formaPago": "01-07 segun codigo",
-  "tipoBienServicio": "01-13 segun codigo",
-  "items": [{"descripcion": "...", "cantidad": 1, "precioUnit": 100, "importe": 100}]
+// buildPromptVision creates prompt for direct image analysis (Gemini Vision),
+// with the corrected examples of the emisor, if any, before the final
+// instruction
+func (e *Extractor) buildPromptVision(examples string) (string, error) {
+	return e.prompt.Vision(PromptData{Year: e.year, Examples: examples})
 }
 
-## GUIA DE IMPUESTOS DOMINICANOS
-
-### ITBIS (Impuesto Transferencia Bienes y Servicios)
-- 18%% normal o 16%% zona franca - busca "ITBIS", "I.T.B.I.S", "IVA"
-- itbisRetenidoPorcentaje: 30 si gran contribuyente, 100 si retenedor designado, 0 si no hay retencion
-
-### ISC (Impuesto Selectivo al Consumo) - por categoria
-- seguros: 16%% sobre prima neta (facturas de aseguradoras)
-- telecom: 10%% sobre servicio de telecomunicaciones (Claro, Altice, Viva)
-- alcohol: monto especifico por litro (no porcentaje fijo)
-- tabaco: monto especifico por unidad (no porcentaje fijo)
-- combustibles: monto fijo por galon segun tipo de combustible
-- vehiculos: monto segun categoria del vehiculo
-
-COMO IDENTIFICAR ISC EN LA FACTURA:
-- Busca exactamente las palabras: "ISC", "Imp. Selectivo", "Selectivo Consumo", "ISCA", "Impuesto Selectivo al Consumo"
-- En facturas telecom (Claro, Altice, Viva, Wind Telecom): ISC aparece como línea separada junto al ITBIS. Si ves "CDT" o "Cargo 911", hay ISC telecom del 10%%.
-- En facturas de seguros (ARS, aseguradoras): busca "Prima Neta" y calcula 16%% sobre ese valor
-- Si encuentras monto de ISC pero no puedes determinar categoría, usa "otros" como iscCategoria
-- NUNCA confundas ISC con ITBIS — son impuestos diferentes y separados en la factura
-
-### CDT y 911 (solo telecom)
-- CDT: 2%% adicional en facturas telecom - "Contribucion Desarrollo Telecomunicaciones"
-- 911: Cargo fijo en lineas telefonicas - "Contribucion 911", "Cargo 911"
-
-### ISR (Impuesto Sobre la Renta) - tipos de retencion
-- Tipo 1: Alquileres (10%%), Tipo 2: Honorarios personas fisicas (10%%)
-- Tipo 3: Otros ingresos personas fisicas (10%%), Tipo 4: Renta presunta (25%% o 27%%)
-- Tipo 5: Loterias y premios (25%%), Tipo 6: Personas juridicas (27%%)
-- Tipo 7: Servicios en general (10%%), Tipo 8: Dividendos (10%%)
-
-### Propina y ncfModifica
-- Propina: 10%% legal en restaurantes/hoteles - busca "Propina", "Servicio", "10%%"
-- ncfModifica: OBLIGATORIO si tipoNcf es B04 (Nota Credito), E32 (Nota Debito Electronica) o E33 (Nota Credito Electronica)
-
-## REGLAS CRITICAS
-
-1. LEE CARACTER POR CARACTER si el texto es dificil
-2. Los SELLOS tienen informacion importante - no los ignores
-3. Si ves un RNC en un sello circular, ESE es el rncEmisor
-4. NUNCA inventes datos - usa null si no puedes leer
-5. NUNCA copies rncEmisor a rncReceptor o viceversa
-6. NUNCA pongas el mismo RNC en emisor y receptor
-7. Si ves "RNC/Cedula:" DEBAJO de los totales, ESE es el RECEPTOR (comprador)
-8. Si ves un nombre de empresa con URL web, ESA empresa es el EMISOR (vendedor)
-9. El TOTAL siempre es el numero MAS GRANDE al final
-10. Si el encabezado esta borroso, busca pistas: URL web, "CLUB xxx", slogan, direccion
-11. Ano por defecto si no se ve: %d
-12. NUNCA devuelvas null para subtotal, itbis, o total - usa 0 si no puedes leer el valor
-13. NUNCA inventes ni calcules montos que no puedas leer en la imagen
-14. Si un campo numerico no aparece en la factura, pon 0 (no null, no calculado)
-
-## CODIGOS
-
-formaPago: 01=Efectivo, 02=Cheque/Transferencia, 03=Tarjeta, 04=Credito, 05=Permuta, 06=Nota Credito, 07=Mixto
-
-tipoBienServicio: 01=Personal, 02=Servicios, 03=Arrendamiento, 04=Activos fijos, 05=Representacion, 06=Deducciones, 07=Financieros, 08=Extraordinarios, 09=Costo venta, 10=Activos, 11=Seguros, 12=Viajes, 13=Otros
-
-AHORA ANALIZA LA IMAGEN CUIDADOSAMENTE. PRIMERO identifica quien VENDE y quien COMPRA, LUEGO extrae los datos.`, currentYear)
-
-	return prompt
-}
 // buildPromptDGII creates specialized prompt for Dominican Republic invoices (OCR text mode)
-func (e *Extractor) buildPromptDGII(ocrText string) string {
-	currentYear := time.Now().Year()
-
-	prompt := fmt.Sprintf(`Eres un EXPERTO en facturas fiscales de Republica Dominicana. Tu trabajo es extraer TODOS los datos fiscales de este texto OCR para el sistema DGII.
-
-## PASO 1 - IDENTIFICA EL TIPO DE DOCUMENTO:
-- TICKET DE TIENDA: papel termico (supermercados, tiendas, farmacias)
-  * El EMISOR (vendedor) aparece ARRIBA: nombre tienda, RNC, direccion, telefono
-  * El RECEPTOR (comprador) aparece ABAJO: nombre empresa cliente, "RNC/Cedula:"
-- FACTURA FORMAL: papel carta con formato estructurado
-  * El EMISOR esta en el membrete superior
-  * El RECEPTOR dice "Cliente:", "Facturar a:", "Vendido a:"
-
-## PASO 2 - REGLA CRITICA EMISOR vs RECEPTOR:
-- EMISOR = Quien VENDE (la tienda/negocio que emite la factura)
-  * En tickets: es el negocio del ENCABEZADO (texto al inicio)
-  * Su RNC aparece ARRIBA cerca del nombre o entre los datos del encabezado
-  * Tiendas conocidas RD: Plaza Lama, Jumbo, La Sirena, CCN, Iberia, Bravo, Nacional, etc.
-- RECEPTOR = Quien COMPRA (el cliente que paga)
-  * En tickets: aparece ABAJO, despues de "Total Articulos Vendidos" o "Gracias por su compra"
-  * Busca: "RNC/Cedula:", "Cliente:", "Facturar a:", "Vendido a:"
-  * Si un nombre de empresa aparece DESPUES de los totales con "RNC/Cedula:", ESO es el RECEPTOR
-
-## PASO 3 - FORMATO RNC DOMINICANO:
-- Empresas: 9 digitos (ej: 131047939, 1-31-04793-9)
-- Personas: 11 digitos (cedula, ej: 00112345678)
-- Quita guiones al extraer: "1-31-04793-9" -> "131047939"
-- PUEDE haber DOS RNC diferentes en la factura: uno del emisor y otro del receptor
-- NUNCA copies rncEmisor a rncReceptor o viceversa
-
-## PASO 4 - FORMATO NCF (Comprobante Fiscal):
-- Credito Fiscal: B01XXXXXXXXX (11 digitos despues de B01)
-- Consumidor Final: B02XXXXXXXXX
-- Nota de Credito: B04XXXXXXXXX (REQUIERE ncfModifica)
-- Gubernamental: B15XXXXXXXXX
-- E-CF: E31XXXXXXXXXXXXX (13 digitos despues de E31)
-- Nota Debito Electronica: E32XXXXXXXXXXXXX (REQUIERE ncfModifica)
-- Nota Credito Electronica: E33XXXXXXXXXXXXX (REQUIERE ncfModifica)
-- El NCF aparece frecuentemente al FINAL del ticket, NO confundir con datos del emisor
-
-## CAMPOS A EXTRAER
-
-Devuelve SOLO JSON valido (sin markdown, sin comentarios):
-{
-  "ncf": "el NCF completo",
-  "tipoNcf": "B01, B02, B04, B15, E31, etc",
-  "ncfModifica": "NCF original que se modifica, OBLIGATORIO si tipoNcf es B04, E32 o E33, null si no aplica",
-  "rncEmisor": "solo digitos, sin guiones - del VENDEDOR",
-  "nombreEmisor": "nombre de la tienda/empresa que VENDE",
-  "tipoIdEmisor": "1=RNC empresa 9 digitos, 2=Cedula 11 digitos",
-  "rncReceptor": "solo digitos, sin guiones - del COMPRADOR",
-  "nombreReceptor": "nombre del cliente que COMPRA",
-  "tipoIdReceptor": "1=RNC empresa 9 digitos, 2=Cedula 11 digitos",
-  "fechaFactura": "YYYY-MM-DD",
-  "horaFactura": "HH:MM (hora que aparece impresa en la factura, null si no se ve)",
-  "fechaVencimiento": "YYYY-MM-DD o null",
-  "fechaPago": "YYYY-MM-DD, requerida si hay retenciones ITBIS o ISR, null si no aplica",
-  "subtotal": numero (base antes de impuestos, usa 0 si no aparece),
-  "descuento": numero (descuento aplicado, usa 0 si no aparece),
-  "montoServicios": numero (monto de la parte de servicios, usa 0 si no aplica - si la factura mezcla productos y servicios separar los montos; si solo servicios poner todo aqui; si solo bienes/productos usar 0),
-  "montoBienes": numero (monto de la parte de bienes/productos, usa 0 si no aplica - si solo bienes poner todo aqui; si solo servicios usar 0),
-  "itbis": numero (ITBIS 18%% facturado, usa 0 si no aparece),
-  "itbisTasa": numero (18 normal o 16 zona franca, usa 18 por defecto),
-  "itbisRetenido": numero (ITBIS retenido, usa 0 si no aparece),
-  "itbisRetenidoPorcentaje": numero (30 si gran contribuyente retiene 30%%, 100 si retenedor designado retiene 100%%, 0 si no hay retencion),
-  "itbisExento": numero (monto exento de ITBIS, usa 0 si no aparece),
-  "isr": numero (ISR retenido, usa 0 si no aparece),
-  "retencionIsrTipo": numero 1-8 (tipo retencion ISR segun tabla DGII, usa 0 si no aplica),
-  "isc": numero (Impuesto Selectivo al Consumo, usa 0 si no aparece),
-  "iscCategoria": "seguros|telecom|alcohol|tabaco|vehiculos|combustibles" o null,
-  "cdtMonto": numero (Contribucion Desarrollo Telecom 2%%, usa 0 si no aparece),
-  "cargo911": numero (Contribucion al 911, usa 0 si no aparece),
-  "propina": numero (propina legal 10%%, usa 0 si no aparece),
-  "otrosImpuestos": numero (impuestos no clasificados, usa 0 si no aparece),
-  "montoNoFacturable": numero (propinas voluntarias, reembolsos, usa 0 si no aparece),
-  "total": numero final a pagar (usa 0 si no aparece, NUNCA null),
-  "formaPago": "01-07 segun codigo",
-  "tipoBienServicio": "01-13 segun codigo",
-  "items": [{"codigo": "001", "descripcion": "...", "cantidad": 1, "precioUnit": 100, "descuento": 0, "itbis": 18, "importe": 118}]
+func (e *Extractor) buildPromptDGII(ocrText string, examples string) (string, error) {
+	return e.prompt.Text(PromptData{Year: e.year, Examples: examples, OCRText: ocrText})
 }
 
-## GUIA DE IMPUESTOS DOMINICANOS
-
-### ITBIS (Impuesto Transferencia Bienes y Servicios)
-- 18%% normal - busca "ITBIS", "I.T.B.I.S", "IVA", "Impuesto"
-- 16%% zona franca
-- itbisRetenido: monto retenido por el receptor (no por el emisor)
-- itbisRetenidoPorcentaje: 30 si gran contribuyente, 100 si retenedor designado, 0 si no hay retencion
-
-### ISC (Impuesto Selectivo al Consumo) - por categoria
-- seguros: 16%% sobre prima neta (facturas de aseguradoras)
-- telecom: 10%% sobre servicio de telecomunicaciones (Claro, Altice, Viva)
-- alcohol: monto especifico por litro (no porcentaje fijo)
-- tabaco: monto especifico por unidad (no porcentaje fijo)
-- combustibles: monto fijo por galon segun tipo
-- vehiculos: monto segun categoria del vehiculo
-
-COMO IDENTIFICAR ISC EN LA FACTURA:
-- Busca exactamente las palabras: "ISC", "Imp. Selectivo", "Selectivo Consumo", "ISCA", "Impuesto Selectivo al Consumo"
-- En facturas telecom (Claro, Altice, Viva, Wind Telecom): ISC aparece como línea separada junto al ITBIS. Si ves "CDT" o "Cargo 911", hay ISC telecom del 10%%.
-- En facturas de seguros (ARS, aseguradoras): busca "Prima Neta" y calcula 16%% sobre ese valor
-- Si encuentras monto de ISC pero no puedes determinar categoría, usa "otros" como iscCategoria
-- NUNCA confundas ISC con ITBIS — son impuestos diferentes y separados en la factura
-
-### CDT y 911 (solo telecom)
-- CDT: 2%% adicional en facturas telecom - "Contribucion Desarrollo Telecomunicaciones"
-- 911: Cargo fijo en lineas telefonicas - "Contribucion 911", "Cargo 911"
-
-### ISR (Impuesto Sobre la Renta) - tipos de retencion
-- Tipo 1: Alquileres (10%%)
-- Tipo 2: Honorarios y comisiones personas fisicas (10%%)
-- Tipo 3: Otros ingresos personas fisicas (10%%)
-- Tipo 4: Renta presunta (25%% o 27%%)
-- Tipo 5: Loterias y premios (25%%)
-- Tipo 6: Personas juridicas (27%%)
-- Tipo 7: Servicios en general (10%%)
-- Tipo 8: Dividendos (10%%)
-
-### Propina
-- 10%% legal en restaurantes y hoteles - busca "Propina", "Servicio", "10%%"
-
-### ncfModifica (OBLIGATORIO para notas)
-- Si tipoNcf es B04 (Nota de Credito): ncfModifica = NCF de la factura que se corrige
-- Si tipoNcf es E32 (Nota Debito Electronica): ncfModifica = e-NCF que se modifica
-- Si tipoNcf es E33 (Nota Credito Electronica): ncfModifica = e-NCF que se modifica
-- Para cualquier otro tipo de NCF: ncfModifica = null
-
-## REGLAS CRITICAS
-1. NCF: Busca "NCF:", "Comprobante:", "e-NCF:", "B01", "B02", "B04", "E31"
-2. RNC Emisor: Busca "RNC:", "R.N.C." seguido de 9 u 11 digitos (ARRIBA del texto)
-3. RNC Receptor: Busca "RNC Cliente:", "Cedula:", "RNC/Cedula:" (ABAJO del texto)
-4. tipoNcf: Primeros 3 caracteres del NCF (B01, B02, B04, B14, B15, B16, E31-E45)
-5. tipoIdEmisor/Receptor: "1" si 9 digitos (RNC empresa), "2" si 11 digitos (Cedula)
-6. Subtotal: busca "Sub-Total", "Subtotal", "Base Imponible", "Monto Gravado"
-7. Si no encuentras un dato, usa null para strings o 0 para numeros
-8. Ano por defecto si no se ve: %d
-9. Todos los montos deben ser numeros decimales (no strings)
-10. NUNCA devuelvas null para subtotal, itbis, o total - usa 0 si no puedes leer el valor
-11. NUNCA inventes ni calcules montos que no aparezcan en el texto
-12. NUNCA pongas el mismo RNC en emisor y receptor
-
-## CODIGOS
-
-formaPago: 01=Efectivo, 02=Cheque/Transferencia, 03=Tarjeta, 04=Credito, 05=Permuta, 06=Nota Credito, 07=Mixto
-
-tipoBienServicio: 01=Personal, 02=Servicios, 03=Arrendamiento, 04=Activos fijos, 05=Representacion, 06=Deducciones, 07=Financieros, 08=Extraordinarios, 09=Costo venta, 10=Activos, 11=Seguros, 12=Viajes, 13=Otros
-
-AHORA ANALIZA EL TEXTO. PRIMERO identifica quien VENDE y quien COMPRA, LUEGO extrae todos los datos fiscales.
-
-Texto de la factura:
-%s`, currentYear, ocrText)
-
-	return prompt
-}
 // parseResponseDGII converts AI JSON response to Invoice struct with DGII fields
 func (e *Extractor) parseResponseDGII(response string, ocrText string) (*models.Invoice, error) {
 	// Clean response (remove markdown code blocks if present)
@@ -441,6 +221,7 @@ func (e *Extractor) parseResponseDGII(response string, ocrText string) (*models.
 		MontoNoFacturable interface{} `json:"montoNoFacturable"`
 		// Total
 		Total            interface{} `json:"total"`
+		Moneda           string      `json:"moneda"`
 		FormaPago        string      `json:"formaPago"`
 		TipoBienServicio string      `json:"tipoBienServicio"`
 		Items            []struct {
@@ -557,6 +338,7 @@ func (e *Extractor) parseResponseDGII(response string, ocrText string) (*models.
 	// Parse amounts - Total
 	invoice.Total = parseDecimal(raw.Total)
 	invoice.Tax = invoice.ITBIS // Legacy
+	invoice.Moneda = normalizeMoneda(raw.Moneda)
 
 	// Determine invoice type
 	if invoice.TipoNCF == "B01" || invoice.TipoNCF == "B15" || invoice.TipoNCF == "B14" {
@@ -613,6 +395,24 @@ func (e *Extractor) parseResponseDGII(response string, ocrText string) (*models.
 
 // Helper functions
 
+// normalizeMoneda maps the currency reported by the model to an ISO 4217 code.
+// Anything unrecognized is treated as DOP.
+func normalizeMoneda(m string) string {
+	m = strings.ToUpper(strings.TrimSpace(m))
+	switch m {
+	case "USD", "US$", "U$S", "DOLARES", "DÓLARES", "DOLLAR", "DOLLARS":
+		return "USD"
+	case "EUR", "€", "EUROS", "EURO":
+		return "EUR"
+	case "", "DOP", "RD$", "RD", "PESOS", "$":
+		return "DOP"
+	}
+	if len(m) == 3 {
+		return m
+	}
+	return "DOP"
+}
+
 func parseDate(s string) time.Time {
 	if s == "" {
 		return time.Time{}
@@ -0,0 +1,127 @@
+package ai
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const plazaLamaJSON = `{"ncf":"B0100000001","rncEmisor":"131047939","nombreEmisor":"PLAZA","subtotal":100,"itbis":18,"total":118}`
+
+func plazaLamaExamples(rncs []string) []Example {
+	for _, rnc := range rncs {
+		if rnc == "131047939" {
+			return []Example{{
+				Corrections: []FieldCorrection{{Field: "nombreEmisor", Extracted: "PLAZA", Correct: "PLAZA LAMA"}},
+				Corrected:   map[string]interface{}{"nombreEmisor": "PLAZA LAMA", "total": 118.0},
+			}}
+		}
+	}
+	return nil
+}
+
+func TestExtractParsesResponse(t *testing.T) {
+	p := NewFakeProvider(FakeResponse{Text: "```json\n" + plazaLamaJSON + "\n```"})
+	inv, _, err := NewExtractor(p, nil).Extract("", "data:image/jpeg;base64,AAAA")
+	if err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	if inv.NCF != "B0100000001" || inv.RNCEmisor != "131047939" || !inv.Total.Equal(inv.Subtotal.Add(inv.ITBIS)) {
+		t.Errorf("invoice = %+v", inv)
+	}
+}
+
+func TestExtractWithoutExamplesOfEmisor(t *testing.T) {
+	p := NewFakeProvider(FakeResponse{Text: `{"ncf":"B0100000002","rncEmisor":"101000001","total":50}`})
+	e := NewExtractor(p, nil)
+	e.UseExamples(plazaLamaExamples, "", 1500)
+
+	if _, _, err := e.Extract("", "data:image/jpeg;base64,AAAA"); err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	if len(p.Calls()) != 1 || e.UsedExamples() {
+		t.Errorf("calls = %d, UsedExamples = %v; want one pass without examples", len(p.Calls()), e.UsedExamples())
+	}
+}
+
+func TestExtractVisionMakesOneCallByDefault(t *testing.T) {
+	p := NewFakeProvider(FakeResponse{Text: plazaLamaJSON})
+	e := NewExtractor(p, nil)
+	e.UseExamples(plazaLamaExamples, "", 1500)
+
+	if _, _, err := e.Extract("", "data:image/jpeg;base64,AAAA"); err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	if len(p.Calls()) != 1 || e.UsedExamples() {
+		t.Errorf("calls = %d, UsedExamples = %v; want one pass without examples", len(p.Calls()), e.UsedExamples())
+	}
+}
+
+func TestExtractVisionAddsExamplesOfExtractedEmisor(t *testing.T) {
+	p := NewFakeProvider(FakeResponse{Text: plazaLamaJSON})
+	e := NewExtractor(p, nil)
+	e.UseExamples(plazaLamaExamples, "", 1500)
+	e.UseSecondPass(true)
+
+	if _, _, err := e.Extract("", "data:image/jpeg;base64,AAAA"); err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	calls := p.Calls()
+	if len(calls) != 2 {
+		t.Fatalf("provider called %d times, want a second pass with examples", len(calls))
+	}
+	if strings.Contains(calls[0].Prompt, "CORRECCIONES PREVIAS") {
+		t.Error("first pass has examples before the emisor is known")
+	}
+	if !strings.Contains(calls[1].Prompt, `"PLAZA LAMA"`) {
+		t.Error("second pass lacks the emisor's corrections")
+	}
+	if !e.UsedExamples() {
+		t.Error("UsedExamples = false after a pass with examples")
+	}
+}
+
+func TestExtractTextUsesRNCsOfOCRText(t *testing.T) {
+	p := NewFakeProvider(FakeResponse{Text: plazaLamaJSON})
+	e := NewExtractor(p, nil)
+	e.UseExamples(plazaLamaExamples, "", 1500)
+
+	if _, _, err := e.Extract("PLAZA LAMA\nRNC: 1-31-04793-9\nTOTAL 118.00", ""); err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	calls := p.Calls()
+	if len(calls) != 1 || !strings.Contains(calls[0].Prompt, "CORRECCIONES PREVIAS") {
+		t.Errorf("want one call with examples, got %d calls", len(calls))
+	}
+}
+
+func TestBuildExamplesSectionRespectsBudget(t *testing.T) {
+	examples := plazaLamaExamples([]string{"131047939"})
+	if s := buildExamplesSection(examples, 10); s != "" {
+		t.Errorf("section over budget = %q, want none", s)
+	}
+	s := buildExamplesSection(examples, 1500)
+	if estimateTokens(s) > 1500 || !strings.Contains(s, "Ejemplo 1") {
+		t.Errorf("section = %q", s)
+	}
+}
+
+func TestUseYearPinsThePrompt(t *testing.T) {
+	p := NewFakeProvider(FakeResponse{Text: plazaLamaJSON}, FakeResponse{Text: plazaLamaJSON})
+	e := NewExtractor(p, nil)
+	e.UseYear(2019)
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := e.Extract("", "data:image/jpeg;base64,AAAA"); err != nil {
+			t.Fatalf("Extract: %v", err)
+		}
+	}
+	calls := p.Calls()
+	if !strings.Contains(calls[0].Prompt, "2019") || strings.Contains(calls[0].Prompt, strconv.Itoa(time.Now().Year())) {
+		t.Error("prompt does not use the pinned year")
+	}
+	if calls[0].Prompt != calls[1].Prompt {
+		t.Error("same extraction rendered two prompts")
+	}
+}
@@ -0,0 +1,78 @@
+package ai
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// FakeResponse is one answer of a FakeProvider: the model's text or an
+// error, and the usage reported for it
+type FakeResponse struct {
+	Text  string
+	Err   error
+	Usage Usage
+}
+
+// FakeJSON is a FakeResponse with v, marshaled, as the model's text
+func FakeJSON(v interface{}) FakeResponse {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return FakeResponse{Err: err}
+	}
+	return FakeResponse{Text: string(data)}
+}
+
+// FakeCall is a call received by a FakeProvider
+type FakeCall struct {
+	Prompt      string
+	ImageBase64 string
+}
+
+// FakeProvider is a deterministic provider for tests: it answers with its
+// responses in order (repeating the last one) or, when Respond is set, with
+// whatever Respond returns. Every call is kept in Calls.
+type FakeProvider struct {
+	Respond func(prompt, imageBase64 string) (string, error)
+
+	mu        sync.Mutex
+	responses []FakeResponse
+	calls     []FakeCall
+}
+
+// NewFakeProvider answers with responses in order
+func NewFakeProvider(responses ...FakeResponse) *FakeProvider {
+	return &FakeProvider{responses: responses}
+}
+
+// ExtractData returns the next configured response
+func (p *FakeProvider) ExtractData(prompt string, imageBase64 string) (string, error) {
+	result, _, err := p.ExtractDataWithUsage(prompt, imageBase64)
+	return result, err
+}
+
+// ExtractDataWithUsage returns the next configured response and its usage
+func (p *FakeProvider) ExtractDataWithUsage(prompt string, imageBase64 string) (string, Usage, error) {
+	p.mu.Lock()
+	n := len(p.calls)
+	p.calls = append(p.calls, FakeCall{Prompt: prompt, ImageBase64: imageBase64})
+	p.mu.Unlock()
+
+	if p.Respond != nil {
+		result, err := p.Respond(prompt, imageBase64)
+		return result, Usage{}, err
+	}
+	if len(p.responses) == 0 {
+		return "{}", Usage{}, nil
+	}
+	if n >= len(p.responses) {
+		n = len(p.responses) - 1
+	}
+	return p.responses[n].Text, p.responses[n].Usage, p.responses[n].Err
+}
+
+// Calls returns the calls received so far
+func (p *FakeProvider) Calls() []FakeCall {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]FakeCall(nil), p.calls...)
+}
@@ -0,0 +1,201 @@
+package ai
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// DefaultPromptVersion is the built-in prompt used when no other is selected
+const DefaultPromptVersion = "v1"
+
+// Each prompt version is a directory with the two extraction templates
+const (
+	visionTemplate = "vision.tmpl"
+	textTemplate   = "dgii.tmpl"
+)
+
+//go:embed prompts
+var builtinPrompts embed.FS
+
+// PromptData is what the prompt templates render
+type PromptData struct {
+	Year     int    // Default year for dates without one
+	Examples string // Corrected examples of the emisor, "" when none
+	OCRText  string // Tesseract text (text prompt only)
+}
+
+// Prompt is one version of the extraction prompts. A directory version can be
+// edited in place under the same name, so Hash identifies the template text.
+type Prompt struct {
+	Version string
+	Hash    string // First 12 hex digits of the SHA-256 of both templates
+	vision  *template.Template
+	text    *template.Template
+}
+
+// Revision identifies the exact prompt text, "version@hash": what results
+// extracted with it record and what the extraction cache is keyed by
+func (p *Prompt) Revision() string {
+	return p.Version + "@" + p.Hash
+}
+
+// Vision renders the prompt for direct image analysis
+func (p *Prompt) Vision(data PromptData) (string, error) {
+	return p.render(p.vision, data)
+}
+
+// Text renders the prompt for OCR text analysis
+func (p *Prompt) Text(data PromptData) (string, error) {
+	return p.render(p.text, data)
+}
+
+func (p *Prompt) render(t *template.Template, data PromptData) (string, error) {
+	if data.Year == 0 {
+		data.Year = time.Now().Year()
+	}
+	var sb strings.Builder
+	if err := t.Execute(&sb, data); err != nil {
+		return "", fmt.Errorf("prompt %s: %w", p.Version, err)
+	}
+	return sb.String(), nil
+}
+
+// parsePrompt reads the templates of version from fsys/dir
+func parsePrompt(fsys fs.FS, dir, version string) (*Prompt, error) {
+	p := &Prompt{Version: version}
+	sum := sha256.New()
+	for _, t := range []struct {
+		name string
+		dst  **template.Template
+	}{{visionTemplate, &p.vision}, {textTemplate, &p.text}} {
+		data, err := fs.ReadFile(fsys, path.Join(dir, t.name))
+		if err != nil {
+			return nil, fmt.Errorf("prompt %s: %w", version, err)
+		}
+		tmpl, err := template.New(t.name).Option("missingkey=error").Parse(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("prompt %s: %w", version, err)
+		}
+		*t.dst = tmpl
+		fmt.Fprintf(sum, "%s\x00%d\x00", t.name, len(data))
+		sum.Write(data)
+	}
+	p.Hash = hex.EncodeToString(sum.Sum(nil))[:12]
+	return p, nil
+}
+
+var (
+	defaultPromptOnce sync.Once
+	defaultPrompt     *Prompt
+)
+
+// DefaultPrompt returns the built-in prompt of DefaultPromptVersion
+func DefaultPrompt() *Prompt {
+	defaultPromptOnce.Do(func() {
+		p, err := parsePrompt(builtinPrompts, path.Join("prompts", DefaultPromptVersion), DefaultPromptVersion)
+		if err != nil {
+			panic(err) // The embedded templates are part of the build
+		}
+		defaultPrompt = p
+	})
+	return defaultPrompt
+}
+
+// PromptLibrary holds the prompt versions: the built-in ones and those of a
+// directory (<dir>/<version>/vision.tmpl and dgii.tmpl), which can be added
+// or changed without a redeploy and picked up with Reload. A directory
+// version cannot reuse a built-in name: a version identifies one prompt text.
+type PromptLibrary struct {
+	dir string
+
+	mu       sync.RWMutex
+	versions map[string]*Prompt
+}
+
+// NewPromptLibrary loads the built-in prompts and those of dir ("" for none)
+func NewPromptLibrary(dir string) (*PromptLibrary, error) {
+	l := &PromptLibrary{dir: dir}
+	if err := l.Reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Reload reads the prompt versions again. On error the loaded versions are
+// kept.
+func (l *PromptLibrary) Reload() error {
+	versions := map[string]*Prompt{}
+	if err := loadPromptVersions(builtinPrompts, "prompts", versions); err != nil {
+		return err
+	}
+	if l.dir != "" {
+		builtin := make(map[string]bool, len(versions))
+		for v := range versions {
+			builtin[v] = true
+		}
+		loaded := map[string]*Prompt{}
+		if err := loadPromptVersions(os.DirFS(l.dir), ".", loaded); err != nil {
+			return err
+		}
+		for v, p := range loaded {
+			if builtin[v] {
+				return fmt.Errorf("prompt %s in %s: the version is built in, use a new name", v, l.dir)
+			}
+			versions[v] = p
+		}
+	}
+
+	l.mu.Lock()
+	l.versions = versions
+	l.mu.Unlock()
+	return nil
+}
+
+// loadPromptVersions parses every version directory of fsys/root
+func loadPromptVersions(fsys fs.FS, root string, versions map[string]*Prompt) error {
+	entries, err := fs.ReadDir(fsys, root)
+	if err != nil {
+		return fmt.Errorf("reading prompts: %w", err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		p, err := parsePrompt(fsys, path.Join(root, entry.Name()), entry.Name())
+		if err != nil {
+			return err
+		}
+		versions[p.Version] = p
+	}
+	return nil
+}
+
+// Get returns a prompt version
+func (l *PromptLibrary) Get(version string) (*Prompt, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	p, ok := l.versions[version]
+	return p, ok
+}
+
+// Versions lists the loaded prompt versions
+func (l *PromptLibrary) Versions() []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	versions := make([]string, 0, len(l.versions))
+	for v := range l.versions {
+		versions = append(versions, v)
+	}
+	sort.Strings(versions)
+	return versions
+}
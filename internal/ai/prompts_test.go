@@ -0,0 +1,124 @@
+package ai
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writePrompt(t *testing.T, dir, version, vision, text string) {
+	t.Helper()
+	vdir := filepath.Join(dir, version)
+	if err := os.MkdirAll(vdir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(vdir, visionTemplate), []byte(vision), 0o644)
+	os.WriteFile(filepath.Join(vdir, textTemplate), []byte(text), 0o644)
+}
+
+func TestPromptLibraryLoadsDirectoryVersions(t *testing.T) {
+	dir := t.TempDir()
+	writePrompt(t, dir, "v2", "vision {{.Year}}{{.Examples}}", "text {{.OCRText}}")
+
+	lib, err := NewPromptLibrary(dir)
+	if err != nil {
+		t.Fatalf("NewPromptLibrary: %v", err)
+	}
+	if got := strings.Join(lib.Versions(), ","); got != "v1,v2" {
+		t.Errorf("Versions() = %s, want v1,v2", got)
+	}
+	p, _ := lib.Get("v2")
+	if s, err := p.Text(PromptData{OCRText: "RNC 131047939"}); err != nil || s != "text RNC 131047939" {
+		t.Errorf("Text() = %q, %v", s, err)
+	}
+
+	// A new version is picked up without restarting
+	writePrompt(t, dir, "v3", "vision", "text")
+	if err := lib.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if _, ok := lib.Get("v3"); !ok {
+		t.Error("v3 not loaded by Reload")
+	}
+}
+
+func TestPromptLibraryRejectsBuiltinVersions(t *testing.T) {
+	dir := t.TempDir()
+	writePrompt(t, dir, DefaultPromptVersion, "vision", "text")
+	if _, err := NewPromptLibrary(dir); err == nil {
+		t.Error("a directory version overriding a built-in one was accepted")
+	}
+
+	// A broken reload keeps what was loaded
+	dir = t.TempDir()
+	writePrompt(t, dir, "v2", "vision", "text")
+	lib, err := NewPromptLibrary(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePrompt(t, dir, "v2", "vision {{.Nope", "text")
+	if err := lib.Reload(); err == nil {
+		t.Error("Reload accepted a broken template")
+	}
+	if _, ok := lib.Get("v2"); !ok {
+		t.Error("failed Reload dropped the loaded versions")
+	}
+}
+
+func TestExtractRecordsPromptVersion(t *testing.T) {
+	dir := t.TempDir()
+	writePrompt(t, dir, "v2", "PROMPT V2{{.Examples}}", "text")
+	lib, err := NewPromptLibrary(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := lib.Get("v2")
+
+	fake := NewFakeProvider(FakeResponse{Text: plazaLamaJSON})
+	e := NewExtractor(fake, nil)
+	e.UsePrompt(p)
+	inv, _, err := e.Extract("", "data:image/jpeg;base64,AAAA")
+	if err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	if inv.PromptVersion != p.Revision() || !strings.HasPrefix(inv.PromptVersion, "v2@") {
+		t.Errorf("PromptVersion = %q, want %s", inv.PromptVersion, p.Revision())
+	}
+	if calls := fake.Calls(); calls[0].Prompt != "PROMPT V2" {
+		t.Errorf("prompt = %q", calls[0].Prompt)
+	}
+
+	inv, _, _ = NewExtractor(fake, nil).Extract("", "data:image/jpeg;base64,AAAA")
+	if inv.PromptVersion != DefaultPrompt().Revision() {
+		t.Errorf("default PromptVersion = %q", inv.PromptVersion)
+	}
+}
+
+func TestEditedPromptGetsANewRevision(t *testing.T) {
+	dir := t.TempDir()
+	writePrompt(t, dir, "v2", "vision", "text")
+	lib, err := NewPromptLibrary(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, _ := lib.Get("v2")
+
+	// Same name, same text: same revision
+	if err := lib.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := lib.Get("v2"); again.Revision() != before.Revision() {
+		t.Errorf("unchanged reload: %s != %s", again.Revision(), before.Revision())
+	}
+
+	// Edited in place under the same name
+	writePrompt(t, dir, "v2", "vision", "text, revisado")
+	if err := lib.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := lib.Get("v2")
+	if after.Version != "v2" || after.Revision() == before.Revision() {
+		t.Errorf("edited prompt kept revision %s", after.Revision())
+	}
+}
@@ -54,6 +54,15 @@ func NamedProvider(name string, p Provider) namedProvider {
 // Returns ErrAllProvidersFailed (wrapping last error) when all providers fail transiently.
 type FallbackProvider struct {
 	providers []namedProvider
+	observer  func(FallbackEvent)
+}
+
+// FallbackEvent reports one step of the chain to an observer
+type FallbackEvent struct {
+	Provider string
+	Kind     string        // "attempt", "success", "fallback" (transient error, trying next) or "failed"
+	Err      error         // For "fallback" and "failed"
+	Duration time.Duration // Of the call, for every kind but "attempt"
 }
 
 // NewFallbackProvider creates a FallbackProvider from an ordered list of named providers
@@ -61,24 +70,50 @@ func NewFallbackProvider(providers ...namedProvider) *FallbackProvider {
 	return &FallbackProvider{providers: providers}
 }
 
+// Names returns the provider names in the order they are tried
+func (f *FallbackProvider) Names() []string {
+	names := make([]string, len(f.providers))
+	for i, np := range f.providers {
+		names[i] = np.name
+	}
+	return names
+}
+
+// Observe sets a function called on every attempt, success and failure, e.g.
+// to report progress. Set it before ExtractData.
+func (f *FallbackProvider) Observe(fn func(FallbackEvent)) {
+	f.observer = fn
+}
+
+func (f *FallbackProvider) notify(ev FallbackEvent) {
+	if f.observer != nil {
+		f.observer(ev)
+	}
+}
+
 // ExtractData tries each provider in order
 func (f *FallbackProvider) ExtractData(prompt string, imageBase64 string) (string, error) {
 	var lastErr error
 	for _, np := range f.providers {
+		f.notify(FallbackEvent{Provider: np.name, Kind: "attempt"})
+		start := time.Now()
 		result, err := np.provider.ExtractData(prompt, imageBase64)
 		if err == nil {
 			if len(f.providers) > 1 {
 				fmt.Printf("[FallbackProvider] Success with provider: %s\n", np.name)
 			}
+			f.notify(FallbackEvent{Provider: np.name, Kind: "success", Duration: time.Since(start)})
 			return result, nil
 		}
 		lastErr = err
 		fmt.Printf("[FallbackProvider] Provider %s failed: %v\n", np.name, err)
 		if !isCooldownError(err) {
 			// Non-transient error — don't try next provider, return immediately
+			f.notify(FallbackEvent{Provider: np.name, Kind: "failed", Err: err, Duration: time.Since(start)})
 			return "", err
 		}
 		fmt.Printf("[FallbackProvider] Transient error on %s, trying next provider...\n", np.name)
+		f.notify(FallbackEvent{Provider: np.name, Kind: "fallback", Err: err, Duration: time.Since(start)})
 	}
 	return "", fmt.Errorf("%w: last error: %v", ErrAllProvidersFailed, lastErr)
 }
@@ -109,6 +144,13 @@ func NewOpenAIProvider(apiKey, baseURL, model string) *OpenAIProvider {
 
 // ExtractData sends prompt and image to OpenAI
 func (p *OpenAIProvider) ExtractData(prompt string, imageBase64 string) (string, error) {
+	result, _, err := p.ExtractDataWithUsage(prompt, imageBase64)
+	return result, err
+}
+
+// ExtractDataWithUsage is ExtractData reporting the usage of the response
+func (p *OpenAIProvider) ExtractDataWithUsage(prompt string, imageBase64 string) (string, Usage, error) {
+	usage := Usage{Provider: "openai", Model: p.model}
 	var config openai.ClientConfig
 
 	// Check if Azure OpenAI
@@ -176,14 +218,16 @@ func (p *OpenAIProvider) ExtractData(prompt string, imageBase64 string) (string,
 	)
 
 	if err != nil {
-		return "", fmt.Errorf("OpenAI API call failed: %w", err)
+		return "", usage, fmt.Errorf("OpenAI API call failed: %w", err)
 	}
+	usage.InputTokens = resp.Usage.PromptTokens
+	usage.OutputTokens = resp.Usage.CompletionTokens
 
 	if len(resp.Choices) == 0 {
-		return "", fmt.Errorf("no response from OpenAI")
+		return "", usage, fmt.Errorf("no response from OpenAI")
 	}
 
-	return resp.Choices[0].Message.Content, nil
+	return resp.Choices[0].Message.Content, usage, nil
 }
 
 // GeminiProvider implements Provider for Google Gemini
@@ -205,11 +249,18 @@ func NewGeminiProvider(apiKey, model string) *GeminiProvider {
 
 // ExtractData sends prompt and image to Gemini
 func (p *GeminiProvider) ExtractData(prompt string, imageBase64 string) (string, error) {
+	result, _, err := p.ExtractDataWithUsage(prompt, imageBase64)
+	return result, err
+}
+
+// ExtractDataWithUsage is ExtractData reporting the response's UsageMetadata
+func (p *GeminiProvider) ExtractDataWithUsage(prompt string, imageBase64 string) (string, Usage, error) {
+	usage := Usage{Provider: "gemini", Model: p.model}
 	ctx := context.Background()
 
 	client, err := genai.NewClient(ctx, option.WithAPIKey(p.apiKey))
 	if err != nil {
-		return "", fmt.Errorf("failed to create Gemini client: %w", err)
+		return "", usage, fmt.Errorf("failed to create Gemini client: %w", err)
 	}
 	defer client.Close()
 
@@ -233,7 +284,7 @@ func (p *GeminiProvider) ExtractData(prompt string, imageBase64 string) (string,
 		// Decode base64
 		imageBytes, err := decodeBase64(imageData)
 		if err != nil {
-			return "", fmt.Errorf("failed to decode image: %w", err)
+			return "", usage, fmt.Errorf("failed to decode image: %w", err)
 		}
 
 		// Detect MIME type
@@ -250,11 +301,15 @@ func (p *GeminiProvider) ExtractData(prompt string, imageBase64 string) (string,
 	// Generate content
 	resp, err := model.GenerateContent(ctx, parts...)
 	if err != nil {
-		return "", fmt.Errorf("Gemini API call failed: %w", err)
+		return "", usage, fmt.Errorf("Gemini API call failed: %w", err)
+	}
+	if resp.UsageMetadata != nil {
+		usage.InputTokens = int(resp.UsageMetadata.PromptTokenCount)
+		usage.OutputTokens = int(resp.UsageMetadata.CandidatesTokenCount)
 	}
 
 	if len(resp.Candidates) == 0 {
-		return "", fmt.Errorf("no response from Gemini")
+		return "", usage, fmt.Errorf("no response from Gemini")
 	}
 
 	// Extract text from first candidate
@@ -263,7 +318,7 @@ func (p *GeminiProvider) ExtractData(prompt string, imageBase64 string) (string,
 		result += fmt.Sprintf("%s", part)
 	}
 
-	return result, nil
+	return result, usage, nil
 }
 
 // OllamaProvider implements Provider for local Ollama
@@ -288,6 +343,14 @@ func NewOllamaProvider(baseURL, model string) *OllamaProvider {
 
 // ExtractData sends prompt and image to Ollama
 func (p *OllamaProvider) ExtractData(prompt string, imageBase64 string) (string, error) {
+	result, _, err := p.ExtractDataWithUsage(prompt, imageBase64)
+	return result, err
+}
+
+// ExtractDataWithUsage is ExtractData reporting the response's eval counts
+func (p *OllamaProvider) ExtractDataWithUsage(prompt string, imageBase64 string) (string, Usage, error) {
+	usage := Usage{Provider: "ollama", Model: p.model}
+
 	// Build message
 	message := map[string]interface{}{
 		"role":    "user",
@@ -318,7 +381,7 @@ func (p *OllamaProvider) ExtractData(prompt string, imageBase64 string) (string,
 
 	bodyBytes, err := json.Marshal(body)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
+		return "", usage, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
 	// Make HTTP request
@@ -329,40 +392,45 @@ func (p *OllamaProvider) ExtractData(prompt string, imageBase64 string) (string,
 	url := p.baseURL + "/api/chat"
 	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(bodyBytes))
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return "", usage, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := httpClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("Ollama API call failed: %w", err)
+		return "", usage, fmt.Errorf("Ollama API call failed: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		bodyText, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("Ollama returned status %d: %s", resp.StatusCode, string(bodyText))
+		return "", usage, fmt.Errorf("Ollama returned status %d: %s", resp.StatusCode, string(bodyText))
 	}
 
 	// Parse response
 	responseBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
+		return "", usage, fmt.Errorf("failed to read response: %w", err)
 	}
 
 	var responseObj struct {
 		Message struct {
 			Content string `json:"content"`
 		} `json:"message"`
+		PromptEvalCount int `json:"prompt_eval_count"`
+		EvalCount       int `json:"eval_count"`
 	}
 
 	err = json.Unmarshal(responseBody, &responseObj)
 	if err != nil {
-		return "", fmt.Errorf("failed to parse response: %w", err)
+		return "", usage, fmt.Errorf("failed to parse response: %w", err)
 	}
 
-	return responseObj.Message.Content, nil
+	usage.InputTokens = responseObj.PromptEvalCount
+	usage.OutputTokens = responseObj.EvalCount
+
+	return responseObj.Message.Content, usage, nil
 }
 
 // Helper functions
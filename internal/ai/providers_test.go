@@ -0,0 +1,68 @@
+package ai
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFallbackProviderFallsBackOnTransientErrors(t *testing.T) {
+	primary := NewFakeProvider(FakeResponse{Err: errors.New("429 Too Many Requests")})
+	secondary := NewFakeProvider(FakeResponse{Text: `{"ncf":"B0100000001"}`})
+	fp := NewFallbackProvider(NamedProvider("primary", primary), NamedProvider("secondary", secondary))
+
+	var kinds []string
+	fp.Observe(func(ev FallbackEvent) { kinds = append(kinds, ev.Provider+":"+ev.Kind) })
+
+	got, err := fp.ExtractData("prompt", "")
+	if err != nil {
+		t.Fatalf("ExtractData: %v", err)
+	}
+	if got != `{"ncf":"B0100000001"}` {
+		t.Errorf("response = %q, want the secondary's", got)
+	}
+	want := []string{"primary:attempt", "primary:fallback", "secondary:attempt", "secondary:success"}
+	if len(kinds) != len(want) {
+		t.Fatalf("events = %v, want %v", kinds, want)
+	}
+	for i := range want {
+		if kinds[i] != want[i] {
+			t.Errorf("event %d = %s, want %s", i, kinds[i], want[i])
+		}
+	}
+}
+
+func TestFallbackProviderStopsOnPermanentErrors(t *testing.T) {
+	permanent := errors.New("invalid API key")
+	primary := NewFakeProvider(FakeResponse{Err: permanent})
+	secondary := NewFakeProvider(FakeResponse{Text: "{}"})
+	fp := NewFallbackProvider(NamedProvider("primary", primary), NamedProvider("secondary", secondary))
+
+	if _, err := fp.ExtractData("prompt", ""); !errors.Is(err, permanent) {
+		t.Fatalf("err = %v, want %v", err, permanent)
+	}
+	if n := len(secondary.Calls()); n != 0 {
+		t.Errorf("secondary called %d times after a permanent error", n)
+	}
+}
+
+func TestFallbackProviderAllFailed(t *testing.T) {
+	fp := NewFallbackProvider(
+		NamedProvider("a", NewFakeProvider(FakeResponse{Err: errors.New("quota exceeded")})),
+		NamedProvider("b", NewFakeProvider(FakeResponse{Err: errors.New("503 service unavailable")})),
+	)
+	if _, err := fp.ExtractData("prompt", ""); !errors.Is(err, ErrAllProvidersFailed) {
+		t.Fatalf("err = %v, want ErrAllProvidersFailed", err)
+	}
+}
+
+func TestFakeProviderRepeatsLastResponse(t *testing.T) {
+	p := NewFakeProvider(FakeResponse{Text: "first"}, FakeResponse{Text: "second"})
+	for i, want := range []string{"first", "second", "second"} {
+		if got, _ := p.ExtractData("prompt", ""); got != want {
+			t.Errorf("call %d = %q, want %q", i, got, want)
+		}
+	}
+	if n := len(p.Calls()); n != 3 {
+		t.Errorf("Calls() = %d, want 3", n)
+	}
+}
@@ -0,0 +1,129 @@
+package ai
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrNoRecording is returned by ReplayProvider for a call never recorded
+var ErrNoRecording = errors.New("no recorded AI response")
+
+// Recording is one provider call stored on disk: a file named after its key
+type Recording struct {
+	Key         string    `json:"key"`
+	Prompt      string    `json:"prompt"`
+	ImageSHA256 string    `json:"image_sha256,omitempty"`
+	Response    string    `json:"response"`
+	Error       string    `json:"error,omitempty"` // The call failed with this error
+	RecordedAt  time.Time `json:"recorded_at"`
+}
+
+// imageSHA256 hashes the image bytes of a base64 image, with or without data
+// URI prefix, so re-encodings of the same file share the hash
+func imageSHA256(imageBase64 string) string {
+	if imageBase64 == "" {
+		return ""
+	}
+	data := []byte(imageBase64)
+	if i := strings.Index(imageBase64, ","); strings.HasPrefix(imageBase64, "data:") && i >= 0 {
+		data = []byte(imageBase64[i+1:])
+	}
+	if decoded, err := decodeBase64(string(data)); err == nil {
+		data = decoded
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// recordingKey identifies a call by its prompt and image
+func recordingKey(prompt, imageHash string) string {
+	sum := sha256.Sum256([]byte(prompt + "\x00" + imageHash))
+	return hex.EncodeToString(sum[:])
+}
+
+// RecordingProvider passes calls to another provider and stores each prompt,
+// image hash and response in dir, for a ReplayProvider to serve back
+type RecordingProvider struct {
+	next Provider
+	dir  string
+}
+
+// NewRecordingProvider records the calls made to next in dir
+func NewRecordingProvider(next Provider, dir string) *RecordingProvider {
+	return &RecordingProvider{next: next, dir: dir}
+}
+
+// ExtractData calls the wrapped provider and records the result, failed
+// calls included. A recording that can't be written fails the call: a
+// recording run must not silently miss responses.
+func (p *RecordingProvider) ExtractData(prompt string, imageBase64 string) (string, error) {
+	response, callErr := p.next.ExtractData(prompt, imageBase64)
+
+	imageHash := imageSHA256(imageBase64)
+	rec := Recording{
+		Key:         recordingKey(prompt, imageHash),
+		Prompt:      prompt,
+		ImageSHA256: imageHash,
+		Response:    response,
+		RecordedAt:  time.Now().UTC(),
+	}
+	if callErr != nil {
+		rec.Error = callErr.Error()
+	}
+	if err := writeRecording(p.dir, rec); err != nil {
+		return "", fmt.Errorf("recording AI response: %w", err)
+	}
+	return response, callErr
+}
+
+func writeRecording(dir string, rec Recording) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, rec.Key+".json"), data, 0o644)
+}
+
+// ReplayProvider answers with the responses a RecordingProvider stored in
+// dir, without network calls. Any change to the prompt or the image is a
+// different call and has to be recorded again.
+type ReplayProvider struct {
+	dir string
+}
+
+// NewReplayProvider serves the recordings in dir
+func NewReplayProvider(dir string) *ReplayProvider {
+	return &ReplayProvider{dir: dir}
+}
+
+// ExtractData returns the recorded response, or the recorded error of a
+// failed call
+func (p *ReplayProvider) ExtractData(prompt string, imageBase64 string) (string, error) {
+	key := recordingKey(prompt, imageSHA256(imageBase64))
+	data, err := os.ReadFile(filepath.Join(p.dir, key+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("%w: %s in %s", ErrNoRecording, key, p.dir)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var rec Recording
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return "", fmt.Errorf("recording %s: %w", key, err)
+	}
+	if rec.Error != "" {
+		return rec.Response, errors.New(rec.Error)
+	}
+	return rec.Response, nil
+}
@@ -0,0 +1,41 @@
+package ai
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func TestRecordingReplayRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	image := "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString([]byte("receipt"))
+
+	live := NewFakeProvider(FakeResponse{Text: `{"total":118}`}, FakeResponse{Err: errors.New("model overloaded")})
+	rec := NewRecordingProvider(live, dir)
+	if _, err := rec.ExtractData("prompt A", image); err != nil {
+		t.Fatalf("recording: %v", err)
+	}
+	if _, err := rec.ExtractData("prompt B", image); err == nil {
+		t.Fatal("recording: want the provider's error")
+	}
+
+	replay := NewReplayProvider(dir)
+	got, err := replay.ExtractData("prompt A", image)
+	if err != nil || got != `{"total":118}` {
+		t.Errorf("replay A = %q, %v; want the recorded response", got, err)
+	}
+	// The same image without data URI prefix is the same call
+	raw := base64.StdEncoding.EncodeToString([]byte("receipt"))
+	if got, _ := replay.ExtractData("prompt A", raw); got != `{"total":118}` {
+		t.Errorf("replay without prefix = %q, want the recorded response", got)
+	}
+	if _, err := replay.ExtractData("prompt B", image); err == nil || err.Error() != "model overloaded" {
+		t.Errorf("replay B err = %v, want the recorded error", err)
+	}
+	if _, err := replay.ExtractData("prompt C", image); !errors.Is(err, ErrNoRecording) {
+		t.Errorf("replay C err = %v, want ErrNoRecording", err)
+	}
+	if n := len(live.Calls()); n != 2 {
+		t.Errorf("live provider called %d times, want 2", n)
+	}
+}
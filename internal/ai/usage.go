@@ -0,0 +1,85 @@
+package ai
+
+import (
+	"time"
+)
+
+// Usage is what one provider call consumed, as reported by the provider
+type Usage struct {
+	Provider     string // "openai", "gemini", "ollama"
+	Model        string
+	InputTokens  int
+	OutputTokens int
+}
+
+// UsageProvider is a Provider that reports the token usage of its calls
+type UsageProvider interface {
+	Provider
+	ExtractDataWithUsage(prompt string, imageBase64 string) (string, Usage, error)
+}
+
+// Call is one metered provider call
+type Call struct {
+	Name    string // Provider name, as in the fallback chain
+	Usage   Usage  // Zero tokens when the provider does not report them
+	Latency time.Duration
+	Err     error
+}
+
+// Outcome classifies a call: "success", "transient_error" (rate limit,
+// quota, unavailable: the chain falls back) or "error"
+func (c Call) Outcome() string {
+	switch {
+	case c.Err == nil:
+		return "success"
+	case isCooldownError(c.Err):
+		return "transient_error"
+	default:
+		return "error"
+	}
+}
+
+// meteredProvider reports every call of a provider
+type meteredProvider struct {
+	name     string // Call name: the chain member name, or the provider's
+	kind     string // Usage.Provider when the call does not report it
+	provider Provider
+	record   func(Call)
+}
+
+// Metered returns provider reporting each call to record; name is the
+// provider ("openai", "gemini", "ollama"). Every provider of a fallback chain
+// is metered on its own under its chain name, and the result is still a
+// *FallbackProvider.
+func Metered(provider Provider, name string, record func(Call)) Provider {
+	if fp, ok := provider.(*FallbackProvider); ok {
+		metered := &FallbackProvider{observer: fp.observer}
+		for _, np := range fp.providers {
+			metered.providers = append(metered.providers, NamedProvider(np.name, &meteredProvider{name: np.name, kind: name, provider: np.provider, record: record}))
+		}
+		return metered
+	}
+	return &meteredProvider{name: name, kind: name, provider: provider, record: record}
+}
+
+// ExtractData calls the provider and records the call
+func (m *meteredProvider) ExtractData(prompt string, imageBase64 string) (string, error) {
+	start := time.Now()
+	var result string
+	var usage Usage
+	var err error
+	if up, ok := m.provider.(UsageProvider); ok {
+		result, usage, err = up.ExtractDataWithUsage(prompt, imageBase64)
+	} else {
+		result, err = m.provider.ExtractData(prompt, imageBase64)
+	}
+	if usage.Provider == "" {
+		usage.Provider = m.kind
+	}
+	if usage.Model == "" && m.name != m.kind {
+		// Chain members are named after their model
+		usage.Model = m.name
+	}
+	m.record(Call{Name: m.name, Usage: usage, Latency: time.Since(start), Err: err})
+	return result, err
+}
@@ -0,0 +1,51 @@
+package ai
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMeteredRecordsEveryProviderOfTheChain(t *testing.T) {
+	chain := NewFallbackProvider(
+		NamedProvider("gpt-4o", NewFakeProvider(FakeResponse{Err: errors.New("429 resource exhausted")})),
+		NamedProvider("openrouter-gemma-27b", NewFakeProvider(FakeResponse{
+			Text:  "{}",
+			Usage: Usage{Provider: "openai", Model: "openrouter-gemma-27b", InputTokens: 1200, OutputTokens: 300},
+		})),
+	)
+	var observed []string
+	chain.Observe(func(ev FallbackEvent) { observed = append(observed, ev.Kind) })
+
+	var calls []Call
+	metered := Metered(chain, "openai", func(c Call) { calls = append(calls, c) })
+	if _, ok := metered.(*FallbackProvider); !ok {
+		t.Fatalf("Metered(chain) = %T, want *FallbackProvider", metered)
+	}
+	if _, err := metered.ExtractData("prompt", ""); err != nil {
+		t.Fatalf("ExtractData: %v", err)
+	}
+
+	if len(calls) != 2 {
+		t.Fatalf("recorded %d calls, want 2", len(calls))
+	}
+	// The failed call reports no usage: it is recorded under the provider,
+	// not the chain member's model name
+	if calls[0].Name != "gpt-4o" || calls[0].Usage.Provider != "openai" || calls[0].Usage.Model != "gpt-4o" || calls[0].Outcome() != "transient_error" {
+		t.Errorf("first call = %+v (%s)", calls[0], calls[0].Outcome())
+	}
+	if calls[1].Name != "openrouter-gemma-27b" || calls[1].Usage.Provider != "openai" || calls[1].Usage.InputTokens != 1200 || calls[1].Usage.OutputTokens != 300 || calls[1].Outcome() != "success" {
+		t.Errorf("second call = %+v (%s)", calls[1], calls[1].Outcome())
+	}
+	if len(observed) != 4 {
+		t.Errorf("observer saw %v, want it kept by Metered", observed)
+	}
+}
+
+func TestMeteredSingleProvider(t *testing.T) {
+	var calls []Call
+	p := Metered(NewFakeProvider(FakeResponse{Err: errors.New("invalid API key")}), "ollama", func(c Call) { calls = append(calls, c) })
+	p.ExtractData("prompt", "")
+	if len(calls) != 1 || calls[0].Usage.Provider != "ollama" || calls[0].Outcome() != "error" {
+		t.Errorf("calls = %+v", calls)
+	}
+}
@@ -0,0 +1,143 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// AIUsage is one metered AI provider call
+type AIUsage struct {
+	EmpresaAlias string
+	ClienteID    string
+	FacturaID    string // Set when reprocessing an invoice
+	Provider     string
+	Model        string
+	LatencyMs    int64
+	InputTokens  int
+	OutputTokens int
+	CostUSD      float64
+	Outcome      string // success, transient_error, error
+	Error        string
+}
+
+// AIUsageTotals aggregates the calls of an empresa in a month, optionally
+// for one provider and model
+type AIUsageTotals struct {
+	EmpresaAlias string  `json:"empresa_alias"`
+	Mes          string  `json:"mes"` // YYYY-MM
+	Provider     string  `json:"provider,omitempty"`
+	Model        string  `json:"model,omitempty"`
+	Llamadas     int     `json:"llamadas"`
+	Errores      int     `json:"errores"`
+	InputTokens  int64   `json:"input_tokens"`
+	OutputTokens int64   `json:"output_tokens"`
+	CostoUSD     float64 `json:"costo_usd"`
+	LatenciaMs   float64 `json:"latencia_promedio_ms"`
+}
+
+// usageEmpresa is the empresa of a call: the given one or, for calls made
+// without a session (revision_manual retries), the cliente's last known one.
+// Takes the empresa as $1 and the cliente as $2.
+const usageEmpresa = `COALESCE(NULLIF($1, ''),
+	(SELECT u.empresa_alias FROM ai_usage u
+	 WHERE u.cliente_id = NULLIF($2, '')::uuid AND u.empresa_alias <> ''
+	 ORDER BY u.created_at DESC LIMIT 1), '')`
+
+// RecordAIUsage stores one provider call
+func RecordAIUsage(ctx context.Context, u AIUsage) error {
+	if Pool == nil {
+		return ErrNoDatabase
+	}
+	_, err := Pool.Exec(ctx, `
+		INSERT INTO ai_usage (empresa_alias, cliente_id, factura_id, provider, model, latency_ms,
+			input_tokens, output_tokens, cost_usd, outcome, error)
+		VALUES (`+usageEmpresa+`, NULLIF($2, '')::uuid, NULLIF($3, '')::uuid, $4, $5, $6, $7, $8, $9, $10, $11)
+	`, u.EmpresaAlias, u.ClienteID, u.FacturaID, u.Provider, u.Model, u.LatencyMs,
+		u.InputTokens, u.OutputTokens, u.CostUSD, u.Outcome, u.Error)
+	return err
+}
+
+// GetAIUsageMonth returns the usage of an empresa in the month of mes. The
+// empresa is resolved from the cliente when empty, like RecordAIUsage does.
+func GetAIUsageMonth(ctx context.Context, empresaAlias, clienteID string, mes time.Time) (*AIUsageTotals, error) {
+	if Pool == nil {
+		return nil, ErrNoDatabase
+	}
+	desde, hasta := monthRange(mes)
+	t := &AIUsageTotals{Mes: desde.Format("2006-01")}
+	err := Pool.QueryRow(ctx, `
+		WITH empresa AS (SELECT `+usageEmpresa+` AS alias)
+		SELECT empresa.alias, COUNT(u.id), COUNT(u.id) FILTER (WHERE u.outcome <> 'success'),
+		       COALESCE(SUM(u.input_tokens), 0), COALESCE(SUM(u.output_tokens), 0),
+		       COALESCE(SUM(u.cost_usd), 0)::float8, COALESCE(AVG(u.latency_ms), 0)::float8
+		FROM empresa
+		LEFT JOIN ai_usage u ON u.empresa_alias = empresa.alias AND empresa.alias <> ''
+			AND u.created_at >= $3 AND u.created_at < $4
+		GROUP BY empresa.alias
+	`, empresaAlias, clienteID, desde, hasta).Scan(&t.EmpresaAlias, &t.Llamadas, &t.Errores,
+		&t.InputTokens, &t.OutputTokens, &t.CostoUSD, &t.LatenciaMs)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// GetAIUsageByEmpresa returns the usage of every empresa in the month of mes,
+// most expensive first
+func GetAIUsageByEmpresa(ctx context.Context, mes time.Time) ([]AIUsageTotals, error) {
+	if Pool == nil {
+		return nil, ErrNoDatabase
+	}
+	desde, hasta := monthRange(mes)
+	return queryAIUsage(ctx, `
+		SELECT empresa_alias, $3::text, '', '', COUNT(*), COUNT(*) FILTER (WHERE outcome <> 'success'),
+		       SUM(input_tokens), SUM(output_tokens), SUM(cost_usd)::float8, AVG(latency_ms)::float8
+		FROM ai_usage
+		WHERE created_at >= $1 AND created_at < $2
+		GROUP BY empresa_alias
+		ORDER BY SUM(cost_usd) DESC, empresa_alias
+	`, desde, hasta, desde.Format("2006-01"))
+}
+
+// GetAIUsageEmpresa returns the usage of an empresa per month, provider and
+// model since the month of desde, latest month first
+func GetAIUsageEmpresa(ctx context.Context, empresaAlias string, desde time.Time) ([]AIUsageTotals, error) {
+	if Pool == nil {
+		return nil, ErrNoDatabase
+	}
+	desde, _ = monthRange(desde)
+	return queryAIUsage(ctx, `
+		SELECT empresa_alias, to_char(date_trunc('month', created_at), 'YYYY-MM') AS mes, provider, model,
+		       COUNT(*), COUNT(*) FILTER (WHERE outcome <> 'success'),
+		       SUM(input_tokens), SUM(output_tokens), SUM(cost_usd)::float8, AVG(latency_ms)::float8
+		FROM ai_usage
+		WHERE empresa_alias = $1 AND created_at >= $2
+		GROUP BY empresa_alias, mes, provider, model
+		ORDER BY mes DESC, SUM(cost_usd) DESC, provider, model
+	`, empresaAlias, desde)
+}
+
+func queryAIUsage(ctx context.Context, query string, args ...interface{}) ([]AIUsageTotals, error) {
+	rows, err := Pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := []AIUsageTotals{}
+	for rows.Next() {
+		var t AIUsageTotals
+		if err := rows.Scan(&t.EmpresaAlias, &t.Mes, &t.Provider, &t.Model, &t.Llamadas, &t.Errores,
+			&t.InputTokens, &t.OutputTokens, &t.CostoUSD, &t.LatenciaMs); err != nil {
+			return nil, err
+		}
+		totals = append(totals, t)
+	}
+	return totals, rows.Err()
+}
+
+// monthRange returns the first instant of the month of t and of the next one
+func monthRange(t time.Time) (time.Time, time.Time) {
+	desde := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	return desde, desde.AddDate(0, 1, 0)
+}
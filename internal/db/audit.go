@@ -0,0 +1,190 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// Audit sources: who set a value
+const (
+	AuditSourceAI       = "ai"       // Extraction (upload, reprocess, retries)
+	AuditSourceUser     = "user"     // The client in the app
+	AuditSourceContador = "contador" // An accountant (or admin)
+	AuditSourceSystem   = "system"   // Anything else automatic
+)
+
+// AuditActor is recorded with every field change of an invoice
+type AuditActor struct {
+	ID     string // User that made or requested the change; "" when automatic
+	Source string // One of the AuditSource constants; "" means system
+	Reason string
+}
+
+// Columns that are not versioned: documents derived from the extraction, not
+// invoice data
+var auditExcluded = map[string]bool{
+	"raw_ocr_json":      true,
+	"items_json":        true,
+	"montos_originales": true,
+}
+
+// InvoiceVersion is one audited update of an invoice
+type InvoiceVersion struct {
+	Version   int                    `json:"version"`
+	ActorID   *string                `json:"actor_id,omitempty"`
+	Source    string                 `json:"source"`
+	Reason    string                 `json:"reason,omitempty"`
+	CreatedAt time.Time              `json:"created_at"`
+	Cambios   map[string]FieldChange `json:"cambios"`
+}
+
+// recordInvoiceAudit writes, as the next version of the invoice, the columns
+// whose value differs between old and updated. The caller holds the row lock.
+func recordInvoiceAudit(ctx context.Context, q querier, clienteID, invoiceID string, actor AuditActor, old, updated *ClientInvoice, columns []string) error {
+	type change struct {
+		campo    string
+		old, new []byte
+	}
+	var changes []change
+	for _, name := range columns {
+		col, ok := invoiceColumns[name]
+		if !ok || auditExcluded[name] {
+			continue
+		}
+		oldValue, err := json.Marshal(col.value(old))
+		if err != nil {
+			return err
+		}
+		newValue, err := json.Marshal(col.value(updated))
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(oldValue, newValue) {
+			changes = append(changes, change{name, oldValue, newValue})
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].campo < changes[j].campo })
+
+	var version int
+	if err := q.QueryRow(ctx, `
+		SELECT COALESCE(MAX(version), 0) + 1 FROM invoice_field_audit WHERE factura_id = $1::uuid
+	`, invoiceID).Scan(&version); err != nil {
+		return err
+	}
+
+	source := actor.Source
+	if source == "" {
+		source = AuditSourceSystem
+	}
+	var actorID interface{}
+	if actor.ID != "" {
+		actorID = actor.ID
+	}
+	for _, c := range changes {
+		if _, err := q.Exec(ctx, `
+			INSERT INTO invoice_field_audit (factura_id, cliente_id, version, campo, old_value, new_value, actor_id, source, reason)
+			VALUES ($1::uuid, $2::uuid, $3, $4, $5::jsonb, $6::jsonb, $7::uuid, $8, $9)
+		`, invoiceID, clienteID, version, c.campo, string(c.old), string(c.new), actorID, source, actor.Reason); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetInvoiceHistory returns the audited versions of an invoice, oldest first
+func GetInvoiceHistory(ctx context.Context, clienteID, invoiceID string) ([]InvoiceVersion, error) {
+	if Pool == nil {
+		return nil, ErrNoDatabase
+	}
+
+	rows, err := Pool.Query(ctx, `
+		SELECT version, campo, COALESCE(old_value, 'null'::jsonb)::text, COALESCE(new_value, 'null'::jsonb)::text,
+		       actor_id::text, source, reason, created_at
+		FROM invoice_field_audit
+		WHERE factura_id = $1::uuid AND cliente_id = $2::uuid
+		ORDER BY version, campo
+	`, invoiceID, clienteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []InvoiceVersion{}
+	for rows.Next() {
+		var v InvoiceVersion
+		var campo, oldValue, newValue string
+		if err := rows.Scan(&v.Version, &campo, &oldValue, &newValue, &v.ActorID, &v.Source, &v.Reason, &v.CreatedAt); err != nil {
+			return nil, err
+		}
+		if n := len(versions); n == 0 || versions[n-1].Version != v.Version {
+			v.Cambios = map[string]FieldChange{}
+			versions = append(versions, v)
+		}
+		versions[len(versions)-1].Cambios[campo] = FieldChange{
+			Old: json.RawMessage(oldValue),
+			New: json.RawMessage(newValue),
+		}
+	}
+	return versions, rows.Err()
+}
+
+// GetInvoiceValuesAtVersion returns, for every field changed after version,
+// the value it had at that version (0 = as uploaded): the old value of its
+// first later change
+func GetInvoiceValuesAtVersion(ctx context.Context, clienteID, invoiceID string, version int) (map[string]json.RawMessage, error) {
+	if Pool == nil {
+		return nil, ErrNoDatabase
+	}
+
+	rows, err := Pool.Query(ctx, `
+		SELECT DISTINCT ON (campo) campo, COALESCE(old_value, 'null'::jsonb)::text
+		FROM invoice_field_audit
+		WHERE factura_id = $1::uuid AND cliente_id = $2::uuid AND version > $3
+		ORDER BY campo, version
+	`, invoiceID, clienteID, version)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := map[string]json.RawMessage{}
+	for rows.Next() {
+		var campo, value string
+		if err := rows.Scan(&campo, &value); err != nil {
+			return nil, err
+		}
+		values[campo] = json.RawMessage(value)
+	}
+	return values, rows.Err()
+}
+
+// RestoreInvoiceValues writes audited values (as returned by
+// GetInvoiceValuesAtVersion) into inv and returns the columns to update.
+// Fields that can no longer be updated are skipped.
+func RestoreInvoiceValues(inv *ClientInvoice, values map[string]json.RawMessage) ([]string, error) {
+	restore := map[string]json.RawMessage{}
+	columns := make([]string, 0, len(values))
+	for campo, value := range values {
+		if !IsUpdatableInvoiceColumn(campo) || auditExcluded[campo] {
+			continue
+		}
+		restore[campo] = value
+		columns = append(columns, campo)
+	}
+	data, err := json.Marshal(restore)
+	if err != nil {
+		return nil, err
+	}
+	// Columns and ClientInvoice JSON fields share their names
+	if err := json.Unmarshal(data, inv); err != nil {
+		return nil, err
+	}
+	sort.Strings(columns)
+	return columns, nil
+}
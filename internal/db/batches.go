@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// UploadBatch groups the processing jobs of one batch upload
+type UploadBatch struct {
+	ID        string      `json:"id"`
+	ClienteID string      `json:"cliente_id"`
+	CreatedBy string      `json:"created_by"`
+	Total     int         `json:"total"`
+	CreatedAt time.Time   `json:"created_at"`
+	Archivos  []BatchFile `json:"archivos"`
+}
+
+// BatchFile is the state of one file of a batch (one processing job)
+type BatchFile struct {
+	JobID            string     `json:"job_id"`
+	ArchivoNombre    string     `json:"archivo_nombre"`
+	JobStatus        string     `json:"job_status"`
+	HTTPStatus       *int       `json:"http_status,omitempty"`
+	Error            string     `json:"error,omitempty"`
+	FacturaID        *string    `json:"factura_id,omitempty"`
+	ExtractionStatus string     `json:"extraction_status,omitempty"`
+	FinishedAt       *time.Time `json:"finished_at,omitempty"`
+}
+
+// CreateUploadBatch stores the batch and its jobs in one transaction, so a
+// batch is never visible half-queued. Jobs keep the Status they are given:
+// files rejected before processing are stored as failed.
+func CreateUploadBatch(ctx context.Context, b *UploadBatch, jobs []*ProcessingJob) error {
+	if Pool == nil {
+		return ErrNoDatabase
+	}
+
+	tx, err := Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	b.Total = len(jobs)
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO upload_batches (cliente_id, created_by, total)
+		VALUES ($1::uuid, $2::uuid, $3)
+		RETURNING id, created_at
+	`, b.ClienteID, b.CreatedBy, b.Total).Scan(&b.ID, &b.CreatedAt); err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		job.ClienteID = b.ClienteID
+		job.BatchID = &b.ID
+		if job.Status == "" {
+			job.Status = JobQueued
+		}
+		if err := insertProcessingJob(ctx, tx, job); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// GetUploadBatch returns a batch with the state of its files if userID owns
+// the invoices or uploaded it, or (nil, nil)
+func GetUploadBatch(ctx context.Context, userID, batchID string) (*UploadBatch, error) {
+	if Pool == nil {
+		return nil, ErrNoDatabase
+	}
+
+	var b UploadBatch
+	err := Pool.QueryRow(ctx, `
+		SELECT id, cliente_id, created_by, total, created_at
+		FROM upload_batches
+		WHERE id = $1::uuid AND (cliente_id = $2::uuid OR created_by = $2::uuid)
+	`, batchID, userID).Scan(&b.ID, &b.ClienteID, &b.CreatedBy, &b.Total, &b.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := Pool.Query(ctx, `
+		SELECT id, COALESCE(archivo_nombre, ''), status, http_status, COALESCE(error, ''),
+		       factura_id::text, COALESCE(result->>'extraction_status', ''), finished_at
+		FROM processing_jobs
+		WHERE batch_id = $1::uuid
+		ORDER BY archivo_nombre, created_at
+	`, b.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	b.Archivos = []BatchFile{}
+	for rows.Next() {
+		var f BatchFile
+		if err := rows.Scan(&f.JobID, &f.ArchivoNombre, &f.JobStatus, &f.HTTPStatus, &f.Error,
+			&f.FacturaID, &f.ExtractionStatus, &f.FinishedAt); err != nil {
+			return nil, err
+		}
+		b.Archivos = append(b.Archivos, f)
+	}
+	return &b, rows.Err()
+}
+
+// GetClientOwnerID returns the owner (contador or empresa) of a cliente, or ""
+func GetClientOwnerID(ctx context.Context, clienteID string) (string, error) {
+	if Pool == nil {
+		return "", ErrNoDatabase
+	}
+	var owner string
+	err := Pool.QueryRow(ctx, "SELECT COALESCE(owner_id::text, '') FROM clientes WHERE id = $1::uuid", clienteID).Scan(&owner)
+	return owner, err
+}
@@ -3,8 +3,11 @@ package db
 import (
 	"context"
 	"errors"
+	"fmt"
 	"strings"
 	"time"
+
+	"github.com/jackc/pgx/v5"
 )
 
 var ErrNoDatabase = errors.New("database not available")
@@ -13,6 +16,7 @@ var ErrNoDatabase = errors.New("database not available")
 type ClientInvoice struct {
 	ID             string     `json:"id"`
 	ClienteID      string     `json:"cliente_id"`
+	EmpresaAlias   string     `json:"-"` // Tenant of the upload, written on insert only
 	EmpresaID      *string    `json:"empresa_id,omitempty"`
 	ArchivoURL     string     `json:"archivo_url,omitempty"`
 	ArchivoNombre  string     `json:"archivo_nombre,omitempty"`
@@ -75,6 +79,15 @@ type ClientInvoice struct {
 	ITBISAdelantar float64 `json:"itbis_adelantar"`
 	ITBISPercibido float64 `json:"itbis_percibido"`
 	ISRPercibido   float64 `json:"isr_percibido"`
+
+	// TipoFactura: "gastos" (606 - compras) o "ingresos" (607 - ventas)
+	TipoFactura string `json:"tipo_factura,omitempty"`
+
+	// Moneda original de la factura. Los montos de arriba están siempre en DOP;
+	// MontosOriginalesJSON guarda los montos en Moneda para auditoría.
+	Moneda               string  `json:"moneda,omitempty"`
+	TasaCambio           float64 `json:"tasa_cambio,omitempty"`
+	MontosOriginalesJSON string  `json:"montos_originales,omitempty"`
 }
 
 // ClientStats - Estadisticas para clientes
@@ -111,7 +124,9 @@ func GetClientInvoices(ctx context.Context, clienteID string, limit int) ([]Clie
 		       COALESCE(monto_servicios, 0), COALESCE(monto_bienes, 0),
 		       COALESCE(itbis_retenido_porcentaje, 0),
 		       COALESCE(aplica_606, false), COALESCE(periodo_606, ''), COALESCE(itbis_adelantar, 0),
-		       COALESCE(itbis_percibido, 0), COALESCE(isr_percibido, 0)
+		       COALESCE(itbis_percibido, 0), COALESCE(isr_percibido, 0),
+		       COALESCE(tipo_factura, 'gastos'),
+		       COALESCE(moneda, 'DOP'), COALESCE(tasa_cambio, 1), COALESCE(montos_originales::text, '')
 		FROM facturas_clientes
 		WHERE cliente_id = $1::uuid
 		ORDER BY created_at DESC
@@ -147,6 +162,8 @@ func GetClientInvoices(ctx context.Context, clienteID string, limit int) ([]Clie
 			&inv.ITBISRetenidoPorcentaje,
 			&inv.Aplica606, &inv.Periodo606, &inv.ITBISAdelantar,
 			&inv.ITBISPercibido, &inv.ISRPercibido,
+			&inv.TipoFactura,
+			&inv.Moneda, &inv.TasaCambio, &inv.MontosOriginalesJSON,
 		)
 		if err != nil {
 			return nil, err
@@ -189,7 +206,9 @@ func GetClientInvoicesPaginated(ctx context.Context, clienteID string, limit, of
 		       COALESCE(monto_servicios, 0), COALESCE(monto_bienes, 0),
 		       COALESCE(itbis_retenido_porcentaje, 0),
 		       COALESCE(aplica_606, false), COALESCE(periodo_606, ''), COALESCE(itbis_adelantar, 0),
-		       COALESCE(itbis_percibido, 0), COALESCE(isr_percibido, 0)
+		       COALESCE(itbis_percibido, 0), COALESCE(isr_percibido, 0),
+		       COALESCE(tipo_factura, 'gastos'),
+		       COALESCE(moneda, 'DOP'), COALESCE(tasa_cambio, 1), COALESCE(montos_originales::text, '')
 		FROM facturas_clientes
 		WHERE cliente_id = $1::uuid
 		ORDER BY created_at DESC
@@ -225,6 +244,8 @@ func GetClientInvoicesPaginated(ctx context.Context, clienteID string, limit, of
 			&inv.ITBISRetenidoPorcentaje,
 			&inv.Aplica606, &inv.Periodo606, &inv.ITBISAdelantar,
 			&inv.ITBISPercibido, &inv.ISRPercibido,
+			&inv.TipoFactura,
+			&inv.Moneda, &inv.TasaCambio, &inv.MontosOriginalesJSON,
 		)
 		if err != nil {
 			return nil, 0, err
@@ -296,7 +317,10 @@ func GetClientInvoiceByID(ctx context.Context, clienteID, invoiceID string) (*Cl
 	if Pool == nil {
 		return nil, ErrNoDatabase
 	}
+	return getClientInvoiceByID(ctx, Pool, clienteID, invoiceID)
+}
 
+func getClientInvoiceByID(ctx context.Context, q querier, clienteID, invoiceID string) (*ClientInvoice, error) {
 	query := `
 		SELECT id, cliente_id, empresa_id, COALESCE(archivo_url, ''), COALESCE(archivo_nombre, ''),
 		       COALESCE(archivo_size, 0), COALESCE(tipo_documento, ''), COALESCE(hora_factura, ''), fecha_documento,
@@ -316,13 +340,17 @@ func GetClientInvoiceByID(ctx context.Context, clienteID, invoiceID string) (*Cl
 		       COALESCE(monto_servicios, 0), COALESCE(monto_bienes, 0),
 		       COALESCE(itbis_retenido_porcentaje, 0),
 		       COALESCE(aplica_606, false), COALESCE(periodo_606, ''), COALESCE(itbis_adelantar, 0),
-		       COALESCE(itbis_percibido, 0), COALESCE(isr_percibido, 0)
+		       COALESCE(itbis_percibido, 0), COALESCE(isr_percibido, 0),
+		       COALESCE(tipo_factura, 'gastos'),
+		       COALESCE(moneda, 'DOP'), COALESCE(tasa_cambio, 1), COALESCE(montos_originales::text, ''),
+		       COALESCE(raw_ocr_json::text, ''), COALESCE(items_json::text, ''),
+		       COALESCE(extraction_status, ''), COALESCE(review_notes, '')
 		FROM facturas_clientes
 		WHERE cliente_id = $1::uuid AND id = $2::uuid
 	`
 
 	var inv ClientInvoice
-	err := Pool.QueryRow(ctx, query, clienteID, invoiceID).Scan(
+	err := q.QueryRow(ctx, query, clienteID, invoiceID).Scan(
 		&inv.ID, &inv.ClienteID, &inv.EmpresaID, &inv.ArchivoURL, &inv.ArchivoNombre,
 		&inv.ArchivoSize, &inv.TipoDocumento, &inv.HoraFactura, &inv.FechaDocumento,
 		&inv.Monto, &inv.NCF, &inv.Proveedor,
@@ -342,6 +370,10 @@ func GetClientInvoiceByID(ctx context.Context, clienteID, invoiceID string) (*Cl
 		&inv.ITBISRetenidoPorcentaje,
 		&inv.Aplica606, &inv.Periodo606, &inv.ITBISAdelantar,
 		&inv.ITBISPercibido, &inv.ISRPercibido,
+		&inv.TipoFactura,
+		&inv.Moneda, &inv.TasaCambio, &inv.MontosOriginalesJSON,
+		&inv.RawOCRJSON, &inv.ItemsJSON,
+		&inv.ExtractionStatus, &inv.ReviewNotes,
 	)
 	if err != nil {
 		return nil, err
@@ -350,12 +382,66 @@ func GetClientInvoiceByID(ctx context.Context, clienteID, invoiceID string) (*Cl
 	return &inv, nil
 }
 
+// GetClientInvoiceForOwner returns an invoice of any client of ownerID (the
+// contador), or of any client at all when ownerID is "" (admin). It returns
+// (nil, nil) when there is no such invoice.
+func GetClientInvoiceForOwner(ctx context.Context, ownerID, invoiceID string) (*ClientInvoice, error) {
+	if Pool == nil {
+		return nil, ErrNoDatabase
+	}
+	clienteID, err := ownedInvoiceClienteID(ctx, Pool, ownerID, invoiceID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return getClientInvoiceByID(ctx, Pool, clienteID, invoiceID)
+}
+
+func ownedInvoiceClienteID(ctx context.Context, q querier, ownerID, facturaID string) (string, error) {
+	var clienteID string
+	err := q.QueryRow(ctx, `
+		SELECT f.cliente_id::text
+		FROM facturas_clientes f
+		JOIN clientes c ON c.id = f.cliente_id
+		WHERE f.id = $1::uuid AND ($2::text = '' OR c.owner_id::text = $2)
+	`, facturaID, ownerID).Scan(&clienteID)
+	return clienteID, err
+}
+
 // SaveClientInvoice - Guardar factura escaneada por cliente en facturas_clientes
 func SaveClientInvoice(ctx context.Context, inv *ClientInvoice) error {
 	if Pool == nil {
 		return ErrNoDatabase
 	}
+	return insertClientInvoice(ctx, Pool, inv)
+}
+
+// SaveClientInvoiceWithOutbox saves inv and the outbox events built by events
+// (called once inv.ID is known) in one transaction, so side effects are never
+// lost nor announced for an invoice that was not stored
+func SaveClientInvoiceWithOutbox(ctx context.Context, inv *ClientInvoice, events func(*ClientInvoice) []OutboxEvent) error {
+	if Pool == nil {
+		return ErrNoDatabase
+	}
+
+	tx, err := Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := insertClientInvoice(ctx, tx, inv); err != nil {
+		return err
+	}
+	if err := insertOutboxEvents(ctx, tx, events, inv); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
 
+func insertClientInvoice(ctx context.Context, q querier, inv *ClientInvoice) error {
 	query := `
 		INSERT INTO facturas_clientes (
 			cliente_id, archivo_url, archivo_nombre, archivo_size,
@@ -371,7 +457,8 @@ func SaveClientInvoice(ctx context.Context, inv *ClientInvoice) error {
 			extraction_status, review_notes,
 			itbis_tasa, fecha_pago, ncf_modifica, tipo_id_emisor, tipo_id_receptor,
 			monto_servicios, monto_bienes, itbis_retenido_porcentaje,
-			itbis_percibido, isr_percibido
+			itbis_percibido, isr_percibido, tipo_factura,
+			moneda, tasa_cambio, montos_originales, empresa_alias
 		) VALUES (
 			$1::uuid, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11,
 			$12, $13, $14, $15, $16, $17, $18,
@@ -383,7 +470,8 @@ func SaveClientInvoice(ctx context.Context, inv *ClientInvoice) error {
 			$39, $40,
 			$41, $42, $43, $44, $45,
 			$46, $47, $48,
-			$49, $50
+			$49, $50, $51,
+			$52, $53, $54::jsonb, $55
 		)
 		RETURNING id, created_at
 	`
@@ -397,7 +485,14 @@ func SaveClientInvoice(ctx context.Context, inv *ClientInvoice) error {
 		itemsJSON = inv.ItemsJSON
 	}
 
-	err := Pool.QueryRow(ctx, query,
+	tipoFactura := inv.TipoFactura
+	if tipoFactura == "" {
+		tipoFactura = "gastos"
+	}
+
+	moneda, tasaCambio, montosOriginales := monedaArgs(inv)
+
+	err := q.QueryRow(ctx, query,
 		inv.ClienteID, inv.ArchivoURL, inv.ArchivoNombre, inv.ArchivoSize,
 		inv.TipoDocumento, inv.HoraFactura, inv.FechaDocumento, inv.Monto, inv.NCF, inv.Proveedor,
 		inv.Estado, inv.NotasCliente,
@@ -411,7 +506,8 @@ func SaveClientInvoice(ctx context.Context, inv *ClientInvoice) error {
 		inv.ExtractionStatus, inv.ReviewNotes,
 		inv.ITBISTasa, inv.FechaPago, inv.NCFModifica, inv.TipoIDEmisor, inv.TipoIDReceptor,
 		inv.MontoServicios, inv.MontoBienes, inv.ITBISRetenidoPorcentaje,
-		inv.ITBISPercibido, inv.ISRPercibido,
+		inv.ITBISPercibido, inv.ISRPercibido, tipoFactura,
+		moneda, tasaCambio, montosOriginales, inv.EmpresaAlias,
 	).Scan(&inv.ID, &inv.CreatedAt)
 
 	return err
@@ -520,6 +616,33 @@ func DeleteClientInvoice(ctx context.Context, clienteID, invoiceID string) error
 	return err
 }
 
+// DeleteClientInvoiceWithOutbox deletes the invoice and writes the events built
+// by events (from the row as it was before deleting) in one transaction.
+// Returns pgx.ErrNoRows if the invoice does not exist.
+func DeleteClientInvoiceWithOutbox(ctx context.Context, clienteID, invoiceID string, events func(*ClientInvoice) []OutboxEvent) error {
+	if Pool == nil {
+		return ErrNoDatabase
+	}
+
+	tx, err := Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	inv, err := getClientInvoiceByID(ctx, tx, clienteID, invoiceID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM facturas_clientes WHERE cliente_id = $1::uuid AND id = $2::uuid`, clienteID, invoiceID); err != nil {
+		return err
+	}
+	if err := insertOutboxEvents(ctx, tx, events, inv); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
 // Formato606Invoice holds the fields needed to generate DGII Formato 606 TXT
 type Formato606Invoice struct {
 	ID                    string
@@ -534,6 +657,7 @@ type Formato606Invoice struct {
 	MontoBienes           float64
 	Subtotal              float64
 	ITBIS                 float64
+	ITBISExento           float64
 	ITBISRetenido         float64
 	ITBISProporcionalidad float64
 	ITBISCosto            float64
@@ -549,6 +673,68 @@ type Formato606Invoice struct {
 	Proveedor             string
 }
 
+// enPesos keeps foreign-currency invoices whose conversion to DOP failed out
+// of the DGII reports: their amounts are still in the original currency.
+// The reports list them apart (see FacturaSinConvertir).
+const enPesos = `(COALESCE(moneda, 'DOP') = 'DOP' OR montos_originales IS NOT NULL)`
+
+// formato606Where selects the purchases of receptor $1 in periodo $2 (YYYYMM)
+const formato606Where = `REPLACE(COALESCE(receptor_rnc,''),'-','') = $1
+		  AND to_char(fecha_documento, 'YYYYMM') = $2
+		  AND (estado IS NULL OR estado NOT IN ('eliminada', 'anulada', 'rechazada'))
+		  AND aplica_606 = true
+		  AND COALESCE(tipo_factura, 'gastos') != 'ingresos'`
+
+// FacturaSinConvertir is a foreign-currency invoice that a DGII report leaves
+// out because its conversion to DOP failed. RNC is the counterpart: the
+// proveedor in the 606 and IR-17, the cliente in the 607.
+type FacturaSinConvertir struct {
+	ID             string     `json:"id"`
+	NCF            string     `json:"ncf"`
+	RNC            string     `json:"rnc"`
+	Moneda         string     `json:"moneda"`
+	Monto          float64    `json:"monto"`
+	FechaDocumento *time.Time `json:"fecha_documento"`
+}
+
+// getFacturasSinConvertir returns the invoices matching where (with the RNC
+// as $1 and the periodo as $2) that enPesos excludes. rncColumn is the
+// counterpart's RNC column.
+func getFacturasSinConvertir(ctx context.Context, where, rncColumn, rnc, periodo string) ([]FacturaSinConvertir, error) {
+	if Pool == nil {
+		return nil, ErrNoDatabase
+	}
+
+	rows, err := Pool.Query(ctx, `
+		SELECT id, COALESCE(ncf,''), COALESCE(`+rncColumn+`,''), COALESCE(moneda,''),
+		       COALESCE(monto,0), fecha_documento
+		FROM facturas_clientes
+		WHERE `+where+`
+		  AND NOT `+enPesos+`
+		ORDER BY fecha_documento, id
+	`, rnc, periodo)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	facturas := []FacturaSinConvertir{}
+	for rows.Next() {
+		var f FacturaSinConvertir
+		if err := rows.Scan(&f.ID, &f.NCF, &f.RNC, &f.Moneda, &f.Monto, &f.FechaDocumento); err != nil {
+			return nil, err
+		}
+		facturas = append(facturas, f)
+	}
+	return facturas, rows.Err()
+}
+
+// GetFormato606SinConvertir returns the purchases GetFormato606Invoices
+// leaves out because they were not converted to DOP
+func GetFormato606SinConvertir(ctx context.Context, rncReceptor, periodo string) ([]FacturaSinConvertir, error) {
+	return getFacturasSinConvertir(ctx, formato606Where, "emisor_rnc", rncReceptor, periodo)
+}
+
 // GetFormato606Invoices queries facturas_clientes for 606-eligible invoices
 func GetFormato606Invoices(ctx context.Context, rncReceptor, periodo string) ([]Formato606Invoice, error) {
 	if Pool == nil {
@@ -560,7 +746,7 @@ func GetFormato606Invoices(ctx context.Context, rncReceptor, periodo string) ([]
 		       COALESCE(ncf,''), COALESCE(ncf_modifica,''),
 		       fecha_documento, fecha_pago,
 		       COALESCE(monto_servicios,0), COALESCE(monto_bienes,0),
-		       COALESCE(subtotal,0), COALESCE(itbis,0), COALESCE(itbis_retenido,0),
+		       COALESCE(subtotal,0), COALESCE(itbis,0), COALESCE(itbis_exento,0), COALESCE(itbis_retenido,0),
 		       COALESCE(itbis_proporcionalidad,0), COALESCE(itbis_costo,0),
 		       COALESCE(itbis_percibido,0),
 		       retencion_isr_tipo, COALESCE(isr,0),
@@ -569,10 +755,8 @@ func GetFormato606Invoices(ctx context.Context, rncReceptor, periodo string) ([]
 		       COALESCE(forma_pago,''),
 		       COALESCE(proveedor,'')
 		FROM facturas_clientes
-		WHERE REPLACE(COALESCE(receptor_rnc,''),'-','') = $1
-		  AND to_char(fecha_documento, 'YYYYMM') = $2
-		  AND (estado IS NULL OR estado != 'eliminada')
-		  AND aplica_606 = true
+		WHERE `+formato606Where+`
+		  AND `+enPesos+`
 		ORDER BY fecha_documento, id
 	`, rncReceptor, periodo)
 	if err != nil {
@@ -588,7 +772,7 @@ func GetFormato606Invoices(ctx context.Context, rncReceptor, periodo string) ([]
 			&inv.NCF, &inv.NCFModifica,
 			&inv.FechaDocumento, &inv.FechaPago,
 			&inv.MontoServicios, &inv.MontoBienes,
-			&inv.Subtotal, &inv.ITBIS, &inv.ITBISRetenido,
+			&inv.Subtotal, &inv.ITBIS, &inv.ITBISExento, &inv.ITBISRetenido,
 			&inv.ITBISProporcionalidad, &inv.ITBISCosto,
 			&inv.ITBISPercibido,
 			&inv.RetencionISRTipo, &inv.ISR,
@@ -605,139 +789,421 @@ func GetFormato606Invoices(ctx context.Context, rncReceptor, periodo string) ([]
 	return invoices, nil
 }
 
-// InsertEnvio606 inserts a new entry into envios_606 and returns its id.
-// empresaID may be empty ("") if not known — pass nil in that case.
-func InsertEnvio606(ctx context.Context, empresaID, rnc, periodo, archivoTXT, archivoNombre string, cantRegistros int, totalMonto, totalITBIS, totalAdelantar float64) (string, error) {
-	if Pool == nil {
-		return "", ErrNoDatabase
-	}
-
-	var empresaIDArg interface{}
-	if empresaID != "" {
-		empresaIDArg = empresaID
-	}
+// enviosReferencia maps each DGII format to its envíos table and the columns
+// set along with the reference: a 606 accepted by DGII (completado) is
+// finalized as well.
+var enviosReferencia = map[string]struct{ tabla, extra string }{
+	"606": {"envios_606", `,
+		    finalizado = finalizado OR $2 = 'completado',
+		    finalizado_at = CASE WHEN $2 = 'completado' THEN COALESCE(finalizado_at, NOW()) ELSE finalizado_at END,
+		    updated_at = NOW()`},
+	"607": {"envios_607", ""},
+	"608": {"envios_608", ""},
+}
 
-	var id string
-	err := Pool.QueryRow(ctx, `
-		INSERT INTO envios_606 (empresa_id, rnc, periodo, archivo_txt, archivo_nombre,
-		                        cantidad_registros, total_monto_facturado, total_itbis_facturado,
-		                        total_itbis_por_adelantar, estado)
-		VALUES ($1::uuid, $2, $3, $4, $5, $6, $7, $8, $9, 'generado')
-		RETURNING id
-	`, empresaIDArg, rnc, periodo, archivoTXT, archivoNombre,
-		cantRegistros, totalMonto, totalITBIS, totalAdelantar).Scan(&id)
-	return id, err
-}
-
-// UpdateEnvio606Referencia updates the DGII reference and status for an envio.
-// estado must be one of: generado, enviado, completado, rechazado, anulado
-func UpdateEnvio606Referencia(ctx context.Context, envioID, referenciaDGII, estado string) error {
+// UpdateEnvioReferencia updates the DGII reference and status of an envío of
+// formato (606, 607 or 608).
+// estado must be one of: generado, enviado, completado, rechazado, anulado.
+func UpdateEnvioReferencia(ctx context.Context, formato, envioID, referenciaDGII, estado string) error {
 	if Pool == nil {
 		return ErrNoDatabase
 	}
+	t, ok := enviosReferencia[formato]
+	if !ok {
+		return fmt.Errorf("formato %s sin envíos", formato)
+	}
 	// Default to 'enviado' if caller sends an unrecognized value
 	validEstados := map[string]bool{"generado": true, "enviado": true, "completado": true, "rechazado": true, "anulado": true}
 	if !validEstados[estado] {
 		estado = "enviado"
 	}
 	_, err := Pool.Exec(ctx, `
-		UPDATE envios_606
+		UPDATE `+t.tabla+`
 		SET referencia_dgii = $1,
 		    estado = $2,
-		    fecha_envio = NOW()
+		    fecha_envio = NOW()`+t.extra+`
 		WHERE id = $3::uuid
 	`, referenciaDGII, estado, envioID)
 	return err
 }
 
 // ToggleAplica606 updates the aplica_606 flag on a specific factura
-func ToggleAplica606(ctx context.Context, clienteID, invoiceID string, aplica606 bool) error {
+func ToggleAplica606(ctx context.Context, clienteID, invoiceID string, aplica606 bool, actor AuditActor) error {
+	return UpdateClientInvoice(ctx, clienteID, invoiceID, &ClientInvoice{Aplica606: aplica606}, actor, "aplica_606")
+}
+
+// UpdateClientInvoice - Actualizar campos extraídos de una factura. Sin
+// columns actualiza todos los campos de extracción (reprocesamiento); con
+// columns (nombres de columna de facturas_clientes) solo esas, p. ej. una
+// corrección manual. Cada campo que cambia queda en la auditoría con actor.
+func UpdateClientInvoice(ctx context.Context, clienteID, invoiceID string, inv *ClientInvoice, actor AuditActor, columns ...string) error {
+	return UpdateClientInvoiceWithOutbox(ctx, clienteID, invoiceID, inv, actor, nil, columns...)
+}
+
+// UpdateClientInvoiceWithOutbox updates the invoice (see UpdateClientInvoice
+// for columns) and writes its audit and the events built by events (from the
+// updated row) in one transaction
+func UpdateClientInvoiceWithOutbox(ctx context.Context, clienteID, invoiceID string, inv *ClientInvoice, actor AuditActor, events func(*ClientInvoice) []OutboxEvent, columns ...string) error {
+	return updateClientInvoiceWithOutbox(ctx, clienteID, invoiceID, inv, actor, events, nil, columns...)
+}
+
+// updateClientInvoiceWithOutbox is UpdateClientInvoiceWithOutbox running check
+// first in the transaction, when not nil: an error from check aborts the update
+func updateClientInvoiceWithOutbox(ctx context.Context, clienteID, invoiceID string, inv *ClientInvoice, actor AuditActor, events func(*ClientInvoice) []OutboxEvent, check func(pgx.Tx) error, columns ...string) error {
 	if Pool == nil {
 		return ErrNoDatabase
 	}
-	_, err := Pool.Exec(ctx, `
-		UPDATE facturas_clientes
-		SET aplica_606 = $1
-		WHERE id = $2::uuid AND cliente_id = $3::uuid
-	`, aplica606, invoiceID, clienteID)
-	return err
+
+	tx, err := Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if check != nil {
+		if err := check(tx); err != nil {
+			return err
+		}
+	}
+	if err := updateClientInvoice(ctx, tx, clienteID, invoiceID, inv, actor, columns...); err != nil {
+		return err
+	}
+	if events != nil {
+		updated, err := getClientInvoiceByID(ctx, tx, clienteID, invoiceID)
+		if err != nil {
+			return err
+		}
+		if err := insertOutboxEvents(ctx, tx, events, updated); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
 }
 
-// UpdateClientInvoice - Actualizar campos extraídos de una factura (reprocesamiento)
-func UpdateClientInvoice(ctx context.Context, clienteID, invoiceID string, inv *ClientInvoice) error {
-	if Pool == nil {
-		return ErrNoDatabase
+// invoiceColumn is an updatable facturas_clientes column: expr is its SET
+// expression, with %s for the placeholder
+type invoiceColumn struct {
+	expr  string
+	value func(inv *ClientInvoice) interface{}
+}
+
+func column(value func(inv *ClientInvoice) interface{}) invoiceColumn {
+	return invoiceColumn{expr: "%s", value: value}
+}
+
+// nullableJSON stores empty JSON documents as NULL
+func nullableJSON(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+var invoiceColumns = map[string]invoiceColumn{
+	"ncf":                       column(func(inv *ClientInvoice) interface{} { return inv.NCF }),
+	"tipo_ncf":                  column(func(inv *ClientInvoice) interface{} { return inv.TipoNCF }),
+	"tipo_documento":            column(func(inv *ClientInvoice) interface{} { return inv.TipoDocumento }),
+	"emisor_rnc":                column(func(inv *ClientInvoice) interface{} { return inv.EmisorRNC }),
+	"proveedor":                 column(func(inv *ClientInvoice) interface{} { return inv.Proveedor }),
+	"receptor_nombre":           column(func(inv *ClientInvoice) interface{} { return inv.ReceptorNombre }),
+	"receptor_rnc":              column(func(inv *ClientInvoice) interface{} { return inv.ReceptorRNC }),
+	"fecha_documento":           column(func(inv *ClientInvoice) interface{} { return inv.FechaDocumento }),
+	"hora_factura":              column(func(inv *ClientInvoice) interface{} { return inv.HoraFactura }),
+	"monto":                     column(func(inv *ClientInvoice) interface{} { return inv.Monto }),
+	"subtotal":                  column(func(inv *ClientInvoice) interface{} { return inv.Subtotal }),
+	"descuento":                 column(func(inv *ClientInvoice) interface{} { return inv.Descuento }),
+	"itbis":                     column(func(inv *ClientInvoice) interface{} { return inv.ITBIS }),
+	"itbis_retenido":            column(func(inv *ClientInvoice) interface{} { return inv.ITBISRetenido }),
+	"itbis_exento":              column(func(inv *ClientInvoice) interface{} { return inv.ITBISExento }),
+	"itbis_proporcionalidad":    column(func(inv *ClientInvoice) interface{} { return inv.ITBISProporcionalidad }),
+	"itbis_costo":               column(func(inv *ClientInvoice) interface{} { return inv.ITBISCosto }),
+	"isr":                       column(func(inv *ClientInvoice) interface{} { return inv.ISR }),
+	"retencion_isr_tipo":        column(func(inv *ClientInvoice) interface{} { return inv.RetencionISRTipo }),
+	"isc":                       column(func(inv *ClientInvoice) interface{} { return inv.ISC }),
+	"isc_categoria":             column(func(inv *ClientInvoice) interface{} { return inv.ISCCategoria }),
+	"cdt_monto":                 column(func(inv *ClientInvoice) interface{} { return inv.CDTMonto }),
+	"cargo_911":                 column(func(inv *ClientInvoice) interface{} { return inv.Cargo911 }),
+	"propina":                   column(func(inv *ClientInvoice) interface{} { return inv.Propina }),
+	"otros_impuestos":           column(func(inv *ClientInvoice) interface{} { return inv.OtrosImpuestos }),
+	"monto_no_facturable":       column(func(inv *ClientInvoice) interface{} { return inv.MontoNoFacturable }),
+	"forma_pago":                column(func(inv *ClientInvoice) interface{} { return inv.FormaPago }),
+	"tipo_bien_servicio":        column(func(inv *ClientInvoice) interface{} { return inv.TipoBienServicio }),
+	"confidence_score":          column(func(inv *ClientInvoice) interface{} { return inv.ConfidenceScore }),
+	"raw_ocr_json":              {"%s::jsonb", func(inv *ClientInvoice) interface{} { return nullableJSON(inv.RawOCRJSON) }},
+	"items_json":                {"%s::jsonb", func(inv *ClientInvoice) interface{} { return nullableJSON(inv.ItemsJSON) }},
+	"extraction_status":         column(func(inv *ClientInvoice) interface{} { return inv.ExtractionStatus }),
+	"review_notes":              column(func(inv *ClientInvoice) interface{} { return inv.ReviewNotes }),
+	"estado":                    column(func(inv *ClientInvoice) interface{} { return inv.Estado }),
+	"notas_contador":            column(func(inv *ClientInvoice) interface{} { return inv.NotasContador }),
+	"aplica_606":                column(func(inv *ClientInvoice) interface{} { return inv.Aplica606 }),
+	"itbis_tasa":                column(func(inv *ClientInvoice) interface{} { return inv.ITBISTasa }),
+	"fecha_pago":                column(func(inv *ClientInvoice) interface{} { return inv.FechaPago }),
+	"ncf_modifica":              column(func(inv *ClientInvoice) interface{} { return inv.NCFModifica }),
+	"tipo_id_emisor":            column(func(inv *ClientInvoice) interface{} { return inv.TipoIDEmisor }),
+	"tipo_id_receptor":          column(func(inv *ClientInvoice) interface{} { return inv.TipoIDReceptor }),
+	"monto_servicios":           column(func(inv *ClientInvoice) interface{} { return inv.MontoServicios }),
+	"monto_bienes":              column(func(inv *ClientInvoice) interface{} { return inv.MontoBienes }),
+	"itbis_retenido_porcentaje": column(func(inv *ClientInvoice) interface{} { return inv.ITBISRetenidoPorcentaje }),
+	"tipo_factura":              {"COALESCE(NULLIF(%s, ''), tipo_factura)", func(inv *ClientInvoice) interface{} { return inv.TipoFactura }},
+	"moneda": column(func(inv *ClientInvoice) interface{} {
+		moneda, _, _ := monedaArgs(inv)
+		return moneda
+	}),
+	"tasa_cambio": column(func(inv *ClientInvoice) interface{} {
+		_, tasa, _ := monedaArgs(inv)
+		return tasa
+	}),
+	"montos_originales": {"%s::jsonb", func(inv *ClientInvoice) interface{} {
+		_, _, originales := monedaArgs(inv)
+		return originales
+	}},
+}
+
+// extractionColumns are the columns a full update (reprocessing) writes
+var extractionColumns = []string{
+	"ncf", "tipo_ncf", "emisor_rnc", "proveedor", "receptor_nombre", "receptor_rnc",
+	"fecha_documento", "monto", "subtotal", "descuento",
+	"itbis", "itbis_retenido", "itbis_exento", "itbis_proporcionalidad", "itbis_costo",
+	"isr", "retencion_isr_tipo", "isc", "isc_categoria",
+	"cdt_monto", "cargo_911", "propina", "otros_impuestos", "monto_no_facturable",
+	"forma_pago", "tipo_bien_servicio",
+	"confidence_score", "raw_ocr_json", "items_json",
+	"extraction_status", "review_notes", "estado",
+	"itbis_tasa", "fecha_pago", "ncf_modifica", "tipo_id_emisor", "tipo_id_receptor",
+	"monto_servicios", "monto_bienes", "itbis_retenido_porcentaje",
+	"tipo_factura", "moneda", "tasa_cambio", "montos_originales",
+}
+
+// IsUpdatableInvoiceColumn reports whether UpdateClientInvoice can write name
+func IsUpdatableInvoiceColumn(name string) bool {
+	_, ok := invoiceColumns[name]
+	return ok
+}
+
+// updateClientInvoice writes columns of inv and audits the ones that changed.
+// It must run in a transaction: the row is locked while old values are read.
+func updateClientInvoice(ctx context.Context, q querier, clienteID, invoiceID string, inv *ClientInvoice, actor AuditActor, columns ...string) error {
+	if len(columns) == 0 {
+		columns = extractionColumns
 	}
 
-	query := `
-		UPDATE facturas_clientes SET
-			ncf = $3,
-			tipo_ncf = $4,
-			emisor_rnc = $5,
-			proveedor = $6,
-			receptor_nombre = $7,
-			receptor_rnc = $8,
-			fecha_documento = $9,
-			monto = $10,
-			subtotal = $11,
-			descuento = $12,
-			itbis = $13,
-			itbis_retenido = $14,
-			itbis_exento = $15,
-			itbis_proporcionalidad = $16,
-			itbis_costo = $17,
-			isr = $18,
-			retencion_isr_tipo = $19,
-			isc = $20,
-			isc_categoria = $21,
-			cdt_monto = $22,
-			cargo_911 = $23,
-			propina = $24,
-			otros_impuestos = $25,
-			monto_no_facturable = $26,
-			forma_pago = $27,
-			tipo_bien_servicio = $28,
-			confidence_score = $29,
-			raw_ocr_json = $30::jsonb,
-			items_json = $31::jsonb,
-			extraction_status = $32,
-			review_notes = $33,
-			estado = $34,
-			itbis_tasa = $35,
-			fecha_pago = $36,
-			ncf_modifica = $37,
-			tipo_id_emisor = $38,
-			tipo_id_receptor = $39,
-			monto_servicios = $40,
-			monto_bienes = $41,
-			itbis_retenido_porcentaje = $42
+	sets := make([]string, 0, len(columns))
+	args := []interface{}{clienteID, invoiceID}
+	seen := make(map[string]bool, len(columns))
+	written := make([]string, 0, len(columns))
+	for _, name := range columns {
+		col, ok := invoiceColumns[name]
+		if !ok {
+			return fmt.Errorf("columna no actualizable: %s", name)
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		written = append(written, name)
+		args = append(args, col.value(inv))
+		sets = append(sets, name+" = "+fmt.Sprintf(col.expr, fmt.Sprintf("$%d", len(args))))
+	}
+
+	var locked int
+	if err := q.QueryRow(ctx, `
+		SELECT 1 FROM facturas_clientes WHERE cliente_id = $1::uuid AND id = $2::uuid FOR UPDATE
+	`, clienteID, invoiceID).Scan(&locked); err != nil {
+		return err
+	}
+	old, err := getClientInvoiceByID(ctx, q, clienteID, invoiceID)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf(`
+		UPDATE facturas_clientes SET %s
 		WHERE cliente_id = $1::uuid AND id = $2::uuid
-	`
+	`, strings.Join(sets, ", "))
 
-	// Handle nullable JSONB
-	var rawJSON, itemsJSON interface{}
-	if inv.RawOCRJSON != "" {
-		rawJSON = inv.RawOCRJSON
+	if _, err := q.Exec(ctx, query, args...); err != nil {
+		return err
 	}
-	if inv.ItemsJSON != "" {
-		itemsJSON = inv.ItemsJSON
+	return recordInvoiceAudit(ctx, q, clienteID, invoiceID, actor, old, inv, written)
+}
+
+// monedaArgs returns the moneda/tasa_cambio/montos_originales query args,
+// defaulting to DOP at rate 1 with no original amounts
+func monedaArgs(inv *ClientInvoice) (string, float64, interface{}) {
+	moneda := strings.ToUpper(inv.Moneda)
+	if moneda == "" {
+		moneda = "DOP"
+	}
+	tasa := inv.TasaCambio
+	if tasa == 0 {
+		tasa = 1
 	}
+	var originales interface{}
+	if inv.MontosOriginalesJSON != "" {
+		originales = inv.MontosOriginalesJSON
+	}
+	return moneda, tasa, originales
+}
 
-	_, err := Pool.Exec(ctx, query,
-		clienteID, invoiceID,
-		inv.NCF, inv.TipoNCF, inv.EmisorRNC, inv.Proveedor,
-		inv.ReceptorNombre, inv.ReceptorRNC, inv.FechaDocumento, inv.Monto,
-		inv.Subtotal, inv.Descuento,
-		inv.ITBIS, inv.ITBISRetenido, inv.ITBISExento, inv.ITBISProporcionalidad, inv.ITBISCosto,
-		inv.ISR, inv.RetencionISRTipo,
-		inv.ISC, inv.ISCCategoria,
-		inv.CDTMonto, inv.Cargo911, inv.Propina, inv.OtrosImpuestos, inv.MontoNoFacturable,
-		inv.FormaPago, inv.TipoBienServicio,
-		inv.ConfidenceScore, rawJSON, itemsJSON,
-		inv.ExtractionStatus, inv.ReviewNotes, inv.Estado,
-		inv.ITBISTasa, inv.FechaPago, inv.NCFModifica, inv.TipoIDEmisor, inv.TipoIDReceptor,
-		inv.MontoServicios, inv.MontoBienes, inv.ITBISRetenidoPorcentaje,
-	)
+// Envio606Resumen holds the stored totals of a generated 606 envío
+type Envio606Resumen struct {
+	ID                     string    `json:"id"`
+	CantidadRegistros      int       `json:"cantidad_registros"`
+	TotalMontoFacturado    float64   `json:"total_monto_facturado"`
+	TotalITBISFacturado    float64   `json:"total_itbis_facturado"`
+	TotalITBISPorAdelantar float64   `json:"total_itbis_por_adelantar"`
+	Estado                 string    `json:"estado"`
+	CreatedAt              time.Time `json:"created_at"`
+}
+
+// GetLatestEnvio606 returns the most recent envios_606 row for rnc+periodo,
+// or (nil, nil) if the 606 was never generated
+func GetLatestEnvio606(ctx context.Context, rnc, periodo string) (*Envio606Resumen, error) {
+	if Pool == nil {
+		return nil, ErrNoDatabase
+	}
+
+	var e Envio606Resumen
+	err := Pool.QueryRow(ctx, `
+		SELECT id, COALESCE(cantidad_registros,0), COALESCE(total_monto_facturado,0),
+		       COALESCE(total_itbis_facturado,0), COALESCE(total_itbis_por_adelantar,0),
+		       COALESCE(estado,''), created_at
+		FROM envios_606
+		WHERE rnc = $1 AND periodo = $2
+		ORDER BY version DESC
+		LIMIT 1
+	`, rnc, periodo).Scan(&e.ID, &e.CantidadRegistros, &e.TotalMontoFacturado,
+		&e.TotalITBISFacturado, &e.TotalITBISPorAdelantar, &e.Estado, &e.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// RevisionManualPendiente is an invoice saved without extraction (all AI
+// providers failed) that is due for an automatic retry
+type RevisionManualPendiente struct {
+	ID            string
+	ClienteID     string
+	EmpresaAlias  string // Empresa of the upload: its PII policy and prompt apply
+	ArchivoURL    string
+	RetryAttempts int
+	AuditVersion  int // Audit version when claimed: a later one means someone edited the invoice
+}
+
+// ErrRetrySuperseded is returned by SaveRevisionManualRetry when the invoice
+// left revision_manual or was edited after the retry claimed it
+var ErrRetrySuperseded = errors.New("la factura cambió durante el reintento")
+
+// ClaimRevisionManualRetries picks up to limit revision_manual invoices whose
+// retry is due, that used fewer than maxAttempts and that no reviewer holds
+// (a review claim younger than reviewLease), counts the attempt and pushes
+// next_retry_at forward by lease so no other worker takes them meanwhile
+func ClaimRevisionManualRetries(ctx context.Context, maxAttempts, limit int, lease, reviewLease time.Duration) ([]RevisionManualPendiente, error) {
+	if Pool == nil {
+		return nil, ErrNoDatabase
+	}
+
+	rows, err := Pool.Query(ctx, `
+		UPDATE facturas_clientes
+		SET retry_attempts = retry_attempts + 1,
+		    next_retry_at = NOW() + $3 * INTERVAL '1 second'
+		WHERE id IN (
+			SELECT id FROM facturas_clientes
+			WHERE extraction_status = 'revision_manual'
+			  AND (estado IS NULL OR estado NOT IN ('eliminada', 'anulada', 'rechazada'))
+			  AND retry_attempts < $1
+			  AND (next_retry_at IS NULL OR next_retry_at <= NOW())
+			  AND COALESCE(archivo_url, '') <> ''
+			  AND NOT `+reviewClaimLive("$4")+`
+			ORDER BY created_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, cliente_id, empresa_alias, archivo_url, retry_attempts,
+		          (SELECT COALESCE(MAX(a.version), 0) FROM invoice_field_audit a WHERE a.factura_id = facturas_clientes.id)
+	`, maxAttempts, limit, int(lease.Seconds()), int(reviewLease.Seconds()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
+	var pendientes []RevisionManualPendiente
+	for rows.Next() {
+		var p RevisionManualPendiente
+		if err := rows.Scan(&p.ID, &p.ClienteID, &p.EmpresaAlias, &p.ArchivoURL, &p.RetryAttempts, &p.AuditVersion); err != nil {
+			return nil, err
+		}
+		pendientes = append(pendientes, p)
+	}
+	return pendientes, rows.Err()
+}
+
+// reviewClaimLive returns the condition of a row a reviewer holds, for the
+// review lease in seconds in placeholder lease
+func reviewClaimLive(lease string) string {
+	return `COALESCE(review_claimed_by IS NOT NULL AND review_claimed_at > NOW() - ` + lease + ` * INTERVAL '1 second', false)`
+}
+
+// SaveRevisionManualRetry stores the result of a revision_manual retry (see
+// UpdateClientInvoiceWithOutbox) only if the invoice is still as claimed:
+// in revision_manual, not voided, not held by a reviewer and not edited since
+// the claim. Returns ErrRetrySuperseded or ErrReviewClaimed otherwise, and
+// writes nothing.
+func SaveRevisionManualRetry(ctx context.Context, p RevisionManualPendiente, inv *ClientInvoice, reviewLease time.Duration, actor AuditActor, events func(*ClientInvoice) []OutboxEvent) error {
+	return updateClientInvoiceWithOutbox(ctx, p.ClienteID, p.ID, inv, actor, events, func(tx pgx.Tx) error {
+		var pendiente, claimed bool
+		var auditVersion int
+		err := tx.QueryRow(ctx, `
+			SELECT extraction_status = 'revision_manual'
+			           AND (estado IS NULL OR estado NOT IN ('eliminada', 'anulada', 'rechazada')),
+			       `+reviewClaimLive("$3")+`,
+			       (SELECT COALESCE(MAX(a.version), 0) FROM invoice_field_audit a WHERE a.factura_id = f.id)
+			FROM facturas_clientes f
+			WHERE cliente_id = $1::uuid AND id = $2::uuid
+			FOR UPDATE OF f
+		`, p.ClienteID, p.ID, int(reviewLease.Seconds())).Scan(&pendiente, &claimed, &auditVersion)
+		switch {
+		case err != nil:
+			return err
+		case !pendiente || auditVersion != p.AuditVersion:
+			return ErrRetrySuperseded
+		case claimed:
+			return ErrReviewClaimed
+		}
+		return nil
+	})
+}
+
+// DeferRevisionManualRetry moves the next retry of a claimed invoice to
+// nextRetryAt without counting the attempt, for failures that are not the
+// extraction's (quota, a reviewer holding the invoice)
+func DeferRevisionManualRetry(ctx context.Context, invoiceID string, nextRetryAt time.Time, lastError string) error {
+	if Pool == nil {
+		return ErrNoDatabase
+	}
+	_, err := Pool.Exec(ctx, `
+		UPDATE facturas_clientes
+		SET retry_attempts = GREATEST(retry_attempts - 1, 0), next_retry_at = $2, last_retry_error = $3
+		WHERE id = $1::uuid
+	`, invoiceID, nextRetryAt, lastError)
+	return err
+}
+
+// ScheduleRevisionManualRetry records a failed retry. A nil nextRetryAt means
+// no more automatic attempts (next_retry_at = infinity): the invoice is left
+// for manual review.
+func ScheduleRevisionManualRetry(ctx context.Context, invoiceID string, nextRetryAt *time.Time, lastError string) error {
+	if Pool == nil {
+		return ErrNoDatabase
+	}
+	_, err := Pool.Exec(ctx, `
+		UPDATE facturas_clientes
+		SET next_retry_at = COALESCE($2, 'infinity'::timestamptz), last_retry_error = $3
+		WHERE id = $1::uuid
+	`, invoiceID, nextRetryAt, lastError)
 	return err
 }
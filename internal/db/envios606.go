@@ -0,0 +1,371 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	// ErrPeriodoFinalizado is returned when the 606 of a period was finalized and
+	// changes require a rectificativa
+	ErrPeriodoFinalizado = errors.New("periodo 606 finalizado")
+	// ErrEnvioNoFinalizado is returned when rectifying a period whose current
+	// envío is still a draft
+	ErrEnvioNoFinalizado = errors.New("el envío 606 vigente no está finalizado")
+)
+
+// Envio606 is one version of the Formato 606 for an RNC + periodo.
+// Lineas maps factura id → TXT line and is the snapshot used for diffs.
+type Envio606 struct {
+	ID                     string            `json:"id"`
+	RNC                    string            `json:"rnc"`
+	Periodo                string            `json:"periodo"`
+	Version                int               `json:"version"`
+	TipoEnvio              string            `json:"tipo_envio"` // original | rectificativa
+	RectificaEnvioID       *string           `json:"rectifica_envio_id,omitempty"`
+	ArchivoTXT             string            `json:"-"`
+	ArchivoNombre          string            `json:"archivo_nombre"`
+	CantidadRegistros      int               `json:"cantidad_registros"`
+	TotalMontoFacturado    float64           `json:"total_monto_facturado"`
+	TotalITBISFacturado    float64           `json:"total_itbis_facturado"`
+	TotalITBISPorAdelantar float64           `json:"total_itbis_por_adelantar"`
+	Estado                 string            `json:"estado"`
+	ReferenciaDGII         string            `json:"referencia_dgii,omitempty"`
+	FechaEnvio             *time.Time        `json:"fecha_envio,omitempty"`
+	Finalizado             bool              `json:"finalizado"`
+	FinalizadoAt           *time.Time        `json:"finalizado_at,omitempty"`
+	Lineas                 map[string]string `json:"-"`
+	CreatedAt              time.Time         `json:"created_at"`
+	UpdatedAt              time.Time         `json:"updated_at"`
+}
+
+const envio606Columns = `
+	id, rnc, periodo, version, tipo_envio, rectifica_envio_id::text,
+	archivo_txt, archivo_nombre, COALESCE(cantidad_registros,0),
+	COALESCE(total_monto_facturado,0), COALESCE(total_itbis_facturado,0),
+	COALESCE(total_itbis_por_adelantar,0),
+	COALESCE(estado,''), COALESCE(referencia_dgii,''), fecha_envio,
+	finalizado, finalizado_at, lineas, created_at, updated_at`
+
+func scanEnvio606(row pgx.Row) (*Envio606, error) {
+	var e Envio606
+	var lineas []byte
+	err := row.Scan(
+		&e.ID, &e.RNC, &e.Periodo, &e.Version, &e.TipoEnvio, &e.RectificaEnvioID,
+		&e.ArchivoTXT, &e.ArchivoNombre, &e.CantidadRegistros,
+		&e.TotalMontoFacturado, &e.TotalITBISFacturado,
+		&e.TotalITBISPorAdelantar,
+		&e.Estado, &e.ReferenciaDGII, &e.FechaEnvio,
+		&e.Finalizado, &e.FinalizadoAt, &lineas, &e.CreatedAt, &e.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	e.Lineas = map[string]string{}
+	if len(lineas) > 0 {
+		if err := json.Unmarshal(lineas, &e.Lineas); err != nil {
+			return nil, err
+		}
+	}
+	return &e, nil
+}
+
+// SinLineas reports whether the version has no per-invoice line snapshot:
+// envíos stored before versioning were migrated with empty lineas
+func (e *Envio606) SinLineas() bool {
+	return len(e.Lineas) == 0 && e.CantidadRegistros > 0
+}
+
+// getEnvio606 runs a single-row envios_606 query; (nil, nil) when there is no row
+func getEnvio606(q pgx.Row) (*Envio606, error) {
+	e, err := scanEnvio606(q)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return e, err
+}
+
+// GetCurrentEnvio606 returns the highest version for rnc+periodo, or (nil, nil)
+func GetCurrentEnvio606(ctx context.Context, rnc, periodo string) (*Envio606, error) {
+	if Pool == nil {
+		return nil, ErrNoDatabase
+	}
+	return getEnvio606(Pool.QueryRow(ctx, `
+		SELECT `+envio606Columns+`
+		FROM envios_606
+		WHERE rnc = $1 AND periodo = $2
+		ORDER BY version DESC
+		LIMIT 1
+	`, rnc, periodo))
+}
+
+// GetLastCompletadoEnvio606 returns the latest envío accepted by DGII
+// (estado 'completado') for rnc+periodo, or (nil, nil)
+func GetLastCompletadoEnvio606(ctx context.Context, rnc, periodo string) (*Envio606, error) {
+	if Pool == nil {
+		return nil, ErrNoDatabase
+	}
+	return getEnvio606(Pool.QueryRow(ctx, `
+		SELECT `+envio606Columns+`
+		FROM envios_606
+		WHERE rnc = $1 AND periodo = $2 AND estado = 'completado'
+		ORDER BY version DESC
+		LIMIT 1
+	`, rnc, periodo))
+}
+
+// GetEnvios606 lists every version for rnc+periodo, newest first
+func GetEnvios606(ctx context.Context, rnc, periodo string) ([]Envio606, error) {
+	if Pool == nil {
+		return nil, ErrNoDatabase
+	}
+
+	rows, err := Pool.Query(ctx, `
+		SELECT `+envio606Columns+`
+		FROM envios_606
+		WHERE rnc = $1 AND periodo = $2
+		ORDER BY version DESC
+	`, rnc, periodo)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	envios := []Envio606{}
+	for rows.Next() {
+		e, err := scanEnvio606(rows)
+		if err != nil {
+			return nil, err
+		}
+		envios = append(envios, *e)
+	}
+	return envios, rows.Err()
+}
+
+// Bloqueado606 reports whether versions of one period lock its invoices: some
+// version was finalized and no rectificativa draft was opened after the last
+// finalized one. A newer draft that is not a rectificativa (a legacy row, or a
+// draft generated after the filing) does not reopen the period.
+func Bloqueado606(versiones []Envio606) bool {
+	ultimoFinal := ultimoFinalizado606(versiones)
+	if ultimoFinal == nil {
+		return false
+	}
+	for _, v := range versiones {
+		if v.Version > ultimoFinal.Version && !v.Finalizado && v.TipoEnvio == "rectificativa" {
+			return false
+		}
+	}
+	return true
+}
+
+// ultimoFinalizado606 returns the highest finalized version, or nil
+func ultimoFinalizado606(versiones []Envio606) *Envio606 {
+	var ultimo *Envio606
+	for i := range versiones {
+		if versiones[i].Finalizado && (ultimo == nil || versiones[i].Version > ultimo.Version) {
+			ultimo = &versiones[i]
+		}
+	}
+	return ultimo
+}
+
+// versiones606 loads the lock state of every version of rnc+periodo, highest
+// first: id, version, tipo_envio, estado and finalizado. suffix is appended
+// to the query, e.g. FOR UPDATE.
+func versiones606(ctx context.Context, q interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}, rnc, periodo, suffix string) ([]Envio606, error) {
+	rows, err := q.Query(ctx, `
+		SELECT id, version, tipo_envio, COALESCE(estado, 'generado'), finalizado
+		FROM envios_606
+		WHERE rnc = $1 AND periodo = $2
+		ORDER BY version DESC
+	`+suffix, rnc, periodo)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versiones []Envio606
+	for rows.Next() {
+		var v Envio606
+		if err := rows.Scan(&v.ID, &v.Version, &v.TipoEnvio, &v.Estado, &v.Finalizado); err != nil {
+			return nil, err
+		}
+		versiones = append(versiones, v)
+	}
+	return versiones, rows.Err()
+}
+
+// IsPeriodo606Finalizado reports whether the invoices of rnc+periodo are locked
+// by a finalized 606 version until a rectificativa is opened (see Bloqueado606)
+func IsPeriodo606Finalizado(ctx context.Context, rnc, periodo string) (bool, error) {
+	if Pool == nil {
+		return false, ErrNoDatabase
+	}
+	versiones, err := versiones606(ctx, Pool, rnc, periodo, "")
+	if err != nil {
+		return false, err
+	}
+	return Bloqueado606(versiones), nil
+}
+
+// SaveEnvio606Borrador stores the generated 606 as the current draft of
+// rnc+periodo: the first generation inserts version 1, later generations
+// overwrite the open draft instead of piling up rows. A version already sent
+// to DGII (estado other than 'generado') is kept as filed: the new draft is
+// the next version. Returns ErrPeriodoFinalizado when the period is locked
+// (see Bloqueado606). e is filled with the stored row.
+func SaveEnvio606Borrador(ctx context.Context, empresaID string, e *Envio606) error {
+	if Pool == nil {
+		return ErrNoDatabase
+	}
+
+	lineas, err := json.Marshal(e.Lineas)
+	if err != nil {
+		return err
+	}
+
+	tx, err := Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	versiones, err := versiones606(ctx, tx, e.RNC, e.Periodo, "FOR UPDATE")
+	if err != nil {
+		return err
+	}
+	if Bloqueado606(versiones) {
+		return ErrPeriodoFinalizado
+	}
+
+	var row pgx.Row
+	switch {
+	case len(versiones) > 0 && !versiones[0].Finalizado && versiones[0].Estado == "generado":
+		row = tx.QueryRow(ctx, `
+			UPDATE envios_606
+			SET archivo_txt = $2,
+			    archivo_nombre = $3,
+			    cantidad_registros = $4,
+			    total_monto_facturado = $5,
+			    total_itbis_facturado = $6,
+			    total_itbis_por_adelantar = $7,
+			    lineas = $8::jsonb,
+			    updated_at = NOW()
+			WHERE id = $1::uuid
+			RETURNING `+envio606Columns,
+			versiones[0].ID, e.ArchivoTXT, e.ArchivoNombre, e.CantidadRegistros,
+			e.TotalMontoFacturado, e.TotalITBISFacturado, e.TotalITBISPorAdelantar, string(lineas))
+	default:
+		// No envío yet (version 0), or the current one was sent
+		version := 0
+		if len(versiones) > 0 {
+			version = versiones[0].Version
+		}
+		var empresaIDArg interface{}
+		if empresaID != "" {
+			empresaIDArg = empresaID
+		}
+		row = tx.QueryRow(ctx, `
+			INSERT INTO envios_606 (empresa_id, rnc, periodo, version, tipo_envio,
+			                        archivo_txt, archivo_nombre, cantidad_registros,
+			                        total_monto_facturado, total_itbis_facturado,
+			                        total_itbis_por_adelantar, lineas, estado)
+			VALUES ($1::uuid, $2, $3, $4, 'original', $5, $6, $7, $8, $9, $10, $11::jsonb, 'generado')
+			RETURNING `+envio606Columns,
+			empresaIDArg, e.RNC, e.Periodo, version+1, e.ArchivoTXT, e.ArchivoNombre, e.CantidadRegistros,
+			e.TotalMontoFacturado, e.TotalITBISFacturado, e.TotalITBISPorAdelantar, string(lineas))
+	}
+
+	saved, err := scanEnvio606(row)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	*e = *saved
+	return nil
+}
+
+// FinalizarEnvio606 marks an envío as final, locking the invoices of its period.
+// Only the current version of a period can be finalized; returns pgx.ErrNoRows
+// otherwise.
+func FinalizarEnvio606(ctx context.Context, envioID string) (*Envio606, error) {
+	if Pool == nil {
+		return nil, ErrNoDatabase
+	}
+	e, err := scanEnvio606(Pool.QueryRow(ctx, `
+		UPDATE envios_606 e
+		SET finalizado = true,
+		    finalizado_at = COALESCE(finalizado_at, NOW()),
+		    updated_at = NOW()
+		WHERE e.id = $1::uuid
+		  AND e.version = (SELECT MAX(version) FROM envios_606 x WHERE x.rnc = e.rnc AND x.periodo = e.periodo)
+		RETURNING `+envio606Columns,
+		envioID))
+	return e, err
+}
+
+// RectificarEnvio606 opens a rectificativa for rnc+periodo: a new draft version
+// pointing at the last finalized one, which it replaces. Returns
+// ErrEnvioNoFinalizado when no version was finalized or a rectificativa is
+// already open, and pgx.ErrNoRows when the period has no envío yet. e is
+// filled with the stored row.
+func RectificarEnvio606(ctx context.Context, empresaID string, e *Envio606) error {
+	if Pool == nil {
+		return ErrNoDatabase
+	}
+
+	lineas, err := json.Marshal(e.Lineas)
+	if err != nil {
+		return err
+	}
+
+	tx, err := Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	versiones, err := versiones606(ctx, tx, e.RNC, e.Periodo, "FOR UPDATE")
+	if err != nil {
+		return err
+	}
+	if len(versiones) == 0 {
+		return pgx.ErrNoRows
+	}
+	if !Bloqueado606(versiones) {
+		return ErrEnvioNoFinalizado
+	}
+	rectificado := ultimoFinalizado606(versiones)
+
+	var empresaIDArg interface{}
+	if empresaID != "" {
+		empresaIDArg = empresaID
+	}
+	saved, err := scanEnvio606(tx.QueryRow(ctx, `
+		INSERT INTO envios_606 (empresa_id, rnc, periodo, version, tipo_envio, rectifica_envio_id,
+		                        archivo_txt, archivo_nombre, cantidad_registros,
+		                        total_monto_facturado, total_itbis_facturado,
+		                        total_itbis_por_adelantar, lineas, estado)
+		VALUES ($1::uuid, $2, $3, $4, 'rectificativa', $5::uuid, $6, $7, $8, $9, $10, $11, $12::jsonb, 'generado')
+		RETURNING `+envio606Columns,
+		empresaIDArg, e.RNC, e.Periodo, versiones[0].Version+1, rectificado.ID,
+		e.ArchivoTXT, e.ArchivoNombre, e.CantidadRegistros,
+		e.TotalMontoFacturado, e.TotalITBISFacturado, e.TotalITBISPorAdelantar, string(lineas)))
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	*e = *saved
+	return nil
+}
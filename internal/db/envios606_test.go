@@ -0,0 +1,40 @@
+package db
+
+import "testing"
+
+func TestBloqueado606(t *testing.T) {
+	v := func(version int, tipo string, finalizado bool) Envio606 {
+		return Envio606{Version: version, TipoEnvio: tipo, Finalizado: finalizado}
+	}
+	cases := []struct {
+		name      string
+		versiones []Envio606
+		want      bool
+	}{
+		{"sin envíos", nil, false},
+		{"borrador", []Envio606{v(1, "original", false)}, false},
+		{"finalizado", []Envio606{v(1, "original", true)}, true},
+		{"borrador posterior al envío", []Envio606{v(2, "original", false), v(1, "original", true)}, true},
+		{"legado migrado", []Envio606{v(3, "original", false), v(2, "original", true), v(1, "original", false)}, true},
+		{"rectificativa abierta", []Envio606{v(2, "rectificativa", false), v(1, "original", true)}, false},
+		{"rectificativa finalizada", []Envio606{v(2, "rectificativa", true), v(1, "original", true)}, true},
+		{"rectificativa anterior al último envío", []Envio606{v(3, "original", true), v(2, "rectificativa", false), v(1, "original", true)}, true},
+	}
+	for _, tc := range cases {
+		if got := Bloqueado606(tc.versiones); got != tc.want {
+			t.Errorf("%s: Bloqueado606 = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestEnvio606SinLineas(t *testing.T) {
+	if !(&Envio606{CantidadRegistros: 3, Lineas: map[string]string{}}).SinLineas() {
+		t.Error("migrated envío with records but no lines not flagged")
+	}
+	if (&Envio606{Lineas: map[string]string{}}).SinLineas() {
+		t.Error("empty envío flagged as missing lines")
+	}
+	if (&Envio606{CantidadRegistros: 1, Lineas: map[string]string{"f-1": "x"}}).SinLineas() {
+		t.Error("envío with lines flagged")
+	}
+}
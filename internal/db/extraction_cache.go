@@ -0,0 +1,68 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ExtractionCacheKey identifies an extraction: same image bytes, same prompt
+// and same model give the same result. Entries belong to a cliente.
+type ExtractionCacheKey struct {
+	ClienteID     string
+	ImageSHA256   string
+	PromptVersion string
+	Vision        bool
+	Model         string // "<provider>/<model>", after "tesseract-<lang>+" in text mode
+	Redaction     string // "redact" when the AI saw the redacted image or text
+}
+
+// CachedExtraction is a stored extraction result
+type CachedExtraction struct {
+	Invoice   []byte // models.Invoice as JSON
+	OCRText   string
+	CreatedAt time.Time
+}
+
+// GetCachedExtraction returns the extraction of key stored less than maxAge
+// ago, counting the hit, or nil when there is none
+func GetCachedExtraction(ctx context.Context, key ExtractionCacheKey, maxAge time.Duration) (*CachedExtraction, error) {
+	if Pool == nil {
+		return nil, ErrNoDatabase
+	}
+	var c CachedExtraction
+	err := Pool.QueryRow(ctx, `
+		UPDATE extraction_cache SET hits = hits + 1, last_hit_at = NOW()
+		WHERE cliente_id = $1::uuid AND image_sha256 = $2 AND prompt_version = $3 AND vision = $4
+		  AND model = $5 AND redaction = $6
+		  AND created_at > NOW() - $7 * INTERVAL '1 second'
+		RETURNING invoice::text, ocr_text, created_at
+	`, key.ClienteID, key.ImageSHA256, key.PromptVersion, key.Vision, key.Model, key.Redaction, maxAge.Seconds()).Scan(&c.Invoice, &c.OCRText, &c.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// SaveCachedExtraction stores the extraction of key, replacing an older one
+func SaveCachedExtraction(ctx context.Context, key ExtractionCacheKey, invoiceJSON []byte, ocrText string) error {
+	if Pool == nil {
+		return ErrNoDatabase
+	}
+	_, err := Pool.Exec(ctx, `
+		INSERT INTO extraction_cache (cliente_id, image_sha256, prompt_version, vision, model, redaction, invoice, ocr_text)
+		VALUES ($1::uuid, $2, $3, $4, $5, $6, $7::jsonb, $8)
+		ON CONFLICT (cliente_id, image_sha256, prompt_version, vision, model, redaction) DO UPDATE SET
+			invoice = EXCLUDED.invoice,
+			ocr_text = EXCLUDED.ocr_text,
+			hits = 0,
+			created_at = NOW(),
+			last_hit_at = NULL
+	`, key.ClienteID, key.ImageSHA256, key.PromptVersion, key.Vision, key.Model, key.Redaction, string(invoiceJSON), ocrText)
+	return err
+}
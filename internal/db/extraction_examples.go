@@ -0,0 +1,121 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// ExtractionExample is an invoice whose extraction a person corrected: what
+// the AI read and the corrected values, keyed by facturas_clientes column
+type ExtractionExample struct {
+	FacturaID string                     `json:"factura_id"`
+	EmisorRNC string                     `json:"emisor_rnc"`
+	Proveedor string                     `json:"proveedor"`
+	ImageRef  string                     `json:"image_ref"`
+	AIOutput  map[string]json.RawMessage `json:"ai_output"`
+	Corrected map[string]json.RawMessage `json:"corrected_output"`
+	Campos    []string                   `json:"campos"`
+	UpdatedAt time.Time                  `json:"updated_at"`
+}
+
+// SaveExtractionExample stores corrected against extracted, comparing the
+// given columns, as the example of the invoice. When nothing differs any
+// more (the correction was undone) the example is removed, and invoices
+// without emisor are not kept: examples are looked up by emisor.
+func SaveExtractionExample(ctx context.Context, corrected, extracted *ClientInvoice, columns []string) error {
+	if Pool == nil {
+		return ErrNoDatabase
+	}
+
+	aiOutput := map[string]json.RawMessage{}
+	correctedOutput := map[string]json.RawMessage{}
+	campos := []string{}
+	for _, name := range columns {
+		col, ok := invoiceColumns[name]
+		if !ok {
+			continue
+		}
+		oldValue, err := json.Marshal(col.value(extracted))
+		if err != nil {
+			return err
+		}
+		newValue, err := json.Marshal(col.value(corrected))
+		if err != nil {
+			return err
+		}
+		aiOutput[name] = oldValue
+		correctedOutput[name] = newValue
+		if !bytes.Equal(oldValue, newValue) {
+			campos = append(campos, name)
+		}
+	}
+
+	if len(campos) == 0 || corrected.EmisorRNC == "" {
+		_, err := Pool.Exec(ctx, `DELETE FROM extraction_examples WHERE factura_id = $1::uuid`, corrected.ID)
+		return err
+	}
+
+	aiJSON, err := json.Marshal(aiOutput)
+	if err != nil {
+		return err
+	}
+	correctedJSON, err := json.Marshal(correctedOutput)
+	if err != nil {
+		return err
+	}
+	_, err = Pool.Exec(ctx, `
+		INSERT INTO extraction_examples (factura_id, cliente_id, emisor_rnc, proveedor, image_ref, ai_output, corrected_output, campos)
+		VALUES ($1::uuid, $2::uuid, $3, $4, $5, $6::jsonb, $7::jsonb, $8)
+		ON CONFLICT (factura_id) DO UPDATE SET
+			emisor_rnc = EXCLUDED.emisor_rnc,
+			proveedor = EXCLUDED.proveedor,
+			image_ref = EXCLUDED.image_ref,
+			ai_output = EXCLUDED.ai_output,
+			corrected_output = EXCLUDED.corrected_output,
+			campos = EXCLUDED.campos,
+			updated_at = NOW()
+	`, corrected.ID, corrected.ClienteID, corrected.EmisorRNC, corrected.Proveedor, corrected.ArchivoURL,
+		string(aiJSON), string(correctedJSON), campos)
+	return err
+}
+
+// GetExtractionExamples returns the latest corrected examples of a client
+// for any of the given emisor RNCs, newest first. excludeFacturaID leaves out
+// the invoice being extracted (reprocessing).
+func GetExtractionExamples(ctx context.Context, clienteID string, emisorRNCs []string, excludeFacturaID string, limit int) ([]ExtractionExample, error) {
+	if Pool == nil {
+		return nil, ErrNoDatabase
+	}
+
+	rows, err := Pool.Query(ctx, `
+		SELECT factura_id::text, emisor_rnc, proveedor, image_ref, ai_output::text, corrected_output::text, campos, updated_at
+		FROM extraction_examples
+		WHERE cliente_id = $1::uuid AND emisor_rnc = ANY($2)
+		  AND factura_id::text <> $3
+		ORDER BY updated_at DESC
+		LIMIT $4
+	`, clienteID, emisorRNCs, excludeFacturaID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var examples []ExtractionExample
+	for rows.Next() {
+		var ex ExtractionExample
+		var aiJSON, correctedJSON string
+		if err := rows.Scan(&ex.FacturaID, &ex.EmisorRNC, &ex.Proveedor, &ex.ImageRef, &aiJSON, &correctedJSON, &ex.Campos, &ex.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(aiJSON), &ex.AIOutput); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(correctedJSON), &ex.Corrected); err != nil {
+			return nil, err
+		}
+		examples = append(examples, ex)
+	}
+	return examples, rows.Err()
+}
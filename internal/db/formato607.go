@@ -0,0 +1,123 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// Formato607Invoice holds the fields needed to generate DGII Formato 607 TXT (ventas).
+// In a 607 the reporting RNC is the emisor; the counterparty is the receptor.
+type Formato607Invoice struct {
+	ID             string
+	ReceptorRNC    string
+	TipoIDReceptor string
+	ReceptorNombre string
+	NCF            string
+	NCFModifica    string
+	FechaDocumento *time.Time
+	FechaPago      *time.Time
+	MontoServicios float64
+	MontoBienes    float64
+	Subtotal       float64
+	Monto          float64
+	ITBIS          float64
+	ITBISExento    float64
+	ITBISRetenido  float64
+	ITBISPercibido float64
+	ISR            float64
+	ISRPercibido   float64
+	ISC            float64
+	CDTMonto       float64
+	Cargo911       float64
+	OtrosImpuestos float64
+	Propina        float64
+	FormaPago      string
+}
+
+// formato607Where selects the sales of emisor $1 in periodo $2 (YYYYMM)
+const formato607Where = `REPLACE(COALESCE(emisor_rnc,''),'-','') = $1
+		  AND fecha_documento >= to_date($2, 'YYYYMM')
+		  AND fecha_documento < to_date($2, 'YYYYMM') + INTERVAL '1 month'
+		  AND (estado IS NULL OR estado NOT IN ('eliminada', 'anulada', 'rechazada'))
+		  AND tipo_factura = 'ingresos'`
+
+// GetFormato607SinConvertir returns the sales GetFormato607Invoices leaves
+// out because they were not converted to DOP
+func GetFormato607SinConvertir(ctx context.Context, rncEmisor, periodo string) ([]FacturaSinConvertir, error) {
+	return getFacturasSinConvertir(ctx, formato607Where, "receptor_rnc", rncEmisor, periodo)
+}
+
+// GetFormato607Invoices queries facturas_clientes for sales (tipo_factura = 'ingresos')
+// issued by rncEmisor in the given periodo (YYYYMM)
+func GetFormato607Invoices(ctx context.Context, rncEmisor, periodo string) ([]Formato607Invoice, error) {
+	if Pool == nil {
+		return nil, ErrNoDatabase
+	}
+
+	rows, err := Pool.Query(ctx, `
+		SELECT id, COALESCE(receptor_rnc,''), COALESCE(tipo_id_receptor,''), COALESCE(receptor_nombre,''),
+		       COALESCE(ncf,''), COALESCE(ncf_modifica,''),
+		       fecha_documento, fecha_pago,
+		       COALESCE(monto_servicios,0), COALESCE(monto_bienes,0),
+		       COALESCE(subtotal,0), COALESCE(monto,0),
+		       COALESCE(itbis,0), COALESCE(itbis_exento,0), COALESCE(itbis_retenido,0), COALESCE(itbis_percibido,0),
+		       COALESCE(isr,0), COALESCE(isr_percibido,0), COALESCE(isc,0),
+		       COALESCE(cdt_monto,0), COALESCE(cargo_911,0), COALESCE(otros_impuestos,0),
+		       COALESCE(propina,0),
+		       COALESCE(forma_pago,'')
+		FROM facturas_clientes
+		WHERE `+formato607Where+`
+		  AND `+enPesos+`
+		ORDER BY fecha_documento, id
+	`, rncEmisor, periodo)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invoices []Formato607Invoice
+	for rows.Next() {
+		var inv Formato607Invoice
+		err := rows.Scan(
+			&inv.ID, &inv.ReceptorRNC, &inv.TipoIDReceptor, &inv.ReceptorNombre,
+			&inv.NCF, &inv.NCFModifica,
+			&inv.FechaDocumento, &inv.FechaPago,
+			&inv.MontoServicios, &inv.MontoBienes,
+			&inv.Subtotal, &inv.Monto,
+			&inv.ITBIS, &inv.ITBISExento, &inv.ITBISRetenido, &inv.ITBISPercibido,
+			&inv.ISR, &inv.ISRPercibido, &inv.ISC,
+			&inv.CDTMonto, &inv.Cargo911, &inv.OtrosImpuestos,
+			&inv.Propina,
+			&inv.FormaPago,
+		)
+		if err != nil {
+			return nil, err
+		}
+		invoices = append(invoices, inv)
+	}
+	return invoices, nil
+}
+
+// InsertEnvio607 inserts a new entry into envios_607 and returns its id.
+// empresaID may be empty ("") if not known — pass nil in that case.
+func InsertEnvio607(ctx context.Context, empresaID, rnc, periodo, archivoTXT, archivoNombre string, cantRegistros int, totalMonto, totalITBIS, totalITBISRetenido float64) (string, error) {
+	if Pool == nil {
+		return "", ErrNoDatabase
+	}
+
+	var empresaIDArg interface{}
+	if empresaID != "" {
+		empresaIDArg = empresaID
+	}
+
+	var id string
+	err := Pool.QueryRow(ctx, `
+		INSERT INTO envios_607 (empresa_id, rnc, periodo, archivo_txt, archivo_nombre,
+		                        cantidad_registros, total_monto_facturado, total_itbis_facturado,
+		                        total_itbis_retenido, estado)
+		VALUES ($1::uuid, $2, $3, $4, $5, $6, $7, $8, $9, 'generado')
+		RETURNING id
+	`, empresaIDArg, rnc, periodo, archivoTXT, archivoNombre,
+		cantRegistros, totalMonto, totalITBIS, totalITBISRetenido).Scan(&id)
+	return id, err
+}
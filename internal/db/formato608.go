@@ -0,0 +1,136 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrAlreadyAnulado is returned when the NCF was already recorded as anulado
+var ErrAlreadyAnulado = errors.New("NCF ya fue anulado")
+
+// ComprobanteAnulado - NCF anulado (comprobantes_anulados), fuente del Formato 608
+type ComprobanteAnulado struct {
+	ID               string     `json:"id"`
+	ClienteID        string     `json:"cliente_id"`
+	FacturaID        *string    `json:"factura_id,omitempty"`
+	RNC              string     `json:"rnc"`
+	NCF              string     `json:"ncf"`
+	TipoAnulacion    string     `json:"tipo_anulacion"` // Codigo DGII 01-10
+	FechaComprobante *time.Time `json:"fecha_comprobante,omitempty"`
+	FechaAnulacion   time.Time  `json:"fecha_anulacion"`
+	Motivo           string     `json:"motivo,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+// AnularClientInvoice records the invoice NCF in comprobantes_anulados and marks the
+// factura as 'anulada' in a single transaction. The NCF issuer (emisor_rnc) is the
+// RNC reported in the 608.
+func AnularClientInvoice(ctx context.Context, clienteID, invoiceID string, ca *ComprobanteAnulado) error {
+	if Pool == nil {
+		return ErrNoDatabase
+	}
+
+	tx, err := Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var exists bool
+	err = tx.QueryRow(ctx, `
+		SELECT EXISTS(SELECT 1 FROM comprobantes_anulados WHERE rnc = $1 AND ncf = $2)
+	`, ca.RNC, ca.NCF).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrAlreadyAnulado
+	}
+
+	ca.ClienteID = clienteID
+	ca.FacturaID = &invoiceID
+	err = tx.QueryRow(ctx, `
+		INSERT INTO comprobantes_anulados (cliente_id, factura_id, rnc, ncf, tipo_anulacion,
+		                                   fecha_comprobante, fecha_anulacion, motivo)
+		VALUES ($1::uuid, $2::uuid, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at
+	`, clienteID, invoiceID, ca.RNC, ca.NCF, ca.TipoAnulacion,
+		ca.FechaComprobante, ca.FechaAnulacion, ca.Motivo).Scan(&ca.ID, &ca.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE facturas_clientes
+		SET estado = 'anulada'
+		WHERE id = $1::uuid AND cliente_id = $2::uuid
+	`, invoiceID, clienteID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	return tx.Commit(ctx)
+}
+
+// GetFormato608Comprobantes returns the NCFs anulados by rnc whose fecha_anulacion
+// falls in periodo (YYYYMM)
+func GetFormato608Comprobantes(ctx context.Context, rnc, periodo string) ([]ComprobanteAnulado, error) {
+	if Pool == nil {
+		return nil, ErrNoDatabase
+	}
+
+	rows, err := Pool.Query(ctx, `
+		SELECT id, cliente_id, factura_id, rnc, ncf, tipo_anulacion,
+		       fecha_comprobante, fecha_anulacion, COALESCE(motivo, ''), created_at
+		FROM comprobantes_anulados
+		WHERE rnc = $1
+		  AND to_char(fecha_anulacion, 'YYYYMM') = $2
+		ORDER BY fecha_anulacion, ncf
+	`, rnc, periodo)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var anulados []ComprobanteAnulado
+	for rows.Next() {
+		var ca ComprobanteAnulado
+		err := rows.Scan(
+			&ca.ID, &ca.ClienteID, &ca.FacturaID, &ca.RNC, &ca.NCF, &ca.TipoAnulacion,
+			&ca.FechaComprobante, &ca.FechaAnulacion, &ca.Motivo, &ca.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		anulados = append(anulados, ca)
+	}
+	return anulados, nil
+}
+
+// InsertEnvio608 inserts a new entry into envios_608 and returns its id.
+// empresaID may be empty ("") if not known — pass nil in that case.
+func InsertEnvio608(ctx context.Context, empresaID, rnc, periodo, archivoTXT, archivoNombre string, cantRegistros int) (string, error) {
+	if Pool == nil {
+		return "", ErrNoDatabase
+	}
+
+	var empresaIDArg interface{}
+	if empresaID != "" {
+		empresaIDArg = empresaID
+	}
+
+	var id string
+	err := Pool.QueryRow(ctx, `
+		INSERT INTO envios_608 (empresa_id, rnc, periodo, archivo_txt, archivo_nombre,
+		                        cantidad_registros, estado)
+		VALUES ($1::uuid, $2, $3, $4, $5, $6, 'generado')
+		RETURNING id
+	`, empresaIDArg, rnc, periodo, archivoTXT, archivoNombre, cantRegistros).Scan(&id)
+	return id, err
+}
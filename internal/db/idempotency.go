@@ -0,0 +1,113 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Idempotency key statuses
+const (
+	IdempotencyProcessing = "processing"
+	IdempotencyDone       = "done"
+)
+
+// IdempotencyRecord is the stored outcome of a request sent with an
+// Idempotency-Key
+type IdempotencyRecord struct {
+	ClienteID   string
+	Key         string
+	RequestHash string
+	Status      string
+	HTTPStatus  int
+	Response    json.RawMessage
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+// ClaimIdempotencyKey registers key for the cliente. It returns (nil, nil)
+// when the caller owns the key and must process the request, or the existing
+// record when another request already used it. Expired keys, and keys left
+// processing for longer than lease (the request died), are taken over.
+func ClaimIdempotencyKey(ctx context.Context, clienteID, key, requestHash string, ttl, lease time.Duration) (*IdempotencyRecord, error) {
+	if Pool == nil {
+		return nil, ErrNoDatabase
+	}
+
+	var claimed bool
+	err := Pool.QueryRow(ctx, `
+		INSERT INTO idempotency_keys (cliente_id, key, request_hash, status, expires_at)
+		VALUES ($1::uuid, $2, $3, 'processing', NOW() + $4 * INTERVAL '1 second')
+		ON CONFLICT (cliente_id, key) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash, status = 'processing',
+		    http_status = NULL, response = NULL,
+		    created_at = NOW(), updated_at = NOW(), expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at < NOW()
+		   OR (idempotency_keys.status = 'processing'
+		       AND idempotency_keys.updated_at < NOW() - $5 * INTERVAL '1 second')
+		RETURNING true
+	`, clienteID, key, requestHash, int(ttl.Seconds()), int(lease.Seconds())).Scan(&claimed)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+
+	// Conflict without takeover: report the live record
+	var rec IdempotencyRecord
+	var httpStatus *int
+	var response []byte
+	err = Pool.QueryRow(ctx, `
+		SELECT cliente_id, key, request_hash, status, http_status, response, created_at, expires_at
+		FROM idempotency_keys
+		WHERE cliente_id = $1::uuid AND key = $2
+	`, clienteID, key).Scan(&rec.ClienteID, &rec.Key, &rec.RequestHash, &rec.Status,
+		&httpStatus, &response, &rec.CreatedAt, &rec.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if httpStatus != nil {
+		rec.HTTPStatus = *httpStatus
+	}
+	rec.Response = response
+	return &rec, nil
+}
+
+// CompleteIdempotencyKey stores the response to replay for key
+func CompleteIdempotencyKey(ctx context.Context, clienteID, key string, httpStatus int, response []byte) error {
+	if Pool == nil {
+		return ErrNoDatabase
+	}
+	_, err := Pool.Exec(ctx, `
+		UPDATE idempotency_keys
+		SET status = 'done', http_status = $3, response = $4::jsonb, updated_at = NOW()
+		WHERE cliente_id = $1::uuid AND key = $2
+	`, clienteID, key, httpStatus, string(response))
+	return err
+}
+
+// ReleaseIdempotencyKey forgets key so a retry processes the request again
+// (used when the first attempt failed with a server error)
+func ReleaseIdempotencyKey(ctx context.Context, clienteID, key string) error {
+	if Pool == nil {
+		return ErrNoDatabase
+	}
+	_, err := Pool.Exec(ctx, `DELETE FROM idempotency_keys WHERE cliente_id = $1::uuid AND key = $2`, clienteID, key)
+	return err
+}
+
+// DeleteExpiredIdempotencyKeys removes expired keys and returns how many
+func DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	if Pool == nil {
+		return 0, ErrNoDatabase
+	}
+	tag, err := Pool.Exec(ctx, `DELETE FROM idempotency_keys WHERE expires_at < NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// IR17Retencion holds the retention fields of a purchase invoice needed for the
+// IR-17 (retenciones y retribuciones complementarias)
+type IR17Retencion struct {
+	ID                      string
+	EmisorRNC               string
+	Proveedor               string
+	NCF                     string
+	FechaDocumento          *time.Time
+	FechaPago               *time.Time
+	MontoServicios          float64
+	MontoBienes             float64
+	RetencionISRTipo        *int
+	ISR                     float64
+	ITBISRetenido           float64
+	ITBISRetenidoPorcentaje int
+}
+
+// ir17Where selects the purchases of receptor $1 with retentions paid in
+// periodo $2 (YYYYMM), or issued in it when not paid yet
+const ir17Where = `REPLACE(COALESCE(receptor_rnc,''),'-','') = $1
+		  AND (to_char(fecha_pago, 'YYYYMM') = $2
+		       OR (fecha_pago IS NULL AND to_char(fecha_documento, 'YYYYMM') = $2))
+		  AND (COALESCE(isr,0) > 0 OR COALESCE(itbis_retenido,0) > 0)
+		  AND (estado IS NULL OR estado NOT IN ('eliminada', 'anulada', 'rechazada'))
+		  AND COALESCE(tipo_factura, 'gastos') != 'ingresos'`
+
+// GetIR17SinConvertir returns the retentions GetIR17Retenciones leaves out
+// because their invoices were not converted to DOP
+func GetIR17SinConvertir(ctx context.Context, rncReceptor, periodo string) ([]FacturaSinConvertir, error) {
+	return getFacturasSinConvertir(ctx, ir17Where, "emisor_rnc", rncReceptor, periodo)
+}
+
+// GetIR17Retenciones returns the purchase invoices of rncReceptor with ISR or ITBIS
+// retentions paid in periodo (YYYYMM). Retentions are declared in the month they
+// are paid, so invoices without fecha_pago are included when their fecha_documento
+// falls in periodo, so the caller can flag them.
+func GetIR17Retenciones(ctx context.Context, rncReceptor, periodo string) ([]IR17Retencion, error) {
+	if Pool == nil {
+		return nil, ErrNoDatabase
+	}
+
+	rows, err := Pool.Query(ctx, `
+		SELECT id, COALESCE(emisor_rnc,''), COALESCE(proveedor,''), COALESCE(ncf,''),
+		       fecha_documento, fecha_pago,
+		       COALESCE(monto_servicios,0), COALESCE(monto_bienes,0),
+		       retencion_isr_tipo, COALESCE(isr,0),
+		       COALESCE(itbis_retenido,0), COALESCE(itbis_retenido_porcentaje,0)
+		FROM facturas_clientes
+		WHERE `+ir17Where+`
+		  AND `+enPesos+`
+		ORDER BY fecha_pago NULLS LAST, fecha_documento, id
+	`, rncReceptor, periodo)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var retenciones []IR17Retencion
+	for rows.Next() {
+		var ret IR17Retencion
+		err := rows.Scan(
+			&ret.ID, &ret.EmisorRNC, &ret.Proveedor, &ret.NCF,
+			&ret.FechaDocumento, &ret.FechaPago,
+			&ret.MontoServicios, &ret.MontoBienes,
+			&ret.RetencionISRTipo, &ret.ISR,
+			&ret.ITBISRetenido, &ret.ITBISRetenidoPorcentaje,
+		)
+		if err != nil {
+			return nil, err
+		}
+		retenciones = append(retenciones, ret)
+	}
+	return retenciones, nil
+}
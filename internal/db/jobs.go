@@ -0,0 +1,194 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Job statuses
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// ProcessingJob is an asynchronous invoice upload. Params holds the upload
+// options as JSON and Imagen the file until the job finishes. Jobs created by
+// a batch upload carry BatchID and the original file name.
+type ProcessingJob struct {
+	ID            string          `json:"id"`
+	ClienteID     string          `json:"cliente_id"`
+	BatchID       *string         `json:"batch_id,omitempty"`
+	ArchivoNombre string          `json:"archivo_nombre,omitempty"`
+	Tipo          string          `json:"tipo"`
+	Status        string          `json:"status"`
+	Params        json.RawMessage `json:"-"`
+	Imagen        []byte          `json:"-"`
+	Attempts      int             `json:"attempts"`
+	HTTPStatus    *int            `json:"http_status,omitempty"`
+	Result        json.RawMessage `json:"result,omitempty"`
+	Error         string          `json:"error,omitempty"`
+	FacturaID     *string         `json:"factura_id,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+	StartedAt     *time.Time      `json:"started_at,omitempty"`
+	FinishedAt    *time.Time      `json:"finished_at,omitempty"`
+}
+
+// CreateProcessingJob inserts a queued job and fills in its ID and CreatedAt
+func CreateProcessingJob(ctx context.Context, job *ProcessingJob) error {
+	if Pool == nil {
+		return ErrNoDatabase
+	}
+	job.Status = JobQueued
+	return insertProcessingJob(ctx, Pool, job)
+}
+
+// insertProcessingJob inserts job with its current Status. A job created
+// already failed (rejected before processing) gets its error and HTTPStatus.
+func insertProcessingJob(ctx context.Context, q querier, job *ProcessingJob) error {
+	if job.Tipo == "" {
+		job.Tipo = "upload"
+	}
+	if len(job.Params) == 0 {
+		job.Params = json.RawMessage(`{}`)
+	}
+	return q.QueryRow(ctx, `
+		INSERT INTO processing_jobs (cliente_id, tipo, status, params, imagen, batch_id, archivo_nombre,
+		                             http_status, error, finished_at)
+		VALUES ($1, $2, $3::text, $4::jsonb, $5, $6::uuid, NULLIF($7, ''), $8, NULLIF($9, ''),
+		        CASE WHEN $3::text IN ('done', 'failed') THEN NOW() END)
+		RETURNING id, created_at
+	`, job.ClienteID, job.Tipo, job.Status, string(job.Params), job.Imagen, job.BatchID, job.ArchivoNombre,
+		job.HTTPStatus, job.Error).Scan(&job.ID, &job.CreatedAt)
+}
+
+// ClaimNextProcessingJob moves the oldest queued job to running and returns it
+// with its params and file. SKIP LOCKED lets several workers (and instances)
+// poll the same table. Jobs of a batch are skipped while batchConcurrency of
+// its jobs are already running (0 = no limit), so a large batch can't hold
+// every worker; the count is not locked, so the limit is approximate under
+// concurrent claims. Returns (nil, nil) when the queue is empty.
+func ClaimNextProcessingJob(ctx context.Context, batchConcurrency int) (*ProcessingJob, error) {
+	if Pool == nil {
+		return nil, ErrNoDatabase
+	}
+
+	var job ProcessingJob
+	var params []byte
+	err := Pool.QueryRow(ctx, `
+		UPDATE processing_jobs
+		SET status = 'running', attempts = attempts + 1,
+		    started_at = NOW(), updated_at = NOW()
+		WHERE id = (
+			SELECT j.id FROM processing_jobs j
+			WHERE j.status = 'queued'
+			  AND (j.batch_id IS NULL OR $1 <= 0 OR (
+			      SELECT COUNT(*) FROM processing_jobs r
+			      WHERE r.batch_id = j.batch_id AND r.status = 'running') < $1)
+			ORDER BY j.created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, cliente_id, tipo, status, params, imagen, attempts, created_at, started_at
+	`, batchConcurrency).Scan(&job.ID, &job.ClienteID, &job.Tipo, &job.Status, &params, &job.Imagen,
+		&job.Attempts, &job.CreatedAt, &job.StartedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	job.Params = params
+	return &job, nil
+}
+
+// FinishProcessingJob stores the outcome of a job (status done or failed) and
+// drops the uploaded file
+func FinishProcessingJob(ctx context.Context, jobID, status string, httpStatus int, result []byte, errMsg, facturaID string) error {
+	if Pool == nil {
+		return ErrNoDatabase
+	}
+
+	var resultArg, facturaArg interface{}
+	if len(result) > 0 {
+		resultArg = string(result)
+	}
+	if facturaID != "" {
+		facturaArg = facturaID
+	}
+
+	_, err := Pool.Exec(ctx, `
+		UPDATE processing_jobs
+		SET status = $2, http_status = $3, result = $4::jsonb, error = NULLIF($5, ''),
+		    factura_id = $6::uuid, imagen = NULL,
+		    finished_at = NOW(), updated_at = NOW()
+		WHERE id = $1
+	`, jobID, status, httpStatus, resultArg, errMsg, facturaArg)
+	return err
+}
+
+// RequeueStaleProcessingJobs puts back in the queue jobs left running for
+// longer than staleAfter (the instance running them died). Jobs that already
+// used maxAttempts are failed instead. Returns how many were requeued.
+func RequeueStaleProcessingJobs(ctx context.Context, staleAfter time.Duration, maxAttempts int) (int64, error) {
+	if Pool == nil {
+		return 0, ErrNoDatabase
+	}
+
+	_, err := Pool.Exec(ctx, `
+		UPDATE processing_jobs
+		SET status = 'failed', error = 'interrumpido: demasiados intentos',
+		    imagen = NULL, finished_at = NOW(), updated_at = NOW()
+		WHERE status = 'running'
+		  AND started_at < NOW() - $1 * INTERVAL '1 second'
+		  AND attempts >= $2
+	`, int(staleAfter.Seconds()), maxAttempts)
+	if err != nil {
+		return 0, err
+	}
+
+	tag, err := Pool.Exec(ctx, `
+		UPDATE processing_jobs
+		SET status = 'queued', updated_at = NOW()
+		WHERE status = 'running'
+		  AND started_at < NOW() - $1 * INTERVAL '1 second'
+	`, int(staleAfter.Seconds()))
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// GetProcessingJob returns a job owned by clienteID, or (nil, nil)
+func GetProcessingJob(ctx context.Context, clienteID, jobID string) (*ProcessingJob, error) {
+	if Pool == nil {
+		return nil, ErrNoDatabase
+	}
+
+	var job ProcessingJob
+	var result []byte
+	var errMsg *string
+	err := Pool.QueryRow(ctx, `
+		SELECT id, cliente_id, tipo, status, attempts, http_status, result, error,
+		       factura_id::text, created_at, started_at, finished_at
+		FROM processing_jobs
+		WHERE id = $1 AND cliente_id = $2
+	`, jobID, clienteID).Scan(&job.ID, &job.ClienteID, &job.Tipo, &job.Status, &job.Attempts,
+		&job.HTTPStatus, &result, &errMsg, &job.FacturaID, &job.CreatedAt, &job.StartedAt, &job.FinishedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	job.Result = result
+	if errMsg != nil {
+		job.Error = *errMsg
+	}
+	return &job, nil
+}
@@ -0,0 +1,203 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// querier is satisfied by both the Pool and a pgx.Tx, so inserts can run
+// standalone or as part of a transaction
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// Outbox event statuses
+const (
+	OutboxPending   = "pending"
+	OutboxDelivered = "delivered"
+	OutboxFailed    = "failed"
+)
+
+// OutboxEvent is a side effect recorded together with the change that caused
+// it and delivered later by the dispatcher. IdempotencyKey is unique: writing
+// the same key twice keeps the first event, and consumers receive it to
+// discard redeliveries.
+type OutboxEvent struct {
+	ID             string          `json:"id"`
+	Topic          string          `json:"topic"`
+	AggregateType  string          `json:"aggregate_type"`
+	AggregateID    string          `json:"aggregate_id"`
+	IdempotencyKey string          `json:"idempotency_key"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	LastError      string          `json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+// NewOutboxEvent builds an event with payload marshalled to JSON
+func NewOutboxEvent(topic, aggregateType, aggregateID, idempotencyKey string, payload interface{}) (OutboxEvent, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return OutboxEvent{}, err
+	}
+	return OutboxEvent{
+		Topic:          topic,
+		AggregateType:  aggregateType,
+		AggregateID:    aggregateID,
+		IdempotencyKey: idempotencyKey,
+		Payload:        data,
+	}, nil
+}
+
+func insertOutboxEvent(ctx context.Context, q querier, ev OutboxEvent) error {
+	_, err := q.Exec(ctx, `
+		INSERT INTO outbox_events (topic, aggregate_type, aggregate_id, idempotency_key, payload)
+		VALUES ($1, $2, $3, $4, $5::jsonb)
+		ON CONFLICT (idempotency_key) DO NOTHING
+	`, ev.Topic, ev.AggregateType, ev.AggregateID, ev.IdempotencyKey, string(ev.Payload))
+	return err
+}
+
+// insertOutboxEvents writes the events built by events(inv); events may be nil
+func insertOutboxEvents(ctx context.Context, q querier, events func(*ClientInvoice) []OutboxEvent, inv *ClientInvoice) error {
+	if events == nil {
+		return nil
+	}
+	for _, ev := range events(inv) {
+		if err := insertOutboxEvent(ctx, q, ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnqueueOutboxEvents writes events that are not tied to a database change
+// (e.g. a rejected upload that stored nothing)
+func EnqueueOutboxEvents(ctx context.Context, events ...OutboxEvent) error {
+	if Pool == nil {
+		return ErrNoDatabase
+	}
+	for _, ev := range events {
+		if err := insertOutboxEvent(ctx, Pool, ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClaimOutboxEvents locks up to limit due pending events with FOR UPDATE SKIP
+// LOCKED, counts the attempt and hides them for lease. An event whose
+// dispatcher died becomes due again when the lease expires.
+func ClaimOutboxEvents(ctx context.Context, limit int, lease time.Duration) ([]OutboxEvent, error) {
+	if Pool == nil {
+		return nil, ErrNoDatabase
+	}
+
+	rows, err := Pool.Query(ctx, `
+		UPDATE outbox_events e
+		SET attempts = e.attempts + 1,
+		    next_attempt_at = NOW() + $2 * INTERVAL '1 second'
+		WHERE e.id IN (
+			SELECT id FROM outbox_events
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY created_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING e.id, e.topic, e.aggregate_type, e.aggregate_id, e.idempotency_key,
+		          e.payload, e.status, e.attempts, COALESCE(e.last_error, ''), e.created_at
+	`, limit, int(lease.Seconds()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []OutboxEvent
+	for rows.Next() {
+		var ev OutboxEvent
+		var payload []byte
+		if err := rows.Scan(&ev.ID, &ev.Topic, &ev.AggregateType, &ev.AggregateID, &ev.IdempotencyKey,
+			&payload, &ev.Status, &ev.Attempts, &ev.LastError, &ev.CreatedAt); err != nil {
+			return nil, err
+		}
+		ev.Payload = payload
+		events = append(events, ev)
+	}
+	return events, rows.Err()
+}
+
+// MarkOutboxDelivered records a successful delivery
+func MarkOutboxDelivered(ctx context.Context, id string) error {
+	if Pool == nil {
+		return ErrNoDatabase
+	}
+	_, err := Pool.Exec(ctx, `
+		UPDATE outbox_events
+		SET status = 'delivered', delivered_at = NOW(), last_error = NULL
+		WHERE id = $1
+	`, id)
+	return err
+}
+
+// MarkOutboxRetry records a failed delivery. With nextAttemptAt the event is
+// retried then; nil marks it failed for good.
+func MarkOutboxRetry(ctx context.Context, id, lastError string, nextAttemptAt *time.Time) error {
+	if Pool == nil {
+		return ErrNoDatabase
+	}
+	_, err := Pool.Exec(ctx, `
+		UPDATE outbox_events
+		SET status = CASE WHEN $3::timestamptz IS NULL THEN 'failed' ELSE 'pending' END,
+		    last_error = $2,
+		    next_attempt_at = COALESCE($3, next_attempt_at)
+		WHERE id = $1
+	`, id, lastError, nextAttemptAt)
+	return err
+}
+
+// OutboxTopicStats summarizes the outbox of one topic
+type OutboxTopicStats struct {
+	Topic            string  `json:"topic"`
+	Pending          int     `json:"pending"`
+	Failed           int     `json:"failed"`
+	Delivered24h     int     `json:"delivered_24h"`
+	OldestPendingSec float64 `json:"oldest_pending_seconds"` // Lag: age of the oldest undelivered event
+}
+
+// GetOutboxStats returns per-topic backlog, failures and lag
+func GetOutboxStats(ctx context.Context) ([]OutboxTopicStats, error) {
+	if Pool == nil {
+		return nil, ErrNoDatabase
+	}
+
+	rows, err := Pool.Query(ctx, `
+		SELECT topic,
+		       COUNT(*) FILTER (WHERE status = 'pending'),
+		       COUNT(*) FILTER (WHERE status = 'failed'),
+		       COUNT(*) FILTER (WHERE status = 'delivered' AND delivered_at > NOW() - INTERVAL '24 hours'),
+		       COALESCE(EXTRACT(EPOCH FROM NOW() - MIN(created_at) FILTER (WHERE status = 'pending')), 0)::float8
+		FROM outbox_events
+		GROUP BY topic
+		ORDER BY topic
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []OutboxTopicStats{}
+	for rows.Next() {
+		var st OutboxTopicStats
+		if err := rows.Scan(&st.Topic, &st.Pending, &st.Failed, &st.Delivered24h, &st.OldestPendingSec); err != nil {
+			return nil, err
+		}
+		stats = append(stats, st)
+	}
+	return stats, rows.Err()
+}
@@ -0,0 +1,448 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ReviewStatuses are the extraction_status values that put an invoice in the
+// accountant review queue
+var ReviewStatuses = []string{"review", "error", "revision_manual"}
+
+// Review queue actions (review_transitions.accion)
+const (
+	ReviewClaim   = "claim"
+	ReviewRelease = "release"
+	ReviewCorrect = "correct"
+	ReviewApprove = "approve"
+	ReviewReject  = "reject"
+)
+
+// Final states of a reviewed invoice
+const (
+	ReviewApprovedStatus = "validated"
+	ReviewRejectedStatus = "rejected"
+	ReviewRejectedEstado = "rechazada"
+)
+
+var (
+	ErrReviewClaimed = errors.New("la factura está tomada por otro revisor")
+	ErrNotInReview   = errors.New("la factura no está pendiente de revisión")
+)
+
+// IsReviewStatus reports whether status puts an invoice in the review queue
+func IsReviewStatus(status string) bool {
+	for _, s := range ReviewStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// ReviewQueueFilter selects and orders the review queue
+type ReviewQueueFilter struct {
+	OwnerID       string        // Contador whose clients are listed; "" lists every client (admin)
+	ClienteID     string        // Optional: one client only
+	Status        string        // Optional: one of ReviewStatuses
+	MinConfidence *float64      // Optional confidence_score bounds (0-1)
+	MaxConfidence *float64      //
+	MinAge        time.Duration // Optional age bounds (since upload); 0 = no bound
+	MaxAge        time.Duration //
+	Sort          string        // "confidence" (default) or "age"
+	Desc          bool          // Highest confidence / newest first
+	Lease         time.Duration // Claims older than this are shown as free
+	Limit         int
+	Offset        int
+}
+
+// ReviewQueueItem is one invoice waiting for an accountant
+type ReviewQueueItem struct {
+	ID               string     `json:"id"`
+	ClienteID        string     `json:"cliente_id"`
+	ClienteNombre    string     `json:"cliente_nombre"`
+	NCF              string     `json:"ncf,omitempty"`
+	TipoNCF          string     `json:"tipo_ncf,omitempty"`
+	EmisorRNC        string     `json:"emisor_rnc,omitempty"`
+	Proveedor        string     `json:"proveedor,omitempty"`
+	FechaDocumento   *time.Time `json:"fecha_documento,omitempty"`
+	Monto            float64    `json:"monto"`
+	ITBIS            float64    `json:"itbis"`
+	ConfidenceScore  float64    `json:"confidence_score"`
+	ExtractionStatus string     `json:"extraction_status"`
+	ReviewNotes      string     `json:"review_notes,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+	AgeHours         float64    `json:"age_hours"`
+	ClaimedBy        *string    `json:"claimed_by,omitempty"`
+	ClaimedAt        *time.Time `json:"claimed_at,omitempty"`
+}
+
+// FieldChange is the old and new value of a corrected field
+type FieldChange struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// ReviewTransition is one recorded state change of a queue item
+type ReviewTransition struct {
+	ID         string                 `json:"id"`
+	FacturaID  string                 `json:"factura_id"`
+	ClienteID  string                 `json:"cliente_id"`
+	ActorID    string                 `json:"actor_id"`
+	Accion     string                 `json:"accion"`
+	FromStatus string                 `json:"from_status"`
+	ToStatus   string                 `json:"to_status"`
+	Notas      string                 `json:"notas,omitempty"`
+	Cambios    map[string]FieldChange `json:"cambios,omitempty"`
+	CreatedAt  time.Time              `json:"created_at"`
+}
+
+// ReviewAction identifies who acts on which queue item
+type ReviewAction struct {
+	OwnerID   string        // Contador the invoice's client must belong to; "" for admin
+	ActorID   string        // User recorded in the transition and as claimer
+	FacturaID string        //
+	Lease     time.Duration // Claims older than this no longer block other reviewers
+	Notas     string        //
+}
+
+// GetReviewQueue lists the invoices needing review across the clients of
+// f.OwnerID, with the total count for pagination
+func GetReviewQueue(ctx context.Context, f ReviewQueueFilter) ([]ReviewQueueItem, int, error) {
+	if Pool == nil {
+		return nil, 0, ErrNoDatabase
+	}
+
+	where := []string{"f.extraction_status = ANY($1)"}
+	args := []interface{}{ReviewStatuses}
+	add := func(cond string, value interface{}) {
+		args = append(args, value)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if f.OwnerID != "" {
+		add("c.owner_id = $%d::uuid", f.OwnerID)
+	}
+	if f.ClienteID != "" {
+		add("f.cliente_id = $%d::uuid", f.ClienteID)
+	}
+	if f.Status != "" {
+		add("f.extraction_status = $%d", f.Status)
+	}
+	if f.MinConfidence != nil {
+		add("COALESCE(f.confidence_score, 0) >= $%d", *f.MinConfidence)
+	}
+	if f.MaxConfidence != nil {
+		add("COALESCE(f.confidence_score, 0) <= $%d", *f.MaxConfidence)
+	}
+	if f.MinAge > 0 {
+		add("f.created_at <= NOW() - $%d * INTERVAL '1 second'", int(f.MinAge.Seconds()))
+	}
+	if f.MaxAge > 0 {
+		add("f.created_at >= NOW() - $%d * INTERVAL '1 second'", int(f.MaxAge.Seconds()))
+	}
+	whereSQL := strings.Join(where, " AND ")
+
+	var total int
+	countQuery := `
+		SELECT COUNT(*)
+		FROM facturas_clientes f
+		JOIN clientes c ON c.id = f.cliente_id
+		WHERE ` + whereSQL
+	if err := Pool.QueryRow(ctx, countQuery, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	// Lowest confidence / oldest first: the items most likely wrong or most overdue
+	dir := "ASC"
+	if f.Desc {
+		dir = "DESC"
+	}
+	order := "COALESCE(f.confidence_score, 0) " + dir + ", f.created_at ASC"
+	if f.Sort == "age" {
+		order = "f.created_at " + dir
+	}
+
+	args = append(args, int(f.Lease.Seconds()), f.Limit, f.Offset)
+	n := len(args)
+	query := fmt.Sprintf(`
+		SELECT f.id, f.cliente_id, COALESCE(c.razon_social, ''),
+		       COALESCE(f.ncf, ''), COALESCE(f.tipo_ncf, ''), COALESCE(f.emisor_rnc, ''), COALESCE(f.proveedor, ''),
+		       f.fecha_documento, COALESCE(f.monto, 0), COALESCE(f.itbis, 0), COALESCE(f.confidence_score, 0),
+		       f.extraction_status, COALESCE(f.review_notes, ''), f.created_at,
+		       (EXTRACT(EPOCH FROM NOW() - f.created_at) / 3600)::float8,
+		       CASE WHEN f.review_claimed_at > NOW() - $%[1]d * INTERVAL '1 second' THEN f.review_claimed_by::text END,
+		       CASE WHEN f.review_claimed_at > NOW() - $%[1]d * INTERVAL '1 second' THEN f.review_claimed_at END
+		FROM facturas_clientes f
+		JOIN clientes c ON c.id = f.cliente_id
+		WHERE %[2]s
+		ORDER BY %[3]s, f.id
+		LIMIT $%[4]d OFFSET $%[5]d
+	`, n-2, whereSQL, order, n-1, n)
+
+	rows, err := Pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	items := []ReviewQueueItem{}
+	for rows.Next() {
+		var it ReviewQueueItem
+		if err := rows.Scan(
+			&it.ID, &it.ClienteID, &it.ClienteNombre,
+			&it.NCF, &it.TipoNCF, &it.EmisorRNC, &it.Proveedor,
+			&it.FechaDocumento, &it.Monto, &it.ITBIS, &it.ConfidenceScore,
+			&it.ExtractionStatus, &it.ReviewNotes, &it.CreatedAt,
+			&it.AgeHours,
+			&it.ClaimedBy, &it.ClaimedAt,
+		); err != nil {
+			return nil, 0, err
+		}
+		items = append(items, it)
+	}
+	return items, total, rows.Err()
+}
+
+// GetReviewTransitions returns the recorded state changes of an invoice,
+// oldest first
+func GetReviewTransitions(ctx context.Context, ownerID, facturaID string) ([]ReviewTransition, error) {
+	if Pool == nil {
+		return nil, ErrNoDatabase
+	}
+
+	rows, err := Pool.Query(ctx, `
+		SELECT t.id, t.factura_id, t.cliente_id, t.actor_id, t.accion, t.from_status, t.to_status,
+		       t.notas, COALESCE(t.cambios::text, ''), t.created_at
+		FROM review_transitions t
+		JOIN clientes c ON c.id = t.cliente_id
+		WHERE t.factura_id = $1::uuid AND ($2::text = '' OR c.owner_id::text = $2)
+		ORDER BY t.created_at, t.id
+	`, facturaID, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transitions := []ReviewTransition{}
+	for rows.Next() {
+		var t ReviewTransition
+		var cambios string
+		if err := rows.Scan(&t.ID, &t.FacturaID, &t.ClienteID, &t.ActorID, &t.Accion, &t.FromStatus, &t.ToStatus,
+			&t.Notas, &cambios, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		if cambios != "" {
+			json.Unmarshal([]byte(cambios), &t.Cambios)
+		}
+		transitions = append(transitions, t)
+	}
+	return transitions, rows.Err()
+}
+
+// reviewState is a queue item locked for a transition
+type reviewState struct {
+	clienteID string
+	status    string
+}
+
+// lockReviewItem locks the invoice and checks that a.ActorID may act on it:
+// the client belongs to a.OwnerID, the invoice is in the queue and no other
+// reviewer holds a live claim
+func lockReviewItem(ctx context.Context, tx pgx.Tx, a ReviewAction) (*reviewState, error) {
+	var st reviewState
+	var claimedBy string
+	var claimLive bool
+	err := tx.QueryRow(ctx, `
+		SELECT f.cliente_id::text, COALESCE(f.extraction_status, ''),
+		       COALESCE(f.review_claimed_by::text, ''),
+		       COALESCE(f.review_claimed_at > NOW() - $3 * INTERVAL '1 second', false)
+		FROM facturas_clientes f
+		JOIN clientes c ON c.id = f.cliente_id
+		WHERE f.id = $1::uuid AND ($2::text = '' OR c.owner_id::text = $2)
+		FOR UPDATE OF f
+	`, a.FacturaID, a.OwnerID, int(a.Lease.Seconds())).Scan(&st.clienteID, &st.status, &claimedBy, &claimLive)
+	if err != nil {
+		return nil, err
+	}
+	if !IsReviewStatus(st.status) {
+		return nil, ErrNotInReview
+	}
+	if claimLive && claimedBy != a.ActorID {
+		return nil, ErrReviewClaimed
+	}
+	return &st, nil
+}
+
+// applyReviewTransition runs one queue action in a transaction: it locks the
+// item, lets update change the invoice and return the new extraction_status,
+// records the transition and writes the outbox events built from the updated
+// invoice. pgx.ErrNoRows means the invoice doesn't exist for a.OwnerID.
+func applyReviewTransition(ctx context.Context, a ReviewAction, accion string, cambios map[string]FieldChange,
+	update func(tx pgx.Tx, st *reviewState) (string, error), events func(*ClientInvoice) []OutboxEvent) (*ReviewTransition, error) {
+	if Pool == nil {
+		return nil, ErrNoDatabase
+	}
+
+	tx, err := Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	st, err := lockReviewItem(ctx, tx, a)
+	if err != nil {
+		return nil, err
+	}
+	toStatus, err := update(tx, st)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &ReviewTransition{
+		FacturaID:  a.FacturaID,
+		ClienteID:  st.clienteID,
+		ActorID:    a.ActorID,
+		Accion:     accion,
+		FromStatus: st.status,
+		ToStatus:   toStatus,
+		Notas:      a.Notas,
+		Cambios:    cambios,
+	}
+	var cambiosJSON interface{}
+	if len(cambios) > 0 {
+		data, err := json.Marshal(cambios)
+		if err != nil {
+			return nil, err
+		}
+		cambiosJSON = string(data)
+	}
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO review_transitions (factura_id, cliente_id, actor_id, accion, from_status, to_status, notas, cambios)
+		VALUES ($1::uuid, $2::uuid, $3::uuid, $4, $5, $6, $7, $8::jsonb)
+		RETURNING id, created_at
+	`, t.FacturaID, t.ClienteID, t.ActorID, t.Accion, t.FromStatus, t.ToStatus, t.Notas, cambiosJSON).Scan(&t.ID, &t.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	if events != nil {
+		updated, err := getClientInvoiceByID(ctx, tx, st.clienteID, a.FacturaID)
+		if err != nil {
+			return nil, err
+		}
+		if err := insertOutboxEvents(ctx, tx, events, updated); err != nil {
+			return nil, err
+		}
+	}
+	return t, tx.Commit(ctx)
+}
+
+// ClaimReviewItem takes the item for a.ActorID for a.Lease. Claiming an item
+// already held by the same reviewer renews the claim.
+func ClaimReviewItem(ctx context.Context, a ReviewAction) (*ReviewTransition, error) {
+	return applyReviewTransition(ctx, a, ReviewClaim, nil, func(tx pgx.Tx, st *reviewState) (string, error) {
+		_, err := tx.Exec(ctx, `
+			UPDATE facturas_clientes
+			SET review_claimed_by = $2::uuid, review_claimed_at = NOW()
+			WHERE id = $1::uuid
+		`, a.FacturaID, a.ActorID)
+		return st.status, err
+	}, nil)
+}
+
+// ReleaseReviewItem gives the item back to the queue
+func ReleaseReviewItem(ctx context.Context, a ReviewAction) (*ReviewTransition, error) {
+	return applyReviewTransition(ctx, a, ReviewRelease, nil, func(tx pgx.Tx, st *reviewState) (string, error) {
+		_, err := tx.Exec(ctx, `
+			UPDATE facturas_clientes
+			SET review_claimed_by = NULL, review_claimed_at = NULL
+			WHERE id = $1::uuid
+		`, a.FacturaID)
+		return st.status, err
+	}, nil)
+}
+
+// CorrectReviewItem writes columns of the corrected invoice (already
+// revalidated by the caller, with its new ExtractionStatus) and keeps the item
+// claimed by the reviewer
+func CorrectReviewItem(ctx context.Context, a ReviewAction, inv *ClientInvoice, cambios map[string]FieldChange, columns []string,
+	events func(*ClientInvoice) []OutboxEvent) (*ReviewTransition, error) {
+	return applyReviewTransition(ctx, a, ReviewCorrect, cambios, func(tx pgx.Tx, st *reviewState) (string, error) {
+		if err := updateClientInvoice(ctx, tx, st.clienteID, a.FacturaID, inv, a.auditActor("corrección en cola de revisión"), columns...); err != nil {
+			return "", err
+		}
+		_, err := tx.Exec(ctx, `
+			UPDATE facturas_clientes
+			SET review_claimed_by = $2::uuid, review_claimed_at = NOW()
+			WHERE id = $1::uuid
+		`, a.FacturaID, a.ActorID)
+		return inv.ExtractionStatus, err
+	}, events)
+}
+
+// ApproveReviewItem marks the invoice validated, with a.Notas as the
+// accountant's notes, and takes it out of the queue
+func ApproveReviewItem(ctx context.Context, a ReviewAction, reviewNotes string, events func(*ClientInvoice) []OutboxEvent) (*ReviewTransition, error) {
+	return applyReviewTransition(ctx, a, ReviewApprove, nil, func(tx pgx.Tx, st *reviewState) (string, error) {
+		return ReviewApprovedStatus, finishReview(ctx, tx, a, st, ReviewApprovedStatus, "", &reviewNotes)
+	}, events)
+}
+
+// RejectReviewItem marks the invoice rejected (estado rechazada, excluded
+// from the DGII formats), with a.Notas as the reason
+func RejectReviewItem(ctx context.Context, a ReviewAction, events func(*ClientInvoice) []OutboxEvent) (*ReviewTransition, error) {
+	return applyReviewTransition(ctx, a, ReviewReject, nil, func(tx pgx.Tx, st *reviewState) (string, error) {
+		return ReviewRejectedStatus, finishReview(ctx, tx, a, st, ReviewRejectedStatus, ReviewRejectedEstado, nil)
+	}, events)
+}
+
+// finishReview takes the item out of the queue. Empty estado or a.Notas and a
+// nil reviewNotes keep the stored values.
+func finishReview(ctx context.Context, tx pgx.Tx, a ReviewAction, st *reviewState, status, estado string, reviewNotes *string) error {
+	inv, err := getClientInvoiceByID(ctx, tx, st.clienteID, a.FacturaID)
+	if err != nil {
+		return err
+	}
+	inv.ExtractionStatus = status
+	if estado != "" {
+		inv.Estado = estado
+	}
+	if a.Notas != "" {
+		inv.NotasContador = a.Notas
+	}
+	if reviewNotes != nil {
+		inv.ReviewNotes = *reviewNotes
+	}
+	reason := "aprobada en cola de revisión"
+	if status == ReviewRejectedStatus {
+		reason = "rechazada en cola de revisión"
+	}
+	if err := updateClientInvoice(ctx, tx, st.clienteID, a.FacturaID, inv, a.auditActor(reason),
+		"extraction_status", "estado", "notas_contador", "review_notes"); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE facturas_clientes
+		SET reviewed_by = $2::uuid,
+		    reviewed_at = NOW(),
+		    review_claimed_by = NULL,
+		    review_claimed_at = NULL
+		WHERE id = $1::uuid
+	`, a.FacturaID, a.ActorID)
+	return err
+}
+
+// auditActor records a queue action as the reviewer's, with their notes as
+// the reason when given
+func (a ReviewAction) auditActor(reason string) AuditActor {
+	if a.Notas != "" {
+		reason += ": " + a.Notas
+	}
+	return AuditActor{ID: a.ActorID, Source: AuditSourceContador, Reason: reason}
+}
@@ -0,0 +1,113 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// SharePoint sync queue statuses
+const (
+	SharePointPending    = "pending"
+	SharePointProcessing = "processing"
+	SharePointSynced     = "synced"
+	SharePointFailed     = "failed"
+)
+
+// SharePointSyncItem is a sharepoint_sync_queue row claimed by the worker
+type SharePointSyncItem struct {
+	ID            string
+	FacturaID     string
+	ClienteID     string
+	RNCCliente    string
+	FechaFactura  *time.Time
+	ArchivoURL    string
+	ArchivoNombre string
+	Attempts      int
+	CreatedAt     time.Time
+}
+
+// ClaimSharePointSyncItems locks up to limit due rows with FOR UPDATE SKIP
+// LOCKED, marks them processing and counts the attempt. The lease pushes
+// next_attempt_at forward so a row whose worker died is picked up again once
+// it expires.
+func ClaimSharePointSyncItems(ctx context.Context, limit int, lease time.Duration) ([]SharePointSyncItem, error) {
+	if Pool == nil {
+		return nil, ErrNoDatabase
+	}
+
+	rows, err := Pool.Query(ctx, `
+		UPDATE sharepoint_sync_queue q
+		SET status = 'processing',
+		    attempts = q.attempts + 1,
+		    next_attempt_at = NOW() + $2 * INTERVAL '1 second',
+		    updated_at = NOW()
+		WHERE q.id IN (
+			SELECT id FROM sharepoint_sync_queue
+			WHERE status IN ('pending', 'processing')
+			  AND (next_attempt_at IS NULL OR next_attempt_at <= NOW())
+			ORDER BY created_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING q.id, q.factura_id, q.cliente_id, COALESCE(q.rnc_cliente, ''), q.fecha_factura,
+		          q.archivo_url, COALESCE(q.archivo_nombre, ''), q.attempts, q.created_at
+	`, limit, int(lease.Seconds()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []SharePointSyncItem
+	for rows.Next() {
+		var it SharePointSyncItem
+		if err := rows.Scan(&it.ID, &it.FacturaID, &it.ClienteID, &it.RNCCliente, &it.FechaFactura,
+			&it.ArchivoURL, &it.ArchivoNombre, &it.Attempts, &it.CreatedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, it)
+	}
+	return items, rows.Err()
+}
+
+// MarkSharePointSynced records a successful upload
+func MarkSharePointSynced(ctx context.Context, id, drivePath, driveItemID, webURL string) error {
+	if Pool == nil {
+		return ErrNoDatabase
+	}
+	_, err := Pool.Exec(ctx, `
+		UPDATE sharepoint_sync_queue
+		SET status = 'synced', drive_path = $2, drive_item_id = $3, web_url = $4,
+		    last_error = NULL, next_attempt_at = NULL, synced_at = NOW(), updated_at = NOW()
+		WHERE id = $1
+	`, id, drivePath, driveItemID, webURL)
+	return err
+}
+
+// MarkSharePointRetry records a failed upload. With nextAttemptAt the row goes
+// back to pending; nil marks it failed for good.
+func MarkSharePointRetry(ctx context.Context, id, lastError string, nextAttemptAt *time.Time) error {
+	if Pool == nil {
+		return ErrNoDatabase
+	}
+	_, err := Pool.Exec(ctx, `
+		UPDATE sharepoint_sync_queue
+		SET status = CASE WHEN $3::timestamptz IS NULL THEN 'failed' ELSE 'pending' END,
+		    last_error = $2, next_attempt_at = $3, updated_at = NOW()
+		WHERE id = $1
+	`, id, lastError, nextAttemptAt)
+	return err
+}
+
+// EnqueueSharePointSync adds an invoice image to the sync queue. Queuing the
+// same factura twice is a no-op.
+func EnqueueSharePointSync(ctx context.Context, facturaID, clienteID, rncCliente string, fechaFactura *time.Time, archivoURL, archivoNombre string) error {
+	if Pool == nil {
+		return ErrNoDatabase
+	}
+	_, err := Pool.Exec(ctx, `
+		INSERT INTO sharepoint_sync_queue (factura_id, cliente_id, rnc_cliente, fecha_factura, archivo_url, archivo_nombre)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT DO NOTHING
+	`, facturaID, clienteID, rncCliente, fechaFactura, archivoURL, archivoNombre)
+	return err
+}
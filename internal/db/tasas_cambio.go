@@ -0,0 +1,76 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// TasaCambio - Tasa de cambio oficial (DOP por unidad de Moneda) de una fecha
+type TasaCambio struct {
+	Moneda string    `json:"moneda"`
+	Fecha  time.Time `json:"fecha"`
+	Compra float64   `json:"compra"`
+	Venta  float64   `json:"venta"`
+	Fuente string    `json:"fuente"`
+}
+
+// GetTasaCambio returns the most recent rate for moneda published on or before
+// fecha, looking back at most maxDias days (weekends and holidays have no
+// rate). Returns (nil, nil) when there is none.
+func GetTasaCambio(ctx context.Context, moneda string, fecha time.Time, maxDias int) (*TasaCambio, error) {
+	if Pool == nil {
+		return nil, ErrNoDatabase
+	}
+
+	var t TasaCambio
+	err := Pool.QueryRow(ctx, `
+		SELECT moneda, fecha, compra, venta, fuente
+		FROM tasas_cambio
+		WHERE moneda = $1
+		  AND fecha <= $2::date
+		  AND fecha > $2::date - $3::int
+		ORDER BY fecha DESC
+		LIMIT 1
+	`, moneda, fecha, maxDias).Scan(&t.Moneda, &t.Fecha, &t.Compra, &t.Venta, &t.Fuente)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// UpsertTasasCambio inserts or replaces the rates (keyed by moneda + fecha) in a
+// single transaction and returns how many rows were written
+func UpsertTasasCambio(ctx context.Context, tasas []TasaCambio) (int, error) {
+	if Pool == nil {
+		return 0, ErrNoDatabase
+	}
+
+	tx, err := Pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	batch := &pgx.Batch{}
+	for _, t := range tasas {
+		batch.Queue(`
+			INSERT INTO tasas_cambio (moneda, fecha, compra, venta, fuente)
+			VALUES ($1, $2::date, $3, $4, $5)
+			ON CONFLICT (moneda, fecha) DO UPDATE
+			SET compra = EXCLUDED.compra, venta = EXCLUDED.venta, fuente = EXCLUDED.fuente
+		`, t.Moneda, t.Fecha, t.Compra, t.Venta, t.Fuente)
+	}
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return len(tasas), nil
+}
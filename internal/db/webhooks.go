@@ -0,0 +1,239 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// WebhookEndpoint is a URL registered by a cliente to receive events.
+// Empty Eventos means every event.
+type WebhookEndpoint struct {
+	ID          string    `json:"id"`
+	ClienteID   string    `json:"cliente_id"`
+	URL         string    `json:"url"`
+	Secret      string    `json:"-"`
+	Eventos     []string  `json:"eventos"`
+	Descripcion string    `json:"descripcion,omitempty"`
+	Activo      bool      `json:"activo"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// WebhookDelivery is one delivery attempt of an event to an endpoint
+type WebhookDelivery struct {
+	ID           string          `json:"id"`
+	EndpointID   string          `json:"endpoint_id"`
+	EventID      string          `json:"event_id"`
+	Evento       string          `json:"evento"`
+	Payload      json.RawMessage `json:"payload,omitempty"`
+	Attempt      int             `json:"attempt"`
+	StatusCode   *int            `json:"status_code,omitempty"`
+	ResponseBody string          `json:"response_body,omitempty"`
+	Error        string          `json:"error,omitempty"`
+	DurationMs   int             `json:"duration_ms"`
+	Success      bool            `json:"success"`
+	CreatedAt    time.Time       `json:"created_at"`
+}
+
+const webhookEndpointColumns = `
+	id, cliente_id, url, secret, eventos, COALESCE(descripcion, ''), activo, created_at, updated_at`
+
+func scanWebhookEndpoint(row pgx.Row) (*WebhookEndpoint, error) {
+	var e WebhookEndpoint
+	err := row.Scan(&e.ID, &e.ClienteID, &e.URL, &e.Secret, &e.Eventos, &e.Descripcion, &e.Activo, &e.CreatedAt, &e.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// CreateWebhookEndpoint stores a new endpoint; e is filled with the stored row
+func CreateWebhookEndpoint(ctx context.Context, e *WebhookEndpoint) error {
+	if Pool == nil {
+		return ErrNoDatabase
+	}
+	if e.Eventos == nil {
+		e.Eventos = []string{}
+	}
+	saved, err := scanWebhookEndpoint(Pool.QueryRow(ctx, `
+		INSERT INTO webhook_endpoints (cliente_id, url, secret, eventos, descripcion)
+		VALUES ($1::uuid, $2, $3, $4, NULLIF($5, ''))
+		RETURNING `+webhookEndpointColumns,
+		e.ClienteID, e.URL, e.Secret, e.Eventos, e.Descripcion))
+	if err != nil {
+		return err
+	}
+	*e = *saved
+	return nil
+}
+
+// GetWebhookEndpoints lists the endpoints of a cliente
+func GetWebhookEndpoints(ctx context.Context, clienteID string) ([]WebhookEndpoint, error) {
+	if Pool == nil {
+		return nil, ErrNoDatabase
+	}
+
+	rows, err := Pool.Query(ctx, `
+		SELECT `+webhookEndpointColumns+`
+		FROM webhook_endpoints
+		WHERE cliente_id = $1::uuid
+		ORDER BY created_at
+	`, clienteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	endpoints := []WebhookEndpoint{}
+	for rows.Next() {
+		e, err := scanWebhookEndpoint(rows)
+		if err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, *e)
+	}
+	return endpoints, rows.Err()
+}
+
+// GetWebhookEndpointsForEvent lists the active endpoints of a cliente
+// subscribed to evento
+func GetWebhookEndpointsForEvent(ctx context.Context, clienteID, evento string) ([]WebhookEndpoint, error) {
+	if Pool == nil {
+		return nil, ErrNoDatabase
+	}
+
+	rows, err := Pool.Query(ctx, `
+		SELECT `+webhookEndpointColumns+`
+		FROM webhook_endpoints
+		WHERE cliente_id = $1::uuid
+		  AND activo
+		  AND (cardinality(eventos) = 0 OR $2 = ANY(eventos))
+	`, clienteID, evento)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var endpoints []WebhookEndpoint
+	for rows.Next() {
+		e, err := scanWebhookEndpoint(rows)
+		if err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, *e)
+	}
+	return endpoints, rows.Err()
+}
+
+// GetWebhookEndpoint returns an endpoint by ID regardless of owner, or
+// (nil, nil). Used by the delivery worker.
+func GetWebhookEndpoint(ctx context.Context, endpointID string) (*WebhookEndpoint, error) {
+	if Pool == nil {
+		return nil, ErrNoDatabase
+	}
+	e, err := scanWebhookEndpoint(Pool.QueryRow(ctx, `
+		SELECT `+webhookEndpointColumns+`
+		FROM webhook_endpoints
+		WHERE id = $1::uuid
+	`, endpointID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return e, err
+}
+
+// DeleteWebhookEndpoint removes an endpoint of the cliente and its delivery
+// log. Returns pgx.ErrNoRows when it does not exist.
+func DeleteWebhookEndpoint(ctx context.Context, clienteID, endpointID string) error {
+	if Pool == nil {
+		return ErrNoDatabase
+	}
+	tag, err := Pool.Exec(ctx, `
+		DELETE FROM webhook_endpoints WHERE id = $1::uuid AND cliente_id = $2::uuid
+	`, endpointID, clienteID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// InsertWebhookDelivery logs a delivery attempt
+func InsertWebhookDelivery(ctx context.Context, d *WebhookDelivery) error {
+	if Pool == nil {
+		return ErrNoDatabase
+	}
+	return Pool.QueryRow(ctx, `
+		INSERT INTO webhook_deliveries (endpoint_id, event_id, evento, payload, attempt,
+		                                status_code, response_body, error, duration_ms, success)
+		VALUES ($1::uuid, $2, $3, $4::jsonb, $5, $6, NULLIF($7, ''), NULLIF($8, ''), $9, $10)
+		RETURNING id, created_at
+	`, d.EndpointID, d.EventID, d.Evento, string(d.Payload), d.Attempt,
+		d.StatusCode, d.ResponseBody, d.Error, d.DurationMs, d.Success).Scan(&d.ID, &d.CreatedAt)
+}
+
+// GetWebhookDeliveries lists the latest delivery attempts of an endpoint owned
+// by the cliente, newest first (payloads omitted)
+func GetWebhookDeliveries(ctx context.Context, clienteID, endpointID string, limit int) ([]WebhookDelivery, error) {
+	if Pool == nil {
+		return nil, ErrNoDatabase
+	}
+
+	rows, err := Pool.Query(ctx, `
+		SELECT d.id, d.endpoint_id, d.event_id, d.evento, d.attempt, d.status_code,
+		       COALESCE(d.response_body, ''), COALESCE(d.error, ''), d.duration_ms, d.success, d.created_at
+		FROM webhook_deliveries d
+		JOIN webhook_endpoints e ON e.id = d.endpoint_id
+		WHERE d.endpoint_id = $1::uuid AND e.cliente_id = $2::uuid
+		ORDER BY d.created_at DESC
+		LIMIT $3
+	`, endpointID, clienteID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var d WebhookDelivery
+		if err := rows.Scan(&d.ID, &d.EndpointID, &d.EventID, &d.Evento, &d.Attempt, &d.StatusCode,
+			&d.ResponseBody, &d.Error, &d.DurationMs, &d.Success, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// GetWebhookDelivery returns a delivery (with payload) of an endpoint owned by
+// the cliente, or (nil, nil)
+func GetWebhookDelivery(ctx context.Context, clienteID, deliveryID string) (*WebhookDelivery, error) {
+	if Pool == nil {
+		return nil, ErrNoDatabase
+	}
+
+	var d WebhookDelivery
+	var payload []byte
+	err := Pool.QueryRow(ctx, `
+		SELECT d.id, d.endpoint_id, d.event_id, d.evento, d.payload, d.attempt, d.status_code,
+		       COALESCE(d.response_body, ''), COALESCE(d.error, ''), d.duration_ms, d.success, d.created_at
+		FROM webhook_deliveries d
+		JOIN webhook_endpoints e ON e.id = d.endpoint_id
+		WHERE d.id = $1::uuid AND e.cliente_id = $2::uuid
+	`, deliveryID, clienteID).Scan(&d.ID, &d.EndpointID, &d.EventID, &d.Evento, &payload, &d.Attempt,
+		&d.StatusCode, &d.ResponseBody, &d.Error, &d.DurationMs, &d.Success, &d.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	d.Payload = payload
+	return &d, nil
+}
@@ -64,6 +64,12 @@ type Invoice struct {
 	TipoBienServicio string `json:"tipoBienServicio,omitempty"` // Codigo de bien/servicio
 	TipoFactura      string `json:"tipoFactura,omitempty"`      // gastos o ingresos
 
+	// Moneda - los montos DGII de arriba quedan en DOP; si la factura vino en otra
+	// moneda, MontosOriginales conserva lo extraído y TasaCambio la tasa aplicada
+	Moneda           string            `json:"moneda,omitempty"`           // ISO 4217: DOP, USD, EUR (vacío = DOP)
+	TasaCambio       decimal.Decimal   `json:"tasaCambio,omitempty"`       // DOP por unidad de Moneda
+	MontosOriginales *MontosOriginales `json:"montosOriginales,omitempty"` // Montos en la moneda original
+
 	// Legacy fields (for backwards compatibility)
 	Vendor string          `json:"vendor"`           // Merchant/store name (same as NombreEmisor)
 	Date   time.Time       `json:"date"`             // Invoice date (same as FechaFactura)
@@ -80,8 +86,32 @@ type Invoice struct {
 	RawText string `json:"rawText,omitempty"` // Complete OCR text
 
 	// Metadata
-	Confidence  float64   `json:"confidence"`   // Overall confidence score (0-1)
-	ProcessedAt time.Time `json:"processedAt"`  // When it was processed
+	Confidence    float64   `json:"confidence"`              // Overall confidence score (0-1)
+	ProcessedAt   time.Time `json:"processedAt"`             // When it was processed
+	PromptVersion string    `json:"promptVersion,omitempty"` // Extraction prompt revision, "version@hash" (see ai.Prompt)
+}
+
+// MontosOriginales holds the invoice amounts in their original currency, before
+// conversion to DOP
+type MontosOriginales struct {
+	Moneda                string          `json:"moneda"`
+	Subtotal              decimal.Decimal `json:"subtotal"`
+	Descuento             decimal.Decimal `json:"descuento"`
+	MontoServicios        decimal.Decimal `json:"montoServicios"`
+	MontoBienes           decimal.Decimal `json:"montoBienes"`
+	ITBIS                 decimal.Decimal `json:"itbis"`
+	ITBISRetenido         decimal.Decimal `json:"itbisRetenido"`
+	ITBISExento           decimal.Decimal `json:"itbisExento"`
+	ITBISProporcionalidad decimal.Decimal `json:"itbisProporcionalidad"`
+	ITBISCosto            decimal.Decimal `json:"itbisCosto"`
+	ISR                   decimal.Decimal `json:"isr"`
+	ISC                   decimal.Decimal `json:"isc"`
+	CDTMonto              decimal.Decimal `json:"cdtMonto"`
+	Cargo911              decimal.Decimal `json:"cargo911"`
+	Propina               decimal.Decimal `json:"propina"`
+	OtrosImpuestos        decimal.Decimal `json:"otrosImpuestos"`
+	MontoNoFacturable     decimal.Decimal `json:"montoNoFacturable"`
+	Total                 decimal.Decimal `json:"total"`
 }
 
 // InvoiceItem represents a line item in an invoice with DGII fields
@@ -140,6 +170,54 @@ type Config struct {
 
 	// Categories (for better extraction)
 	Categories []string `yaml:"categories"`
+
+	// Exchange rates for non-DOP invoices
+	ExchangeRates ExchangeRateConfig `yaml:"exchange_rates"`
+
+	// Asynchronous processing jobs
+	Jobs JobsConfig `yaml:"jobs"`
+
+	// SharePoint sync of invoice images
+	SharePoint SharePointConfig `yaml:"sharepoint"`
+}
+
+// SharePointConfig configures the worker that copies invoice images to a
+// SharePoint document library through Microsoft Graph
+type SharePointConfig struct {
+	Enabled        bool   `yaml:"enabled"`
+	TenantID       string `yaml:"tenant_id"`
+	ClientID       string `yaml:"client_id"`
+	ClientSecret   string `yaml:"client_secret"`
+	DriveID        string `yaml:"drive_id"`        // Document library (drive) receiving the files
+	RootFolder     string `yaml:"root_folder"`     // Base folder inside the drive (default: Facturas)
+	GraphBaseURL   string `yaml:"graph_base_url"`  // Optional: override for tests (default: https://graph.microsoft.com/v1.0)
+	TokenURL       string `yaml:"token_url"`       // Optional: override for tests
+	Workers        int    `yaml:"workers"`         // Concurrent uploads (default: 2)
+	MaxAttempts    int    `yaml:"max_attempts"`    // Attempts before a row is marked failed (default: 8)
+	BackoffSeconds int    `yaml:"backoff_seconds"` // First retry delay, doubled each attempt (default: 60)
+}
+
+// JobsConfig controls the asynchronous upload workers
+type JobsConfig struct {
+	Workers      int  `yaml:"workers"`       // Jobs processed concurrently (default: 4)
+	AsyncUploads bool `yaml:"async_uploads"` // Upload returns a job ID unless async=false is sent
+	MaxAttempts  int  `yaml:"max_attempts"`  // Restarts tolerated before a job is failed (default: 3)
+
+	// Jobs of one batch upload running at the same time, so a batch leaves
+	// workers for other uploads (default: 2, <0 = no limit)
+	BatchConcurrency int `yaml:"batch_concurrency"`
+
+	// Automatic retry of invoices saved as revision_manual (all AI providers failed)
+	RetryMaxAttempts    int `yaml:"retry_max_attempts"`    // Retries before leaving it to a human (default: 5, <0 disables)
+	RetryBackoffMinutes int `yaml:"retry_backoff_minutes"` // First retry delay, doubled each attempt (default: 5)
+}
+
+// ExchangeRateConfig selects where DOP exchange rates come from
+type ExchangeRateConfig struct {
+	Source  string             `yaml:"source"`   // "db" (tabla tasas_cambio, default) o "static"
+	CSVPath string             `yaml:"csv_path"` // CSV del Banco Central a cargar en tasas_cambio al iniciar
+	Static  map[string]float64 `yaml:"static"`   // Tasas fijas por moneda (source: static, pruebas)
+	MaxDias int                `yaml:"max_dias"` // Días hacia atrás para buscar la última tasa (default: 7)
 }
 
 // OCRConfig represents OCR-specific configuration
@@ -161,6 +239,87 @@ type AIConfig struct {
 
 	// Default provider
 	DefaultProvider string `yaml:"default_provider"` // "openai", "gemini", "ollama"
+
+	// Corrected invoices of the same emisor added to extraction prompts
+	FewShot FewShotConfig `yaml:"few_shot"`
+
+	// Extraction prompt versions and which tenant uses each
+	Prompts PromptsConfig `yaml:"prompts"`
+
+	// Extraction results reused for the same image, prompt and model
+	Cache ExtractionCacheConfig `yaml:"cache"`
+
+	// PII redaction before images and OCR text reach a third-party AI
+	Redaction RedactionConfig `yaml:"redaction"`
+
+	// Estimated cost of each model and monthly AI quotas per empresa
+	Pricing map[string]ModelPricing `yaml:"pricing"` // Model -> price
+	Quotas  AIQuotasConfig          `yaml:"quotas"`
+}
+
+// FewShotConfig bounds the corrected examples added to extraction prompts
+type FewShotConfig struct {
+	MaxExamples int  `yaml:"max_examples"` // Examples per invoice (default: 3, <0 disables)
+	TokenBudget int  `yaml:"token_budget"` // Prompt tokens for examples and hints (default: 1500)
+	SecondPass  bool `yaml:"second_pass"`  // Re-extract vision results with the examples of the emisor read (default: off, a second AI call)
+}
+
+// RedactionConfig sets the PII policy of each empresa: Default applies to
+// every empresa (or cliente) not listed in Empresas
+type RedactionConfig struct {
+	Default  RedactionPolicy            `yaml:"default"`
+	Empresas map[string]RedactionPolicy `yaml:"empresas"` // Empresa alias or cliente ID -> policy
+}
+
+// RedactionPolicy decides what a third-party AI may see of an invoice
+type RedactionPolicy struct {
+	// "" or "off": send as is; "redact": black out card numbers, phones and
+	// Patterns first; "local_only": extract with Ollama only
+	Mode     string   `yaml:"mode"`
+	Patterns []string `yaml:"patterns"` // Extra regexps: cardholder names, addresses...
+}
+
+// ExtractionCacheConfig keeps extraction results by image content hash
+type ExtractionCacheConfig struct {
+	TTLDays int `yaml:"ttl_days"` // Days a result is reused (default: 90, <0 disables)
+}
+
+// PromptsConfig selects the extraction prompt version of each upload. A tenant
+// listed in Tenants uses its version; the others are split into A/B cohorts by
+// Experiments and otherwise use Default.
+type PromptsConfig struct {
+	Dir         string             `yaml:"dir"`         // Extra versions: <dir>/<version>/{vision,dgii}.tmpl
+	Default     string             `yaml:"default"`     // Default: built-in "v1"
+	Tenants     map[string]string  `yaml:"tenants"`     // Empresa alias or cliente ID -> version
+	Experiments []PromptExperiment `yaml:"experiments"` // A/B cohorts, by hash of the tenant
+}
+
+// PromptExperiment sends Percent of the tenants to a prompt version
+type PromptExperiment struct {
+	Version string `yaml:"version"`
+	Percent int    `yaml:"percent"` // 0-100; experiments take consecutive ranges
+}
+
+// ModelPricing is the price of a model in USD per million tokens. Models
+// without a price (Ollama) cost nothing.
+type ModelPricing struct {
+	InputPerMillion  float64 `yaml:"input_per_million"`
+	OutputPerMillion float64 `yaml:"output_per_million"`
+}
+
+// AIQuotasConfig limits the monthly AI usage of each empresa: Default applies
+// to every empresa not listed in Empresas
+type AIQuotasConfig struct {
+	Default  AIQuota            `yaml:"default"`
+	Empresas map[string]AIQuota `yaml:"empresas"` // Empresa alias -> quota
+}
+
+// AIQuota is a monthly limit and what happens past it
+type AIQuota struct {
+	MonthlyCostUSD  float64 `yaml:"monthly_cost_usd"` // 0 = unlimited
+	MonthlyTokens   int64   `yaml:"monthly_tokens"`   // 0 = unlimited
+	OnExceeded      string  `yaml:"on_exceeded"`      // "downgrade" (default) or "block"
+	CheaperProvider string  `yaml:"cheaper_provider"` // Provider once downgraded (default: ollama)
 }
 
 // OpenAIConfig for OpenAI/Azure OpenAI
@@ -0,0 +1,183 @@
+package ocr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ErrNothingToRedactWith is returned when the OCR found no words, so the
+// sensitive parts of the image cannot be located
+var ErrNothingToRedactWith = errors.New("no OCR word boxes to locate sensitive data")
+
+// DefaultRedactionPatterns match card numbers, masked or labeled card last-4
+// digits and Dominican phone numbers. RNC, cédula and NCF do not match: the
+// extraction needs them.
+var DefaultRedactionPatterns = []string{
+	`\b(?:\d[ -]?){12,18}\d\b`,                       // Full card number
+	`[Xx*•#]{4}(?:[ -]?[Xx*•#]{4}){1,2}[ -]?\d{4}\b`, // XXXX XXXX XXXX 1234
+	`[Xx*•#]{6,}\d{4}\b`,                             // ************1234
+	`(?i)\b(?:visa|master(?:card)?|amex|discover|tarjeta|card)\b\W{0,3}(?:[Xx*•#]+\W?)?\d{4}\b`,
+	`(?:\+?1[ .-]?)?\(?\b(?:809|829|849)\)?[ .-]?\d{3}[ .-]?\d{4}\b`, // 809/829/849 phones
+}
+
+// redactedText replaces sensitive text in OCR output
+const redactedText = "[REDACTADO]"
+
+// Redactor finds sensitive data (card numbers, phones, configured patterns)
+// in OCR text and word boxes
+type Redactor struct {
+	patterns []*regexp.Regexp
+}
+
+// NewRedactor compiles the default patterns and extra ones
+func NewRedactor(extraPatterns []string) (*Redactor, error) {
+	r := &Redactor{}
+	for _, p := range append(append([]string{}, DefaultRedactionPatterns...), extraPatterns...) {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("redaction pattern %q: %w", p, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// RedactText replaces the sensitive parts of a text
+func (r *Redactor) RedactText(text string) string {
+	for _, re := range r.patterns {
+		text = re.ReplaceAllString(text, redactedText)
+	}
+	return text
+}
+
+// SensitiveBoxes returns the boxes of the words holding sensitive data. Words
+// are joined into lines first, so values split across words (a card number
+// in groups of four, a phone with spaces) are found too.
+func (r *Redactor) SensitiveBoxes(words []WordInfo) []BoundingBox {
+	var boxes []BoundingBox
+	for _, line := range groupLines(words) {
+		// Text of the line and the span of each word in it
+		var sb strings.Builder
+		spans := make([][2]int, len(line))
+		for i, w := range line {
+			if i > 0 {
+				sb.WriteByte(' ')
+			}
+			spans[i][0] = sb.Len()
+			sb.WriteString(w.Text)
+			spans[i][1] = sb.Len()
+		}
+		text := sb.String()
+
+		hit := make([]bool, len(line))
+		for _, re := range r.patterns {
+			for _, m := range re.FindAllStringIndex(text, -1) {
+				for i, span := range spans {
+					if span[0] < m[1] && m[0] < span[1] {
+						hit[i] = true
+					}
+				}
+			}
+		}
+		for i, w := range line {
+			if hit[i] {
+				boxes = append(boxes, w.Box)
+			}
+		}
+	}
+	return boxes
+}
+
+// groupLines sorts words into lines, top to bottom and left to right: a word
+// belongs to the current line when its vertical center falls within it
+func groupLines(words []WordInfo) [][]WordInfo {
+	sorted := make([]WordInfo, 0, len(words))
+	for _, w := range words {
+		if strings.TrimSpace(w.Text) != "" {
+			sorted = append(sorted, w)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Box.Y < sorted[j].Box.Y })
+
+	var lines [][]WordInfo
+	var top, bottom int
+	for _, w := range sorted {
+		center := w.Box.Y + w.Box.Height/2
+		if len(lines) > 0 && center >= top && center <= bottom {
+			lines[len(lines)-1] = append(lines[len(lines)-1], w)
+			if end := w.Box.Y + w.Box.Height; end > bottom {
+				bottom = end
+			}
+			continue
+		}
+		lines = append(lines, []WordInfo{w})
+		top, bottom = w.Box.Y, w.Box.Y+w.Box.Height
+	}
+	for _, line := range lines {
+		sort.SliceStable(line, func(i, j int) bool { return line[i].Box.X < line[j].Box.X })
+	}
+	return lines
+}
+
+// RedactImage blacks out boxes, with a small margin, and re-encodes the image
+// (PNG stays PNG, anything else becomes JPEG)
+func RedactImage(imageData []byte, boxes []BoundingBox) ([]byte, error) {
+	src, format, err := image.Decode(bytes.NewReader(imageData))
+	if err != nil {
+		return nil, fmt.Errorf("decoding image for redaction: %w", err)
+	}
+	bounds := src.Bounds()
+	dst := image.NewRGBA(bounds)
+	draw.Draw(dst, bounds, src, bounds.Min, draw.Src)
+
+	const margin = 3
+	black := &image.Uniform{C: color.Black}
+	for _, b := range boxes {
+		rect := image.Rect(b.X-margin, b.Y-margin, b.X+b.Width+margin, b.Y+b.Height+margin).
+			Add(bounds.Min).Intersect(bounds)
+		draw.Draw(dst, rect, black, image.Point{}, draw.Src)
+	}
+
+	var out bytes.Buffer
+	if format == "png" {
+		err = png.Encode(&out, dst)
+	} else {
+		err = jpeg.Encode(&out, dst, &jpeg.Options{Quality: 90})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("encoding redacted image: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+// RedactImageWords runs the OCR on the original image and blacks out its
+// sensitive words. It returns the redacted image and how many words were
+// covered; without word boxes nothing can be located and
+// ErrNothingToRedactWith is returned.
+func (r *Redactor) RedactImageWords(engine *TesseractOCR, imageData []byte) ([]byte, int, error) {
+	_, words, err := engine.ExtractTextWithDetails(imageData)
+	if err != nil {
+		return nil, 0, fmt.Errorf("OCR for redaction: %w", err)
+	}
+	if len(words) == 0 {
+		return nil, 0, ErrNothingToRedactWith
+	}
+	boxes := r.SensitiveBoxes(words)
+	if len(boxes) == 0 {
+		return imageData, 0, nil
+	}
+	redacted, err := RedactImage(imageData, boxes)
+	if err != nil {
+		return nil, 0, err
+	}
+	return redacted, len(boxes), nil
+}
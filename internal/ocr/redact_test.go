@@ -0,0 +1,96 @@
+package ocr
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// receiptLine lays words out left to right on one line
+func receiptLine(y int, words ...string) []WordInfo {
+	var out []WordInfo
+	x := 10
+	for _, w := range words {
+		out = append(out, WordInfo{Text: w, Confidence: 90, Box: BoundingBox{X: x, Y: y, Width: 10 * len(w), Height: 20}})
+		x += 10*len(w) + 10
+	}
+	return out
+}
+
+func TestSensitiveBoxes(t *testing.T) {
+	r, err := NewRedactor([]string{`(?i)titular:\s*\S+(?:\s+\S+)?`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var words []WordInfo
+	words = append(words, receiptLine(0, "SUPERMERCADO", "NACIONAL")...)
+	words = append(words, receiptLine(30, "RNC:", "1-01-00394-1", "NCF:", "B0100000001")...)
+	words = append(words, receiptLine(60, "TEL:", "(809)", "555-1234")...)
+	words = append(words, receiptLine(90, "TARJETA", "4111", "1111", "1111", "1111")...)
+	words = append(words, receiptLine(120, "VISA", "****", "1234")...)
+	words = append(words, receiptLine(150, "TITULAR:", "JUAN", "PEREZ")...)
+	words = append(words, receiptLine(180, "TOTAL", "RD$", "1,180.00")...)
+
+	redacted := map[string]bool{}
+	for _, b := range r.SensitiveBoxes(words) {
+		for _, w := range words {
+			if w.Box == b {
+				redacted[w.Text] = true
+			}
+		}
+	}
+	for _, want := range []string{"(809)", "555-1234", "4111", "1111", "****", "1234", "JUAN", "PEREZ"} {
+		if !redacted[want] {
+			t.Errorf("%q not redacted", want)
+		}
+	}
+	for _, keep := range []string{"1-01-00394-1", "B0100000001", "1,180.00", "SUPERMERCADO"} {
+		if redacted[keep] {
+			t.Errorf("%q redacted, the extraction needs it", keep)
+		}
+	}
+}
+
+func TestRedactText(t *testing.T) {
+	r, _ := NewRedactor(nil)
+	got := r.RedactText("RNC 131047939\nTARJETA: XXXX XXXX XXXX 4321\nTel. 829-555-0199\nTOTAL 118.00")
+	want := "RNC 131047939\nTARJETA: [REDACTADO]\nTel. [REDACTADO]\nTOTAL 118.00"
+	if got != want {
+		t.Errorf("RedactText = %q, want %q", got, want)
+	}
+}
+
+func TestRedactImage(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 100, 50))
+	for y := 0; y < 50; y++ {
+		for x := 0; x < 100; x++ {
+			src.Set(x, y, color.White)
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, src)
+
+	out, err := RedactImage(buf.Bytes(), []BoundingBox{{X: 20, Y: 10, Width: 30, Height: 10}})
+	if err != nil {
+		t.Fatalf("RedactImage: %v", err)
+	}
+	img, format, err := image.Decode(bytes.NewReader(out))
+	if err != nil || format != "png" {
+		t.Fatalf("decoding redacted image: %v (%s)", err, format)
+	}
+	if r, _, _, _ := img.At(30, 15).RGBA(); r != 0 {
+		t.Error("box not blacked out")
+	}
+	if r, _, _, _ := img.At(80, 40).RGBA(); r == 0 {
+		t.Error("pixel outside the box blacked out")
+	}
+}
+
+func TestRedactImageWordsWithoutBoxes(t *testing.T) {
+	r, _ := NewRedactor(nil)
+	if _, _, err := r.RedactImageWords(NewTesseractOCR("spa"), []byte("image")); err != ErrNothingToRedactWith {
+		t.Errorf("err = %v, want ErrNothingToRedactWith", err)
+	}
+}
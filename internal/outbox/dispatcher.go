@@ -0,0 +1,204 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/facturaIA/invoice-ocr-service/internal/db"
+)
+
+const (
+	defaultBatchSize   = 20
+	defaultMaxAttempts = 10
+	pollInterval       = 2 * time.Second
+	deliveryTimeout    = time.Minute
+	baseBackoff        = 10 * time.Second
+	maxBackoff         = time.Hour
+	// Claimed events are hidden from other dispatchers for this long
+	claimLease = 2 * deliveryTimeout
+)
+
+// ErrPermanent marks a delivery error that retrying won't fix; the event is
+// failed immediately. Wrap it: fmt.Errorf("%w: ...", outbox.ErrPermanent).
+var ErrPermanent = errors.New("permanent delivery error")
+
+// Handler delivers one event. It may be called more than once for the same
+// event (at-least-once), so it must be idempotent on ev.IdempotencyKey.
+type Handler func(ctx context.Context, ev db.OutboxEvent) error
+
+// Dispatcher delivers outbox events to the handler registered for their topic
+type Dispatcher struct {
+	handlers    map[string]Handler
+	maxAttempts int
+	wake        chan struct{}
+
+	delivered atomic.Int64
+	retried   atomic.Int64
+	failed    atomic.Int64
+	lastError atomic.Value // string
+
+	// Database access and clock, replaced in tests
+	claim         func(ctx context.Context, limit int, lease time.Duration) ([]db.OutboxEvent, error)
+	markDelivered func(ctx context.Context, id string) error
+	markRetry     func(ctx context.Context, id, lastError string, nextAttemptAt *time.Time) error
+	now           func() time.Time
+}
+
+// NewDispatcher creates a dispatcher with no handlers
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		handlers:    make(map[string]Handler),
+		maxAttempts: defaultMaxAttempts,
+		wake:        make(chan struct{}, 1),
+
+		claim:         db.ClaimOutboxEvents,
+		markDelivered: db.MarkOutboxDelivered,
+		markRetry:     db.MarkOutboxRetry,
+		now:           time.Now,
+	}
+}
+
+// Register sets the handler for topic. Call before Run.
+func (d *Dispatcher) Register(topic string, h Handler) {
+	d.handlers[topic] = h
+}
+
+// Notify wakes the dispatcher after new events were committed, instead of
+// waiting for the next poll
+func (d *Dispatcher) Notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers due events until ctx ends
+func (d *Dispatcher) Run(ctx context.Context) {
+	log.Printf("[Outbox] Dispatcher started (%d topics)", len(d.handlers))
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		for db.Pool != nil && ctx.Err() == nil && d.runBatch(ctx) {
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// runBatch delivers one batch concurrently; returns true if the batch was full
+// and there may be more due events
+func (d *Dispatcher) runBatch(ctx context.Context) bool {
+	events, err := d.claim(ctx, defaultBatchSize, claimLease)
+	if err != nil {
+		log.Printf("[Outbox] Error claiming events: %v", err)
+		return false
+	}
+
+	var wg sync.WaitGroup
+	for _, ev := range events {
+		wg.Add(1)
+		go func(ev db.OutboxEvent) {
+			defer wg.Done()
+			d.deliver(ctx, ev)
+		}(ev)
+	}
+	wg.Wait()
+	return len(events) == defaultBatchSize
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, ev db.OutboxEvent) {
+	err := d.call(ctx, ev)
+
+	// Record the outcome even if ctx was cancelled mid-delivery
+	saveCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err == nil {
+		d.delivered.Add(1)
+		if err := d.markDelivered(saveCtx, ev.ID); err != nil {
+			log.Printf("[Outbox] Error marking %s delivered: %v", ev.ID, err)
+		}
+		return
+	}
+
+	d.lastError.Store(fmt.Sprintf("%s %s: %v", ev.Topic, ev.ID, err))
+	var next *time.Time
+	if ev.Attempts < d.maxAttempts && !errors.Is(err, ErrPermanent) {
+		d.retried.Add(1)
+		delay := baseBackoff << (ev.Attempts - 1)
+		if delay <= 0 || delay > maxBackoff {
+			delay = maxBackoff
+		}
+		t := d.now().Add(delay)
+		next = &t
+		log.Printf("[Outbox] %s %s falló (intento %d/%d): %v", ev.Topic, ev.ID, ev.Attempts, d.maxAttempts, err)
+	} else {
+		d.failed.Add(1)
+		log.Printf("[Outbox] %s %s falló definitivamente (intento %d/%d): %v", ev.Topic, ev.ID, ev.Attempts, d.maxAttempts, err)
+	}
+	if err := d.markRetry(saveCtx, ev.ID, err.Error(), next); err != nil {
+		log.Printf("[Outbox] Error saving retry state of %s: %v", ev.ID, err)
+	}
+}
+
+// call runs the topic handler, turning panics into errors
+func (d *Dispatcher) call(ctx context.Context, ev db.OutboxEvent) (err error) {
+	h, ok := d.handlers[ev.Topic]
+	if !ok {
+		return fmt.Errorf("%w: no handler for topic %q", ErrPermanent, ev.Topic)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, deliveryTimeout)
+	defer cancel()
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("handler panic: %v", rec)
+		}
+	}()
+	return h(ctx, ev)
+}
+
+// Metrics is the dispatcher state exposed to admins. Counters are for this
+// process since start; Topics comes from the table and covers every instance.
+type Metrics struct {
+	Delivered int64                 `json:"delivered"`
+	Retried   int64                 `json:"retried"`
+	Failed    int64                 `json:"failed"`
+	LastError string                `json:"last_error,omitempty"`
+	Topics    []db.OutboxTopicStats `json:"topics"`
+	// MaxLagSeconds is the age of the oldest pending event across topics
+	MaxLagSeconds float64 `json:"max_lag_seconds"`
+}
+
+// Metrics returns delivery counters plus per-topic backlog and lag
+func (d *Dispatcher) Metrics(ctx context.Context) (*Metrics, error) {
+	topics, err := db.GetOutboxStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m := &Metrics{
+		Delivered: d.delivered.Load(),
+		Retried:   d.retried.Load(),
+		Failed:    d.failed.Load(),
+		Topics:    topics,
+	}
+	if s, ok := d.lastError.Load().(string); ok {
+		m.LastError = s
+	}
+	for _, t := range topics {
+		if t.OldestPendingSec > m.MaxLagSeconds {
+			m.MaxLagSeconds = t.OldestPendingSec
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,247 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/facturaIA/invoice-ocr-service/internal/db"
+)
+
+// fakeStore is an in-memory outbox_events table with the semantics of the
+// db functions: claiming counts the attempt and hides the event for the
+// lease, and a retry without a next attempt fails the event
+type fakeStore struct {
+	mu     sync.Mutex
+	now    time.Time
+	events map[string]*fakeEvent
+	// failMarkDelivered loses the delivered mark, as if the process died
+	// right after the handler returned
+	failMarkDelivered bool
+}
+
+type fakeEvent struct {
+	ev          db.OutboxEvent
+	nextAttempt time.Time
+}
+
+func newFakeStore(events ...db.OutboxEvent) *fakeStore {
+	s := &fakeStore{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), events: map[string]*fakeEvent{}}
+	for i, ev := range events {
+		ev.ID = fmt.Sprintf("ev-%d", i+1)
+		ev.Status = "pending"
+		s.events[ev.ID] = &fakeEvent{ev: ev, nextAttempt: s.now}
+	}
+	return s
+}
+
+// dispatcher returns a Dispatcher on s and its clock
+func (s *fakeStore) dispatcher() *Dispatcher {
+	d := NewDispatcher()
+	d.claim = s.claim
+	d.markDelivered = s.markDelivered
+	d.markRetry = s.markRetry
+	d.now = func() time.Time {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.now
+	}
+	return d
+}
+
+func (s *fakeStore) claim(_ context.Context, limit int, lease time.Duration) ([]db.OutboxEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]string, 0, len(s.events))
+	for id := range s.events {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var out []db.OutboxEvent
+	for _, id := range ids {
+		e := s.events[id]
+		if len(out) == limit || e.ev.Status != "pending" || e.nextAttempt.After(s.now) {
+			continue
+		}
+		e.ev.Attempts++
+		e.nextAttempt = s.now.Add(lease)
+		out = append(out, e.ev)
+	}
+	return out, nil
+}
+
+func (s *fakeStore) markDelivered(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failMarkDelivered {
+		return errors.New("connection lost")
+	}
+	s.events[id].ev.Status = "delivered"
+	s.events[id].ev.LastError = ""
+	return nil
+}
+
+func (s *fakeStore) markRetry(_ context.Context, id, lastError string, nextAttemptAt *time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.events[id]
+	e.ev.LastError = lastError
+	if nextAttemptAt == nil {
+		e.ev.Status = "failed"
+		return nil
+	}
+	e.nextAttempt = *nextAttemptAt
+	return nil
+}
+
+// advance moves the clock to the event's next attempt and returns the delay
+func (s *fakeStore) advance(id string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delay := s.events[id].nextAttempt.Sub(s.now)
+	s.now = s.events[id].nextAttempt
+	return delay
+}
+
+func (s *fakeStore) event(id string) db.OutboxEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.events[id].ev
+}
+
+func TestRetrySchedule(t *testing.T) {
+	store := newFakeStore(db.OutboxEvent{Topic: "test", IdempotencyKey: "k-1"})
+	d := store.dispatcher()
+	d.maxAttempts = 12
+	d.Register("test", func(context.Context, db.OutboxEvent) error { return errors.New("timeout") })
+
+	want := []time.Duration{
+		10 * time.Second, 20 * time.Second, 40 * time.Second, 80 * time.Second, 160 * time.Second,
+		320 * time.Second, 640 * time.Second, 1280 * time.Second, 2560 * time.Second,
+		time.Hour, time.Hour, // Capped
+	}
+	ctx := context.Background()
+	for i, delay := range want {
+		d.runBatch(ctx)
+		if got := store.advance("ev-1"); got != delay {
+			t.Errorf("delay after attempt %d = %v, want %v", i+1, got, delay)
+		}
+	}
+	// The last attempt fails the event for good
+	d.runBatch(ctx)
+	ev := store.event("ev-1")
+	if ev.Attempts != d.maxAttempts || ev.Status != "failed" || ev.LastError != "timeout" {
+		t.Errorf("event = %+v, want failed after %d attempts with the last error", ev, d.maxAttempts)
+	}
+	if d.runBatch(ctx); store.event("ev-1").Attempts != d.maxAttempts {
+		t.Error("failed event claimed again")
+	}
+	if d.delivered.Load() != 0 || d.retried.Load() != int64(len(want)) || d.failed.Load() != 1 {
+		t.Errorf("delivered, retried, failed = %d, %d, %d", d.delivered.Load(), d.retried.Load(), d.failed.Load())
+	}
+}
+
+func TestRetryWaitsForItsTime(t *testing.T) {
+	store := newFakeStore(db.OutboxEvent{Topic: "test", IdempotencyKey: "k-1"})
+	d := store.dispatcher()
+	calls := 0
+	d.Register("test", func(context.Context, db.OutboxEvent) error { calls++; return errors.New("timeout") })
+
+	d.runBatch(context.Background())
+	d.runBatch(context.Background())
+	if calls != 1 {
+		t.Errorf("handler called %d times before the retry was due, want 1", calls)
+	}
+}
+
+func TestPermanentErrorsFailAtOnce(t *testing.T) {
+	store := newFakeStore(
+		db.OutboxEvent{Topic: "test", IdempotencyKey: "k-1"},
+		db.OutboxEvent{Topic: "unknown", IdempotencyKey: "k-2"},
+		db.OutboxEvent{Topic: "panics", IdempotencyKey: "k-3"},
+	)
+	d := store.dispatcher()
+	d.Register("test", func(context.Context, db.OutboxEvent) error {
+		return fmt.Errorf("%w: invalid payload", ErrPermanent)
+	})
+	d.Register("panics", func(context.Context, db.OutboxEvent) error { panic("nil map") })
+
+	d.runBatch(context.Background())
+	for _, id := range []string{"ev-1", "ev-2"} {
+		if ev := store.event(id); ev.Status != "failed" || ev.Attempts != 1 {
+			t.Errorf("%s = %+v, want failed after one attempt", id, ev)
+		}
+	}
+	// A panic is retried like any other error
+	if ev := store.event("ev-3"); ev.Status != "pending" || ev.LastError != "handler panic: nil map" {
+		t.Errorf("ev-3 = %+v, want pending with the panic", ev)
+	}
+}
+
+func TestRedeliveryIsIdempotent(t *testing.T) {
+	store := newFakeStore(db.OutboxEvent{Topic: "test", IdempotencyKey: "factura:f-1"})
+	d := store.dispatcher()
+
+	// The handler applies each idempotency key once, as Handler requires
+	var mu sync.Mutex
+	var keys []string
+	applied := map[string]int{}
+	d.Register("test", func(_ context.Context, ev db.OutboxEvent) error {
+		mu.Lock()
+		defer mu.Unlock()
+		keys = append(keys, ev.IdempotencyKey)
+		if applied[ev.IdempotencyKey] == 0 {
+			applied[ev.IdempotencyKey]++
+		}
+		return nil
+	})
+
+	// Delivered, but the mark is lost: the event stays pending under its lease
+	store.failMarkDelivered = true
+	d.runBatch(context.Background())
+	if ev := store.event("ev-1"); ev.Status != "pending" {
+		t.Fatalf("event = %+v, want pending", ev)
+	}
+	if d.runBatch(context.Background()); len(keys) != 1 {
+		t.Fatalf("redelivered before the lease expired: %v", keys)
+	}
+
+	// Once the lease expires another pass delivers it again, with the same key
+	store.failMarkDelivered = false
+	if got := store.advance("ev-1"); got != claimLease {
+		t.Errorf("lease = %v, want %v", got, claimLease)
+	}
+	d.runBatch(context.Background())
+
+	if ev := store.event("ev-1"); ev.Status != "delivered" || ev.Attempts != 2 {
+		t.Errorf("event = %+v, want delivered on the second attempt", ev)
+	}
+	if len(keys) != 2 || keys[0] != keys[1] || applied["factura:f-1"] != 1 {
+		t.Errorf("deliveries = %v, applied = %v; want the same key twice, applied once", keys, applied)
+	}
+}
+
+func TestRunBatchReportsFullBatches(t *testing.T) {
+	events := make([]db.OutboxEvent, defaultBatchSize+1)
+	for i := range events {
+		events[i] = db.OutboxEvent{Topic: "test", IdempotencyKey: fmt.Sprintf("k-%d", i)}
+	}
+	store := newFakeStore(events...)
+	d := store.dispatcher()
+	d.Register("test", func(context.Context, db.OutboxEvent) error { return nil })
+
+	if !d.runBatch(context.Background()) {
+		t.Error("full batch reported as the last one")
+	}
+	if d.runBatch(context.Background()) {
+		t.Error("partial batch reported as full")
+	}
+	if got := d.delivered.Load(); got != int64(len(events)) {
+		t.Errorf("delivered = %d, want %d", got, len(events))
+	}
+}
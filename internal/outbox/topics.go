@@ -0,0 +1,41 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/facturaIA/invoice-ocr-service/internal/db"
+)
+
+// Topics
+const (
+	TopicSharePointSync = "sharepoint.sync"
+)
+
+// SharePointSyncPayload is the payload of TopicSharePointSync
+type SharePointSyncPayload struct {
+	FacturaID     string     `json:"factura_id"`
+	ClienteID     string     `json:"cliente_id"`
+	RNCCliente    string     `json:"rnc_cliente"`
+	FechaFactura  *time.Time `json:"fecha_factura,omitempty"`
+	ArchivoURL    string     `json:"archivo_url"`
+	ArchivoNombre string     `json:"archivo_nombre"`
+}
+
+// NewSharePointSyncEvent builds the event that queues an invoice image for
+// SharePoint. One per factura: the idempotency key is the factura ID.
+func NewSharePointSyncEvent(p SharePointSyncPayload) (db.OutboxEvent, error) {
+	return db.NewOutboxEvent(TopicSharePointSync, "factura", p.FacturaID, TopicSharePointSync+":"+p.FacturaID, p)
+}
+
+// SharePointSyncHandler moves the event into sharepoint_sync_queue, where the
+// SharePoint worker picks it up. The insert ignores an already queued factura.
+func SharePointSyncHandler(ctx context.Context, ev db.OutboxEvent) error {
+	var p SharePointSyncPayload
+	if err := json.Unmarshal(ev.Payload, &p); err != nil {
+		return fmt.Errorf("%w: invalid payload: %v", ErrPermanent, err)
+	}
+	return db.EnqueueSharePointSync(ctx, p.FacturaID, p.ClienteID, p.RNCCliente, p.FechaFactura, p.ArchivoURL, p.ArchivoNombre)
+}
@@ -0,0 +1,196 @@
+// Package progress publishes the stages of an invoice upload so clients can
+// follow it live (Server-Sent Events). Streams live in memory on the instance
+// that processes the upload and are dropped a while after they finish.
+package progress
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Stages
+const (
+	StageReceived       = "received"        // Request read, file in memory
+	StageQueued         = "queued"          // Persisted as an async job
+	StageStored         = "stored"          // Image uploaded to object storage
+	StageQualityChecked = "quality_checked" // Image prepared for OCR/vision
+	StageOCRDone        = "ocr_done"        // Tesseract finished
+	StageRedacted       = "redacted"        // Sensitive data blacked out before calling the AI
+	StageAIAttempt      = "ai_attempt"      // Calling an AI provider
+	StageAIFallback     = "ai_fallback"     // Provider failed transiently, trying the next one
+	StageAIDone         = "ai_done"         // Extraction parsed
+	StageConverted      = "converted"       // Amounts converted to DOP
+	StageValidated      = "validated"       // Tax validation done
+	StageDuplicateCheck = "duplicate_check" // Dedup against stored invoices
+	StageSaved          = "saved"           // Invoice stored
+	StageDone           = "done"            // Final: upload finished (success or not)
+)
+
+const (
+	// Events kept per stream for subscribers that connect late
+	maxHistory = 100
+	// Finished streams are kept this long so a late client still gets them
+	finishedTTL = 5 * time.Minute
+	// Streams that never finish (crashed upload) are dropped after this
+	staleTTL = 30 * time.Minute
+)
+
+// Event is one stage of an upload
+type Event struct {
+	Stage      string                 `json:"stage"`
+	Message    string                 `json:"message,omitempty"`
+	Provider   string                 `json:"provider,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+	Data       map[string]interface{} `json:"data,omitempty"`
+	At         time.Time              `json:"at"`
+	ElapsedMs  int64                  `json:"elapsed_ms"`            // Since the stream started
+	DurationMs int64                  `json:"duration_ms,omitempty"` // Of the step that just ended, when known
+}
+
+type stream struct {
+	start    time.Time
+	updated  time.Time
+	events   []Event
+	subs     map[chan Event]struct{}
+	finished bool
+}
+
+// Broker fans events out to subscribers, per stream key
+type Broker struct {
+	mu      sync.Mutex
+	streams map[string]*stream
+}
+
+// NewBroker creates an empty broker and starts its janitor
+func NewBroker() *Broker {
+	b := &Broker{streams: make(map[string]*stream)}
+	go b.janitor()
+	return b
+}
+
+// Key scopes a stream ID (client upload ID or job ID) to its owner, so users
+// can only follow their own uploads
+func Key(clienteID, id string) string {
+	return clienteID + ":" + id
+}
+
+func (b *Broker) get(key string) *stream {
+	s, ok := b.streams[key]
+	if !ok {
+		now := time.Now()
+		s = &stream{start: now, updated: now, subs: make(map[chan Event]struct{})}
+		b.streams[key] = s
+	}
+	return s
+}
+
+// Publish appends ev to the stream and sends it to subscribers. A slow
+// subscriber misses events rather than blocking the upload.
+func (b *Broker) Publish(key string, ev Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := b.get(key)
+	now := time.Now()
+	ev.At = now
+	ev.ElapsedMs = now.Sub(s.start).Milliseconds()
+	s.updated = now
+	if len(s.events) < maxHistory {
+		s.events = append(s.events, ev)
+	}
+	for ch := range s.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+	if ev.Stage == StageDone {
+		s.finished = true
+		for ch := range s.subs {
+			close(ch)
+		}
+		s.subs = make(map[chan Event]struct{})
+	}
+}
+
+// Subscribe returns the events published so far and a channel with the ones
+// that follow. The channel is closed after StageDone; call cancel when the
+// client goes away. For a finished stream the channel is already closed.
+func (b *Broker) Subscribe(key string) (history []Event, ch <-chan Event, cancel func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := b.get(key)
+	history = append([]Event(nil), s.events...)
+	c := make(chan Event, 32)
+	if s.finished {
+		close(c)
+		return history, c, func() {}
+	}
+	s.subs[c] = struct{}{}
+	return history, c, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := s.subs[c]; ok {
+			delete(s.subs, c)
+			close(c)
+		}
+	}
+}
+
+func (b *Broker) janitor() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		b.mu.Lock()
+		for key, s := range b.streams {
+			age := time.Since(s.updated)
+			if (s.finished && age > finishedTTL) || (len(s.subs) == 0 && age > staleTTL) {
+				delete(b.streams, key)
+			}
+		}
+		b.mu.Unlock()
+	}
+}
+
+// Tracker publishes the events of one upload. A nil Tracker discards them, so
+// code paths without a listener need no checks.
+type Tracker struct {
+	broker *Broker
+	keys   []string
+}
+
+// Tracker returns a tracker publishing to every given stream (e.g. the
+// client's upload ID and the job ID of the same upload)
+func (b *Broker) Tracker(keys ...string) *Tracker {
+	return &Tracker{broker: b, keys: keys}
+}
+
+// Emit publishes a stage
+func (t *Tracker) Emit(ev Event) {
+	if t == nil {
+		return
+	}
+	for _, key := range t.keys {
+		t.broker.Publish(key, ev)
+	}
+}
+
+// Stage publishes a stage with a message
+func (t *Tracker) Stage(stage, message string) {
+	t.Emit(Event{Stage: stage, Message: message})
+}
+
+type ctxKey struct{}
+
+// WithTracker attaches t to ctx
+func WithTracker(ctx context.Context, t *Tracker) context.Context {
+	return context.WithValue(ctx, ctxKey{}, t)
+}
+
+// FromContext returns the tracker attached to ctx, or nil
+func FromContext(ctx context.Context) *Tracker {
+	t, _ := ctx.Value(ctxKey{}).(*Tracker)
+	return t
+}
@@ -0,0 +1,263 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/facturaIA/invoice-ocr-service/internal/db"
+	"github.com/facturaIA/invoice-ocr-service/internal/models"
+)
+
+// MonedaBase is the currency all DGII amounts are reported in
+const MonedaBase = "DOP"
+
+// ErrTasaNoDisponible is returned when no exchange rate exists for the currency/date
+var ErrTasaNoDisponible = errors.New("tasa de cambio no disponible")
+
+// ExchangeRateSource returns how many DOP one unit of moneda was worth on fecha
+type ExchangeRateSource interface {
+	Rate(ctx context.Context, moneda string, fecha time.Time) (decimal.Decimal, error)
+}
+
+// NewExchangeRateSource builds the source selected in config: "static" uses the
+// fixed rates from the config file, anything else reads the tasas_cambio table.
+func NewExchangeRateSource(cfg models.ExchangeRateConfig) ExchangeRateSource {
+	if cfg.Source == "static" {
+		rates := make(StaticExchangeRateSource, len(cfg.Static))
+		for moneda, rate := range cfg.Static {
+			rates[strings.ToUpper(moneda)] = decimal.NewFromFloat(rate)
+		}
+		return rates
+	}
+	maxDias := cfg.MaxDias
+	if maxDias <= 0 {
+		maxDias = 7
+	}
+	return &DBExchangeRateSource{MaxDias: maxDias}
+}
+
+// DBExchangeRateSource reads the Banco Central rates loaded in tasas_cambio.
+// The "venta" rate of the latest business day on or before the date is used.
+type DBExchangeRateSource struct {
+	MaxDias int
+}
+
+func (s *DBExchangeRateSource) Rate(ctx context.Context, moneda string, fecha time.Time) (decimal.Decimal, error) {
+	t, err := db.GetTasaCambio(ctx, moneda, fecha, s.MaxDias)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	if t == nil || t.Venta <= 0 {
+		return decimal.Zero, fmt.Errorf("%w: %s al %s", ErrTasaNoDisponible, moneda, fecha.Format("2006-01-02"))
+	}
+	return decimal.NewFromFloat(t.Venta), nil
+}
+
+// StaticExchangeRateSource returns a fixed rate per currency regardless of the
+// date. Meant for tests and environments without the rates table.
+type StaticExchangeRateSource map[string]decimal.Decimal
+
+func (s StaticExchangeRateSource) Rate(_ context.Context, moneda string, fecha time.Time) (decimal.Decimal, error) {
+	rate, ok := s[moneda]
+	if !ok || !rate.IsPositive() {
+		return decimal.Zero, fmt.Errorf("%w: %s al %s", ErrTasaNoDisponible, moneda, fecha.Format("2006-01-02"))
+	}
+	return rate, nil
+}
+
+// ConvertirADOP converts every monetary field of inv from inv.Moneda to DOP
+// using the rate of the invoice date. The original amounts are kept in
+// inv.MontosOriginales and the applied rate in inv.TasaCambio. DOP invoices
+// are left untouched (rate 1).
+func ConvertirADOP(ctx context.Context, src ExchangeRateSource, inv *models.Invoice) error {
+	moneda := strings.ToUpper(inv.Moneda)
+	if moneda == "" {
+		moneda = MonedaBase
+	}
+	inv.Moneda = moneda
+	if moneda == MonedaBase {
+		inv.TasaCambio = decimal.NewFromInt(1)
+		return nil
+	}
+	if inv.MontosOriginales != nil {
+		// Already converted (e.g. reprocessing a stored result)
+		return nil
+	}
+
+	fecha := inv.FechaFactura
+	if fecha.IsZero() {
+		fecha = inv.Date
+	}
+	if fecha.IsZero() {
+		fecha = time.Now()
+	}
+
+	rate, err := src.Rate(ctx, moneda, fecha)
+	if err != nil {
+		return err
+	}
+
+	inv.MontosOriginales = &models.MontosOriginales{
+		Moneda:                moneda,
+		Subtotal:              inv.Subtotal,
+		Descuento:             inv.Descuento,
+		MontoServicios:        inv.MontoServicios,
+		MontoBienes:           inv.MontoBienes,
+		ITBIS:                 inv.ITBIS,
+		ITBISRetenido:         inv.ITBISRetenido,
+		ITBISExento:           inv.ITBISExento,
+		ITBISProporcionalidad: inv.ITBISProporcionalidad,
+		ITBISCosto:            inv.ITBISCosto,
+		ISR:                   inv.ISR,
+		ISC:                   inv.ISC,
+		CDTMonto:              inv.CDTMonto,
+		Cargo911:              inv.Cargo911,
+		Propina:               inv.Propina,
+		OtrosImpuestos:        inv.OtrosImpuestos,
+		MontoNoFacturable:     inv.MontoNoFacturable,
+		Total:                 inv.Total,
+	}
+
+	conv := func(d decimal.Decimal) decimal.Decimal { return d.Mul(rate).Round(2) }
+	inv.Subtotal = conv(inv.Subtotal)
+	inv.Descuento = conv(inv.Descuento)
+	inv.MontoServicios = conv(inv.MontoServicios)
+	inv.MontoBienes = conv(inv.MontoBienes)
+	inv.ITBIS = conv(inv.ITBIS)
+	inv.ITBISRetenido = conv(inv.ITBISRetenido)
+	inv.ITBISExento = conv(inv.ITBISExento)
+	inv.ITBISProporcionalidad = conv(inv.ITBISProporcionalidad)
+	inv.ITBISCosto = conv(inv.ITBISCosto)
+	inv.ISR = conv(inv.ISR)
+	inv.ISC = conv(inv.ISC)
+	inv.CDTMonto = conv(inv.CDTMonto)
+	inv.Cargo911 = conv(inv.Cargo911)
+	inv.Propina = conv(inv.Propina)
+	inv.OtrosImpuestos = conv(inv.OtrosImpuestos)
+	inv.MontoNoFacturable = conv(inv.MontoNoFacturable)
+	inv.Total = conv(inv.Total)
+	inv.Tax = inv.ITBIS
+	inv.TasaCambio = rate
+	return nil
+}
+
+// ParseTasasBCRD reads the Banco Central exchange-rate CSV. Two layouts are
+// accepted, matched by header name (case-insensitive):
+//
+//	fecha,compra,venta[,moneda]        fecha as YYYY-MM-DD or DD/MM/YYYY
+//	año,mes,día,compra,venta[,moneda]  mes as number or Spanish name (Ene, Feb...)
+//
+// Rows without a moneda column use monedaDefault (the BCRD file is USD).
+func ParseTasasBCRD(r io.Reader, monedaDefault string) ([]db.TasaCambio, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("CSV sin cabecera: %w", err)
+	}
+	col := make(map[string]int, len(header))
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		h = strings.NewReplacer("ñ", "n", "í", "i", "á", "a").Replace(h)
+		col[h] = i
+	}
+	_, hasFecha := col["fecha"]
+	_, hasAno := col["ano"]
+	if _, ok := col["venta"]; !ok || (!hasFecha && !hasAno) {
+		return nil, errors.New("CSV debe tener columnas fecha (o año/mes/día) y venta")
+	}
+
+	get := func(rec []string, name string) string {
+		if i, ok := col[name]; ok && i < len(rec) {
+			return strings.TrimSpace(rec[i])
+		}
+		return ""
+	}
+
+	var tasas []db.TasaCambio
+	for line := 2; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("línea %d: %w", line, err)
+		}
+
+		var fecha time.Time
+		if hasFecha {
+			fecha, err = parseFechaTasa(get(rec, "fecha"))
+		} else {
+			fecha, err = parseFechaPartes(get(rec, "ano"), get(rec, "mes"), get(rec, "dia"))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("línea %d: %w", line, err)
+		}
+
+		venta, err := parseTasa(get(rec, "venta"))
+		if err != nil {
+			return nil, fmt.Errorf("línea %d: venta: %w", line, err)
+		}
+		compra := venta
+		if c := get(rec, "compra"); c != "" {
+			if compra, err = parseTasa(c); err != nil {
+				return nil, fmt.Errorf("línea %d: compra: %w", line, err)
+			}
+		}
+
+		moneda := strings.ToUpper(get(rec, "moneda"))
+		if moneda == "" {
+			moneda = strings.ToUpper(monedaDefault)
+		}
+
+		tasas = append(tasas, db.TasaCambio{
+			Moneda: moneda,
+			Fecha:  fecha,
+			Compra: compra,
+			Venta:  venta,
+			Fuente: "bcrd",
+		})
+	}
+	return tasas, nil
+}
+
+func parseTasa(s string) (float64, error) {
+	return strconv.ParseFloat(strings.ReplaceAll(s, ",", ""), 64)
+}
+
+func parseFechaTasa(s string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", "02/01/2006", "2/1/2006"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("fecha inválida %q", s)
+}
+
+var mesesBCRD = map[string]int{
+	"ene": 1, "feb": 2, "mar": 3, "abr": 4, "may": 5, "jun": 6,
+	"jul": 7, "ago": 8, "sep": 9, "oct": 10, "nov": 11, "dic": 12,
+}
+
+func parseFechaPartes(ano, mes, dia string) (time.Time, error) {
+	y, err1 := strconv.Atoi(ano)
+	d, err2 := strconv.Atoi(dia)
+	m, err3 := strconv.Atoi(mes)
+	if err3 != nil && len(mes) >= 3 {
+		m, err3 = mesesBCRD[strings.ToLower(mes[:3])], nil
+	}
+	if err1 != nil || err2 != nil || err3 != nil || m < 1 || m > 12 || d < 1 || d > 31 {
+		return time.Time{}, fmt.Errorf("fecha inválida %s-%s-%s", ano, mes, dia)
+	}
+	return time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC), nil
+}
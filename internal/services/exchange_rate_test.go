@@ -0,0 +1,102 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/facturaIA/invoice-ocr-service/internal/models"
+)
+
+func TestConvertirADOP(t *testing.T) {
+	src := StaticExchangeRateSource{"USD": decimal.RequireFromString("58.50")}
+	inv := &models.Invoice{
+		Moneda:       "usd",
+		FechaFactura: time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC),
+		Subtotal:     decimal.RequireFromString("100.00"),
+		ITBIS:        decimal.RequireFromString("18.00"),
+		Total:        decimal.RequireFromString("118.00"),
+	}
+
+	if err := ConvertirADOP(context.Background(), src, inv); err != nil {
+		t.Fatalf("ConvertirADOP: %v", err)
+	}
+	if inv.Moneda != "USD" || !inv.TasaCambio.Equal(decimal.RequireFromString("58.50")) {
+		t.Errorf("moneda %s tasa %s, want USD 58.50", inv.Moneda, inv.TasaCambio)
+	}
+	for name, got := range map[string]decimal.Decimal{"subtotal": inv.Subtotal, "itbis": inv.ITBIS, "total": inv.Total, "tax": inv.Tax} {
+		want := map[string]string{"subtotal": "5850", "itbis": "1053", "total": "6903", "tax": "1053"}[name]
+		if !got.Equal(decimal.RequireFromString(want)) {
+			t.Errorf("%s = %s, want %s", name, got, want)
+		}
+	}
+	if inv.MontosOriginales == nil || !inv.MontosOriginales.Total.Equal(decimal.RequireFromString("118")) {
+		t.Fatalf("montos originales = %+v", inv.MontosOriginales)
+	}
+
+	// A converted invoice is not converted twice
+	if err := ConvertirADOP(context.Background(), src, inv); err != nil || !inv.Total.Equal(decimal.RequireFromString("6903")) {
+		t.Errorf("second conversion: total %s, err %v", inv.Total, err)
+	}
+}
+
+func TestConvertirADOPDOPAndMissingRate(t *testing.T) {
+	src := StaticExchangeRateSource{}
+
+	dop := &models.Invoice{Total: decimal.RequireFromString("500")}
+	if err := ConvertirADOP(context.Background(), src, dop); err != nil {
+		t.Fatalf("DOP: %v", err)
+	}
+	if dop.Moneda != MonedaBase || !dop.TasaCambio.Equal(decimal.NewFromInt(1)) || dop.MontosOriginales != nil {
+		t.Errorf("DOP invoice changed: %s %s %+v", dop.Moneda, dop.TasaCambio, dop.MontosOriginales)
+	}
+
+	eur := &models.Invoice{Moneda: "EUR", Total: decimal.RequireFromString("10")}
+	err := ConvertirADOP(context.Background(), src, eur)
+	if !errors.Is(err, ErrTasaNoDisponible) {
+		t.Fatalf("err = %v, want ErrTasaNoDisponible", err)
+	}
+	if !eur.Total.Equal(decimal.RequireFromString("10")) || eur.MontosOriginales != nil {
+		t.Errorf("unconverted invoice changed: total %s, originales %+v", eur.Total, eur.MontosOriginales)
+	}
+}
+
+func TestParseTasasBCRD(t *testing.T) {
+	tasas, err := ParseTasasBCRD(strings.NewReader("\ufeffFecha,Compra,Venta\n2026-03-10,58.10,58.50\n11/03/2026,\"58.20\",\"1,058.60\"\n"), "usd")
+	if err != nil {
+		t.Fatalf("ParseTasasBCRD: %v", err)
+	}
+	if len(tasas) != 2 {
+		t.Fatalf("got %d tasas, want 2", len(tasas))
+	}
+	if tasas[0].Moneda != "USD" || tasas[0].Compra != 58.10 || tasas[0].Venta != 58.50 || tasas[0].Fuente != "bcrd" {
+		t.Errorf("tasa 0 = %+v", tasas[0])
+	}
+	if !tasas[1].Fecha.Equal(time.Date(2026, 3, 11, 0, 0, 0, 0, time.UTC)) || tasas[1].Venta != 1058.60 {
+		t.Errorf("tasa 1 = %+v", tasas[1])
+	}
+
+	tasas, err = ParseTasasBCRD(strings.NewReader("Año,Mes,Día,Venta,Moneda\n2026,Feb,3,64.1,eur\n2026,12,31,65,EUR\n"), "USD")
+	if err != nil {
+		t.Fatalf("ParseTasasBCRD partes: %v", err)
+	}
+	if len(tasas) != 2 || tasas[0].Moneda != "EUR" || tasas[0].Compra != 64.1 ||
+		!tasas[0].Fecha.Equal(time.Date(2026, 2, 3, 0, 0, 0, 0, time.UTC)) || tasas[1].Fecha.Month() != time.December {
+		t.Errorf("tasas = %+v", tasas)
+	}
+
+	for _, bad := range []string{
+		"fecha,compra\n2026-03-10,58\n",
+		"fecha,venta\n2026-13-40,58\n",
+		"fecha,venta\n2026-03-10,abc\n",
+		"ano,mes,dia,venta\n2026,Xyz,1,58\n",
+	} {
+		if _, err := ParseTasasBCRD(strings.NewReader(bad), "USD"); err == nil {
+			t.Errorf("ParseTasasBCRD(%q) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"github.com/facturaIA/invoice-ocr-service/internal/models"
+)
+
+// InvoiceInputFromExtraction builds the TaxValidator input of an invoice
+// extracted by the AI
+func InvoiceInputFromExtraction(invoice *models.Invoice) *InvoiceInput {
+	// Calcular montoServicios/montoBienes con fallback al subtotal
+	montoServicios := invoice.MontoServicios.InexactFloat64()
+	montoBienes := invoice.MontoBienes.InexactFloat64()
+	// Fallback: si la IA no separó servicios/bienes, usar subtotal
+	if montoServicios == 0 && montoBienes == 0 {
+		montoServicios = invoice.Subtotal.InexactFloat64()
+	}
+
+	// FechaPago: solo si no es zero
+	fechaPagoStr := ""
+	if !invoice.FechaPago.IsZero() {
+		fechaPagoStr = invoice.FechaPago.Format("2006-01-02")
+	}
+
+	// NCFVencimiento: solo si no es zero
+	ncfVencimientoStr := ""
+	if !invoice.FechaVencimiento.IsZero() {
+		ncfVencimientoStr = invoice.FechaVencimiento.Format("2006-01-02")
+	}
+
+	return &InvoiceInput{
+		MontoServicios:          montoServicios,
+		MontoBienes:             montoBienes,
+		Descuento:               invoice.Descuento.InexactFloat64(),
+		ITBISFacturado:          invoice.ITBIS.InexactFloat64(),
+		ITBISTasa:               invoice.ITBISTasa.InexactFloat64(),
+		ITBISExento:             invoice.ITBISExento.InexactFloat64(),
+		ITBISRetenido:           invoice.ITBISRetenido.InexactFloat64(),
+		ITBISProporcionalidad:   invoice.ITBISProporcionalidad.InexactFloat64(),
+		ITBISCosto:              invoice.ITBISCosto.InexactFloat64(),
+		ISCMonto:                invoice.ISC.InexactFloat64(),
+		ISCCategoria:            invoice.ISCCategoria,
+		CDTMonto:                invoice.CDTMonto.InexactFloat64(),
+		Cargo911:                invoice.Cargo911.InexactFloat64(),
+		PropinaLegal:            invoice.Propina.InexactFloat64(),
+		OtrosImpuestos:          invoice.OtrosImpuestos.InexactFloat64(),
+		MontoNoFacturable:       invoice.MontoNoFacturable.InexactFloat64(),
+		RetencionISRTipo:        invoice.RetencionISRTipo,
+		RetencionISRMonto:       invoice.ISR.InexactFloat64(),
+		TotalFactura:            invoice.Total.InexactFloat64(),
+		NCF:                     invoice.NCF,
+		NCFModifica:             invoice.NCFModifica,
+		TipoNCF:                 invoice.TipoNCF,
+		ITBISRetenidoPorcentaje: invoice.ITBISRetenidoPorcentaje,
+		FechaPago:               fechaPagoStr,
+		NCFVencimiento:          ncfVencimientoStr,
+	}
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"sort"
+
+	"github.com/facturaIA/invoice-ocr-service/internal/db"
+)
+
+// tiposRetencionISR are the DGII RetencionISRTipo codes (same as validateISRRate)
+var tiposRetencionISR = map[int]string{
+	1: "Alquileres",
+	2: "Honorarios por servicios independientes",
+	3: "Comisiones",
+	4: "Intereses pagados a personas físicas",
+	5: "Dividendos",
+	6: "Premios",
+	7: "Transferencias inmobiliarias",
+	8: "Otras retenciones",
+}
+
+// IR17GrupoISR aggregates ISR retentions of one RetencionISRTipo
+type IR17GrupoISR struct {
+	Tipo        int     `json:"tipo"`
+	Descripcion string  `json:"descripcion"`
+	Cantidad    int     `json:"cantidad"`
+	MontoBase   float64 `json:"monto_base"`
+	Retenido    float64 `json:"retenido"`
+}
+
+// IR17GrupoITBIS aggregates ITBIS retentions of one percentage (30 or 100)
+type IR17GrupoITBIS struct {
+	Porcentaje int     `json:"porcentaje"`
+	Cantidad   int     `json:"cantidad"`
+	Retenido   float64 `json:"retenido"`
+}
+
+// IR17Alerta flags an invoice whose retention cannot be declared as-is
+type IR17Alerta struct {
+	FacturaID string `json:"factura_id"`
+	NCF       string `json:"ncf"`
+	EmisorRNC string `json:"emisor_rnc"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+}
+
+// IR17Report is the computed IR-17 for an RNC and period
+type IR17Report struct {
+	RNC               string           `json:"rnc"`
+	Periodo           string           `json:"periodo"`
+	ISR               []IR17GrupoISR   `json:"isr"`
+	ITBIS             []IR17GrupoITBIS `json:"itbis"`
+	TotalISR          float64          `json:"total_isr"`
+	TotalITBIS        float64          `json:"total_itbis"`
+	TotalRetenido     float64          `json:"total_retenido"`
+	FacturasIncluidas int              `json:"facturas_incluidas"`
+	Alertas           []IR17Alerta     `json:"alertas"`
+}
+
+// CalcularIR17 groups the retentions paid in the period: ISR by RetencionISRTipo
+// and ITBIS by retention percentage. Invoices with retentions but no fecha_pago
+// are left out of the totals and reported in Alertas, since the IR-17 period is
+// determined by the payment date.
+func CalcularIR17(rnc, periodo string, retenciones []db.IR17Retencion) *IR17Report {
+	rep := &IR17Report{
+		RNC:     rnc,
+		Periodo: periodo,
+		ISR:     []IR17GrupoISR{},
+		ITBIS:   []IR17GrupoITBIS{},
+		Alertas: []IR17Alerta{},
+	}
+
+	isrGrupos := make(map[int]*IR17GrupoISR)
+	itbisGrupos := make(map[int]*IR17GrupoITBIS)
+
+	for _, ret := range retenciones {
+		alerta := func(code, msg string) {
+			rep.Alertas = append(rep.Alertas, IR17Alerta{
+				FacturaID: ret.ID,
+				NCF:       ret.NCF,
+				EmisorRNC: ret.EmisorRNC,
+				Code:      code,
+				Message:   msg,
+			})
+		}
+
+		if ret.FechaPago == nil || ret.FechaPago.IsZero() {
+			alerta("missing_payment_date", "Factura con retenciones sin fecha de pago; no se incluye en el IR-17")
+			continue
+		}
+		rep.FacturasIncluidas++
+
+		if ret.ISR > 0 {
+			tipo := 0
+			if ret.RetencionISRTipo != nil {
+				tipo = *ret.RetencionISRTipo
+			}
+			desc, ok := tiposRetencionISR[tipo]
+			if !ok {
+				alerta("missing_retencion_tipo", "Retención ISR sin tipo válido (1-8)")
+				desc = "Sin tipo"
+				tipo = 0
+			}
+			g := isrGrupos[tipo]
+			if g == nil {
+				g = &IR17GrupoISR{Tipo: tipo, Descripcion: desc}
+				isrGrupos[tipo] = g
+			}
+			g.Cantidad++
+			g.MontoBase += ret.MontoServicios + ret.MontoBienes
+			g.Retenido += ret.ISR
+			rep.TotalISR += ret.ISR
+		}
+
+		if ret.ITBISRetenido > 0 {
+			pct := ret.ITBISRetenidoPorcentaje
+			if pct != 30 && pct != 100 {
+				alerta("itbis_retenido_porcentaje_invalido", "ITBIS retenido debe ser 30% o 100%")
+				pct = 0
+			}
+			g := itbisGrupos[pct]
+			if g == nil {
+				g = &IR17GrupoITBIS{Porcentaje: pct}
+				itbisGrupos[pct] = g
+			}
+			g.Cantidad++
+			g.Retenido += ret.ITBISRetenido
+			rep.TotalITBIS += ret.ITBISRetenido
+		}
+	}
+
+	for _, g := range isrGrupos {
+		g.MontoBase = round2(g.MontoBase)
+		g.Retenido = round2(g.Retenido)
+		rep.ISR = append(rep.ISR, *g)
+	}
+	sort.Slice(rep.ISR, func(i, j int) bool { return rep.ISR[i].Tipo < rep.ISR[j].Tipo })
+
+	for _, g := range itbisGrupos {
+		g.Retenido = round2(g.Retenido)
+		rep.ITBIS = append(rep.ITBIS, *g)
+	}
+	sort.Slice(rep.ITBIS, func(i, j int) bool { return rep.ITBIS[i].Porcentaje < rep.ITBIS[j].Porcentaje })
+
+	rep.TotalISR = round2(rep.TotalISR)
+	rep.TotalITBIS = round2(rep.TotalITBIS)
+	rep.TotalRetenido = round2(rep.TotalISR + rep.TotalITBIS)
+	return rep
+}
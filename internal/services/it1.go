@@ -0,0 +1,180 @@
+package services
+
+import (
+	"math"
+
+	"github.com/facturaIA/invoice-ocr-service/internal/db"
+)
+
+// IT1Linea is one line of the IT-1 worksheet
+type IT1Linea struct {
+	Linea    int     `json:"linea"`
+	Seccion  string  `json:"seccion"`
+	Concepto string  `json:"concepto"`
+	Monto    float64 `json:"monto"`
+}
+
+// IT1Discrepancia reports a mismatch between the IT-1 and the generated 606
+type IT1Discrepancia struct {
+	Campo    string  `json:"campo"`
+	IT1      float64 `json:"it1"`
+	Envio606 float64 `json:"envio_606"`
+	Message  string  `json:"message"`
+}
+
+// IT1Worksheet is the computed IT-1 (declaración mensual de ITBIS) for an RNC and period
+type IT1Worksheet struct {
+	RNC     string `json:"rnc"`
+	Periodo string `json:"periodo"`
+
+	// Ventas (607)
+	RegistrosVentas       int     `json:"registros_ventas"`
+	TotalOperaciones      float64 `json:"total_operaciones"`
+	OperacionesExentas    float64 `json:"operaciones_exentas"`
+	OperacionesGravadas   float64 `json:"operaciones_gravadas"`
+	ITBISFacturadoVentas  float64 `json:"itbis_facturado_ventas"`
+	ITBISRetenidoTerceros float64 `json:"itbis_retenido_por_terceros"`
+
+	// Compras (606)
+	RegistrosCompras       int     `json:"registros_compras"`
+	TotalCompras           float64 `json:"total_compras"`
+	ComprasExentas         float64 `json:"compras_exentas"`
+	ComprasGravadas        float64 `json:"compras_gravadas"`
+	ITBISFacturadoCompras  float64 `json:"itbis_facturado_compras"`
+	ITBISProporcionalidad  float64 `json:"itbis_proporcionalidad"`
+	ITBISCosto             float64 `json:"itbis_costo"`
+	ITBISRetenidoATerceros float64 `json:"itbis_retenido_a_terceros"`
+	ITBISDeducible         float64 `json:"itbis_deducible"`
+	ITBISPorAdelantar606   float64 `json:"itbis_por_adelantar_606"`
+
+	// Liquidación
+	ImpuestoAPagar float64 `json:"impuesto_a_pagar"`
+	SaldoAFavor    float64 `json:"saldo_a_favor"`
+	TotalAPagar    float64 `json:"total_a_pagar"`
+
+	Lineas         []IT1Linea        `json:"lineas"`
+	Discrepancias  []IT1Discrepancia `json:"discrepancias"`
+	TieneVentas607 bool              `json:"tiene_ventas_607"`
+}
+
+// CalcularIT1 builds the IT-1 worksheet from the same 606 rows used for the
+// Formato 606 plus the 607 (ventas) rows when present.
+//
+// ITBIS deducible is the full ITBIS paid in purchases less proporcionalidad
+// and costo, as in the IT-1 form. ITBIS retenido a proveedores is not
+// deducted there: it is added once, to the total a pagar. The 606 "ITBIS por
+// adelantar" (which does deduct the retención) is kept apart to reconcile
+// with the TXT sent to DGII.
+func CalcularIT1(rnc, periodo string, compras []db.Formato606Invoice, ventas []db.Formato607Invoice) *IT1Worksheet {
+	ws := &IT1Worksheet{
+		RNC:              rnc,
+		Periodo:          periodo,
+		RegistrosVentas:  len(ventas),
+		RegistrosCompras: len(compras),
+		TieneVentas607:   len(ventas) > 0,
+		Discrepancias:    []IT1Discrepancia{},
+	}
+
+	for _, v := range ventas {
+		monto := v.MontoServicios + v.MontoBienes
+		if monto == 0 {
+			monto = v.Subtotal
+		}
+		ws.TotalOperaciones += monto
+		ws.OperacionesExentas += v.ITBISExento
+		ws.ITBISFacturadoVentas += v.ITBIS
+		ws.ITBISRetenidoTerceros += v.ITBISRetenido
+	}
+	ws.OperacionesGravadas = math.Max(ws.TotalOperaciones-ws.OperacionesExentas, 0)
+
+	for _, c := range compras {
+		monto := c.MontoServicios + c.MontoBienes
+		if monto == 0 {
+			monto = c.Subtotal
+		}
+		ws.TotalCompras += monto
+		ws.ComprasExentas += c.ITBISExento
+		ws.ITBISFacturadoCompras += c.ITBIS
+		ws.ITBISProporcionalidad += c.ITBISProporcionalidad
+		ws.ITBISCosto += c.ITBISCosto
+		ws.ITBISRetenidoATerceros += c.ITBISRetenido
+		ws.ITBISDeducible += math.Max(c.ITBIS-c.ITBISProporcionalidad-c.ITBISCosto, 0)
+		ws.ITBISPorAdelantar606 += math.Max(c.ITBIS-c.ITBISRetenido-c.ITBISProporcionalidad-c.ITBISCosto, 0)
+	}
+	ws.ComprasGravadas = math.Max(ws.TotalCompras-ws.ComprasExentas, 0)
+
+	// Liquidación: ITBIS cobrado - ITBIS deducible - retenido por terceros.
+	// ITBIS retenido a proveedores is always payable on top of the difference.
+	diferencia := ws.ITBISFacturadoVentas - ws.ITBISDeducible - ws.ITBISRetenidoTerceros
+	if diferencia > 0 {
+		ws.ImpuestoAPagar = diferencia
+	} else {
+		ws.SaldoAFavor = -diferencia
+	}
+	ws.TotalAPagar = ws.ImpuestoAPagar + ws.ITBISRetenidoATerceros
+
+	roundIT1(ws)
+	ws.Lineas = buildIT1Lineas(ws)
+	return ws
+}
+
+// CompararCon606 cross-checks the worksheet against the stored totals of the
+// generated 606 envío and records every mismatch above one peso.
+func (ws *IT1Worksheet) CompararCon606(envio *db.Envio606Resumen) {
+	if envio == nil {
+		return
+	}
+	check := func(campo string, it1, e606 float64, msg string) {
+		if math.Abs(it1-e606) > 1 {
+			ws.Discrepancias = append(ws.Discrepancias, IT1Discrepancia{
+				Campo:    campo,
+				IT1:      round2(it1),
+				Envio606: round2(e606),
+				Message:  msg,
+			})
+		}
+	}
+	check("registros_compras", float64(ws.RegistrosCompras), float64(envio.CantidadRegistros),
+		"Cantidad de facturas distinta a la del 606 generado; regenere el 606")
+	check("total_compras", ws.TotalCompras, envio.TotalMontoFacturado,
+		"Total de compras no coincide con el 606 generado")
+	check("itbis_facturado_compras", ws.ITBISFacturadoCompras, envio.TotalITBISFacturado,
+		"ITBIS facturado en compras no coincide con el 606 generado")
+	check("itbis_por_adelantar_606", ws.ITBISPorAdelantar606, envio.TotalITBISPorAdelantar,
+		"ITBIS por adelantar no coincide con el 606 generado")
+}
+
+func roundIT1(ws *IT1Worksheet) {
+	for _, f := range []*float64{
+		&ws.TotalOperaciones, &ws.OperacionesExentas, &ws.OperacionesGravadas,
+		&ws.ITBISFacturadoVentas, &ws.ITBISRetenidoTerceros,
+		&ws.TotalCompras, &ws.ComprasExentas, &ws.ComprasGravadas, &ws.ITBISFacturadoCompras,
+		&ws.ITBISProporcionalidad, &ws.ITBISCosto, &ws.ITBISRetenidoATerceros, &ws.ITBISDeducible,
+		&ws.ITBISPorAdelantar606,
+		&ws.ImpuestoAPagar, &ws.SaldoAFavor, &ws.TotalAPagar,
+	} {
+		*f = round2(*f)
+	}
+}
+
+func buildIT1Lineas(ws *IT1Worksheet) []IT1Linea {
+	return []IT1Linea{
+		{1, "I. Ingresos por operaciones", "Total de operaciones del período", ws.TotalOperaciones},
+		{2, "I. Ingresos por operaciones", "Operaciones exentas", ws.OperacionesExentas},
+		{3, "I. Ingresos por operaciones", "Total operaciones gravadas", ws.OperacionesGravadas},
+		{4, "II. Liquidación", "ITBIS facturado (cobrado) en ventas", ws.ITBISFacturadoVentas},
+		{5, "II. Liquidación", "ITBIS pagado en compras locales", ws.ITBISFacturadoCompras},
+		{6, "II. Liquidación", "Menos: ITBIS proporcionalidad (Art. 349)", ws.ITBISProporcionalidad},
+		{7, "II. Liquidación", "Menos: ITBIS llevado al costo", ws.ITBISCosto},
+		{8, "II. Liquidación", "ITBIS deducible", ws.ITBISDeducible},
+		{9, "II. Liquidación", "ITBIS retenido por terceros en ventas", ws.ITBISRetenidoTerceros},
+		{10, "II. Liquidación", "Impuesto a pagar", ws.ImpuestoAPagar},
+		{11, "II. Liquidación", "Saldo a favor", ws.SaldoAFavor},
+		{12, "III. Retenciones", "ITBIS retenido a proveedores a pagar", ws.ITBISRetenidoATerceros},
+		{13, "IV. Total", "Total a pagar", ws.TotalAPagar},
+		{14, "Referencia 606", "Compras del período (monto facturado)", ws.TotalCompras},
+		{15, "Referencia 606", "Compras exentas", ws.ComprasExentas},
+		{16, "Referencia 606", "Compras gravadas", ws.ComprasGravadas},
+		{17, "Referencia 606", "ITBIS por adelantar (606)", ws.ITBISPorAdelantar606},
+	}
+}
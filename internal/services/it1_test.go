@@ -0,0 +1,104 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/facturaIA/invoice-ocr-service/internal/db"
+)
+
+func TestCalcularIT1(t *testing.T) {
+	// Servicios with 30% ITBIS retained, a purchase with ITBIS llevado al
+	// costo and an exempt one
+	compras := []db.Formato606Invoice{
+		{MontoServicios: 50000, ITBIS: 9000, ITBISRetenido: 2700},
+		{MontoBienes: 20000, ITBIS: 3600, ITBISCosto: 600},
+		{Subtotal: 10000, ITBISExento: 10000},
+	}
+
+	cases := []struct {
+		name   string
+		ventas []db.Formato607Invoice
+		want   IT1Worksheet
+	}{
+		{
+			name:   "impuesto a pagar",
+			ventas: []db.Formato607Invoice{{MontoServicios: 100000, ITBIS: 18000, ITBISRetenido: 5400}},
+			want: IT1Worksheet{
+				TotalOperaciones: 100000, OperacionesGravadas: 100000,
+				ITBISFacturadoVentas: 18000, ITBISRetenidoTerceros: 5400,
+				// 18,000 - 12,000 - 5,400
+				ImpuestoAPagar: 600,
+				// 600 + 2,700 retenido a proveedores, counted once
+				TotalAPagar: 3300,
+			},
+		},
+		{
+			name:   "saldo a favor",
+			ventas: []db.Formato607Invoice{{Subtotal: 10000, ITBIS: 1800}},
+			want: IT1Worksheet{
+				TotalOperaciones: 10000, OperacionesGravadas: 10000,
+				ITBISFacturadoVentas: 1800,
+				SaldoAFavor:          10200,
+				TotalAPagar:          2700,
+			},
+		},
+		{
+			name:   "ventas exentas",
+			ventas: []db.Formato607Invoice{{MontoBienes: 30000, ITBISExento: 10000, ITBIS: 3600}},
+			want: IT1Worksheet{
+				TotalOperaciones: 30000, OperacionesExentas: 10000, OperacionesGravadas: 20000,
+				ITBISFacturadoVentas: 3600,
+				SaldoAFavor:          8400,
+				TotalAPagar:          2700,
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		ws := CalcularIT1("101000001", "202503", compras, tc.ventas)
+
+		// Compras are the same in every case
+		if ws.TotalCompras != 80000 || ws.ComprasExentas != 10000 || ws.ComprasGravadas != 70000 ||
+			ws.ITBISFacturadoCompras != 12600 || ws.ITBISCosto != 600 || ws.ITBISRetenidoATerceros != 2700 {
+			t.Errorf("%s: compras = %+v", tc.name, ws)
+		}
+		// Full ITBIS paid less costo; the 606 por adelantar also deducts the retención
+		if ws.ITBISDeducible != 12000 || ws.ITBISPorAdelantar606 != 9300 {
+			t.Errorf("%s: deducible %.2f, por adelantar 606 %.2f; want 12000, 9300", tc.name, ws.ITBISDeducible, ws.ITBISPorAdelantar606)
+		}
+
+		got := []float64{ws.TotalOperaciones, ws.OperacionesExentas, ws.OperacionesGravadas,
+			ws.ITBISFacturadoVentas, ws.ITBISRetenidoTerceros, ws.ImpuestoAPagar, ws.SaldoAFavor, ws.TotalAPagar}
+		want := []float64{tc.want.TotalOperaciones, tc.want.OperacionesExentas, tc.want.OperacionesGravadas,
+			tc.want.ITBISFacturadoVentas, tc.want.ITBISRetenidoTerceros, tc.want.ImpuestoAPagar, tc.want.SaldoAFavor, tc.want.TotalAPagar}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Errorf("%s: got %v, want %v", tc.name, got, want)
+				break
+			}
+		}
+		if ws.Lineas[len(ws.Lineas)-1].Monto != ws.ITBISPorAdelantar606 {
+			t.Errorf("%s: last line = %+v", tc.name, ws.Lineas[len(ws.Lineas)-1])
+		}
+	}
+}
+
+func TestCompararCon606(t *testing.T) {
+	compras := []db.Formato606Invoice{{MontoServicios: 50000, ITBIS: 9000, ITBISRetenido: 2700}}
+	ws := CalcularIT1("101000001", "202503", compras, nil)
+	ws.CompararCon606(&db.Envio606Resumen{
+		CantidadRegistros:      1,
+		TotalMontoFacturado:    50000,
+		TotalITBISFacturado:    9000,
+		TotalITBISPorAdelantar: 6300,
+	})
+	if len(ws.Discrepancias) != 0 {
+		t.Errorf("reconciled 606 reported %+v", ws.Discrepancias)
+	}
+
+	ws = CalcularIT1("101000001", "202503", compras, nil)
+	ws.CompararCon606(&db.Envio606Resumen{CantidadRegistros: 3, TotalMontoFacturado: 50000, TotalITBISFacturado: 9000, TotalITBISPorAdelantar: 9000})
+	if len(ws.Discrepancias) != 2 || ws.Discrepancias[0].Campo != "registros_compras" || ws.Discrepancias[1].Campo != "itbis_por_adelantar_606" {
+		t.Errorf("discrepancias = %+v", ws.Discrepancias)
+	}
+}
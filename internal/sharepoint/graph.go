@@ -0,0 +1,240 @@
+package sharepoint
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultGraphBaseURL = "https://graph.microsoft.com/v1.0"
+	// Graph accepts a single PUT up to 4 MB; larger files need an upload session
+	simpleUploadLimit = 4 * 1024 * 1024
+	// Upload session chunks must be multiples of 320 KiB
+	uploadChunkSize = 10 * 320 * 1024
+)
+
+// DriveItem is the part of a Graph driveItem the worker keeps
+type DriveItem struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	WebURL string `json:"webUrl"`
+}
+
+// GraphClient uploads files to a SharePoint drive. drivePath is relative to
+// the drive root, e.g. "Facturas/101000001/2025/03/factura.jpg".
+type GraphClient interface {
+	UploadFile(ctx context.Context, drivePath string, r io.Reader, size int64, contentType string) (*DriveItem, error)
+}
+
+// GraphError is a non-2xx answer from Graph or the token endpoint
+type GraphError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *GraphError) Error() string {
+	return fmt.Sprintf("graph: HTTP %d: %s", e.StatusCode, e.Body)
+}
+
+// HTTPGraphClient talks to Microsoft Graph with the OAuth2 client credentials
+// flow. BaseURL and TokenURL can point at a local fake server.
+type HTTPGraphClient struct {
+	baseURL      string
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	driveID      string
+	http         *http.Client
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// NewHTTPGraphClient creates a Graph client for one drive. Empty baseURL and
+// tokenURL use the public Microsoft endpoints for tenantID.
+func NewHTTPGraphClient(tenantID, clientID, clientSecret, driveID, baseURL, tokenURL string) *HTTPGraphClient {
+	if baseURL == "" {
+		baseURL = defaultGraphBaseURL
+	}
+	if tokenURL == "" {
+		tokenURL = fmt.Sprintf("https://login.microsoftonline.com/%s/oauth2/v2.0/token", url.PathEscape(tenantID))
+	}
+	return &HTTPGraphClient{
+		baseURL:      strings.TrimRight(baseURL, "/"),
+		tokenURL:     tokenURL,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		driveID:      driveID,
+		http:         &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+// UploadFile streams r to drivePath, replacing any existing file
+func (c *HTTPGraphClient) UploadFile(ctx context.Context, drivePath string, r io.Reader, size int64, contentType string) (*DriveItem, error) {
+	if size <= simpleUploadLimit {
+		return c.simpleUpload(ctx, drivePath, r, size, contentType)
+	}
+	return c.sessionUpload(ctx, drivePath, r, size)
+}
+
+func (c *HTTPGraphClient) itemURL(drivePath, action string) string {
+	segments := strings.Split(strings.Trim(drivePath, "/"), "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return fmt.Sprintf("%s/drives/%s/root:/%s:/%s", c.baseURL, url.PathEscape(c.driveID), strings.Join(segments, "/"), action)
+}
+
+func (c *HTTPGraphClient) simpleUpload(ctx context.Context, drivePath string, r io.Reader, size int64, contentType string) (*DriveItem, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.itemURL(drivePath, "content"), r)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	var item DriveItem
+	if err := c.do(req, &item); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+func (c *HTTPGraphClient) sessionUpload(ctx context.Context, drivePath string, r io.Reader, size int64) (*DriveItem, error) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"item": map[string]string{"@microsoft.graph.conflictBehavior": "replace"},
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.itemURL(drivePath, "createUploadSession"), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var session struct {
+		UploadURL string `json:"uploadUrl"`
+	}
+	if err := c.do(req, &session); err != nil {
+		return nil, err
+	}
+
+	// The upload URL is pre-authenticated: chunks go without the bearer token
+	buf := make([]byte, uploadChunkSize)
+	var offset int64
+	for offset < size {
+		n, err := io.ReadFull(r, buf)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("reading file at offset %d: %w", offset, err)
+		}
+		chunk, err := http.NewRequestWithContext(ctx, http.MethodPut, session.UploadURL, bytes.NewReader(buf[:n]))
+		if err != nil {
+			return nil, err
+		}
+		chunk.ContentLength = int64(n)
+		chunk.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+int64(n)-1, size))
+
+		resp, err := c.http.Do(chunk)
+		if err != nil {
+			return nil, err
+		}
+		offset += int64(n)
+
+		if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated {
+			var item DriveItem
+			err := json.NewDecoder(resp.Body).Decode(&item)
+			resp.Body.Close()
+			return &item, err
+		}
+		if err := checkResponse(resp); err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+	}
+	return nil, fmt.Errorf("upload session ended without a drive item")
+}
+
+// do sends an authenticated request and decodes the JSON answer into out
+func (c *HTTPGraphClient) do(req *http.Request, out interface{}) error {
+	token, err := c.accessToken(req.Context())
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		if resp.StatusCode == http.StatusUnauthorized {
+			// Token revoked or expired early: fetch a new one next time
+			c.mu.Lock()
+			c.token = ""
+			c.mu.Unlock()
+		}
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 2048))
+	resp.Body.Close()
+	return &GraphError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+}
+
+// accessToken returns a cached app token, refreshing it a minute before expiry
+func (c *HTTPGraphClient) accessToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && time.Now().Before(c.tokenExpiry) {
+		return c.token, nil
+	}
+
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {c.clientID},
+		"client_secret": {c.clientSecret},
+		"scope":         {"https://graph.microsoft.com/.default"},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return "", err
+	}
+
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return "", fmt.Errorf("invalid token response: %w", err)
+	}
+	c.token = tok.AccessToken
+	c.tokenExpiry = time.Now().Add(time.Duration(tok.ExpiresIn)*time.Second - time.Minute)
+	return c.token, nil
+}
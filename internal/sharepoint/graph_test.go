@@ -0,0 +1,185 @@
+package sharepoint
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeGraph is a local stand-in for the token endpoint and the Graph drive
+type fakeGraph struct {
+	mu           sync.Mutex
+	tokens       int      // tokens issued
+	unauthorized int      // next N Graph calls answer 401
+	auth         []string // Authorization of each drive call
+	ranges       []string // Content-Range of each session chunk
+	chunkAuth    []string
+	received     bytes.Buffer
+	srv          *httptest.Server
+}
+
+func newFakeGraph(t *testing.T) *fakeGraph {
+	f := &fakeGraph{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("grant_type") != "client_credentials" || r.Form.Get("client_secret") != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		f.tokens++
+		n := f.tokens
+		f.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": fmt.Sprintf("tok-%d", n), "expires_in": 3600})
+	})
+	mux.HandleFunc("/graph/drives/drive-1/", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.auth = append(f.auth, r.Header.Get("Authorization"))
+		if f.unauthorized > 0 {
+			f.unauthorized--
+			http.Error(w, `{"error":{"code":"InvalidAuthenticationToken"}}`, http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == http.MethodPut && strings.HasSuffix(r.URL.Path, ":/content"):
+			f.received.Reset()
+			io.Copy(&f.received, r.Body)
+			json.NewEncoder(w).Encode(DriveItem{ID: "item-simple", Name: "factura.jpg", WebURL: "https://sp/simple"})
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, ":/createUploadSession"):
+			f.received.Reset()
+			json.NewEncoder(w).Encode(map[string]string{"uploadUrl": f.srv.URL + "/upload-session/1"})
+		default:
+			t.Errorf("unexpected drive call %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	mux.HandleFunc("/upload-session/1", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.ranges = append(f.ranges, r.Header.Get("Content-Range"))
+		f.chunkAuth = append(f.chunkAuth, r.Header.Get("Authorization"))
+		io.Copy(&f.received, r.Body)
+		if strings.HasSuffix(r.Header.Get("Content-Range"), fmt.Sprintf("-%d/%d", f.received.Len()-1, f.received.Len())) {
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(DriveItem{ID: "item-session", WebURL: "https://sp/session"})
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"nextExpectedRanges":[]}`))
+	})
+	f.srv = httptest.NewServer(mux)
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+func (f *fakeGraph) client() *HTTPGraphClient {
+	return NewHTTPGraphClient("tenant", "client", "secret", "drive-1", f.srv.URL+"/graph/", f.srv.URL+"/token")
+}
+
+func TestSimpleUploadCachesToken(t *testing.T) {
+	f := newFakeGraph(t)
+	c := f.client()
+
+	for i := 0; i < 2; i++ {
+		item, err := c.UploadFile(context.Background(), "Facturas/101000001/2025/03/factura.jpg", strings.NewReader("jpeg"), 4, "image/jpeg")
+		if err != nil {
+			t.Fatalf("UploadFile: %v", err)
+		}
+		if item.ID != "item-simple" || item.WebURL != "https://sp/simple" {
+			t.Errorf("item = %+v", item)
+		}
+	}
+	if f.tokens != 1 {
+		t.Errorf("issued %d tokens, want the first one reused", f.tokens)
+	}
+	if f.auth[0] != "Bearer tok-1" || f.auth[1] != "Bearer tok-1" {
+		t.Errorf("Authorization = %v", f.auth)
+	}
+	if f.received.String() != "jpeg" {
+		t.Errorf("uploaded %q", f.received.String())
+	}
+}
+
+func TestTokenRefreshedAfterUnauthorized(t *testing.T) {
+	f := newFakeGraph(t)
+	c := f.client()
+	f.unauthorized = 1
+
+	_, err := c.UploadFile(context.Background(), "a/b.jpg", strings.NewReader("x"), 1, "")
+	if gerr, ok := err.(*GraphError); !ok || gerr.StatusCode != http.StatusUnauthorized || !retryable(err) {
+		t.Fatalf("err = %v, want a retryable 401", err)
+	}
+	if _, err := c.UploadFile(context.Background(), "a/b.jpg", strings.NewReader("x"), 1, ""); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if f.tokens != 2 || f.auth[1] != "Bearer tok-2" {
+		t.Errorf("tokens = %d, Authorization = %v; want a new token after the 401", f.tokens, f.auth)
+	}
+}
+
+func TestLargeFileUsesUploadSession(t *testing.T) {
+	f := newFakeGraph(t)
+	c := f.client()
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), (simpleUploadLimit+1024*1024)/16)
+	size := int64(len(data))
+	item, err := c.UploadFile(context.Background(), "Facturas/x/2025/03/grande.pdf", bytes.NewReader(data), size, "application/pdf")
+	if err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+	if item.ID != "item-session" {
+		t.Errorf("item = %+v", item)
+	}
+	want := []string{
+		fmt.Sprintf("bytes 0-%d/%d", uploadChunkSize-1, size),
+		fmt.Sprintf("bytes %d-%d/%d", uploadChunkSize, size-1, size),
+	}
+	if strings.Join(f.ranges, ";") != strings.Join(want, ";") {
+		t.Errorf("Content-Range = %v, want %v", f.ranges, want)
+	}
+	for _, a := range f.chunkAuth {
+		if a != "" {
+			t.Errorf("chunk sent with Authorization %q, the upload URL is pre-authenticated", a)
+		}
+	}
+	if len(f.auth) != 1 || f.auth[0] != "Bearer tok-1" {
+		t.Errorf("createUploadSession Authorization = %v", f.auth)
+	}
+	if !bytes.Equal(f.received.Bytes(), data) {
+		t.Error("uploaded bytes differ from the file")
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{io.ErrUnexpectedEOF, true},
+		{&GraphError{StatusCode: 400}, false},
+		{&GraphError{StatusCode: 401}, true},
+		{&GraphError{StatusCode: 403}, true},
+		{&GraphError{StatusCode: 404}, false},
+		{&GraphError{StatusCode: 409}, true},
+		{&GraphError{StatusCode: 413}, false},
+		{&GraphError{StatusCode: 423}, true},
+		{&GraphError{StatusCode: 429}, true},
+		{&GraphError{StatusCode: 500}, true},
+		{&GraphError{StatusCode: 503}, true},
+		{fmt.Errorf("upload: %w", &GraphError{StatusCode: 400}), false},
+	}
+	for _, tc := range cases {
+		if got := retryable(tc.err); got != tc.want {
+			t.Errorf("retryable(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package sharepoint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/facturaIA/invoice-ocr-service/internal/db"
+	"github.com/facturaIA/invoice-ocr-service/internal/models"
+	"github.com/facturaIA/invoice-ocr-service/internal/storage"
+)
+
+const (
+	defaultRootFolder     = "Facturas"
+	defaultWorkers        = 2
+	defaultMaxAttempts    = 8
+	defaultBackoffSeconds = 60
+	maxBackoff            = 6 * time.Hour
+	pollInterval          = 15 * time.Second
+	uploadTimeout         = 5 * time.Minute
+	// Claimed rows are hidden from other workers for this long
+	claimLease = 2 * uploadTimeout
+)
+
+// invalidPathChars are characters SharePoint rejects in file and folder names
+var invalidPathChars = regexp.MustCompile(`["*:<>?/\\|#%]`)
+
+// DrivePath builds the destination of an invoice image:
+// {root}/{rnc}/{YYYY}/{MM}/{archivo}. The queue's rnc_cliente is used as the
+// client folder; fecha is the invoice date (the queue time when missing).
+func DrivePath(root, rncCliente string, fecha time.Time, archivoNombre string) string {
+	cliente := sanitize(rncCliente)
+	if cliente == "" {
+		cliente = "sin_rnc"
+	}
+	return path.Join(
+		strings.Trim(root, "/"),
+		cliente,
+		fmt.Sprintf("%04d", fecha.Year()),
+		fmt.Sprintf("%02d", int(fecha.Month())),
+		sanitize(archivoNombre),
+	)
+}
+
+func sanitize(s string) string {
+	return strings.TrimSpace(invalidPathChars.ReplaceAllString(s, "_"))
+}
+
+// Worker consumes sharepoint_sync_queue: claims due rows, streams each image
+// from MinIO to the Graph drive and records the outcome with backoff.
+type Worker struct {
+	graph       GraphClient
+	rootFolder  string
+	workers     int
+	maxAttempts int
+	backoff     time.Duration
+}
+
+// NewWorker creates a sync worker using graph for the uploads
+func NewWorker(graph GraphClient, cfg models.SharePointConfig) *Worker {
+	w := &Worker{
+		graph:       graph,
+		rootFolder:  cfg.RootFolder,
+		workers:     cfg.Workers,
+		maxAttempts: cfg.MaxAttempts,
+		backoff:     time.Duration(cfg.BackoffSeconds) * time.Second,
+	}
+	if w.rootFolder == "" {
+		w.rootFolder = defaultRootFolder
+	}
+	if w.workers <= 0 {
+		w.workers = defaultWorkers
+	}
+	if w.maxAttempts <= 0 {
+		w.maxAttempts = defaultMaxAttempts
+	}
+	if w.backoff <= 0 {
+		w.backoff = defaultBackoffSeconds * time.Second
+	}
+	return w
+}
+
+// Run polls the queue until ctx ends
+func (w *Worker) Run(ctx context.Context) {
+	log.Printf("[SharePoint Sync] Worker started (%d concurrent uploads)", w.workers)
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if db.Pool != nil && storage.Client != nil {
+			w.runBatch(ctx)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runBatch claims up to one row per worker slot and uploads them concurrently
+func (w *Worker) runBatch(ctx context.Context) {
+	items, err := db.ClaimSharePointSyncItems(ctx, w.workers, claimLease)
+	if err != nil {
+		log.Printf("[SharePoint Sync] Error claiming queue rows: %v", err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, it := range items {
+		wg.Add(1)
+		go func(it db.SharePointSyncItem) {
+			defer wg.Done()
+			w.sync(ctx, it)
+		}(it)
+	}
+	wg.Wait()
+}
+
+func (w *Worker) sync(ctx context.Context, it db.SharePointSyncItem) {
+	fecha := it.CreatedAt
+	if it.FechaFactura != nil && !it.FechaFactura.IsZero() {
+		fecha = *it.FechaFactura
+	}
+	nombre := it.ArchivoNombre
+	if nombre == "" {
+		nombre = path.Base(it.ArchivoURL)
+	}
+	drivePath := DrivePath(w.rootFolder, it.RNCCliente, fecha, nombre)
+
+	item, err := w.upload(ctx, it.ArchivoURL, drivePath)
+
+	// Record the outcome even if ctx was cancelled mid-upload
+	saveCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err == nil {
+		if err := db.MarkSharePointSynced(saveCtx, it.ID, drivePath, item.ID, item.WebURL); err != nil {
+			log.Printf("[SharePoint Sync] Error marking %s synced: %v", it.ID, err)
+		}
+		log.Printf("[SharePoint Sync] Factura %s → %s", it.FacturaID, drivePath)
+		return
+	}
+
+	next := w.nextAttempt(it.Attempts, err, time.Now())
+	if next != nil {
+		log.Printf("[SharePoint Sync] Factura %s falló (intento %d/%d): %v. Reintento %s", it.FacturaID, it.Attempts, w.maxAttempts, err, next.Format(time.RFC3339))
+	} else {
+		log.Printf("[SharePoint Sync] Factura %s falló definitivamente (intento %d/%d): %v", it.FacturaID, it.Attempts, w.maxAttempts, err)
+	}
+	if err := db.MarkSharePointRetry(saveCtx, it.ID, err.Error(), next); err != nil {
+		log.Printf("[SharePoint Sync] Error saving retry state of %s: %v", it.ID, err)
+	}
+}
+
+// nextAttempt returns when to retry an upload that failed with err on its
+// attempts-th try: the backoff doubles each attempt, up to maxBackoff. nil
+// means the row is failed for good (max attempts reached or not retryable).
+func (w *Worker) nextAttempt(attempts int, err error, now time.Time) *time.Time {
+	if attempts >= w.maxAttempts || !retryable(err) {
+		return nil
+	}
+	delay := w.backoff << (attempts - 1)
+	if delay <= 0 || delay > maxBackoff {
+		delay = maxBackoff
+	}
+	t := now.Add(delay)
+	return &t
+}
+
+func (w *Worker) upload(ctx context.Context, archivoURL, drivePath string) (*DriveItem, error) {
+	ctx, cancel := context.WithTimeout(ctx, uploadTimeout)
+	defer cancel()
+
+	r, size, contentType, err := storage.OpenImage(ctx, archivoURL)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return w.graph.UploadFile(ctx, drivePath, r, size, contentType)
+}
+
+// retryable reports whether a failed upload may succeed later. Graph 4xx
+// answers other than throttling/timeouts/auth won't change with a retry.
+func retryable(err error) bool {
+	var gerr *GraphError
+	if !errors.As(err, &gerr) {
+		return true
+	}
+	switch gerr.StatusCode {
+	case 401, 403, 408, 409, 423, 429:
+		return true
+	}
+	return gerr.StatusCode >= 500
+}
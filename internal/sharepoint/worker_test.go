@@ -0,0 +1,55 @@
+package sharepoint
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/facturaIA/invoice-ocr-service/internal/models"
+)
+
+func TestNextAttempt(t *testing.T) {
+	w := NewWorker(nil, models.SharePointConfig{MaxAttempts: 4, BackoffSeconds: 60})
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	transient := errors.New("connection reset")
+
+	// The backoff doubles on each failed attempt
+	for attempts, delay := range map[int]time.Duration{1: time.Minute, 2: 2 * time.Minute, 3: 4 * time.Minute} {
+		next := w.nextAttempt(attempts, transient, now)
+		if next == nil || !next.Equal(now.Add(delay)) {
+			t.Errorf("attempt %d: next = %v, want %v", attempts, next, now.Add(delay))
+		}
+	}
+
+	// Failed for good after the last attempt or on a permanent Graph error
+	if next := w.nextAttempt(4, transient, now); next != nil {
+		t.Errorf("attempt 4 of 4: next = %v, want failed", next)
+	}
+	if next := w.nextAttempt(1, &GraphError{StatusCode: 400}, now); next != nil {
+		t.Errorf("HTTP 400: next = %v, want failed", next)
+	}
+	if next := w.nextAttempt(1, &GraphError{StatusCode: 429}, now); next == nil {
+		t.Error("HTTP 429 not retried")
+	}
+}
+
+func TestNextAttemptCapsBackoff(t *testing.T) {
+	w := NewWorker(nil, models.SharePointConfig{MaxAttempts: 100, BackoffSeconds: 60})
+	now := time.Now()
+	for _, attempts := range []int{10, 40, 70} {
+		next := w.nextAttempt(attempts, errors.New("timeout"), now)
+		if next == nil || !next.Equal(now.Add(maxBackoff)) {
+			t.Errorf("attempt %d: next = %v, want capped at %v", attempts, next, maxBackoff)
+		}
+	}
+}
+
+func TestDrivePath(t *testing.T) {
+	fecha := time.Date(2025, 3, 9, 0, 0, 0, 0, time.UTC)
+	if got := DrivePath("/Facturas/", "101-00000-1", fecha, "fac:01?.jpg"); got != "Facturas/101-00000-1/2025/03/fac_01_.jpg" {
+		t.Errorf("DrivePath = %s", got)
+	}
+	if got := DrivePath("Facturas", "", fecha, "a.jpg"); got != "Facturas/sin_rnc/2025/03/a.jpg" {
+		t.Errorf("DrivePath without RNC = %s", got)
+	}
+}
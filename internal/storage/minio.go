@@ -99,6 +99,46 @@ func GetPresignedURL(ctx context.Context, objectPath string) (string, error) {
 	return url.String(), nil
 }
 
+// OpenImage streams a stored image without loading it in memory. The caller
+// must close the reader. Returns the object size and content type.
+func OpenImage(ctx context.Context, objectPath string) (io.ReadCloser, int64, string, error) {
+	objectName := objectPath
+	if len(objectPath) > len(BucketName)+1 && objectPath[:len(BucketName)+1] == BucketName+"/" {
+		objectName = objectPath[len(BucketName)+1:]
+	}
+
+	obj, err := Client.GetObject(ctx, BucketName, objectName, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("failed to get image: %w", err)
+	}
+	info, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		return nil, 0, "", fmt.Errorf("failed to stat image: %w", err)
+	}
+	return obj, info.Size, info.ContentType, nil
+}
+
+// DownloadImage reads a stored image back, given the path saved in the DB
+func DownloadImage(ctx context.Context, objectPath string) ([]byte, error) {
+	objectName := objectPath
+	if len(objectPath) > len(BucketName)+1 && objectPath[:len(BucketName)+1] == BucketName+"/" {
+		objectName = objectPath[len(BucketName)+1:]
+	}
+
+	obj, err := Client.GetObject(ctx, BucketName, objectName, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image: %w", err)
+	}
+	defer obj.Close()
+
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+	return data, nil
+}
+
 // DeleteImage removes an image from storage
 func DeleteImage(ctx context.Context, objectPath string) error {
 	objectName := objectPath
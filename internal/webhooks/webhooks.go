@@ -0,0 +1,270 @@
+// Package webhooks delivers invoice lifecycle events to endpoints registered
+// by each cliente. Events travel through the outbox: TopicEvent fans an event
+// out to one TopicDelivery per subscribed endpoint, so every endpoint is
+// retried and logged on its own.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/facturaIA/invoice-ocr-service/internal/db"
+	"github.com/facturaIA/invoice-ocr-service/internal/outbox"
+)
+
+// Event types
+const (
+	InvoiceProcessed         = "invoice.processed"
+	InvoiceNeedsReview       = "invoice.needs_review"
+	InvoiceDuplicateRejected = "invoice.duplicate_rejected"
+	InvoiceUpdated           = "invoice.updated"
+	InvoiceDeleted           = "invoice.deleted"
+	Envio606Generated        = "envio606.generated"
+)
+
+// EventTypes lists every event an endpoint can subscribe to
+var EventTypes = []string{
+	InvoiceProcessed,
+	InvoiceNeedsReview,
+	InvoiceDuplicateRejected,
+	InvoiceUpdated,
+	InvoiceDeleted,
+	Envio606Generated,
+}
+
+// Outbox topics
+const (
+	TopicEvent    = "webhook.event"
+	TopicDelivery = "webhook.delivery"
+)
+
+// Headers sent with every delivery
+const (
+	HeaderSignature = "X-FacturaIA-Signature"
+	HeaderEvent     = "X-FacturaIA-Event"
+	HeaderEventID   = "X-FacturaIA-Event-ID"
+	HeaderDelivery  = "X-FacturaIA-Delivery"
+)
+
+const (
+	deliveryTimeout = 15 * time.Second
+	// Only the start of the receiver's answer is kept in the delivery log
+	maxResponseBody = 2048
+)
+
+// Event is the JSON body POSTed to endpoints
+type Event struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"event"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// eventPayload is the outbox payload of TopicEvent
+type eventPayload struct {
+	ClienteID string `json:"cliente_id"`
+	Event     Event  `json:"event"`
+}
+
+// deliveryPayload is the outbox payload of TopicDelivery
+type deliveryPayload struct {
+	EndpointID string `json:"endpoint_id"`
+	Event      Event  `json:"event"`
+}
+
+// ValidEventType reports whether t is a known event type
+func ValidEventType(t string) bool {
+	for _, e := range EventTypes {
+		if e == t {
+			return true
+		}
+	}
+	return false
+}
+
+// NewEvent builds the outbox event that notifies the endpoints of clienteID.
+// key identifies the occurrence (e.g. factura ID + updated_at) so a retried
+// write does not notify twice.
+func NewEvent(clienteID, eventType, aggregateType, aggregateID, key string, data interface{}) (db.OutboxEvent, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return db.OutboxEvent{}, err
+	}
+	p := eventPayload{
+		ClienteID: clienteID,
+		Event: Event{
+			ID:        "evt_" + strings.ReplaceAll(uuid.New().String(), "-", ""),
+			Type:      eventType,
+			CreatedAt: time.Now().UTC(),
+			Data:      raw,
+		},
+	}
+	return db.NewOutboxEvent(TopicEvent, aggregateType, aggregateID, TopicEvent+":"+eventType+":"+key, p)
+}
+
+// NewReplay re-queues a logged delivery to its endpoint. It is a new outbox
+// event, so it gets its own attempts and backoff.
+func NewReplay(d *db.WebhookDelivery) (db.OutboxEvent, error) {
+	var ev Event
+	if err := json.Unmarshal(d.Payload, &ev); err != nil {
+		return db.OutboxEvent{}, fmt.Errorf("invalid logged payload: %w", err)
+	}
+	key := fmt.Sprintf("webhook.replay:%s:%s", d.ID, uuid.New().String())
+	return db.NewOutboxEvent(TopicDelivery, "webhook_endpoint", d.EndpointID, key, deliveryPayload{EndpointID: d.EndpointID, Event: ev})
+}
+
+// Sign returns the signature header value for body sent at t:
+// "t=<unix>,v1=<hex HMAC-SHA256(secret, "<unix>.<body>")>". Receivers should
+// recompute it and reject old timestamps to prevent replays.
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Deliverer implements the outbox handlers of both topics
+type Deliverer struct {
+	http *http.Client
+
+	// Database access, replaced in tests
+	endpointsFor func(ctx context.Context, clienteID, evento string) ([]db.WebhookEndpoint, error)
+	endpoint     func(ctx context.Context, endpointID string) (*db.WebhookEndpoint, error)
+	enqueue      func(ctx context.Context, events ...db.OutboxEvent) error
+	logDelivery  func(ctx context.Context, d *db.WebhookDelivery) error
+}
+
+// NewDeliverer creates a Deliverer that only posts to public https endpoints
+// and does not follow redirects
+func NewDeliverer() *Deliverer {
+	return &Deliverer{
+		http:         newDeliveryClient(),
+		endpointsFor: db.GetWebhookEndpointsForEvent,
+		endpoint:     db.GetWebhookEndpoint,
+		enqueue:      db.EnqueueOutboxEvents,
+		logDelivery:  db.InsertWebhookDelivery,
+	}
+}
+
+// FanOut queues one delivery per active endpoint subscribed to the event. The
+// delivery keys are derived from the event ID, so a redelivered fan-out does
+// not duplicate them.
+func (d *Deliverer) FanOut(ctx context.Context, ev db.OutboxEvent) error {
+	var p eventPayload
+	if err := json.Unmarshal(ev.Payload, &p); err != nil {
+		return fmt.Errorf("%w: invalid payload: %v", outbox.ErrPermanent, err)
+	}
+
+	endpoints, err := d.endpointsFor(ctx, p.ClienteID, p.Event.Type)
+	if err != nil {
+		return err
+	}
+
+	deliveries := make([]db.OutboxEvent, 0, len(endpoints))
+	for _, e := range endpoints {
+		key := fmt.Sprintf("%s:%s:%s", TopicDelivery, p.Event.ID, e.ID)
+		de, err := db.NewOutboxEvent(TopicDelivery, "webhook_endpoint", e.ID, key, deliveryPayload{EndpointID: e.ID, Event: p.Event})
+		if err != nil {
+			return fmt.Errorf("%w: %v", outbox.ErrPermanent, err)
+		}
+		deliveries = append(deliveries, de)
+	}
+	return d.enqueue(ctx, deliveries...)
+}
+
+// Deliver POSTs the event to one endpoint and logs the attempt. A non-2xx
+// answer is an error, so the outbox retries it with backoff. Deleted or
+// disabled endpoints are skipped.
+func (d *Deliverer) Deliver(ctx context.Context, ev db.OutboxEvent) error {
+	var p deliveryPayload
+	if err := json.Unmarshal(ev.Payload, &p); err != nil {
+		return fmt.Errorf("%w: invalid payload: %v", outbox.ErrPermanent, err)
+	}
+
+	endpoint, err := d.endpoint(ctx, p.EndpointID)
+	if err != nil {
+		return err
+	}
+	if endpoint == nil || !endpoint.Activo {
+		return nil
+	}
+
+	body, err := json.Marshal(p.Event)
+	if err != nil {
+		return fmt.Errorf("%w: %v", outbox.ErrPermanent, err)
+	}
+
+	entry := &db.WebhookDelivery{
+		EndpointID: endpoint.ID,
+		EventID:    p.Event.ID,
+		Evento:     p.Event.Type,
+		Payload:    body,
+		Attempt:    ev.Attempts,
+	}
+	start := time.Now()
+	deliveryErr := d.post(ctx, endpoint, ev.ID, body, entry)
+	entry.DurationMs = int(time.Since(start).Milliseconds())
+	entry.Success = deliveryErr == nil
+	if deliveryErr != nil {
+		entry.Error = deliveryErr.Error()
+	}
+
+	// Log even if ctx expired during the request
+	logCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := d.logDelivery(logCtx, entry); err != nil {
+		log.Printf("[Webhooks] Error logging delivery of %s to %s: %v", p.Event.ID, endpoint.ID, err)
+	}
+	return deliveryErr
+}
+
+func (d *Deliverer) post(ctx context.Context, endpoint *db.WebhookEndpoint, deliveryID string, body []byte, entry *db.WebhookDelivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %v", outbox.ErrPermanent, err)
+	}
+	if req.URL.Scheme != "https" {
+		return fmt.Errorf("%w: endpoint URL is not https", outbox.ErrPermanent)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "FacturaIA-Webhooks/1.0")
+	req.Header.Set(HeaderSignature, Sign(endpoint.Secret, time.Now(), body))
+	req.Header.Set(HeaderEvent, entry.Evento)
+	req.Header.Set(HeaderEventID, entry.EventID)
+	req.Header.Set(HeaderDelivery, deliveryID)
+
+	resp, err := d.http.Do(req)
+	if errors.Is(err, ErrForbiddenAddress) {
+		return fmt.Errorf("%w: %v", outbox.ErrPermanent, err)
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	status := resp.StatusCode
+	entry.StatusCode = &status
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	entry.ResponseBody = strings.TrimSpace(string(respBody))
+
+	if status < 200 || status >= 300 {
+		return fmt.Errorf("endpoint answered HTTP %d", status)
+	}
+	return nil
+}
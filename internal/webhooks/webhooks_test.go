@@ -0,0 +1,256 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/facturaIA/invoice-ocr-service/internal/db"
+	"github.com/facturaIA/invoice-ocr-service/internal/outbox"
+)
+
+func TestSign(t *testing.T) {
+	at := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	body := []byte(`{"id":"evt_1"}`)
+
+	got := Sign("whsec_test", at, body)
+	want := "t=1767225600,v1=45b40331de0325606dc5400202ade162460fbe48daf9401adfdcd0d7b4f35470"
+	if got != want {
+		t.Errorf("Sign = %s, want %s", got, want)
+	}
+	if Sign("other", at, body) == got || Sign("whsec_test", at.Add(time.Second), body) == got ||
+		Sign("whsec_test", at, []byte(`{"id":"evt_2"}`)) == got {
+		t.Error("signature does not depend on secret, timestamp and body")
+	}
+}
+
+// testDeliverer returns a Deliverer on stub storage: endpoints by ID, the
+// queued outbox events and the logged deliveries
+func testDeliverer(endpoints ...db.WebhookEndpoint) (*Deliverer, *[]db.OutboxEvent, *[]*db.WebhookDelivery) {
+	var queued []db.OutboxEvent
+	var logged []*db.WebhookDelivery
+	d := NewDeliverer()
+	d.endpointsFor = func(_ context.Context, clienteID, evento string) ([]db.WebhookEndpoint, error) {
+		var out []db.WebhookEndpoint
+		for _, e := range endpoints {
+			if e.ClienteID == clienteID {
+				out = append(out, e)
+			}
+		}
+		return out, nil
+	}
+	d.endpoint = func(_ context.Context, id string) (*db.WebhookEndpoint, error) {
+		for i := range endpoints {
+			if endpoints[i].ID == id {
+				return &endpoints[i], nil
+			}
+		}
+		return nil, nil
+	}
+	d.enqueue = func(_ context.Context, events ...db.OutboxEvent) error {
+		queued = append(queued, events...)
+		return nil
+	}
+	d.logDelivery = func(_ context.Context, entry *db.WebhookDelivery) error {
+		logged = append(logged, entry)
+		return nil
+	}
+	return d, &queued, &logged
+}
+
+func TestFanOut(t *testing.T) {
+	d, queued, _ := testDeliverer(
+		db.WebhookEndpoint{ID: "ep-1", ClienteID: "cliente-1"},
+		db.WebhookEndpoint{ID: "ep-2", ClienteID: "cliente-1"},
+		db.WebhookEndpoint{ID: "ep-3", ClienteID: "cliente-2"},
+	)
+	ev, err := NewEvent("cliente-1", InvoiceProcessed, "factura", "f-1", "f-1", map[string]string{"ncf": "B0100000001"})
+	if err != nil {
+		t.Fatalf("NewEvent: %v", err)
+	}
+
+	// A redelivered fan-out produces the same delivery keys
+	for i := 0; i < 2; i++ {
+		if err := d.FanOut(context.Background(), ev); err != nil {
+			t.Fatalf("FanOut: %v", err)
+		}
+	}
+	if len(*queued) != 4 {
+		t.Fatalf("queued %d deliveries, want 2 per fan-out", len(*queued))
+	}
+	first, again := (*queued)[:2], (*queued)[2:]
+	for i, de := range first {
+		if de.Topic != TopicDelivery || de.IdempotencyKey != again[i].IdempotencyKey {
+			t.Errorf("delivery %d: topic %s, keys %s / %s", i, de.Topic, de.IdempotencyKey, again[i].IdempotencyKey)
+		}
+		var p deliveryPayload
+		if err := json.Unmarshal(de.Payload, &p); err != nil {
+			t.Fatalf("payload: %v", err)
+		}
+		if want := []string{"ep-1", "ep-2"}[i]; p.EndpointID != want || de.AggregateID != want {
+			t.Errorf("delivery %d to %s, want %s", i, p.EndpointID, want)
+		}
+		if p.Event.Type != InvoiceProcessed || string(p.Event.Data) != `{"ncf":"B0100000001"}` {
+			t.Errorf("delivery %d event = %+v", i, p.Event)
+		}
+	}
+	if first[0].IdempotencyKey == first[1].IdempotencyKey {
+		t.Error("both endpoints share a delivery key")
+	}
+
+	if err := d.FanOut(context.Background(), db.OutboxEvent{Payload: json.RawMessage(`{`)}); !errors.Is(err, outbox.ErrPermanent) {
+		t.Errorf("invalid payload: err = %v, want ErrPermanent", err)
+	}
+}
+
+func TestNewReplay(t *testing.T) {
+	logged := &db.WebhookDelivery{
+		ID:         "d-1",
+		EndpointID: "ep-1",
+		Payload:    json.RawMessage(`{"id":"evt_1","event":"invoice.updated","created_at":"2026-01-01T00:00:00Z","data":{"a":1}}`),
+	}
+	first, err := NewReplay(logged)
+	if err != nil {
+		t.Fatalf("NewReplay: %v", err)
+	}
+	second, _ := NewReplay(logged)
+	if first.Topic != TopicDelivery || first.AggregateID != "ep-1" {
+		t.Errorf("replay = %+v", first)
+	}
+	if first.IdempotencyKey == second.IdempotencyKey {
+		t.Error("two replays share an idempotency key")
+	}
+	var p deliveryPayload
+	if err := json.Unmarshal(first.Payload, &p); err != nil {
+		t.Fatalf("payload: %v", err)
+	}
+	if p.EndpointID != "ep-1" || p.Event.ID != "evt_1" || p.Event.Type != InvoiceUpdated || string(p.Event.Data) != `{"a":1}` {
+		t.Errorf("replayed payload = %+v", p)
+	}
+
+	if _, err := NewReplay(&db.WebhookDelivery{Payload: json.RawMessage(`nope`)}); err == nil {
+		t.Error("invalid logged payload accepted")
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	var gotSignature, gotEvent string
+	var gotBody []byte
+	status := http.StatusOK
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotSignature, gotEvent = r.Header.Get(HeaderSignature), r.Header.Get(HeaderEvent)
+		gotBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+		w.Write([]byte(strings.Repeat("x", 3000)))
+	}))
+	defer srv.Close()
+
+	d, _, logged := testDeliverer(db.WebhookEndpoint{ID: "ep-1", URL: srv.URL, Secret: "whsec_test", Activo: true})
+	// The test server listens on loopback, which the real client refuses
+	d.http = srv.Client()
+	de, _ := db.NewOutboxEvent(TopicDelivery, "webhook_endpoint", "ep-1", "k", deliveryPayload{
+		EndpointID: "ep-1",
+		Event:      Event{ID: "evt_1", Type: InvoiceProcessed, Data: json.RawMessage(`{}`)},
+	})
+	de.ID, de.Attempts = "outbox-1", 1
+
+	if err := d.Deliver(context.Background(), de); err != nil {
+		t.Fatalf("Deliver: %v", err)
+	}
+	unix, _ := strconv.ParseInt(strings.TrimPrefix(strings.Split(gotSignature, ",")[0], "t="), 10, 64)
+	if gotSignature != Sign("whsec_test", time.Unix(unix, 0), gotBody) || gotEvent != InvoiceProcessed {
+		t.Errorf("signature %q does not verify (event %s)", gotSignature, gotEvent)
+	}
+	entry := (*logged)[0]
+	if !entry.Success || entry.StatusCode == nil || *entry.StatusCode != 200 || len(entry.ResponseBody) != maxResponseBody {
+		t.Errorf("logged = %+v", entry)
+	}
+
+	status = http.StatusInternalServerError
+	if err := d.Deliver(context.Background(), de); err == nil || errors.Is(err, outbox.ErrPermanent) {
+		t.Errorf("HTTP 500: err = %v, want a retryable error", err)
+	}
+	if (*logged)[1].Success {
+		t.Error("failed delivery logged as success")
+	}
+}
+
+func TestDeliverRefusesInternalAddresses(t *testing.T) {
+	hit := false
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { hit = true }))
+	defer srv.Close()
+
+	d, _, logged := testDeliverer(
+		db.WebhookEndpoint{ID: "loopback", URL: srv.URL, Activo: true},
+		db.WebhookEndpoint{ID: "plain-http", URL: "http://example.com/hook", Activo: true},
+	)
+	for _, id := range []string{"loopback", "plain-http"} {
+		de, _ := db.NewOutboxEvent(TopicDelivery, "webhook_endpoint", id, id, deliveryPayload{EndpointID: id, Event: Event{ID: "evt_1"}})
+		if err := d.Deliver(context.Background(), de); !errors.Is(err, outbox.ErrPermanent) {
+			t.Errorf("%s: err = %v, want ErrPermanent", id, err)
+		}
+	}
+	if hit {
+		t.Error("delivery reached a loopback server")
+	}
+	if len(*logged) != 2 || (*logged)[0].Success {
+		t.Errorf("logged = %+v", *logged)
+	}
+}
+
+func TestDeliveryClientDoesNotFollowRedirects(t *testing.T) {
+	followed := false
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hook", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/internal", http.StatusFound)
+	})
+	mux.HandleFunc("/internal", func(w http.ResponseWriter, r *http.Request) { followed = true })
+	srv := httptest.NewTLSServer(mux)
+	defer srv.Close()
+
+	client := newDeliveryClient()
+	client.Transport = srv.Client().Transport
+	resp, err := client.Post(srv.URL+"/hook", "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound || followed {
+		t.Errorf("status %d, followed %v; want the redirect itself", resp.StatusCode, followed)
+	}
+}
+
+func TestValidateURL(t *testing.T) {
+	for _, raw := range []string{
+		"https://127.0.0.1/hook",
+		"https://localhost:8443/hook",
+		"https://169.254.169.254/latest/meta-data",
+		"https://10.0.0.5/hook",
+		"https://172.16.3.4/hook",
+		"https://192.168.1.1/hook",
+		"https://100.64.0.1/hook",
+		"https://[::1]/hook",
+		"https://[fe80::1]/hook",
+		"https://[::ffff:127.0.0.1]/hook",
+		"https://0.0.0.0/hook",
+	} {
+		if _, err := ValidateURL(context.Background(), raw); !errors.Is(err, ErrForbiddenAddress) {
+			t.Errorf("ValidateURL(%s) = %v, want ErrForbiddenAddress", raw, err)
+		}
+	}
+	for _, raw := range []string{"http://8.8.8.8/hook", "ftp://8.8.8.8", "/hook", "https://user:pw@8.8.8.8/"} {
+		if _, err := ValidateURL(context.Background(), raw); err == nil {
+			t.Errorf("ValidateURL(%s) accepted", raw)
+		}
+	}
+	if u, err := ValidateURL(context.Background(), "https://8.8.8.8/hook"); err != nil || u.Host != "8.8.8.8" {
+		t.Errorf("public address rejected: %v", err)
+	}
+}
@@ -0,0 +1,27 @@
+-- Formato 607 (ventas): persist tipo_factura and track 607 envíos.
+
+ALTER TABLE facturas_clientes
+    ADD COLUMN IF NOT EXISTS tipo_factura VARCHAR(10) NOT NULL DEFAULT 'gastos'
+        CHECK (tipo_factura IN ('gastos', 'ingresos'));
+
+-- The 607 lookup index is created by 019_formato_607_index.sql
+
+CREATE TABLE IF NOT EXISTS envios_607 (
+    id                      UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    empresa_id              UUID,
+    rnc                     VARCHAR(11) NOT NULL,
+    periodo                 CHAR(6) NOT NULL,
+    archivo_txt             TEXT NOT NULL,
+    archivo_nombre          VARCHAR(100) NOT NULL,
+    cantidad_registros      INTEGER NOT NULL DEFAULT 0,
+    total_monto_facturado   NUMERIC(16,2) NOT NULL DEFAULT 0,
+    total_itbis_facturado   NUMERIC(16,2) NOT NULL DEFAULT 0,
+    total_itbis_retenido    NUMERIC(16,2) NOT NULL DEFAULT 0,
+    estado                  VARCHAR(20) NOT NULL DEFAULT 'generado'
+        CHECK (estado IN ('generado', 'enviado', 'completado', 'rechazado', 'anulado')),
+    referencia_dgii         VARCHAR(50),
+    fecha_envio             TIMESTAMPTZ,
+    created_at              TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_envios_607_rnc_periodo ON envios_607 (rnc, periodo);
@@ -0,0 +1,36 @@
+-- Formato 608 (comprobantes anulados): annulled NCFs and 608 envíos.
+
+CREATE TABLE IF NOT EXISTS comprobantes_anulados (
+    id                UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    cliente_id        UUID NOT NULL,
+    factura_id        UUID REFERENCES facturas_clientes(id) ON DELETE SET NULL,
+    rnc               VARCHAR(11) NOT NULL,
+    ncf               VARCHAR(19) NOT NULL,
+    tipo_anulacion    CHAR(2) NOT NULL
+        CHECK (tipo_anulacion IN ('01','02','03','04','05','06','07','08','09','10')),
+    fecha_comprobante DATE,
+    fecha_anulacion   DATE NOT NULL DEFAULT CURRENT_DATE,
+    motivo            TEXT,
+    created_at        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (rnc, ncf)
+);
+
+CREATE INDEX IF NOT EXISTS idx_comprobantes_anulados_periodo
+    ON comprobantes_anulados (rnc, fecha_anulacion);
+
+CREATE TABLE IF NOT EXISTS envios_608 (
+    id                 UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    empresa_id         UUID,
+    rnc                VARCHAR(11) NOT NULL,
+    periodo            CHAR(6) NOT NULL,
+    archivo_txt        TEXT NOT NULL,
+    archivo_nombre     VARCHAR(100) NOT NULL,
+    cantidad_registros INTEGER NOT NULL DEFAULT 0,
+    estado             VARCHAR(20) NOT NULL DEFAULT 'generado'
+        CHECK (estado IN ('generado', 'enviado', 'completado', 'rechazado', 'anulado')),
+    referencia_dgii    VARCHAR(50),
+    fecha_envio        TIMESTAMPTZ,
+    created_at         TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_envios_608_rnc_periodo ON envios_608 (rnc, periodo);
@@ -0,0 +1,30 @@
+-- Formato 606: versioned envíos per RNC + periodo (original / rectificativa),
+-- explicit finalization and a per-invoice line snapshot used for diffs.
+
+ALTER TABLE envios_606
+    ADD COLUMN IF NOT EXISTS version            INTEGER NOT NULL DEFAULT 1,
+    ADD COLUMN IF NOT EXISTS tipo_envio         VARCHAR(15) NOT NULL DEFAULT 'original'
+        CHECK (tipo_envio IN ('original', 'rectificativa')),
+    ADD COLUMN IF NOT EXISTS rectifica_envio_id UUID REFERENCES envios_606(id),
+    ADD COLUMN IF NOT EXISTS finalizado         BOOLEAN NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS finalizado_at      TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS lineas             JSONB NOT NULL DEFAULT '{}'::jsonb,
+    ADD COLUMN IF NOT EXISTS updated_at         TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+-- Existing rows were one insert per download: number them in creation order
+-- so the unique index below can be created.
+UPDATE envios_606 e
+SET version = v.rn
+FROM (
+    SELECT id, ROW_NUMBER() OVER (PARTITION BY rnc, periodo ORDER BY created_at, id) AS rn
+    FROM envios_606
+) v
+WHERE e.id = v.id;
+
+-- Envíos already filed with DGII are final
+UPDATE envios_606
+SET finalizado = true, finalizado_at = COALESCE(fecha_envio, created_at)
+WHERE estado = 'completado';
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_envios_606_rnc_periodo_version
+    ON envios_606 (rnc, periodo, version);
@@ -0,0 +1,18 @@
+-- Multi-moneda: facturas en moneda extranjera se convierten a DOP a la tasa
+-- del Banco Central de la fecha de la factura. Se guardan los montos originales.
+
+ALTER TABLE facturas_clientes
+    ADD COLUMN IF NOT EXISTS moneda            CHAR(3) NOT NULL DEFAULT 'DOP',
+    ADD COLUMN IF NOT EXISTS tasa_cambio       NUMERIC(14,6) NOT NULL DEFAULT 1,
+    ADD COLUMN IF NOT EXISTS montos_originales JSONB;
+
+-- Tasas oficiales (DOP por unidad), cargadas desde el CSV del Banco Central
+CREATE TABLE IF NOT EXISTS tasas_cambio (
+    moneda      CHAR(3) NOT NULL,
+    fecha       DATE NOT NULL,
+    compra      NUMERIC(14,6) NOT NULL,
+    venta       NUMERIC(14,6) NOT NULL,
+    fuente      VARCHAR(20) NOT NULL DEFAULT 'bcrd',
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (moneda, fecha)
+);
@@ -0,0 +1,26 @@
+-- Asynchronous invoice processing: uploads are persisted as jobs and picked up
+-- by a bounded worker pool, so results survive dropped connections and restarts.
+
+CREATE TABLE IF NOT EXISTS processing_jobs (
+    id            UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    cliente_id    UUID NOT NULL,
+    tipo          VARCHAR(20) NOT NULL DEFAULT 'upload',
+    status        VARCHAR(10) NOT NULL DEFAULT 'queued'
+        CHECK (status IN ('queued', 'running', 'done', 'failed')),
+    params        JSONB NOT NULL DEFAULT '{}'::jsonb,
+    imagen        BYTEA,                 -- uploaded file, cleared once the job ends
+    attempts      INTEGER NOT NULL DEFAULT 0,
+    http_status   INTEGER,
+    result        JSONB,
+    error         TEXT,
+    factura_id    UUID,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    started_at    TIMESTAMPTZ,
+    finished_at   TIMESTAMPTZ,
+    updated_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_processing_jobs_queued
+    ON processing_jobs (created_at) WHERE status = 'queued';
+CREATE INDEX IF NOT EXISTS idx_processing_jobs_cliente
+    ON processing_jobs (cliente_id, created_at DESC);
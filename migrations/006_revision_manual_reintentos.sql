@@ -0,0 +1,12 @@
+-- Automatic retries for invoices saved as revision_manual because every AI
+-- provider failed. After retry_attempts reaches the configured maximum the
+-- invoice stays in revision_manual for an accountant.
+
+ALTER TABLE facturas_clientes
+    ADD COLUMN IF NOT EXISTS retry_attempts   INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS next_retry_at    TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS last_retry_error TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_facturas_clientes_revision_manual
+    ON facturas_clientes (next_retry_at)
+    WHERE extraction_status = 'revision_manual';
@@ -0,0 +1,29 @@
+-- SharePoint sync worker: the queue filled by the upload endpoint is now
+-- consumed in-process. Rows move pending → processing → synced, or back to
+-- pending with a backoff, and to failed after the last attempt.
+
+CREATE TABLE IF NOT EXISTS sharepoint_sync_queue (
+    id             UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    factura_id     UUID NOT NULL UNIQUE,
+    cliente_id     UUID NOT NULL,
+    rnc_cliente    VARCHAR(50),
+    fecha_factura  TIMESTAMPTZ,
+    archivo_url    TEXT NOT NULL,
+    archivo_nombre VARCHAR(255),
+    status         VARCHAR(20) NOT NULL DEFAULT 'pending',
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE sharepoint_sync_queue
+    ADD COLUMN IF NOT EXISTS attempts        INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS last_error      TEXT,
+    ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS drive_path      TEXT,
+    ADD COLUMN IF NOT EXISTS drive_item_id   VARCHAR(100),
+    ADD COLUMN IF NOT EXISTS web_url         TEXT,
+    ADD COLUMN IF NOT EXISTS synced_at       TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+CREATE INDEX IF NOT EXISTS idx_sharepoint_sync_queue_pending
+    ON sharepoint_sync_queue (next_attempt_at, created_at)
+    WHERE status IN ('pending', 'processing');
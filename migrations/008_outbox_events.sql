@@ -0,0 +1,24 @@
+-- Transactional outbox: side effects of a change (SharePoint sync, webhooks,
+-- notifications) are written in the same transaction and delivered at least
+-- once by the in-process dispatcher.
+
+CREATE TABLE IF NOT EXISTS outbox_events (
+    id               UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    topic            VARCHAR(50) NOT NULL,
+    aggregate_type   VARCHAR(50) NOT NULL,
+    aggregate_id     VARCHAR(64) NOT NULL,
+    idempotency_key  VARCHAR(200) NOT NULL UNIQUE,
+    payload          JSONB NOT NULL DEFAULT '{}'::jsonb,
+    status           VARCHAR(10) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'delivered', 'failed')),
+    attempts         INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_error       TEXT,
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    delivered_at     TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_events_due
+    ON outbox_events (next_attempt_at, created_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_outbox_events_aggregate
+    ON outbox_events (aggregate_type, aggregate_id);
@@ -0,0 +1,35 @@
+-- Outbound webhooks: endpoints registered per cliente and a log of every
+-- delivery attempt. Deliveries are queued through outbox_events.
+
+CREATE TABLE IF NOT EXISTS webhook_endpoints (
+    id          UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    cliente_id  UUID NOT NULL,
+    url         TEXT NOT NULL,
+    secret      VARCHAR(100) NOT NULL,
+    eventos     TEXT[] NOT NULL DEFAULT '{}',   -- empty = every event
+    descripcion VARCHAR(200),
+    activo      BOOLEAN NOT NULL DEFAULT true,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_endpoints_cliente
+    ON webhook_endpoints (cliente_id) WHERE activo;
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id             UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    endpoint_id    UUID NOT NULL REFERENCES webhook_endpoints(id) ON DELETE CASCADE,
+    event_id       VARCHAR(64) NOT NULL,
+    evento         VARCHAR(50) NOT NULL,
+    payload        JSONB NOT NULL,
+    attempt        INTEGER NOT NULL,
+    status_code    INTEGER,
+    response_body  TEXT,
+    error          TEXT,
+    duration_ms    INTEGER NOT NULL DEFAULT 0,
+    success        BOOLEAN NOT NULL DEFAULT false,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_endpoint
+    ON webhook_deliveries (endpoint_id, created_at DESC);
@@ -0,0 +1,20 @@
+-- Batch uploads: many files (multipart or ZIP) queued at once as processing
+-- jobs. Each file is one job; the batch groups them for status reporting.
+
+CREATE TABLE IF NOT EXISTS upload_batches (
+    id           UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    cliente_id   UUID NOT NULL,          -- owner of the invoices
+    created_by   UUID NOT NULL,          -- user that uploaded (cliente or contador)
+    total        INTEGER NOT NULL DEFAULT 0,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_upload_batches_cliente
+    ON upload_batches (cliente_id, created_at DESC);
+
+ALTER TABLE processing_jobs
+    ADD COLUMN IF NOT EXISTS batch_id       UUID REFERENCES upload_batches(id) ON DELETE CASCADE,
+    ADD COLUMN IF NOT EXISTS archivo_nombre VARCHAR(255);
+
+CREATE INDEX IF NOT EXISTS idx_processing_jobs_batch
+    ON processing_jobs (batch_id, status) WHERE batch_id IS NOT NULL;
@@ -0,0 +1,19 @@
+-- Idempotency-Key support for uploads: the first response for a (cliente,
+-- key) pair is stored and replayed to retries until it expires.
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    cliente_id    UUID NOT NULL,
+    key           VARCHAR(255) NOT NULL,
+    request_hash  VARCHAR(64) NOT NULL,     -- SHA-256 of the uploaded file
+    status        VARCHAR(12) NOT NULL DEFAULT 'processing'
+        CHECK (status IN ('processing', 'done')),
+    http_status   INTEGER,
+    response      JSONB,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at    TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (cliente_id, key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires
+    ON idempotency_keys (expires_at);
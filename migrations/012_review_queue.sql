@@ -0,0 +1,30 @@
+-- Review queue: contadores work through the invoices whose extraction needs a
+-- human (extraction_status review, error or revision_manual). An item is
+-- claimed by one reviewer at a time; every state change is recorded.
+
+ALTER TABLE facturas_clientes
+    ADD COLUMN IF NOT EXISTS review_claimed_by UUID,
+    ADD COLUMN IF NOT EXISTS review_claimed_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS reviewed_by       UUID,
+    ADD COLUMN IF NOT EXISTS reviewed_at       TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_facturas_clientes_review_queue
+    ON facturas_clientes (cliente_id, created_at)
+    WHERE extraction_status IN ('review', 'error', 'revision_manual');
+
+CREATE TABLE IF NOT EXISTS review_transitions (
+    id           UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    factura_id   UUID NOT NULL REFERENCES facturas_clientes(id) ON DELETE CASCADE,
+    cliente_id   UUID NOT NULL,
+    actor_id     UUID NOT NULL,          -- contador or admin
+    accion       VARCHAR(20) NOT NULL
+        CHECK (accion IN ('claim', 'release', 'correct', 'approve', 'reject')),
+    from_status  VARCHAR(30) NOT NULL DEFAULT '',
+    to_status    VARCHAR(30) NOT NULL DEFAULT '',
+    notas        TEXT NOT NULL DEFAULT '',
+    cambios      JSONB,                  -- corrected fields: {"campo": {"old": .., "new": ..}}
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_review_transitions_factura
+    ON review_transitions (factura_id, created_at);
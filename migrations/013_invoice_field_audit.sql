@@ -0,0 +1,34 @@
+-- Field-level audit of facturas_clientes: every update records, per changed
+-- field, the old and new value, who made the change and why. Each update is
+-- one version of the invoice (version 0 is the invoice as uploaded). Rows are
+-- never updated or deleted, and survive the deletion of the invoice.
+
+CREATE TABLE IF NOT EXISTS invoice_field_audit (
+    id          BIGSERIAL PRIMARY KEY,
+    factura_id  UUID NOT NULL,
+    cliente_id  UUID NOT NULL,
+    version     INTEGER NOT NULL,
+    campo       VARCHAR(50) NOT NULL,
+    old_value   JSONB,
+    new_value   JSONB,
+    actor_id    UUID,                    -- NULL for automatic changes
+    source      VARCHAR(20) NOT NULL
+        CHECK (source IN ('ai', 'user', 'contador', 'system')),
+    reason      TEXT NOT NULL DEFAULT '',
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (factura_id, version, campo)
+);
+
+CREATE INDEX IF NOT EXISTS idx_invoice_field_audit_factura
+    ON invoice_field_audit (factura_id, version);
+
+CREATE OR REPLACE FUNCTION invoice_field_audit_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'invoice_field_audit is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_invoice_field_audit_append_only ON invoice_field_audit;
+CREATE TRIGGER trg_invoice_field_audit_append_only
+    BEFORE UPDATE OR DELETE ON invoice_field_audit
+    FOR EACH ROW EXECUTE FUNCTION invoice_field_audit_append_only();
@@ -0,0 +1,20 @@
+-- Corrected extractions kept as few-shot examples: for each invoice a person
+-- corrected, the image, what the AI extracted and the corrected values of
+-- the DGII fields. New invoices of the same emisor get the latest ones in
+-- their extraction prompt. One row per invoice, replaced on each correction.
+
+CREATE TABLE IF NOT EXISTS extraction_examples (
+    factura_id       UUID PRIMARY KEY REFERENCES facturas_clientes(id) ON DELETE CASCADE,
+    cliente_id       UUID NOT NULL,
+    emisor_rnc       VARCHAR(20) NOT NULL,
+    proveedor        TEXT NOT NULL DEFAULT '',
+    image_ref        TEXT NOT NULL DEFAULT '',  -- archivo_url of the invoice
+    ai_output        JSONB NOT NULL,            -- Compared fields as extracted
+    corrected_output JSONB NOT NULL,            -- The same fields after the correction
+    campos           TEXT[] NOT NULL,           -- Fields that differ
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at       TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_extraction_examples_emisor
+    ON extraction_examples (cliente_id, emisor_rnc, updated_at DESC);
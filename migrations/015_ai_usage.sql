@@ -0,0 +1,27 @@
+-- AI usage metering: one row per provider call (each attempt of a fallback
+-- chain is its own row) with its tenant, tokens, latency, estimated cost and
+-- outcome. Aggregated by empresa and month for the usage endpoints and the
+-- monthly quotas.
+
+CREATE TABLE IF NOT EXISTS ai_usage (
+    id            BIGSERIAL PRIMARY KEY,
+    empresa_alias VARCHAR(100) NOT NULL DEFAULT '',
+    cliente_id    UUID,
+    factura_id    UUID,                   -- Known when reprocessing an invoice
+    provider      VARCHAR(20) NOT NULL,
+    model         VARCHAR(100) NOT NULL DEFAULT '',
+    latency_ms    INTEGER NOT NULL,
+    input_tokens  INTEGER NOT NULL DEFAULT 0,
+    output_tokens INTEGER NOT NULL DEFAULT 0,
+    cost_usd      NUMERIC(12, 6) NOT NULL DEFAULT 0,
+    outcome       VARCHAR(20) NOT NULL
+        CHECK (outcome IN ('success', 'transient_error', 'error')),
+    error         TEXT NOT NULL DEFAULT '',
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_ai_usage_empresa_mes
+    ON ai_usage (empresa_alias, created_at);
+
+CREATE INDEX IF NOT EXISTS idx_ai_usage_cliente
+    ON ai_usage (cliente_id, created_at DESC);
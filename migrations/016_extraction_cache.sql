@@ -0,0 +1,20 @@
+-- Extraction cache: the parsed invoice an AI extraction produced, keyed by the
+-- SHA-256 of the image bytes, the prompt version and mode, and the model. A
+-- re-upload or reprocess of the same image with the same prompt and model
+-- reuses it instead of paying for another call.
+
+CREATE TABLE IF NOT EXISTS extraction_cache (
+    image_sha256   CHAR(64) NOT NULL,
+    prompt_version VARCHAR(50) NOT NULL,
+    vision         BOOLEAN NOT NULL,       -- Vision prompt or OCR text prompt
+    model          VARCHAR(150) NOT NULL,  -- "<provider>/<model>", after "tesseract-<lang>+" in text mode
+    invoice        JSONB NOT NULL,         -- models.Invoice, before DOP conversion
+    ocr_text       TEXT NOT NULL DEFAULT '',
+    hits           INTEGER NOT NULL DEFAULT 0,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_hit_at    TIMESTAMPTZ,
+    PRIMARY KEY (image_sha256, prompt_version, vision, model)
+);
+
+CREATE INDEX IF NOT EXISTS idx_extraction_cache_created
+    ON extraction_cache (created_at);
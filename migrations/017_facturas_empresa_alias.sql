@@ -0,0 +1,17 @@
+-- Empresa (tenant) an invoice was uploaded under. Work done later without a
+-- session, like the revision_manual retries, applies the empresa's PII
+-- redaction policy and prompt version through it.
+
+ALTER TABLE facturas_clientes
+    ADD COLUMN IF NOT EXISTS empresa_alias VARCHAR(100) NOT NULL DEFAULT '';
+
+-- Invoices stored before: the cliente's last empresa seen by the AI metering
+UPDATE facturas_clientes f
+SET empresa_alias = u.empresa_alias
+FROM (
+    SELECT DISTINCT ON (cliente_id) cliente_id, empresa_alias
+    FROM ai_usage
+    WHERE cliente_id IS NOT NULL AND empresa_alias <> ''
+    ORDER BY cliente_id, created_at DESC
+) u
+WHERE f.cliente_id = u.cliente_id AND f.empresa_alias = '';
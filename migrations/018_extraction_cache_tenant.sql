@@ -0,0 +1,24 @@
+-- Extraction cache per cliente and redaction: results shaped by a cliente's
+-- correction examples, or read from a redacted image, are not served to
+-- another cliente or policy. The cached results are dropped: they can be
+-- extracted again.
+
+DROP TABLE IF EXISTS extraction_cache;
+
+CREATE TABLE extraction_cache (
+    cliente_id     UUID NOT NULL,
+    image_sha256   CHAR(64) NOT NULL,
+    prompt_version VARCHAR(50) NOT NULL,
+    vision         BOOLEAN NOT NULL,       -- Vision prompt or OCR text prompt
+    model          VARCHAR(150) NOT NULL,  -- "<provider>/<model>", after "tesseract-<lang>+" in text mode
+    redaction      VARCHAR(20) NOT NULL,   -- "redact" when the AI saw the redacted image/text, else ""
+    invoice        JSONB NOT NULL,         -- models.Invoice, before DOP conversion
+    ocr_text       TEXT NOT NULL DEFAULT '',
+    hits           INTEGER NOT NULL DEFAULT 0,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_hit_at    TIMESTAMPTZ,
+    PRIMARY KEY (cliente_id, image_sha256, prompt_version, vision, model, redaction)
+);
+
+CREATE INDEX IF NOT EXISTS idx_extraction_cache_created
+    ON extraction_cache (created_at);
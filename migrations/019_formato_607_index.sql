@@ -0,0 +1,10 @@
+-- Index of the 607 lookup. The first version of 001 indexed
+-- to_char(fecha_documento, 'YYYYMM'), which is not IMMUTABLE, so PostgreSQL
+-- refused it; databases that ran that version have no such index. Index the
+-- date itself: the 607 query filters the period as a date range.
+
+DROP INDEX IF EXISTS idx_facturas_clientes_607;
+
+CREATE INDEX IF NOT EXISTS idx_facturas_clientes_607
+    ON facturas_clientes (REPLACE(COALESCE(emisor_rnc, ''), '-', ''), fecha_documento)
+    WHERE tipo_factura = 'ingresos';
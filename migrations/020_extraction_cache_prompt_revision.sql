@@ -0,0 +1,5 @@
+-- The cache is keyed by the prompt revision, "version@hash", so a template
+-- edited in place under the same version name misses instead of serving
+-- results extracted with the old text. The hash adds 13 characters.
+
+ALTER TABLE extraction_cache ALTER COLUMN prompt_version TYPE VARCHAR(100);